@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
@@ -17,9 +18,12 @@ import (
 	"blogengine/internal/config"
 	"blogengine/internal/content"
 	"blogengine/internal/handlers"
+	"blogengine/internal/mail"
 	"blogengine/internal/middleware"
+	"blogengine/internal/oidc"
 	"blogengine/internal/router"
 	"blogengine/internal/seeder"
+	"blogengine/internal/spam"
 	"blogengine/internal/storage"
 	"blogengine/internal/storage/sqlite"
 	"blogengine/internal/telemetry"
@@ -100,6 +104,28 @@ func (a *App) Run(ctx context.Context) error {
 	return nil
 }
 
+// newMailSender picks the mail transport of the configuration
+func newMailSender(cfg config.MailConfig) (mail.Sender, error) {
+	switch cfg.Transport {
+	case "smtp":
+		return &mail.SMTPSender{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+			TLS:      cfg.SMTPTLS,
+			Timeout:  30 * time.Second,
+		}, nil
+	case "file":
+		return &mail.FileSender{Dir: cfg.Dir, From: cfg.From}, nil
+	case "console":
+		return &mail.ConsoleSender{Out: os.Stdout}, nil
+	default:
+		return nil, fmt.Errorf("unknown mail transport %q", cfg.Transport)
+	}
+}
+
 func main() {
 	cfg := config.LoadWithDefaults()
 	if err := cfg.Validate(); err != nil {
@@ -219,6 +245,10 @@ func main() {
 	}
 
 	renderer := content.NewMarkDownRenderer(assetManager)
+	var postCache *content.RenderCache
+	if cfg.App.PostCacheMB > 0 {
+		postCache = content.NewRenderCache(cfg.App.PostCacheMB << 20)
+	}
 
 	db, err := sqlite.NewStore(cfg.DB.Path)
 	if err != nil {
@@ -234,17 +264,10 @@ func main() {
 
 	logger.Info("database migrated successfully")
 
-	if _, err := db.GetUserByUsername(rootCtx, "admin"); err != nil {
-		// check if error is anything but ErrNotFound
-		if !errors.Is(err, storage.ErrNotFound) {
-			logger.Error("could not check for admin user", "error", err)
-			os.Exit(1)
-		}
-		// ErrNotFound: attempt to Bootstrap db
-		if err := db.Bootstrap(rootCtx, logger); err != nil {
-			logger.Error("could not bootstrap db", "error", err)
-			os.Exit(1)
-		}
+	// every start, an existing admin still on the legacy default password is held to change it
+	if err := db.Bootstrap(rootCtx, logger, cfg.Auth.AdminPassword, os.Stderr); err != nil {
+		logger.Error("could not bootstrap db", "error", err)
+		os.Exit(1)
 	}
 
 	seedTimeout := 2 * time.Minute
@@ -258,39 +281,54 @@ func main() {
 	}
 	logger.Info("seeding completed")
 
+	mailer, err := newMailSender(cfg.Mail)
+	if err != nil {
+		logger.Error("could not set up mail", "transport", cfg.Mail.Transport, "err", err)
+		os.Exit(1)
+	}
+	outbox := mail.NewOutbox(db, mailer, logger.With("component", "outbox"))
+	go outbox.Run(rootCtx)
+	logger.Info("mail outbox started", "transport", cfg.Mail.Transport)
+
 	// session manager
 	sessionLifetime := 24 * time.Hour
-	session := middleware.NewSessionManager(sessionLifetime, cfg.App.Environment == "prod", db.RawDB())
+	session := middleware.NewSessionManager(sessionLifetime, cfg.App.Environment == "prod", cfg.Proxy.Trusted, db.RawDB())
+	session.Users = db
+
+	// security events outlive the logs in the audit_events table
+	audit := middleware.NewAudit(db, logger, cfg.Proxy.Trusted)
 
 	limiter := middleware.NewIPRateLimiter(rootCtx, cfg.Limiter.RPS, cfg.Limiter.Burst, cfg.Proxy.Trusted, metrics)
+	limiter.Audit = audit
 
 	authRPS := 1
 	authBurst := 3
 	authLimiter := middleware.NewIPRateLimiter(rootCtx, authRPS, authBurst, cfg.Proxy.Trusted, metrics)
+	authLimiter.Audit = audit
 
 	geo := middleware.NewGeoStats(rootCtx)
 
 	needsInvite := cfg.Auth.InviteCode != ""
 
+	// checked by cfg.Validate, empty when two-factor enrolment is off
+	totpKey, _ := hex.DecodeString(cfg.Auth.TOTPKey)
+
+	var sso *oidc.Provider
+	if cfg.Auth.OIDC.Issuer != "" {
+		sso = oidc.New(oidc.Config{
+			Name:         cfg.Auth.OIDC.Name,
+			Issuer:       cfg.Auth.OIDC.Issuer,
+			ClientID:     cfg.Auth.OIDC.ClientID,
+			ClientSecret: cfg.Auth.OIDC.ClientSecret,
+			Scopes:       cfg.Auth.OIDC.Scopes,
+		}, nil)
+	}
+
 	// TODO refactor from OldBlogHandler
 	// blogHandler := handlers.OldBlogHandler(repo, db, renderer, cfg.App.Name, needsInvite, cfg.Auth.InviteCode, logger, geo, tel.Tracer, metrics, session, start)
 
-	handlerCfg := handlers.HandlerConfig{
-		Title:       cfg.App.Name,
-		NeedsInvite: needsInvite,
-		InviteCode:  cfg.Auth.InviteCode,
-		DB:          db,
-		S3:          *s3Store,
-		GeoStats:    geo,
-		Renderer:    renderer,
-		Logger:      logger,
-		Tracer:      tel.Tracer,
-		Metrics:     metrics,
-		Sessions:    session,
-		StartTime:   start,
-	}
-
-	blogHandler := handlers.NewHandler(handlerCfg)
+	// the bayes filter learns from moderators and stays quiet until it has seen some spam and some ham
+	bayes := spam.Bayes{DB: db, MinDocs: 10}
 
 	// cheap cheap one cpu thread vps?
 	numProcs := max(1, runtime.GOMAXPROCS(0)-1)
@@ -300,9 +338,53 @@ func main() {
 		os.Exit(1)
 	}
 
+	handlerCfg := handlers.HandlerConfig{
+		Title:             cfg.App.Name,
+		BaseURL:           cfg.App.BaseURL,
+		TrustedProxy:      cfg.Proxy.Trusted,
+		NeedsInvite:       needsInvite,
+		InviteCode:        cfg.Auth.InviteCode,
+		DB:                db,
+		S3:                s3Store,
+		Images:            imgProcessor,
+		GeoStats:          geo,
+		Renderer:          renderer,
+		PostCache:         postCache,
+		Logger:            logger,
+		Tracer:            tel.Tracer,
+		Metrics:           metrics,
+		Sessions:          session,
+		StartTime:         start,
+		CommentEditWindow: cfg.Comments.EditWindow,
+		Mail:              outbox,
+		TOTPKey:           totpKey,
+		OIDC:              sso,
+		LoginPolicy: storage.LoginPolicy{
+			FreeAttempts: 3,
+			LockAfter:    int64(cfg.Auth.LockoutAfter),
+			Lockout:      cfg.Auth.LockoutDuration,
+		},
+		Audit: audit,
+		Spam: &spam.Classifier{
+			Scorers: []spam.SpamScorer{
+				spam.LinkDensity{MaxLinks: 3},
+				spam.Repeated{DB: db, Window: 7 * 24 * time.Hour},
+				spam.AccountAge{DB: db, MinAge: cfg.Comments.SpamMinAccountAge},
+				bayes,
+			},
+			Trainers:    []spam.Trainer{bayes},
+			HoldScore:   cfg.Comments.SpamHoldScore,
+			RejectScore: cfg.Comments.SpamRejectScore,
+			Metrics:     metrics,
+			Logger:      logger,
+		},
+	}
+
+	blogHandler := handlers.NewHandler(handlerCfg)
+
 	assetHandler := &handlers.AssetHandler{Assets: assetManager, Processor: imgProcessor, Tracer: tel.Tracer, Metrics: metrics, Logger: logger}
 
-	csrf := middleware.NewCSRF(cfg.App.Environment == "prod", blogHandler.RenderError)
+	csrf := middleware.NewCSRF(cfg.App.Environment == "prod", blogHandler.RenderError, audit)
 	csp := middleware.NewCSP(cfg.App.Environment == "prod")
 
 	routerDeps := router.RouterDependencies{
@@ -28,8 +28,10 @@ require (
 	go.opentelemetry.io/otel/trace v1.40.0
 	golang.org/x/crypto v0.47.0
 	golang.org/x/image v0.36.0
+	golang.org/x/sync v0.19.0
 	golang.org/x/time v0.14.0
 	modernc.org/sqlite v1.44.3
+	rsc.io/qr v0.2.0
 )
 
 require (
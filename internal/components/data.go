@@ -1,7 +1,167 @@
 package components
 
+import (
+	"blogengine/internal/middleware"
+	"blogengine/internal/storage"
+	"time"
+)
+
 type CommonData struct {
 	Title     string
 	Username  string
 	CSRFToken string
+	FeedURL   string
+}
+
+// SearchPage holds the state of a search form and its results
+type SearchPage struct {
+	Action  string // url the search form submits to
+	Query   string
+	Page    int
+	HasNext bool
+	Results []*storage.SearchResult
+}
+
+// MembershipPage is the join/leave page of a blog, Member is nil for readers who haven't joined
+type MembershipPage struct {
+	Blog      *storage.Blog
+	Member    *storage.BlogMember
+	Invite    string // token from an invite link
+	InviteURL string // freshly created invite link, only shown to the owner
+	Error     string
+}
+
+// MembersPage lists a page of the members of a blog to its owner
+type MembersPage struct {
+	Blog    *storage.Blog
+	Members []*storage.BlogMember
+	Page    int
+	HasNext bool
+	Notice  string
+}
+
+// BlogForm holds the values and inline errors of the dashboard blog forms, BlogID is 0 for a new blog
+type BlogForm struct {
+	BlogID            int64
+	Slug              string
+	Title             string
+	Description       string
+	Visibility        storage.Visibility
+	RegistrationMode  storage.RegistrationMode
+	RegistrationLimit string
+	CommentPolicy     storage.CommentPolicy
+	Errors            map[string]string // keyed by form field, "" for errors not tied to one
+}
+
+// PostForm holds the values and inline errors of the post editor, PostID is 0 for a new post
+type PostForm struct {
+	PostID        int64
+	BlogSlug      string
+	Title         string
+	Slug          string
+	Description   string
+	Category      string
+	Tags          string // comma separated
+	Body          string // markdown
+	RequiresAuth  bool
+	IsListed      bool
+	AllowComments bool
+	Publish       bool
+	PublishedAt   *time.Time
+	Errors        map[string]string // keyed by form field, "" for errors not tied to one
+}
+
+// AccountPage holds the account settings of the logged in user, Notice confirms the last change
+type AccountPage struct {
+	Sessions          []middleware.SessionInfo
+	Notice            string
+	Errors            map[string]string // keyed by form field, "" for errors not tied to one
+	TwoFactor         bool              // an authenticator is enrolled
+	TwoFactorOff      bool              // no key is configured to seal secrets, enrolment is unavailable
+	RecoveryCodesLeft int64
+	Passkeys          []*storage.Passkey
+	Profile           *storage.User // fills the profile forms
+	Passwordless      bool          // the account has no password, a fresh login at Provider stands in for it
+	Reauthenticated   bool          // the passwordless user did that login recently
+	Provider          string        // name of the OIDC provider, "" when there is none
+}
+
+// SingleSignOn is the button of the OpenID Connect provider on the login page, an empty URL hides it
+type SingleSignOn struct {
+	Name string
+	URL  string
+}
+
+// OIDCLinkPage is shown after the first login with an identity of the provider: it is linked to an existing account
+// or gets a new one. Username is a suggestion for the new account
+type OIDCLinkPage struct {
+	Provider      string
+	Username      string
+	NeedsInvite   bool
+	LinkError     string
+	RegisterError string
+}
+
+// TwoFactorSetupPage enrols an authenticator, QRCode is an inline SVG of the otpauth:// link and Secret its base32 form
+// for typing in by hand
+type TwoFactorSetupPage struct {
+	QRCode string
+	Secret string
+	Error  string
+}
+
+// TokensPage lists the api tokens of a user, NewToken is the plaintext of a token just created and is only shown once
+type TokensPage struct {
+	Tokens    []*storage.APIToken
+	NewToken  string
+	Name      string
+	Scope     storage.TokenScope
+	ExpiresIn string            // days, "" never expires
+	Errors    map[string]string // keyed by form field, "" for errors not tied to one
+}
+
+// CommentSection holds the comments of a post and what the viewer can do with them
+type CommentSection struct {
+	BlogSlug      string
+	PostSlug      string
+	AllowComments bool
+	Comments      []*storage.Comment
+	EditableAfter time.Time                            // the viewer can edit their comments posted after this
+	Moderator     bool                                 // the viewer owns or edits the blog and can remove comments
+	Revisions     map[int64][]*storage.CommentRevision // prior versions by comment id, nil unless the viewer moderates the blog
+	Pager         Pager                                // pages of older and newer threads
+}
+
+// CanEdit reports whether the viewer called username can still edit comment
+func (s CommentSection) CanEdit(username string, comment *storage.Comment) bool {
+	return username != "" && username == comment.AuthorName && !comment.IsDeleted() && comment.CreatedAt.After(s.EditableAfter)
+}
+
+// Pager links the pages around the one shown of a listing paged by cursor, "" when there is no such page
+type Pager struct {
+	Newer string // cursor the page of newer items ends before
+	Older string // cursor the page of older items starts after
+}
+
+// AdminLoginsPage lists the usernames logins are refused to after too many failures
+type AdminLoginsPage struct {
+	Blocked []*storage.LoginThrottle
+	Notice  string
+}
+
+// AdminAuditPage lists audit events matching Filter, the latest first. OlderURL is empty on the last page
+type AdminAuditPage struct {
+	Events    []*storage.AuditEvent
+	Filter    storage.AuditFilter
+	OlderURL  string
+	ExportURL string
+}
+
+// ProfilePage is the public profile of a user with their public blogs and a page of their comments
+type ProfilePage struct {
+	User     *storage.User
+	Blogs    []*storage.Blog
+	Comments []*storage.UserComment
+	Page     int
+	HasNext  bool
 }
@@ -1,10 +1,13 @@
 package config
 
 import (
+	"encoding/hex"
 	"fmt"
 	"log/slog"
+	"net/mail"
 	"net/url"
 	"os"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -39,6 +42,8 @@ type AppConfig struct {
 	Environment    string // 'dev' | 'prod'
 	SourcesDir     string
 	AssetNamespace string
+	BaseURL        string // absolute URL used in feeds, empty means derive it from the request
+	PostCacheMB    int    // memory kept for rendered posts, 0 renders every request
 }
 
 type DBConfig struct {
@@ -59,6 +64,39 @@ type TelemetryConfig struct {
 type AuthConfig struct {
 	SessionSecret string
 	InviteCode    string
+	TOTPKey       string // hex AES-256 key sealing two-factor secrets, empty disables enrolment
+	AdminPassword string // first password of the bootstrapped admin, empty generates one
+	OIDC          OIDCConfig
+
+	LockoutAfter    int           // failed logins in a row that lock a username out
+	LockoutDuration time.Duration // how long it stays locked, also the longest backoff before that
+}
+
+// OIDCConfig is an OpenID Connect provider users can log in with, an empty Issuer turns it off
+type OIDCConfig struct {
+	Name         string // shown on the login button
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+type CommentsConfig struct {
+	EditWindow        time.Duration // how long authors can edit a comment after posting it, 0 disables editing
+	SpamHoldScore     float64       // spam score from which new comments wait for a moderator
+	SpamRejectScore   float64       // spam score from which new comments are filed as spam
+	SpamMinAccountAge time.Duration // accounts younger than this add to the spam score of their comments
+}
+
+type MailConfig struct {
+	Transport    string // 'smtp' | 'file' | 'console'
+	From         string
+	Dir          string // where the file transport writes .eml files
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPTLS      string // 'starttls' | 'tls' | 'none'
 }
 
 type S3Config struct {
@@ -79,6 +117,8 @@ type Config struct {
 	Logger      LoggerConfig
 	Metrics     TelemetryConfig
 	Auth        AuthConfig
+	Comments    CommentsConfig
+	Mail        MailConfig
 }
 
 func DefaultConfig() *Config {
@@ -88,6 +128,7 @@ func DefaultConfig() *Config {
 			Environment:    "dev",
 			SourcesDir:     "./sources",
 			AssetNamespace: "570e8400-c29b-45d4-a716-446655440700",
+			PostCacheMB:    32,
 		},
 		DB: DBConfig{
 			Path:           "blogengine.db",
@@ -126,6 +167,25 @@ func DefaultConfig() *Config {
 		Auth: AuthConfig{
 			SessionSecret: "very-secret-key-change-me-in-production",
 			InviteCode:    "",
+			OIDC: OIDCConfig{
+				Name:   "single sign-on",
+				Scopes: []string{"openid", "email", "profile"},
+			},
+			LockoutAfter:    10,
+			LockoutDuration: 15 * time.Minute,
+		},
+		Comments: CommentsConfig{
+			EditWindow:        15 * time.Minute,
+			SpamHoldScore:     0.5,
+			SpamRejectScore:   0.95,
+			SpamMinAccountAge: 24 * time.Hour,
+		},
+		Mail: MailConfig{
+			Transport: "console",
+			From:      "noreply@localhost",
+			Dir:       "./mail",
+			SMTPPort:  587,
+			SMTPTLS:   "starttls",
 		},
 	}
 }
@@ -138,6 +198,8 @@ func LoadWithDefaults() *Config {
 			Environment:    getEnv("APP_ENV", defaults.App.Environment),
 			SourcesDir:     getEnv("APP_SOURCES_DIR", defaults.App.SourcesDir),
 			AssetNamespace: getEnv("ASSET_NAMESPACE", defaults.App.AssetNamespace),
+			BaseURL:        strings.TrimRight(getEnv("APP_BASE_URL", defaults.App.BaseURL), "/"),
+			PostCacheMB:    getEnvAsInt("POST_CACHE_MB", defaults.App.PostCacheMB),
 		},
 		DB: DBConfig{
 			Path:           getEnv("DB_PATH", defaults.DB.Path),
@@ -178,6 +240,33 @@ func LoadWithDefaults() *Config {
 		Auth: AuthConfig{
 			SessionSecret: getEnv("SESSION_SECRET", defaults.Auth.SessionSecret),
 			InviteCode:    getEnv("INVITE_CODE", defaults.Auth.InviteCode),
+			TOTPKey:       getEnv("TOTP_ENCRYPTION_KEY", defaults.Auth.TOTPKey),
+			AdminPassword: getEnv("BOOTSTRAP_ADMIN_PASSWORD", defaults.Auth.AdminPassword),
+			OIDC: OIDCConfig{
+				Name:         getEnv("OIDC_NAME", defaults.Auth.OIDC.Name),
+				Issuer:       strings.TrimRight(getEnv("OIDC_ISSUER", defaults.Auth.OIDC.Issuer), "/"),
+				ClientID:     getEnv("OIDC_CLIENT_ID", defaults.Auth.OIDC.ClientID),
+				ClientSecret: getEnv("OIDC_CLIENT_SECRET", defaults.Auth.OIDC.ClientSecret),
+				Scopes:       strings.Fields(getEnv("OIDC_SCOPES", strings.Join(defaults.Auth.OIDC.Scopes, " "))),
+			},
+			LockoutAfter:    getEnvAsInt("LOGIN_LOCKOUT_AFTER", defaults.Auth.LockoutAfter),
+			LockoutDuration: getEnvAsDuration("LOGIN_LOCKOUT_DURATION", defaults.Auth.LockoutDuration),
+		},
+		Comments: CommentsConfig{
+			EditWindow:        getEnvAsDuration("COMMENT_EDIT_WINDOW", defaults.Comments.EditWindow),
+			SpamHoldScore:     getEnvAsFloat("SPAM_HOLD_SCORE", defaults.Comments.SpamHoldScore),
+			SpamRejectScore:   getEnvAsFloat("SPAM_REJECT_SCORE", defaults.Comments.SpamRejectScore),
+			SpamMinAccountAge: getEnvAsDuration("SPAM_MIN_ACCOUNT_AGE", defaults.Comments.SpamMinAccountAge),
+		},
+		Mail: MailConfig{
+			Transport:    getEnv("MAIL_TRANSPORT", defaults.Mail.Transport),
+			From:         getEnv("SMTP_FROM", defaults.Mail.From),
+			Dir:          getEnv("MAIL_DIR", defaults.Mail.Dir),
+			SMTPHost:     getEnv("SMTP_HOST", defaults.Mail.SMTPHost),
+			SMTPPort:     getEnvAsInt("SMTP_PORT", defaults.Mail.SMTPPort),
+			SMTPUsername: getEnv("SMTP_USERNAME", defaults.Mail.SMTPUsername),
+			SMTPPassword: getEnv("SMTP_PASSWORD", defaults.Mail.SMTPPassword),
+			SMTPTLS:      getEnv("SMTP_TLS", defaults.Mail.SMTPTLS),
 		},
 	}
 }
@@ -214,6 +303,19 @@ func getEnvAsInt(key string, fallback int) int {
 	return value
 }
 
+func getEnvAsFloat(key string, fallback float64) float64 {
+	valueStr, ok := os.LookupEnv(key)
+	if !ok {
+		return fallback
+	}
+
+	value, err := strconv.ParseFloat(valueStr, 64)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
 func getEnvAsDuration(key string, fallback time.Duration) time.Duration {
 	valueStr, ok := os.LookupEnv(key)
 	if !ok {
@@ -273,12 +375,27 @@ func (c *Config) Validate() error {
 	if c.HTTP.Timeouts.Shutdown <= 0 {
 		return fmt.Errorf("HTTP_SHUTDOWN_DELAY must be positive (e.g., 10s), got %s", c.HTTP.Timeouts.Shutdown)
 	}
+	if c.App.PostCacheMB < 0 {
+		return fmt.Errorf("POST_CACHE_MB must not be negative (0 disables the cache), got %d", c.App.PostCacheMB)
+	}
 	if c.Limiter.RPS <= 0 {
 		return fmt.Errorf("LIMITER_RPS must be positive, got %d", c.Limiter.RPS)
 	}
 	if c.Limiter.Burst <= 0 {
 		return fmt.Errorf("LIMITER_BURST must be positive, got %d", c.Limiter.Burst)
 	}
+	if c.Comments.EditWindow < 0 {
+		return fmt.Errorf("COMMENT_EDIT_WINDOW must not be negative (e.g., 15m, 0 disables editing), got %s", c.Comments.EditWindow)
+	}
+	if c.Comments.SpamHoldScore <= 0 || c.Comments.SpamHoldScore > 1 {
+		return fmt.Errorf("SPAM_HOLD_SCORE must be above 0 and at most 1, got %g", c.Comments.SpamHoldScore)
+	}
+	if c.Comments.SpamRejectScore < c.Comments.SpamHoldScore || c.Comments.SpamRejectScore > 1 {
+		return fmt.Errorf("SPAM_REJECT_SCORE must be between SPAM_HOLD_SCORE and 1, got %g", c.Comments.SpamRejectScore)
+	}
+	if c.Comments.SpamMinAccountAge < 0 {
+		return fmt.Errorf("SPAM_MIN_ACCOUNT_AGE must not be negative (e.g., 24h, 0 disables it), got %s", c.Comments.SpamMinAccountAge)
+	}
 	if c.App.Environment == "prod" {
 		if c.Auth.SessionSecret == "" {
 			return fmt.Errorf("SESSION_SECRET must not be empty in production")
@@ -287,12 +404,71 @@ func (c *Config) Validate() error {
 			return fmt.Errorf("SESSION_SECRET must be changed from default value for production")
 		}
 	}
+	// mail
+	if _, err := mail.ParseAddress(c.Mail.From); err != nil {
+		return fmt.Errorf("SMTP_FROM must be an email address (e.g., Blog <noreply@example.com>), got %q", c.Mail.From)
+	}
+	switch c.Mail.Transport {
+	case "smtp":
+		if c.Mail.SMTPHost == "" {
+			return fmt.Errorf("SMTP_HOST must not be empty with MAIL_TRANSPORT=smtp")
+		}
+		// the links of password resets can't come from the Host header of whoever asked for them
+		if c.App.BaseURL == "" {
+			return fmt.Errorf("APP_BASE_URL must not be empty with MAIL_TRANSPORT=smtp")
+		}
+		if p := c.Mail.SMTPPort; p < 1 || p > 65535 {
+			return fmt.Errorf("SMTP_PORT must be between 1 and 65535, got %d", p)
+		}
+		if t := c.Mail.SMTPTLS; t != "starttls" && t != "tls" && t != "none" {
+			return fmt.Errorf("SMTP_TLS must be 'starttls', 'tls' or 'none', got %q", t)
+		}
+	case "file":
+		if c.Mail.Dir == "" {
+			return fmt.Errorf("MAIL_DIR must not be empty with MAIL_TRANSPORT=file")
+		}
+	case "console":
+	default:
+		return fmt.Errorf("MAIL_TRANSPORT must be 'smtp', 'file' or 'console', got %q", c.Mail.Transport)
+	}
 	if _, err := uuid.FromString(c.App.AssetNamespace); err != nil {
 		return fmt.Errorf("ASSET_NAMESPACE must be a valid UUID")
 	}
+	if c.App.BaseURL != "" {
+		u, err := url.Parse(c.App.BaseURL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("APP_BASE_URL must be an absolute URL (e.g., https://blog.example.com), got %q", c.App.BaseURL)
+		}
+	}
 	if len(c.Auth.InviteCode) > 50 {
 		return fmt.Errorf("INVITE_CODE is too long (max 25 ascii chars/bytes)")
 	}
+	if c.Auth.TOTPKey != "" {
+		if key, err := hex.DecodeString(c.Auth.TOTPKey); err != nil || len(key) != 32 {
+			return fmt.Errorf("TOTP_ENCRYPTION_KEY must be 64 hex ascii characters long (e.g., openssl rand -hex 32)")
+		}
+	}
+	if p := c.Auth.AdminPassword; p != "" && len(p) < 8 {
+		return fmt.Errorf("BOOTSTRAP_ADMIN_PASSWORD must be at least 8 characters long, leave it empty to generate one")
+	}
+	if c.Auth.LockoutAfter < 1 {
+		return fmt.Errorf("LOGIN_LOCKOUT_AFTER must be at least 1, got %d", c.Auth.LockoutAfter)
+	}
+	if c.Auth.LockoutDuration < time.Second {
+		return fmt.Errorf("LOGIN_LOCKOUT_DURATION must be at least a second (e.g., 15m), got %s", c.Auth.LockoutDuration)
+	}
+	if c.Auth.OIDC.Issuer != "" {
+		u, err := url.Parse(c.Auth.OIDC.Issuer)
+		if err != nil || u.Host == "" || (u.Scheme != "https" && (u.Scheme != "http" || c.App.Environment == "prod")) {
+			return fmt.Errorf("OIDC_ISSUER must be an https URL (e.g., https://accounts.example.com), got %q", c.Auth.OIDC.Issuer)
+		}
+		if c.Auth.OIDC.ClientID == "" {
+			return fmt.Errorf("OIDC_CLIENT_ID must not be empty with OIDC_ISSUER set")
+		}
+		if !slices.Contains(c.Auth.OIDC.Scopes, "openid") {
+			return fmt.Errorf("OIDC_SCOPES must include openid, got %q", strings.Join(c.Auth.OIDC.Scopes, " "))
+		}
+	}
 	// object storage
 	if _, err := url.Parse(c.ObjectStore.Endpoint); err != nil {
 		return fmt.Errorf("%q is not a valid S3_ENDPOINT", c.ObjectStore.Endpoint)
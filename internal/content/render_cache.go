@@ -0,0 +1,112 @@
+package content
+
+import (
+	"container/list"
+	"sync"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// RenderCache keeps the html of rendered posts in memory, up to maxBytes of it. Entries are keyed by the object key of
+// the markdown and the version of the object they were rendered from, a new upload is a miss even when nobody
+// invalidated the old entry. The least recently used entries make room for new ones
+type RenderCache struct {
+	maxBytes int
+
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element // by object key, one version each
+	recent  *list.List               // most recently used first
+
+	renders singleflight.Group
+}
+
+type renderEntry struct {
+	key     string
+	version string
+	html    []byte
+}
+
+func NewRenderCache(maxBytes int) *RenderCache {
+	return &RenderCache{maxBytes: maxBytes, entries: make(map[string]*list.Element), recent: list.New()}
+}
+
+// Get returns the html rendered from version of the object at key, hit tells whether it was cached. On a miss render
+// makes it, concurrent misses for the same version wait for a single render and share its result
+func (c *RenderCache) Get(key, version string, render func() ([]byte, error)) (html []byte, hit bool, err error) {
+	if html, ok := c.lookup(key, version); ok {
+		return html, true, nil
+	}
+
+	v, err, _ := c.renders.Do(key+"\x00"+version, func() (any, error) {
+		html, err := render()
+		if err != nil {
+			return nil, err
+		}
+		c.add(key, version, html)
+		return html, nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return v.([]byte), false, nil
+}
+
+// Invalidate drops the html of the object at key, for uploads that keep the version the cache saw. A nil cache has
+// nothing to drop
+func (c *RenderCache) Invalidate(key string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+}
+
+// Len is the number of posts cached
+func (c *RenderCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
+
+func (c *RenderCache) lookup(key, version string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || e.Value.(*renderEntry).version != version {
+		return nil, false
+	}
+	c.recent.MoveToFront(e)
+	return e.Value.(*renderEntry).html, true
+}
+
+// add stores html in place of any other version of key, html bigger than the whole cache is not kept
+func (c *RenderCache) add(key, version string, html []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.remove(e)
+	}
+	if len(html) > c.maxBytes {
+		return
+	}
+
+	for c.size+len(html) > c.maxBytes {
+		c.remove(c.recent.Back())
+	}
+	c.entries[key] = c.recent.PushFront(&renderEntry{key: key, version: version, html: html})
+	c.size += len(html)
+}
+
+func (c *RenderCache) remove(e *list.Element) {
+	entry := c.recent.Remove(e).(*renderEntry)
+	delete(c.entries, entry.key)
+	c.size -= len(entry.html)
+}
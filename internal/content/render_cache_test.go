@@ -0,0 +1,114 @@
+package content
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRenderCache(t *testing.T) {
+	t.Parallel()
+
+	c := NewRenderCache(10)
+	var renders int
+	get := func(key, version, html string) (string, bool) {
+		t.Helper()
+		out, hit, err := c.Get(key, version, func() ([]byte, error) {
+			renders++
+			return []byte(html), nil
+		})
+		if err != nil {
+			t.Fatalf("get %s@%s: %v", key, version, err)
+		}
+		return string(out), hit
+	}
+
+	if html, hit := get("a", "1", "aaaa"); hit || html != "aaaa" {
+		t.Fatalf("first get: want a miss rendering aaaa, got %q hit=%v", html, hit)
+	}
+	if html, hit := get("a", "1", "xxxx"); !hit || html != "aaaa" {
+		t.Fatalf("same version: want the cached aaaa, got %q hit=%v", html, hit)
+	}
+	if html, hit := get("a", "2", "AAAA"); hit || html != "AAAA" {
+		t.Fatalf("new version: want a miss rendering AAAA, got %q hit=%v", html, hit)
+	}
+	if c.Len() != 1 {
+		t.Fatalf("versions of a key: want 1 entry, got %d", c.Len())
+	}
+
+	// reading a after b leaves b the least recently used, it makes room for c
+	get("b", "1", "bbbb")
+	get("a", "2", "")
+	get("c", "1", "cccc")
+	if _, hit := get("b", "1", "bbbb"); hit {
+		t.Fatal("full cache: want the least recently used entry evicted")
+	}
+	if _, hit := get("c", "1", "cccc"); !hit {
+		t.Fatal("full cache: want the newest entry kept")
+	}
+
+	c.Invalidate("c")
+	if _, hit := get("c", "1", "cccc"); hit {
+		t.Fatal("invalidated key: want a miss")
+	}
+
+	if html, hit := get("big", "1", "more than ten bytes"); hit || html != "more than ten bytes" {
+		t.Fatalf("oversized html: want it rendered, got %q", html)
+	}
+	if _, hit := get("big", "1", "more than ten bytes"); hit {
+		t.Fatal("oversized html: want it left out of the cache")
+	}
+	if renders != 8 {
+		t.Fatalf("renders: want 8, got %d", renders)
+	}
+}
+
+func TestRenderCacheError(t *testing.T) {
+	t.Parallel()
+
+	c := NewRenderCache(1 << 10)
+	errRender := errors.New("render failed")
+	if _, _, err := c.Get("a", "1", func() ([]byte, error) { return nil, errRender }); !errors.Is(err, errRender) {
+		t.Fatalf("failed render: want %v, got %v", errRender, err)
+	}
+	if c.Len() != 0 {
+		t.Fatalf("failed render: want nothing cached, got %d entries", c.Len())
+	}
+}
+
+func TestRenderCacheCollapsesMisses(t *testing.T) {
+	t.Parallel()
+
+	c := NewRenderCache(1 << 10)
+	var renders atomic.Int32
+	release := make(chan struct{})
+	html := []byte("<p>post</p>")
+
+	const readers = 20
+	var started, done sync.WaitGroup
+	started.Add(readers)
+	done.Add(readers)
+	for range readers {
+		go func() {
+			defer done.Done()
+			started.Done()
+			out, _, err := c.Get("a", "1", func() ([]byte, error) {
+				renders.Add(1)
+				<-release
+				return html, nil
+			})
+			if err != nil || !bytes.Equal(out, html) {
+				t.Errorf("concurrent get: want %q, got %q %v", html, out, err)
+			}
+		}()
+	}
+	started.Wait()
+	close(release)
+	done.Wait()
+
+	if n := renders.Load(); n != 1 {
+		t.Fatalf("concurrent misses: want 1 render, got %d", n)
+	}
+}
@@ -0,0 +1,155 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+)
+
+var (
+	ErrEmptyPassphrase = errors.New("passphrase must not be empty")
+	ErrInvalidIV       = errors.New("invalid encryption iv")
+	ErrCiphertext      = errors.New("ciphertext is too short")
+	ErrKeyLength       = errors.New("key must be 32 bytes")
+	ErrDecrypt         = errors.New("could not decrypt, wrong passphrase or tampered content")
+)
+
+const (
+	saltLen  = 16
+	keyLen   = 32 // AES-256
+	nonceLen = 12 // GCM standard nonce
+
+	// argon2id parameters, the OWASP minimum so unlocking stays cheap on a small vps
+	argonTime    = 2
+	argonMemory  = 19 * 1024 // KiB
+	argonThreads = 1
+)
+
+// NewIV returns a random base64 encoded GCM nonce, stored in the post's encryption_iv
+func NewIV() (string, error) {
+	iv := make([]byte, nonceLen)
+	if _, err := rand.Read(iv); err != nil {
+		return "", fmt.Errorf("could not generate iv: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(iv), nil
+}
+
+// Encrypt seals plaintext with a key derived from passphrase. The random salt is prepended to the
+// ciphertext so the key can be derived again from the stored object and the passphrase alone.
+// additionalData binds the ciphertext to where it is stored so it can't be swapped between posts
+func Encrypt(plaintext []byte, passphrase, iv string, additionalData []byte) ([]byte, error) {
+	if passphrase == "" {
+		return nil, ErrEmptyPassphrase
+	}
+
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("could not generate salt: %w", err)
+	}
+
+	gcm, nonce, err := newGCM(deriveKey(passphrase, salt), iv)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, saltLen+len(plaintext)+gcm.Overhead())
+	out = append(out, salt...)
+	return gcm.Seal(out, nonce, plaintext, additionalData), nil
+}
+
+// UnlockKey derives the key for ciphertext from passphrase, check it with Decrypt before trusting it
+func UnlockKey(ciphertext []byte, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, ErrEmptyPassphrase
+	}
+	if len(ciphertext) < saltLen {
+		return nil, ErrCiphertext
+	}
+	return deriveKey(passphrase, ciphertext[:saltLen]), nil
+}
+
+// Decrypt opens ciphertext produced by Encrypt with a key from UnlockKey
+func Decrypt(ciphertext, key []byte, iv string, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < saltLen {
+		return nil, ErrCiphertext
+	}
+
+	gcm, nonce, err := newGCM(key, iv)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext[saltLen:], additionalData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// Seal encrypts plaintext with a 32 byte key from config, the random nonce is prepended to the ciphertext.
+// additionalData binds the ciphertext to its row, see Encrypt
+func Seal(plaintext, key, additionalData []byte) ([]byte, error) {
+	iv, err := NewIV()
+	if err != nil {
+		return nil, err
+	}
+	gcm, nonce, err := newGCM(key, iv)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(nonce)+len(plaintext)+gcm.Overhead())
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts ciphertext produced by Seal with the same key and additionalData
+func Open(ciphertext, key, additionalData []byte) ([]byte, error) {
+	if len(key) != keyLen {
+		return nil, ErrKeyLength
+	}
+	if len(ciphertext) < nonceLen {
+		return nil, ErrCiphertext
+	}
+
+	gcm, nonce, err := newGCM(key, base64.StdEncoding.EncodeToString(ciphertext[:nonceLen]))
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext[nonceLen:], additionalData)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+func deriveKey(passphrase string, salt []byte) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, argonTime, argonMemory, argonThreads, keyLen)
+}
+
+func newGCM(key []byte, iv string) (cipher.AEAD, []byte, error) {
+	if len(key) != keyLen {
+		return nil, nil, ErrKeyLength
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not create gcm: %w", err)
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(iv)
+	if err != nil || len(nonce) != gcm.NonceSize() {
+		return nil, nil, ErrInvalidIV
+	}
+	return gcm, nonce, nil
+}
@@ -0,0 +1,162 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	t.Parallel()
+
+	plaintext := []byte("# secret\n\nonly for friends")
+	aad := []byte("a-blog/abcdefghijkl")
+
+	iv, err := NewIV()
+	if err != nil {
+		t.Fatalf("could not create iv: %s", err)
+	}
+	ciphertext, err := Encrypt(plaintext, "correct horse", iv, aad)
+	if err != nil {
+		t.Fatalf("could not encrypt: %s", err)
+	}
+	if bytes.Contains(ciphertext, []byte("secret")) {
+		t.Fatal("ciphertext contains plaintext")
+	}
+
+	tampered := bytes.Clone(ciphertext)
+	tampered[len(tampered)-1] ^= 0xff
+
+	otherIV, err := NewIV()
+	if err != nil {
+		t.Fatalf("could not create iv: %s", err)
+	}
+
+	tests := []struct {
+		name       string
+		passphrase string
+		ciphertext []byte
+		iv         string
+		aad        []byte
+		wantErr    error
+	}{
+		{
+			name:       "nominal",
+			passphrase: "correct horse", ciphertext: ciphertext, iv: iv, aad: aad,
+			wantErr: nil,
+		},
+		{
+			name:       "wrong passphrase",
+			passphrase: "battery staple", ciphertext: ciphertext, iv: iv, aad: aad,
+			wantErr: ErrDecrypt,
+		},
+		{
+			name:       "moved to another key",
+			passphrase: "correct horse", ciphertext: ciphertext, iv: iv, aad: []byte("a-blog/otherpostid1"),
+			wantErr: ErrDecrypt,
+		},
+		{
+			name:       "tampered",
+			passphrase: "correct horse", ciphertext: tampered, iv: iv, aad: aad,
+			wantErr: ErrDecrypt,
+		},
+		{
+			name:       "wrong iv",
+			passphrase: "correct horse", ciphertext: ciphertext, iv: otherIV, aad: aad,
+			wantErr: ErrDecrypt,
+		},
+		{
+			name:       "malformed iv",
+			passphrase: "correct horse", ciphertext: ciphertext, iv: "not base64!", aad: aad,
+			wantErr: ErrInvalidIV,
+		},
+		{
+			name:       "truncated",
+			passphrase: "correct horse", ciphertext: ciphertext[:saltLen-1], iv: iv, aad: aad,
+			wantErr: ErrCiphertext,
+		},
+		{
+			name:       "empty passphrase",
+			passphrase: "", ciphertext: ciphertext, iv: iv, aad: aad,
+			wantErr: ErrEmptyPassphrase,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			key, err := UnlockKey(tt.ciphertext, tt.passphrase)
+			if err == nil {
+				var got []byte
+				got, err = Decrypt(tt.ciphertext, key, tt.iv, tt.aad)
+				if err == nil && !bytes.Equal(got, plaintext) {
+					t.Fatalf("plaintext: want %q, got %q", plaintext, got)
+				}
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("errors: want %s, got %s", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestEncryptUsesFreshSalt(t *testing.T) {
+	t.Parallel()
+
+	iv, err := NewIV()
+	if err != nil {
+		t.Fatalf("could not create iv: %s", err)
+	}
+	a, err := Encrypt([]byte("same"), "pass", iv, nil)
+	if err != nil {
+		t.Fatalf("could not encrypt: %s", err)
+	}
+	b, err := Encrypt([]byte("same"), "pass", iv, nil)
+	if err != nil {
+		t.Fatalf("could not encrypt: %s", err)
+	}
+	if bytes.Equal(a, b) {
+		t.Fatal("two encryptions produced the same ciphertext")
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	t.Parallel()
+
+	key := bytes.Repeat([]byte{7}, 32)
+	aad := []byte("user:1")
+
+	sealed, err := Seal([]byte("totp secret"), key, aad)
+	if err != nil {
+		t.Fatalf("could not seal: %s", err)
+	}
+
+	tests := []struct {
+		name       string
+		key        []byte
+		ciphertext []byte
+		aad        []byte
+		wantErr    error
+	}{
+		{name: "nominal", key: key, ciphertext: sealed, aad: aad},
+		{name: "wrong key", key: bytes.Repeat([]byte{8}, 32), ciphertext: sealed, aad: aad, wantErr: ErrDecrypt},
+		{name: "moved to another user", key: key, ciphertext: sealed, aad: []byte("user:2"), wantErr: ErrDecrypt},
+		{name: "short key", key: key[:16], ciphertext: sealed, aad: aad, wantErr: ErrKeyLength},
+		{name: "truncated", key: key, ciphertext: sealed[:nonceLen-1], aad: aad, wantErr: ErrCiphertext},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := Open(tt.ciphertext, tt.key, tt.aad)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("errors: want %s, got %s", tt.wantErr, err)
+			}
+			if err == nil && string(got) != "totp secret" {
+				t.Fatalf("plaintext: want %q, got %q", "totp secret", got)
+			}
+		})
+	}
+}
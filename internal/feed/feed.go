@@ -0,0 +1,222 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	AtomContentType = "application/atom+xml; charset=utf-8"
+	RSSContentType  = "application/rss+xml; charset=utf-8"
+)
+
+// Entry is a single syndicated post, independent of the output format
+type Entry struct {
+	Link      string // absolute permalink, doubles as the entry id
+	Title     string
+	Author    string
+	Summary   string
+	Content   string // full html, may be empty for protected posts
+	Published time.Time
+	Updated   time.Time
+}
+
+// Feed describes a channel of entries that can be written as Atom or RSS 2.0
+type Feed struct {
+	Title    string
+	Subtitle string
+	Link     string // html page the feed belongs to
+	SelfLink string // absolute url of the feed document itself
+	Updated  time.Time
+	Entries  []Entry
+}
+
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle,omitempty"`
+	Updated  string      `xml:"updated"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr"`
+	Body string `xml:",chardata"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Link      atomLink   `xml:"link"`
+	Author    atomAuthor `xml:"author"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Summary   *atomText  `xml:"summary,omitempty"`
+	Content   *atomText  `xml:"content,omitempty"`
+}
+
+type rssFeed struct {
+	XMLName   xml.Name   `xml:"rss"`
+	Version   string     `xml:"version,attr"`
+	ContentNS string     `xml:"xmlns:content,attr"`
+	AtomNS    string     `xml:"xmlns:atom,attr"`
+	DCNS      string     `xml:"xmlns:dc,attr"`
+	Channel   rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title         string    `xml:"title"`
+	Link          string    `xml:"link"`
+	Description   string    `xml:"description"`
+	SelfLink      atomLink  `xml:"atom:link"`
+	LastBuildDate string    `xml:"lastBuildDate"`
+	Items         []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	Author      string  `xml:"dc:creator,omitempty"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description,omitempty"`
+	Content     *cdata  `xml:"content:encoded,omitempty"`
+}
+
+type rssGUID struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type cdata struct {
+	Value string `xml:",cdata"`
+}
+
+// Atom renders the feed as an Atom 1.0 document
+func (f *Feed) Atom() ([]byte, error) {
+	doc := atomFeed{
+		ID:       f.SelfLink,
+		Title:    f.Title,
+		Subtitle: f.Subtitle,
+		Updated:  f.Updated.UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: f.SelfLink, Rel: "self", Type: "application/atom+xml"},
+			{Href: f.Link, Rel: "alternate", Type: "text/html"},
+		},
+		Entries: make([]atomEntry, 0, len(f.Entries)),
+	}
+
+	for _, e := range f.Entries {
+		entry := atomEntry{
+			ID:        e.Link,
+			Title:     e.Title,
+			Link:      atomLink{Href: e.Link, Rel: "alternate", Type: "text/html"},
+			Author:    atomAuthor{Name: e.Author},
+			Published: e.Published.UTC().Format(time.RFC3339),
+			Updated:   e.Updated.UTC().Format(time.RFC3339),
+		}
+		if e.Summary != "" {
+			entry.Summary = &atomText{Type: "text", Body: e.Summary}
+		}
+		if e.Content != "" {
+			entry.Content = &atomText{Type: "html", Body: e.Content}
+		}
+		doc.Entries = append(doc.Entries, entry)
+	}
+
+	return marshal(doc)
+}
+
+// RSS renders the feed as an RSS 2.0 document with the full html in content:encoded
+func (f *Feed) RSS() ([]byte, error) {
+	doc := rssFeed{
+		Version:   "2.0",
+		ContentNS: "http://purl.org/rss/1.0/modules/content/",
+		AtomNS:    "http://www.w3.org/2005/Atom",
+		DCNS:      "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:         f.Title,
+			Link:          f.Link,
+			Description:   f.Subtitle,
+			SelfLink:      atomLink{Href: f.SelfLink, Rel: "self", Type: "application/rss+xml"},
+			LastBuildDate: f.Updated.UTC().Format(time.RFC1123Z),
+			Items:         make([]rssItem, 0, len(f.Entries)),
+		},
+	}
+
+	// description is mandatory for an RSS channel
+	if doc.Channel.Description == "" {
+		doc.Channel.Description = f.Title
+	}
+
+	for _, e := range f.Entries {
+		item := rssItem{
+			Title:       e.Title,
+			Link:        e.Link,
+			GUID:        rssGUID{IsPermaLink: true, Value: e.Link},
+			Author:      e.Author,
+			PubDate:     e.Published.UTC().Format(time.RFC1123Z),
+			Description: e.Summary,
+		}
+		if e.Content != "" {
+			item.Content = &cdata{Value: e.Content}
+		}
+		doc.Channel.Items = append(doc.Channel.Items, item)
+	}
+
+	return marshal(doc)
+}
+
+func marshal(doc any) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+
+	enc := xml.NewEncoder(&buf)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return nil, fmt.Errorf("could not encode feed: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+var (
+	rootRelativeAttr = regexp.MustCompile(`\b(src|href)="(/(?:[^/"][^"]*)?)"`)
+	srcsetAttr       = regexp.MustCompile(`\bsrcset="([^"]*)"`)
+)
+
+// AbsoluteURLs rewrites root-relative src, href and srcset urls in rendered html so they resolve outside the site
+func AbsoluteURLs(html []byte, baseURL string) []byte {
+	baseURL = strings.TrimRight(baseURL, "/")
+
+	html = rootRelativeAttr.ReplaceAll(html, []byte(`${1}="`+baseURL+`${2}"`))
+
+	return srcsetAttr.ReplaceAllFunc(html, func(match []byte) []byte {
+		value := srcsetAttr.FindSubmatch(match)[1]
+
+		candidates := strings.Split(string(value), ",")
+		for i, candidate := range candidates {
+			candidate = strings.TrimSpace(candidate)
+			if strings.HasPrefix(candidate, "/") && !strings.HasPrefix(candidate, "//") {
+				candidate = baseURL + candidate
+			}
+			candidates[i] = candidate
+		}
+		return []byte(`srcset="` + strings.Join(candidates, ", ") + `"`)
+	})
+}
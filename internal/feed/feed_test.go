@@ -0,0 +1,149 @@
+package feed
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testFeed() *Feed {
+	published := time.Date(2026, 1, 20, 10, 0, 0, 0, time.UTC)
+	return &Feed{
+		Title:    "A blog",
+		Subtitle: "about things",
+		Link:     "https://example.com/blogs/a-blog",
+		SelfLink: "https://example.com/blogs/a-blog/feed.xml",
+		Updated:  published.Add(time.Hour),
+		Entries: []Entry{
+			{
+				Link:      "https://example.com/blogs/a-blog/first-post",
+				Title:     "First & best",
+				Author:    "admin",
+				Summary:   "a summary",
+				Content:   `<p>hello <img src="https://example.com/assets/x_800"></p>`,
+				Published: published,
+				Updated:   published.Add(time.Hour),
+			},
+			{
+				Link:      "https://example.com/blogs/a-blog/protected",
+				Title:     "Protected",
+				Author:    "admin",
+				Published: published,
+				Updated:   published,
+			},
+		},
+	}
+}
+
+func TestAtom(t *testing.T) {
+	t.Parallel()
+
+	out, err := testFeed().Atom()
+	if err != nil {
+		t.Fatalf("could not render atom: %s", err)
+	}
+
+	var doc atomFeed
+	if err := xml.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("atom output is not valid xml: %s", err)
+	}
+	if len(doc.Entries) != 2 {
+		t.Fatalf("entries: want 2, got %d", len(doc.Entries))
+	}
+	if doc.Entries[0].Title != "First & best" {
+		t.Fatalf("title was not round tripped: %q", doc.Entries[0].Title)
+	}
+	if doc.Entries[0].Content == nil || !strings.Contains(doc.Entries[0].Content.Body, "<img") {
+		t.Fatalf("expected html content in first entry")
+	}
+	if doc.Entries[1].Content != nil {
+		t.Fatalf("expected no content element for an entry without content")
+	}
+	if doc.Updated != "2026-01-20T11:00:00Z" {
+		t.Fatalf("updated: got %q", doc.Updated)
+	}
+}
+
+func TestRSS(t *testing.T) {
+	t.Parallel()
+
+	out, err := testFeed().RSS()
+	if err != nil {
+		t.Fatalf("could not render rss: %s", err)
+	}
+
+	s := string(out)
+	for _, want := range []string{
+		`<rss version="2.0"`,
+		`xmlns:content="http://purl.org/rss/1.0/modules/content/"`,
+		`<content:encoded><![CDATA[<p>hello`,
+		`<guid isPermaLink="true">https://example.com/blogs/a-blog/first-post</guid>`,
+		`<pubDate>Tue, 20 Jan 2026 10:00:00 +0000</pubDate>`,
+	} {
+		if !strings.Contains(s, want) {
+			t.Errorf("rss output missing %q", want)
+		}
+	}
+
+	var doc struct {
+		Channel struct {
+			Items []struct {
+				Title string `xml:"title"`
+			} `xml:"item"`
+		} `xml:"channel"`
+	}
+	if err := xml.Unmarshal(out, &doc); err != nil {
+		t.Fatalf("rss output is not valid xml: %s", err)
+	}
+	if len(doc.Channel.Items) != 2 {
+		t.Fatalf("items: want 2, got %d", len(doc.Channel.Items))
+	}
+}
+
+func TestAbsoluteURLs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "image with srcset",
+			in:   `<img src="/assets/abc_800" srcset="/assets/abc_800 800w, /assets/abc_1200 1200w">`,
+			want: `<img src="https://example.com/assets/abc_800" srcset="https://example.com/assets/abc_800 800w, https://example.com/assets/abc_1200 1200w">`,
+		},
+		{
+			name: "root relative link",
+			in:   `<a href="/blogs/tech">tech</a>`,
+			want: `<a href="https://example.com/blogs/tech">tech</a>`,
+		},
+		{
+			name: "root link",
+			in:   `<a href="/">home</a>`,
+			want: `<a href="https://example.com/">home</a>`,
+		},
+		{
+			name: "absolute and protocol relative urls untouched",
+			in:   `<a href="https://other.org/x"></a><img src="//cdn.org/y.png">`,
+			want: `<a href="https://other.org/x"></a><img src="//cdn.org/y.png">`,
+		},
+		{
+			name: "fragment untouched",
+			in:   `<a href="#heading">jump</a>`,
+			want: `<a href="#heading">jump</a>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := string(AbsoluteURLs([]byte(tt.in), "https://example.com/"))
+			if got != tt.want {
+				t.Fatalf("\nwant %s\ngot  %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"blogengine/internal/components"
+	"blogengine/internal/storage"
+	"errors"
+	"net/http"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// HandleAccountPage shows the sessions of the logged in user and the forms to change their password or delete the
+// account
+func (h *BlogHandler) HandleAccountPage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := h.Tracer.Start(r.Context(), "HandleAccountPage")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		// notices survive the redirect after a change in the session
+		page := components.AccountPage{Notice: h.Sessions.Manager.PopString(r.Context(), "notice")}
+		h.renderAccountPage(w, r, common, userID, http.StatusOK, page)
+	})
+}
+
+// HandleChangePasswordPage shows the password form on its own to users who must change their password, everyone
+// else changes it from the account page
+func (h *BlogHandler) HandleChangePasswordPage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := h.Tracer.Start(r.Context(), "HandleChangePasswordPage")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		if _, ok := h.dashboardUser(w, r); !ok {
+			return
+		}
+		if !h.Sessions.Manager.GetBool(r.Context(), "must_change_password") {
+			http.Redirect(w, r, "/account", http.StatusSeeOther)
+			return
+		}
+
+		components.ChangePassword(common, nil).Render(r.Context(), w)
+	})
+}
+
+// HandleChangePassword sets a new password once the current one checks out, then logs the user out everywhere else.
+// Passwordless users set their first one this way
+func (h *BlogHandler) HandleChangePassword() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleChangePassword")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		current := r.FormValue("current_password")
+		user, problem := h.accountUser(r, userID, current)
+		if user == nil {
+			h.renderChangePassword(w, r, common, userID, map[string]string{"current_password": problem})
+			return
+		}
+
+		password := r.FormValue("password")
+		switch {
+		case password != r.FormValue("confirm_password"):
+			problem = "Passwords do not match."
+		case len(password) < 8:
+			problem = "Password too short."
+		case password == current:
+			problem = "Choose a password other than the current one."
+		}
+		if problem != "" {
+			h.renderChangePassword(w, r, common, userID, map[string]string{"password": problem})
+			return
+		}
+
+		hashedPwd, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		if err := h.DB.ChangeUserPassword(ctx, user.ID, string(hashedPwd)); err != nil {
+			h.dashboardError(w, r, err)
+			return
+		}
+
+		destroyed, err := h.Sessions.DestroyOtherSessions(ctx, user.ID)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		// a new token too, in case the old one is why the password changes
+		if err := h.Sessions.Login(r, user.ID, user.Username); err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		h.Sessions.RequirePasswordChange(ctx, false)
+
+		h.Logger.Info("password changed", "user_id", user.ID, "sessions_destroyed", destroyed)
+		h.Audit.Record(r, storage.AuditPasswordChange, user.ID, map[string]any{"via": "account", "sessions_destroyed": destroyed})
+		h.Sessions.Manager.Put(ctx, "notice", "Password changed, your other sessions were logged out.")
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+	})
+}
+
+// HandleRevokeSession logs the user out of one of their other sessions
+func (h *BlogHandler) HandleRevokeSession() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleRevokeSession")
+		defer span.End()
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		found, err := h.Sessions.DestroyUserSession(ctx, userID, r.PathValue("session_id"))
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		if !found {
+			h.NotFound(w, r)
+			return
+		}
+
+		h.Logger.Info("session revoked", "user_id", userID)
+		h.Sessions.Manager.Put(ctx, "notice", "Session revoked.")
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+	})
+}
+
+// HandleDeleteAccount soft deletes the user once their password checks out and logs them out of every session, their
+// comments stay without an author
+func (h *BlogHandler) HandleDeleteAccount() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleDeleteAccount")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		user, problem := h.accountUser(r, userID, r.FormValue("delete_password"))
+		if user == nil {
+			page := components.AccountPage{Errors: map[string]string{"delete_password": problem}}
+			h.renderAccountPage(w, r, common, userID, http.StatusUnprocessableEntity, page)
+			return
+		}
+
+		if err := h.DB.DeleteUser(ctx, user.ID); err != nil {
+			if errors.Is(err, storage.ErrUserOwnsBlogs) {
+				page := components.AccountPage{Errors: map[string]string{"delete_password": "Delete the blogs you own from the dashboard first."}}
+				h.renderAccountPage(w, r, common, userID, http.StatusConflict, page)
+				return
+			}
+			if errors.Is(err, storage.ErrLastAdmin) {
+				page := components.AccountPage{Errors: map[string]string{"delete_password": "The last admin cannot delete their account."}}
+				h.renderAccountPage(w, r, common, userID, http.StatusConflict, page)
+				return
+			}
+			h.dashboardError(w, r, err)
+			return
+		}
+
+		destroyed, err := h.Sessions.DestroyOtherSessions(ctx, user.ID)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		if err := h.Sessions.Manager.Destroy(ctx); err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("account deleted", "user_id", user.ID, "sessions_destroyed", destroyed)
+		h.Audit.Record(r, storage.AuditAccountDelete, user.ID, map[string]any{"sessions_destroyed": destroyed})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+}
+
+// accountUser loads the logged in user and checks password against theirs, sensitive account changes ask for it
+// again even in a live session. Passwordless users have none to give, a fresh login at the provider stands in for it,
+// see HandleOIDCReauth. When the check fails the user is nil and the problem to show comes back
+func (h *BlogHandler) accountUser(r *http.Request, userID int64, password string) (*storage.User, string) {
+	user, err := h.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		h.Logger.Warn("account change for missing user", "user_id", userID, "err", err)
+		return nil, "Wrong password."
+	}
+	if user.Passwordless {
+		if !h.reauthenticated(r.Context(), userID) {
+			return nil, "Confirm it is you first, your account has no password."
+		}
+		return user, ""
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, "Wrong password."
+	}
+	return user, ""
+}
+
+// renderChangePassword shows the problems with a password change on the page the form came from
+func (h *BlogHandler) renderChangePassword(w http.ResponseWriter, r *http.Request, common components.CommonData, userID int64, errs map[string]string) {
+	if h.Sessions.Manager.GetBool(r.Context(), "must_change_password") {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		components.ChangePassword(common, errs).Render(r.Context(), w)
+		return
+	}
+	h.renderAccountPage(w, r, common, userID, http.StatusUnprocessableEntity, components.AccountPage{Errors: errs})
+}
+
+func (h *BlogHandler) renderAccountPage(w http.ResponseWriter, r *http.Request, common components.CommonData, userID int64, status int, page components.AccountPage) {
+	sessions, err := h.Sessions.UserSessions(r.Context(), userID)
+	if err != nil {
+		h.InternalError(w, r, err)
+		return
+	}
+	page.Sessions = sessions
+
+	user, err := h.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		h.dashboardError(w, r, err)
+		return
+	}
+	if page.Passkeys, err = h.DB.GetPasskeysForUser(r.Context(), userID); err != nil {
+		h.InternalError(w, r, err)
+		return
+	}
+	page.Profile = user
+	page.Passwordless = user.Passwordless
+	page.Reauthenticated = user.Passwordless && h.reauthenticated(r.Context(), userID)
+	if h.OIDC != nil {
+		page.Provider = h.OIDC.Name
+	}
+	page.TwoFactor = user.HasTOTP()
+	page.TwoFactorOff = h.TOTPKey == nil
+	if page.TwoFactor {
+		if page.RecoveryCodesLeft, err = h.DB.CountRecoveryCodes(r.Context(), userID); err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+	}
+
+	w.WriteHeader(status)
+	components.Account(common, page).Render(r.Context(), w)
+}
@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAccount(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("the password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("could not hash password: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		target       string // {session} is replaced by the id of another session of the user
+		form         url.Values
+		userID       int64
+		wantStatus   int
+		wantLocation string
+		wantBody     string
+		wantSessions int // of user 1 once done, the request itself is one and two more exist
+		wantDeleted  bool
+	}{
+		{
+			name:         "anonymous readers are sent to login",
+			method:       http.MethodGet,
+			target:       "/account",
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/login?next=%2Faccount",
+			wantSessions: 2,
+		},
+		{
+			name:         "sessions are listed",
+			method:       http.MethodGet,
+			target:       "/account",
+			userID:       1,
+			wantStatus:   http.StatusOK,
+			wantBody:     "Firefox on Linux",
+			wantSessions: 3,
+		},
+		{
+			name:         "the current password is checked",
+			method:       http.MethodPost,
+			target:       "/account/password",
+			form:         url.Values{"current_password": {"a guess"}, "password": {"a new password"}, "confirm_password": {"a new password"}},
+			userID:       1,
+			wantStatus:   http.StatusUnprocessableEntity,
+			wantBody:     "Wrong password.",
+			wantSessions: 3,
+		},
+		{
+			name:         "new passwords must match",
+			method:       http.MethodPost,
+			target:       "/account/password",
+			form:         url.Values{"current_password": {"the password"}, "password": {"a new password"}, "confirm_password": {"another one"}},
+			userID:       1,
+			wantStatus:   http.StatusUnprocessableEntity,
+			wantBody:     "Passwords do not match.",
+			wantSessions: 3,
+		},
+		{
+			name:         "changing the password logs out the other sessions",
+			method:       http.MethodPost,
+			target:       "/account/password",
+			form:         url.Values{"current_password": {"the password"}, "password": {"a new password"}, "confirm_password": {"a new password"}},
+			userID:       1,
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/account",
+			wantSessions: 1,
+		},
+		{
+			name:         "revoke a session",
+			method:       http.MethodPost,
+			target:       "/account/sessions/{session}/revoke",
+			userID:       1,
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/account",
+			wantSessions: 2,
+		},
+		{
+			name:         "sessions of other users are not found",
+			method:       http.MethodPost,
+			target:       "/account/sessions/{session}/revoke",
+			userID:       2,
+			wantStatus:   http.StatusNotFound,
+			wantSessions: 2,
+		},
+		{
+			name:         "deletion checks the password",
+			method:       http.MethodPost,
+			target:       "/account/delete",
+			form:         url.Values{"delete_password": {"a guess"}},
+			userID:       1,
+			wantStatus:   http.StatusUnprocessableEntity,
+			wantSessions: 3,
+		},
+		{
+			name:         "blog owners delete their blogs first",
+			method:       http.MethodPost,
+			target:       "/account/delete",
+			form:         url.Values{"delete_password": {"the password"}},
+			userID:       2,
+			wantStatus:   http.StatusConflict,
+			wantBody:     "Delete the blogs you own",
+			wantSessions: 2,
+		},
+		{
+			name:         "deletion logs out everywhere",
+			method:       http.MethodPost,
+			target:       "/account/delete",
+			form:         url.Values{"delete_password": {"the password"}},
+			userID:       1,
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/",
+			wantSessions: 0,
+			wantDeleted:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := newFakeStore()
+			db.users = []*storage.User{
+				{ID: 1, Username: "alice", PasswordHash: string(hash)},
+				{ID: 2, Username: "bob", PasswordHash: string(hash)},
+			}
+			db.blogs = []*storage.Blog{{ID: 1, OwnerID: 2, Slug: "a-blog-slug"}}
+			h := newTestHandler(db, fakeS3{})
+
+			mux := http.NewServeMux()
+			mux.Handle("GET /account", h.HandleAccountPage())
+			mux.Handle("POST /account/password", h.HandleChangePassword())
+			mux.Handle("POST /account/sessions/{session_id}/revoke", h.HandleRevokeSession())
+			mux.Handle("POST /account/delete", h.HandleDeleteAccount())
+
+			// alice is logged in elsewhere, then lists her sessions from another device
+			login := httptest.NewRequest(http.MethodPost, "/login", nil)
+			login.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0")
+			serve(h, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				h.Sessions.Login(r, 1, "alice")
+			}), login, 0)
+			page := serve(h, mux, httptest.NewRequest(http.MethodGet, "/account", nil), 1)
+			match := regexp.MustCompile(`/account/sessions/([0-9a-f]+)/revoke`).FindStringSubmatch(page.Body.String())
+			if match == nil {
+				t.Fatal("account page lists no other session")
+			}
+
+			target := strings.ReplaceAll(tt.target, "{session}", match[1])
+			req := httptest.NewRequest(tt.method, target, strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := serve(h, mux, req, tt.userID)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status: want %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if loc := rec.Header().Get("Location"); loc != tt.wantLocation {
+				t.Fatalf("location: want %q, got %q", tt.wantLocation, loc)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Fatalf("body does not contain %q", tt.wantBody)
+			}
+
+			sessions := 0
+			sm := h.Sessions.Manager
+			if err := sm.Iterate(context.Background(), func(ctx context.Context) error {
+				if sm.GetInt64(ctx, "userID") == 1 {
+					sessions++
+				}
+				return nil
+			}); err != nil {
+				t.Fatalf("could not list sessions: %v", err)
+			}
+			if sessions != tt.wantSessions {
+				t.Fatalf("sessions of alice: want %d, got %d", tt.wantSessions, sessions)
+			}
+			if deleted := db.users[0].DeletedAt != nil; deleted != tt.wantDeleted {
+				t.Fatalf("alice deleted: want %v, got %v", tt.wantDeleted, deleted)
+			}
+		})
+	}
+}
+
+func TestForcedPasswordChange(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("adminadmin"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("could not hash password: %v", err)
+	}
+
+	db := newFakeStore()
+	db.users = []*storage.User{{ID: 1, Username: "admin", PasswordHash: string(hash), MustChangePassword: true}}
+	h := newTestHandler(db, fakeS3{})
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /login", h.HandleLogin())
+	mux.Handle("GET /account", h.HandleAccountPage())
+	mux.Handle("GET /account/password", h.HandleChangePasswordPage())
+	mux.Handle("POST /account/password", h.HandleChangePassword())
+	flagged := func(cookies []*http.Cookie) (must bool) {
+		t.Helper()
+		serveWith(h, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			must = h.Sessions.Manager.GetBool(r.Context(), "must_change_password")
+		}), httptest.NewRequest(http.MethodGet, "/", nil), cookies)
+		return must
+	}
+
+	_, cookies := serveWith(h, mux, postForm("/login", url.Values{"username": {"admin"}, "password": {"adminadmin"}}), nil)
+	if !flagged(cookies) {
+		t.Fatal("login: want the session flagged")
+	}
+
+	rec, cookies := serveWith(h, mux, httptest.NewRequest(http.MethodGet, "/account/password", nil), cookies)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Choose a new password") {
+		t.Fatalf("change password page: want %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	// problems are shown on the same page, not the account page the user can't see
+	rec, cookies = serveWith(h, mux, postForm("/account/password", url.Values{"current_password": {"adminadmin"}, "password": {"adminadmin"}, "confirm_password": {"adminadmin"}}), cookies)
+	if rec.Code != http.StatusUnprocessableEntity || !strings.Contains(rec.Body.String(), "Choose a new password") || !strings.Contains(rec.Body.String(), "other than the current one") {
+		t.Fatalf("same password: want %d on the change password page, got %d", http.StatusUnprocessableEntity, rec.Code)
+	}
+
+	rec, cookies = serveWith(h, mux, postForm("/account/password", url.Values{"current_password": {"adminadmin"}, "password": {"a new password"}, "confirm_password": {"a new password"}}), cookies)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/account" {
+		t.Fatalf("change: want a redirect to /account, got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if flagged(cookies) || db.users[0].MustChangePassword {
+		t.Fatal("change: want the flag cleared in the session and the store")
+	}
+	if rec, _ = serveWith(h, mux, httptest.NewRequest(http.MethodGet, "/account/password", nil), cookies); rec.Header().Get("Location") != "/account" {
+		t.Fatalf("change password page once done: want a redirect to /account, got %d", rec.Code)
+	}
+
+	// and later logins go through as usual
+	_, cookies = serveWith(h, mux, postForm("/login", url.Values{"username": {"admin"}, "password": {"a new password"}}), nil)
+	if flagged(cookies) {
+		t.Fatal("next login: want the session not flagged")
+	}
+}
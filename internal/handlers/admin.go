@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"blogengine/internal/components"
+	"blogengine/internal/storage"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBlockedLogins is how many blocked usernames the admin page lists, a spray at more names than that is better
+// seen in the logs
+const maxBlockedLogins = 100
+
+const (
+	auditPageSize   = 50
+	auditExportSize = 500 // events read at a time while exporting
+)
+
+// HandleAdminLoginsPage lists the usernames logins are refused to right now
+func (h *BlogHandler) HandleAdminLoginsPage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleAdminLoginsPage")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		if _, ok := h.adminUser(w, r); !ok {
+			return
+		}
+
+		blocked, err := h.DB.GetBlockedLogins(ctx, 0, maxBlockedLogins)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		page := components.AdminLoginsPage{Blocked: blocked, Notice: h.Sessions.Manager.PopString(ctx, "notice")}
+		components.AdminLogins(common, page).Render(ctx, w)
+	})
+}
+
+// HandleUnlockLogin forgets the failed logins of a username so it can log in straight away, the audit log keeps who
+// unlocked it
+func (h *BlogHandler) HandleUnlockLogin() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleUnlockLogin")
+		defer span.End()
+
+		admin, ok := h.adminUser(w, r)
+		if !ok {
+			return
+		}
+
+		username := strings.TrimSpace(r.FormValue("username"))
+		if username == "" {
+			h.RenderError(w, r, http.StatusBadRequest, "Bad request", "Pick a username to unlock.")
+			return
+		}
+		if err := h.DB.ClearLoginFailures(ctx, username); err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("login unlocked", "admin_id", admin.ID, "username", username)
+		h.Audit.Record(r, storage.AuditLoginUnlock, admin.ID, map[string]any{"username": username})
+		h.Sessions.Manager.Put(ctx, "notice", "Unlocked "+username+".")
+		http.Redirect(w, r, "/admin/logins", http.StatusSeeOther)
+	})
+}
+
+// HandleAdminAuditPage lists the audit log, filtered by the query string
+func (h *BlogHandler) HandleAdminAuditPage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleAdminAuditPage")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		if _, ok := h.adminUser(w, r); !ok {
+			return
+		}
+
+		filter, query := auditFilter(r)
+		events, err := h.DB.GetAuditEvents(ctx, filter, auditPageSize)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		page := components.AdminAuditPage{Events: events, Filter: filter, ExportURL: "/admin/audit/export?" + query.Encode()}
+		if len(events) == auditPageSize {
+			query.Set("before", strconv.FormatInt(events[len(events)-1].ID, 10))
+			page.OlderURL = "/admin/audit?" + query.Encode()
+		}
+		components.AdminAudit(common, page).Render(ctx, w)
+	})
+}
+
+// auditExportLine is an audit event as a line of the export
+type auditExportLine struct {
+	ID        int64           `json:"id"`
+	Kind      string          `json:"kind"`
+	ActorID   *int64          `json:"actor_id"`
+	Actor     *string         `json:"actor"`
+	IP        string          `json:"ip"`
+	UserAgent string          `json:"user_agent"`
+	TraceID   string          `json:"trace_id,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// HandleAdminAuditExport streams the audit events matching the query string as newline delimited JSON, the latest
+// first
+func (h *BlogHandler) HandleAdminAuditExport() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleAdminAuditExport")
+		defer span.End()
+
+		admin, ok := h.adminUser(w, r)
+		if !ok {
+			return
+		}
+
+		filter, _ := auditFilter(r)
+		events, err := h.DB.GetAuditEvents(ctx, filter, auditExportSize)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("audit log exported", "admin_id", admin.ID)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="audit-`+time.Now().UTC().Format("20060102-150405")+`.ndjson"`)
+		enc := json.NewEncoder(w)
+		for len(events) > 0 {
+			for _, e := range events {
+				line := auditExportLine{
+					ID:        e.ID,
+					Kind:      string(e.Kind),
+					ActorID:   e.ActorID,
+					Actor:     e.Actor,
+					IP:        e.IP,
+					UserAgent: e.UserAgent,
+					TraceID:   e.TraceID,
+					Payload:   json.RawMessage(e.Payload),
+					CreatedAt: e.CreatedAt.UTC(),
+				}
+				if err := enc.Encode(line); err != nil {
+					// the client went away, the status is sent already
+					return
+				}
+			}
+			if len(events) < auditExportSize {
+				return
+			}
+
+			filter.BeforeID = events[len(events)-1].ID
+			if events, err = h.DB.GetAuditEvents(ctx, filter, auditExportSize); err != nil {
+				h.Logger.Error("audit export cut short", "err", err)
+				return
+			}
+		}
+	})
+}
+
+// auditFilter reads the filter of the audit log from the query string, the query it returns has the known filters
+// only, without the page
+func auditFilter(r *http.Request) (storage.AuditFilter, url.Values) {
+	q := r.URL.Query()
+	filter := storage.AuditFilter{
+		Kind:  storage.AuditKind(q.Get("kind")),
+		Actor: strings.TrimSpace(q.Get("actor")),
+		IP:    strings.TrimSpace(q.Get("ip")),
+	}
+	if !filter.Kind.IsValid() {
+		filter.Kind = ""
+	}
+	if before, err := strconv.ParseInt(q.Get("before"), 10, 64); err == nil && before > 0 {
+		filter.BeforeID = before
+	}
+
+	query := url.Values{}
+	for key, value := range map[string]string{"kind": string(filter.Kind), "actor": filter.Actor, "ip": filter.IP} {
+		if value != "" {
+			query.Set(key, value)
+		}
+	}
+	return filter, query
+}
+
+// adminUser is dashboardUser for the pages under /admin, to anyone but an admin they don't exist
+func (h *BlogHandler) adminUser(w http.ResponseWriter, r *http.Request) (*storage.User, bool) {
+	userID, ok := h.dashboardUser(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	user, err := h.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		h.dashboardError(w, r, err)
+		return nil, false
+	}
+	if !user.IsAdmin {
+		h.NotFound(w, r)
+		return nil, false
+	}
+	return user, true
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"blogengine/internal/middleware"
+	"blogengine/internal/storage"
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestAuditLog(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("the password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("could not hash password: %v", err)
+	}
+
+	db := newFakeStore()
+	db.users = []*storage.User{
+		{ID: 1, Username: "bob", PasswordHash: string(hash)},
+		{ID: 2, Username: "admin", PasswordHash: string(hash), IsAdmin: true},
+	}
+	h := newTestHandler(db, fakeS3{})
+	h.Audit = middleware.NewAudit(db, slog.New(slog.DiscardHandler), false)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /admin/audit", h.HandleAdminAuditPage())
+	mux.Handle("GET /admin/audit/export", h.HandleAdminAuditExport())
+
+	login := postForm("/login", url.Values{"username": {"bob"}, "password": {"a guess"}})
+	login.RemoteAddr = "203.0.113.7:4711"
+	login.Header.Set("User-Agent", "curl/8.0")
+	serve(h, h.HandleLogin(), login, 0)
+	serve(h, h.HandleLogin(), postForm("/login", url.Values{"username": {"bob"}, "password": {"the password"}}), 0)
+	serve(h, h.HandleLogout(), postForm("/logout", nil), 1)
+
+	kinds := make([]storage.AuditKind, 0, len(db.audits))
+	for _, e := range db.audits {
+		kinds = append(kinds, e.Kind)
+	}
+	want := []storage.AuditKind{storage.AuditLoginFailed, storage.AuditLogin, storage.AuditLogout}
+	if !slices.Equal(kinds, want) {
+		t.Fatalf("events: want %v, got %v", want, kinds)
+	}
+	failed := db.audits[0]
+	if failed.IP != "203.0.113.7" || failed.UserAgent != "curl/8.0" || failed.ActorID == nil || *failed.ActorID != 1 {
+		t.Fatalf("failed login: want bob from 203.0.113.7 with curl, got %+v", failed)
+	}
+	var payload map[string]any
+	if err := json.Unmarshal([]byte(failed.Payload), &payload); err != nil || payload["method"] != "password" || payload["username"] != "bob" {
+		t.Fatalf("failed login payload: got %s (%v)", failed.Payload, err)
+	}
+
+	// only admins read the log
+	if rec := serve(h, mux, httptest.NewRequest(http.MethodGet, "/admin/audit", nil), 1); rec.Code != http.StatusNotFound {
+		t.Fatalf("audit page as bob: want %d, got %d", http.StatusNotFound, rec.Code)
+	}
+	if rec := serve(h, mux, httptest.NewRequest(http.MethodGet, "/admin/audit/export", nil), 1); rec.Code != http.StatusNotFound {
+		t.Fatalf("export as bob: want %d, got %d", http.StatusNotFound, rec.Code)
+	}
+
+	rec := serve(h, mux, httptest.NewRequest(http.MethodGet, "/admin/audit?kind=login_failed&actor=bob", nil), 2)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "203.0.113.7") || strings.Contains(rec.Body.String(), `card-title">logout<`) {
+		t.Fatalf("audit page: want the failed login only, got %d %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), `href="/admin/audit/export?actor=bob&amp;kind=login_failed"`) {
+		t.Fatalf("audit page: want the export link to keep the filter, got %s", rec.Body)
+	}
+
+	rec = serve(h, mux, httptest.NewRequest(http.MethodGet, "/admin/audit/export?actor=bob", nil), 2)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/x-ndjson" {
+		t.Fatalf("export: want %d and NDJSON, got %d %s", http.StatusOK, rec.Code, rec.Header().Get("Content-Type"))
+	}
+	var lines []auditExportLine
+	for scanner := bufio.NewScanner(rec.Body); scanner.Scan(); {
+		var line auditExportLine
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("export line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 3 || lines[0].Kind != string(storage.AuditLogout) || lines[2].Kind != string(storage.AuditLoginFailed) {
+		t.Fatalf("export: want bob's 3 events latest first, got %+v", lines)
+	}
+}
@@ -0,0 +1,244 @@
+package handlers
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	apiDefaultLimit = 20
+	apiMaxLimit     = 100
+	maxAPIBodyBytes = maxPostBodyLen + 64<<10 // a post body and its metadata
+)
+
+var (
+	errAPIBadRequest  = errors.New("malformed request")
+	errAPIBadCursor   = errors.New("invalid cursor or limit")
+	errAPINoToken     = errors.New("this endpoint needs an api token")
+	errAPIBadToken    = errors.New("invalid, expired or revoked api token")
+	errAPIScope       = errors.New("this endpoint needs a write token")
+	errAPIForbidden   = errors.New("you are not allowed to do this")
+	errAPITooLarge    = errors.New("request body is too large")
+	errAPINoComments  = errors.New("comments are closed on this post")
+	errAPIPostLocked  = errors.New("encrypted posts can only be unlocked on the website")
+	errAPINoSuchRoute = errors.New("no such endpoint")
+)
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+type apiTokenKey struct{}
+
+// apiErrorBody is the body of every api error, Field is set for validation errors
+type apiErrorBody struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Field   string `json:"field,omitempty"`
+	} `json:"error"`
+}
+
+// apiPage is a page of a list endpoint, NextCursor is empty on the last page
+type apiPage[T any] struct {
+	Items      []T    `json:"items"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// APIAuth authenticates api requests carrying an "Authorization: Bearer <token>" header. Requests without
+// one go through anonymously, endpoints that need a token ask for it with apiTokenFor
+func (h *BlogHandler) APIAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		raw, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok || raw == "" {
+			h.apiError(w, r, errAPIBadToken)
+			return
+		}
+
+		token, err := h.DB.UseAPIToken(r.Context(), hashToken(raw))
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				err = errAPIBadToken
+			}
+			h.apiError(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiTokenKey{}, token)))
+	})
+}
+
+// HandleOpenAPI serves the OpenAPI document of the api, it is embedded in the binary
+func (h *BlogHandler) HandleOpenAPI() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(openAPIDocument)
+	})
+}
+
+// HandleAPINotFound answers unknown api routes in json rather than with the html 404 page
+func (h *BlogHandler) HandleAPINotFound() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.apiError(w, r, errAPINoSuchRoute)
+	})
+}
+
+// apiTokenFor returns the token of the request, it writes the error when there is none or when a
+// write is attempted with a read token
+func (h *BlogHandler) apiTokenFor(w http.ResponseWriter, r *http.Request, scope storage.TokenScope) (*storage.APIToken, bool) {
+	token, _ := r.Context().Value(apiTokenKey{}).(*storage.APIToken)
+	switch {
+	case token == nil:
+		h.apiError(w, r, errAPINoToken)
+		return nil, false
+	case scope == storage.ScopeWrite && token.Scope != storage.ScopeWrite:
+		h.apiError(w, r, errAPIScope)
+		return nil, false
+	}
+	return token, true
+}
+
+// apiUserID is the user behind the request token, 0 for anonymous requests
+func apiUserID(r *http.Request) int64 {
+	if token, ok := r.Context().Value(apiTokenKey{}).(*storage.APIToken); ok {
+		return token.UserID
+	}
+	return 0
+}
+
+// apiCanRead is canReadBlog for the api, private blogs and protected posts need the token of a member
+func (h *BlogHandler) apiCanRead(w http.ResponseWriter, r *http.Request, blogID int64, visibility storage.Visibility, requiresAuth bool) bool {
+	if visibility != storage.VisibilityPrivate && !requiresAuth {
+		return true
+	}
+
+	userID := apiUserID(r)
+	if userID == 0 {
+		h.apiError(w, r, errAPINoToken)
+		return false
+	}
+	if visibility != storage.VisibilityPrivate {
+		return true
+	}
+
+	if _, err := h.DB.GetBlogMember(r.Context(), blogID, userID); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			err = errAPIForbidden
+		}
+		h.apiError(w, r, err)
+		return false
+	}
+	return true
+}
+
+// apiError maps err to a status and a stable error code, anything unexpected is logged and reported as internal
+func (h *BlogHandler) apiError(w http.ResponseWriter, r *http.Request, err error) {
+	var body apiErrorBody
+	status := http.StatusInternalServerError
+	body.Error.Code, body.Error.Message = "internal", "something went wrong on our side"
+
+	var invalid *storage.ValidationError
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &invalid):
+		status, body.Error.Code, body.Error.Message, body.Error.Field = http.StatusUnprocessableEntity, "invalid", invalid.Error(), invalid.Field
+	case errors.As(err, &tooLarge):
+		status, body.Error.Code, body.Error.Message = http.StatusRequestEntityTooLarge, "too_large", errAPITooLarge.Error()
+	case errors.Is(err, errAPIBadRequest), errors.Is(err, errAPIBadCursor):
+		status, body.Error.Code, body.Error.Message = http.StatusBadRequest, "bad_request", err.Error()
+	case errors.Is(err, errAPINoToken), errors.Is(err, errAPIBadToken):
+		status, body.Error.Code, body.Error.Message = http.StatusUnauthorized, "unauthorized", err.Error()
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+	case errors.Is(err, errAPIScope):
+		status, body.Error.Code, body.Error.Message = http.StatusForbidden, "insufficient_scope", err.Error()
+	case errors.Is(err, errAPIForbidden), errors.Is(err, errEditorForbidden), errors.Is(err, errAPINoComments), errors.Is(err, errAPIPostLocked):
+		status, body.Error.Code, body.Error.Message = http.StatusForbidden, "forbidden", err.Error()
+	case errors.Is(err, errCommentLength):
+		status, body.Error.Code, body.Error.Message, body.Error.Field = http.StatusUnprocessableEntity, "invalid", err.Error(), "content"
+	case errors.Is(err, storage.ErrNotFound), errors.Is(err, errAPINoSuchRoute):
+		status, body.Error.Code, body.Error.Message = http.StatusNotFound, "not_found", "not found"
+	case errors.Is(err, storage.ErrUniqueViolation):
+		status, body.Error.Code, body.Error.Message = http.StatusConflict, "conflict", "already exists"
+	case errors.Is(err, errEncryptedPostEdit):
+		status, body.Error.Code, body.Error.Message = http.StatusConflict, "conflict", err.Error()
+	case errors.Is(err, storage.ErrCheckViolation):
+		status, body.Error.Code, body.Error.Message = http.StatusUnprocessableEntity, "invalid", "a value is out of range"
+	default:
+		h.Logger.Error("api error", "method", r.Method, "path", r.URL.Path, "err", err)
+	}
+
+	writeJSON(w, status, body)
+}
+
+// writeJSON writes v as the response body, html is left unescaped as api responses are never rendered as pages
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
+
+// decodeJSON reads a json request body into v, unknown fields are refused so typos don't go unnoticed
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxAPIBodyBytes)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return err
+		}
+		return errors.Join(errAPIBadRequest, err)
+	}
+	return nil
+}
+
+// apiPageParams reads the cursor and limit query parameters. Cursors are opaque to clients,
+// they hold the position of the last item of the previous page
+func apiPageParams(r *http.Request) (cursor storage.Cursor, limit int64, err error) {
+	limit = apiDefaultLimit
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err = strconv.ParseInt(s, 10, 64)
+		if err != nil || limit < 1 || limit > apiMaxLimit {
+			return storage.Cursor{}, 0, errAPIBadCursor
+		}
+	}
+
+	if token := r.URL.Query().Get("cursor"); token != "" {
+		if cursor, err = parseCursor(token, false); err != nil {
+			return storage.Cursor{}, 0, errAPIBadCursor
+		}
+	}
+	return cursor, limit, nil
+}
+
+// newAPIPage trims the extra item list endpoints fetch to know whether there is a next page and converts the rest,
+// key is the position of an item
+func newAPIPage[S, T any](items []S, limit int64, key func(S) storage.Cursor, f func(S) T) apiPage[T] {
+	items, _, next := paginate(items, storage.Cursor{}, limit, key)
+	return apiPage[T]{Items: mapSlice(items, f), NextCursor: next}
+}
+
+// mapSlice converts storage records to their api representation
+func mapSlice[S, T any](items []S, f func(S) T) []T {
+	out := make([]T, len(items))
+	for i, item := range items {
+		out[i] = f(item)
+	}
+	return out
+}
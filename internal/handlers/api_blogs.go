@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"blogengine/internal/storage"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var errAPIBadCommentPolicy = errors.New("comment policy must be 'auto', 'first_time' or 'all'")
+
+type apiBlog struct {
+	ID                int64                    `json:"id"`
+	Slug              string                   `json:"slug"`
+	Title             string                   `json:"title"`
+	Description       *string                  `json:"description"`
+	OwnerID           int64                    `json:"owner_id"`
+	Owner             string                   `json:"owner,omitempty"`
+	Visibility        storage.Visibility       `json:"visibility"`
+	RegistrationMode  storage.RegistrationMode `json:"registration_mode"`
+	RegistrationLimit *int64                   `json:"registration_limit"`
+	CommentPolicy     storage.CommentPolicy    `json:"comment_policy"`
+	SeatsTaken        int64                    `json:"seats_taken"`
+	CreatedAt         time.Time                `json:"created_at"`
+	UpdatedAt         *time.Time               `json:"updated_at"`
+}
+
+// apiBlogInput is the body of blog writes, PATCH only changes the fields that are present
+type apiBlogInput struct {
+	Slug              *string                   `json:"slug"`
+	Title             *string                   `json:"title"`
+	Description       *string                   `json:"description"`
+	Visibility        *storage.Visibility       `json:"visibility"`
+	RegistrationMode  *storage.RegistrationMode `json:"registration_mode"`
+	RegistrationLimit *int64                    `json:"registration_limit"`
+	CommentPolicy     *storage.CommentPolicy    `json:"comment_policy"`
+}
+
+func newAPIBlog(b *storage.Blog) apiBlog {
+	return apiBlog{
+		ID:                b.ID,
+		Slug:              b.Slug,
+		Title:             b.Title,
+		Description:       b.Description,
+		OwnerID:           b.OwnerID,
+		Owner:             b.OwnerName,
+		Visibility:        b.Visibility,
+		RegistrationMode:  b.RegistrationMode,
+		RegistrationLimit: b.RegistrationLimit,
+		CommentPolicy:     b.CommentPolicy,
+		SeatsTaken:        b.SeatsTaken,
+		CreatedAt:         b.CreatedAt,
+		UpdatedAt:         b.UpdatedAt,
+	}
+}
+
+// HandleAPIListBlogs lists the public blogs
+func (h *BlogHandler) HandleAPIListBlogs() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleAPIListBlogs")
+		defer span.End()
+
+		cursor, limit, err := apiPageParams(r)
+		if err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		blogs, err := h.DB.GetPublicBlogs(ctx, cursor, limit+1)
+		if err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, newAPIPage(blogs, limit, (*storage.Blog).Cursor, newAPIBlog))
+	})
+}
+
+func (h *BlogHandler) HandleAPIGetBlog() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleAPIGetBlog")
+		defer span.End()
+
+		blog, err := h.DB.GetBlogBySlug(ctx, r.PathValue("blog_slug"))
+		if err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+		if !h.apiCanRead(w, r, blog.ID, blog.Visibility, false) {
+			return
+		}
+
+		writeJSON(w, http.StatusOK, newAPIBlog(blog))
+	})
+}
+
+// HandleAPICreateBlog creates a blog owned by the token user, visibility, registration and comment policy default to
+// public, open and auto
+func (h *BlogHandler) HandleAPICreateBlog() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleAPICreateBlog")
+		defer span.End()
+
+		token, ok := h.apiTokenFor(w, r, storage.ScopeWrite)
+		if !ok {
+			return
+		}
+
+		var in apiBlogInput
+		if err := decodeJSON(w, r, &in); err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+		if in.CommentPolicy != nil && !in.CommentPolicy.IsValid() {
+			h.apiError(w, r, &storage.ValidationError{Field: "comment_policy", Err: errAPIBadCommentPolicy})
+			return
+		}
+
+		params := storage.CreateBlogParams{
+			OwnerID:           token.UserID,
+			Slug:              strings.TrimSpace(derefOr(in.Slug, "")),
+			Title:             strings.TrimSpace(derefOr(in.Title, "")),
+			Description:       in.Description,
+			Visibility:        derefOr(in.Visibility, storage.VisibilityPublic),
+			RegistrationMode:  derefOr(in.RegistrationMode, storage.RegistrationOpen),
+			RegistrationLimit: in.RegistrationLimit,
+		}
+		blog, err := h.DB.CreateBlog(ctx, params)
+		if err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		// new blogs start on auto, another policy is set right after
+		if in.CommentPolicy != nil && *in.CommentPolicy != blog.CommentPolicy {
+			if err := h.DB.UpdateBlogCommentPolicy(ctx, blog.ID, token.UserID, *in.CommentPolicy); err != nil {
+				h.apiError(w, r, err)
+				return
+			}
+			blog.CommentPolicy = *in.CommentPolicy
+		}
+
+		h.Logger.Info("blog created", "user_id", token.UserID, "blog_id", blog.ID, "via", "api")
+		w.Header().Set("Location", "/api/v1/blogs/"+blog.Slug)
+		writeJSON(w, http.StatusCreated, newAPIBlog(blog))
+	})
+}
+
+// HandleAPIUpdateBlog changes a blog of the token user, details, visibility, registration and comment policy each go through
+// their own store call so a PATCH touching several of them is not atomic
+func (h *BlogHandler) HandleAPIUpdateBlog() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleAPIUpdateBlog")
+		defer span.End()
+
+		token, ok := h.apiTokenFor(w, r, storage.ScopeWrite)
+		if !ok {
+			return
+		}
+
+		blog, err := h.apiOwnedBlog(r, token.UserID)
+		if err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		var in apiBlogInput
+		if err := decodeJSON(w, r, &in); err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		if in.Slug != nil || in.Title != nil || in.Description != nil {
+			params := storage.UpdateBlogParams{
+				BlogID:      blog.ID,
+				OwnerID:     token.UserID,
+				Slug:        strings.TrimSpace(derefOr(in.Slug, blog.Slug)),
+				Title:       strings.TrimSpace(derefOr(in.Title, blog.Title)),
+				Description: blog.Description,
+			}
+			if in.Description != nil {
+				params.Description = optionalString(strings.TrimSpace(*in.Description))
+			}
+			if _, err := h.DB.UpdateBlog(ctx, params); err != nil {
+				h.apiError(w, r, err)
+				return
+			}
+		}
+
+		if in.Visibility != nil {
+			if err := h.DB.UpdateBlogVisibility(ctx, blog.ID, token.UserID, *in.Visibility); err != nil {
+				h.apiError(w, r, err)
+				return
+			}
+		}
+
+		if in.RegistrationMode != nil || in.RegistrationLimit != nil {
+			params := storage.UpdateBlogRegistrationParams{
+				BlogID:            blog.ID,
+				OwnerID:           token.UserID,
+				RegistrationMode:  derefOr(in.RegistrationMode, blog.RegistrationMode),
+				RegistrationLimit: in.RegistrationLimit,
+			}
+			// switching a limited blog to another mode drops its limit, changing only the mode keeps it
+			if params.RegistrationLimit == nil && params.RegistrationMode == storage.RegistrationLimited {
+				params.RegistrationLimit = blog.RegistrationLimit
+			}
+			if err := h.DB.UpdateBlogRegistration(ctx, params); err != nil {
+				h.apiError(w, r, err)
+				return
+			}
+		}
+
+		if in.CommentPolicy != nil {
+			if err := h.DB.UpdateBlogCommentPolicy(ctx, blog.ID, token.UserID, *in.CommentPolicy); err != nil {
+				h.apiError(w, r, err)
+				return
+			}
+		}
+
+		updated, err := h.DB.GetBlogByID(ctx, blog.ID)
+		if err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("blog updated", "user_id", token.UserID, "blog_id", blog.ID, "via", "api")
+		writeJSON(w, http.StatusOK, newAPIBlog(updated))
+	})
+}
+
+func (h *BlogHandler) HandleAPIDeleteBlog() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleAPIDeleteBlog")
+		defer span.End()
+
+		token, ok := h.apiTokenFor(w, r, storage.ScopeWrite)
+		if !ok {
+			return
+		}
+
+		blog, err := h.apiOwnedBlog(r, token.UserID)
+		if err == nil {
+			err = h.DB.DeleteBlog(ctx, blog.ID, token.UserID)
+		}
+		if err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("blog deleted", "user_id", token.UserID, "blog_id", blog.ID, "via", "api")
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// apiOwnedBlog loads the blog from the path, blogs of other users are reported as not found like on the dashboard
+func (h *BlogHandler) apiOwnedBlog(r *http.Request, userID int64) (*storage.Blog, error) {
+	blog, err := h.DB.GetBlogBySlug(r.Context(), r.PathValue("blog_slug"))
+	if err != nil {
+		return nil, err
+	}
+	if blog.OwnerID != userID {
+		return nil, storage.ErrNotFound
+	}
+	return blog, nil
+}
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"blogengine/internal/storage"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type apiComment struct {
+	ID        int64                 `json:"id"`
+	PostID    int64                 `json:"post_id"`
+	ParentID  *int64                `json:"parent_id"`
+	Depth     int64                 `json:"depth"`
+	UserID    *int64                `json:"user_id"`
+	Author    string                `json:"author,omitempty"`
+	Content   string                `json:"content"`
+	Status    storage.CommentStatus `json:"status"`  // pending comments are only listed to their author
+	Deleted   bool                  `json:"deleted"` // placeholder kept for its replies, without content or author
+	CreatedAt time.Time             `json:"created_at"`
+	UpdatedAt *time.Time            `json:"updated_at"`
+	EditedAt  *time.Time            `json:"edited_at"`
+}
+
+type apiCommentInput struct {
+	Content  string `json:"content"`
+	ParentID *int64 `json:"parent_id"`
+}
+
+func newAPIComment(c *storage.Comment) apiComment {
+	return apiComment{
+		ID:        c.ID,
+		PostID:    c.PostID,
+		ParentID:  c.ParentID,
+		Depth:     c.Depth,
+		UserID:    c.UserID,
+		Author:    c.AuthorName,
+		Content:   c.Content,
+		Status:    c.Status,
+		Deleted:   c.IsDeleted(),
+		CreatedAt: c.CreatedAt,
+		UpdatedAt: c.UpdatedAt,
+		EditedAt:  c.EditedAt,
+	}
+}
+
+// newAPICommentPage is newAPIPage for comment threads, the store returns up to limit+1 threads with their replies
+func newAPICommentPage(comments []*storage.Comment, limit int64) apiPage[apiComment] {
+	comments, _, next := pageThreads(comments, storage.Cursor{}, limit)
+	return apiPage[apiComment]{Items: mapSlice(comments, newAPIComment), NextCursor: next}
+}
+
+// HandleAPIListComments lists the comment threads of a post, newest first, each followed by its replies. Like on
+// the post page, pending comments are only listed to their author
+func (h *BlogHandler) HandleAPIListComments() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleAPIListComments")
+		defer span.End()
+
+		cursor, limit, err := apiPageParams(r)
+		if err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		post, ok := h.apiCommentedPost(w, r)
+		if !ok {
+			return
+		}
+
+		comments, err := h.DB.GetCommentsForPost(ctx, post.ID, apiUserID(r), cursor, limit+1)
+		if err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, newAPICommentPage(comments, limit))
+	})
+}
+
+func (h *BlogHandler) HandleAPICreateComment() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleAPICreateComment")
+		defer span.End()
+
+		token, ok := h.apiTokenFor(w, r, storage.ScopeWrite)
+		if !ok {
+			return
+		}
+
+		post, ok := h.apiCommentedPost(w, r)
+		if !ok {
+			return
+		}
+		if !post.AllowComments {
+			h.apiError(w, r, errAPINoComments)
+			return
+		}
+
+		var in apiCommentInput
+		if err := decodeJSON(w, r, &in); err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		// same limits as the comment form
+		content, ok := commentContent(in.Content)
+		if !ok {
+			h.apiError(w, r, errCommentLength)
+			return
+		}
+
+		if err := h.checkReplyParent(ctx, post, in.ParentID); err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		params := storage.CreateCommentParams{
+			PostID:   post.ID,
+			UserID:   token.UserID,
+			ParentID: in.ParentID,
+			Content:  content,
+		}
+		h.scoreComment(ctx, &params)
+		comment, err := h.DB.CreateComment(ctx, params)
+		if err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("new comment", "user_id", token.UserID, "post_id", post.ID, "status", comment.Status, "spam_score", comment.SpamScore, "via", "api")
+		writeJSON(w, http.StatusCreated, newAPIComment(comment))
+	})
+}
+
+// HandleAPIDeleteComment deletes a comment of the token user
+func (h *BlogHandler) HandleAPIDeleteComment() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleAPIDeleteComment")
+		defer span.End()
+
+		token, ok := h.apiTokenFor(w, r, storage.ScopeWrite)
+		if !ok {
+			return
+		}
+
+		commentID, err := strconv.ParseInt(r.PathValue("comment_id"), 10, 64)
+		if err != nil || commentID < 1 {
+			h.apiError(w, r, storage.ErrNotFound)
+			return
+		}
+
+		if err := h.DB.DeleteComment(ctx, commentID, token.UserID); err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("comment deleted", "user_id", token.UserID, "comment_id", commentID, "via", "api")
+		h.Audit.Record(r, storage.AuditCommentDelete, token.UserID, map[string]any{"comment_id": commentID, "via": "api", "token_id": token.ID})
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// apiCommentedPost loads the post from the path for its comments, encrypted posts keep them behind the passphrase
+func (h *BlogHandler) apiCommentedPost(w http.ResponseWriter, r *http.Request) (*storage.Post, bool) {
+	post, err := h.DB.GetPostBySlugOrPublicID(r.Context(), r.PathValue("blog_slug"), r.PathValue("post_slug"))
+	if err != nil {
+		h.apiError(w, r, err)
+		return nil, false
+	}
+	if !h.apiCanRead(w, r, post.BlogID, post.BlogVisibility, post.RequiresAuth) {
+		return nil, false
+	}
+	if post.IsEncrypted {
+		h.apiError(w, r, errAPIPostLocked)
+		return nil, false
+	}
+	return post, true
+}
@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"blogengine/internal/storage"
+	"blogengine/internal/utils"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var errPostBodyTooLong = errors.New("posts are limited to 1 MiB of markdown")
+
+type apiPost struct {
+	ID            int64      `json:"id"`
+	PublicID      string     `json:"public_id"`
+	Blog          string     `json:"blog"`
+	Slug          *string    `json:"slug"`
+	Title         string     `json:"title"`
+	Description   *string    `json:"description"`
+	Category      *string    `json:"category"`
+	Tags          []string   `json:"tags,omitempty"`
+	AuthorID      int64      `json:"author_id"`
+	Author        string     `json:"author,omitempty"`
+	IsEncrypted   bool       `json:"is_encrypted"`
+	RequiresAuth  bool       `json:"requires_auth"`
+	IsListed      bool       `json:"is_listed"`
+	AllowComments bool       `json:"allow_comments"`
+	PublishedAt   *time.Time `json:"published_at"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     *time.Time `json:"updated_at"`
+	HTML          *string    `json:"html,omitempty"` // single posts only, never for encrypted ones
+}
+
+// apiPostInput is the body of post writes, PATCH only changes the fields that are present
+type apiPostInput struct {
+	Title         *string         `json:"title"`
+	Slug          *string         `json:"slug"`
+	Description   *string         `json:"description"`
+	Category      *string         `json:"category"`
+	Tags          *[]string       `json:"tags"`
+	Body          *string         `json:"body"` // markdown
+	RequiresAuth  *bool           `json:"requires_auth"`
+	IsListed      *bool           `json:"is_listed"`
+	AllowComments *bool           `json:"allow_comments"`
+	PublishedAt   apiOptionalTime `json:"published_at"`
+}
+
+// apiOptionalTime tells an absent field, which keeps the stored value, from an explicit null, which clears it
+type apiOptionalTime struct {
+	Set  bool
+	Time *time.Time
+}
+
+func (t *apiOptionalTime) UnmarshalJSON(b []byte) error {
+	t.Set = true
+	if string(b) == "null" {
+		t.Time = nil
+		return nil
+	}
+
+	var v time.Time
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	v = v.UTC()
+	t.Time = &v
+	return nil
+}
+
+func newAPIPost(p *storage.Post) apiPost {
+	return apiPost{
+		ID:            p.ID,
+		PublicID:      p.PublicID,
+		Blog:          p.BlogSlug,
+		Slug:          p.Slug,
+		Title:         p.Title,
+		Description:   p.Description,
+		Category:      p.Category,
+		AuthorID:      p.AuthorID,
+		Author:        p.AuthorName,
+		IsEncrypted:   p.IsEncrypted,
+		RequiresAuth:  p.RequiresAuth,
+		IsListed:      p.IsListed,
+		AllowComments: p.AllowComments,
+		PublishedAt:   p.PublishedAt,
+		CreatedAt:     p.CreatedAt,
+		UpdatedAt:     p.UpdatedAt,
+	}
+}
+
+// HandleAPIListPosts lists the published and listed posts of a blog, newest first
+func (h *BlogHandler) HandleAPIListPosts() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleAPIListPosts")
+		defer span.End()
+
+		cursor, limit, err := apiPageParams(r)
+		if err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		blog, err := h.DB.GetBlogBySlug(ctx, r.PathValue("blog_slug"))
+		if err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+		if !h.apiCanRead(w, r, blog.ID, blog.Visibility, false) {
+			return
+		}
+
+		posts, err := h.DB.GetPostsByBlogID(ctx, blog.ID, cursor, limit+1)
+		if err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, newAPIPage(posts, limit, (*storage.Post).Cursor, newAPIPost))
+	})
+}
+
+// HandleAPIGetPost returns a published post with its rendered html, following the rules of the post page
+func (h *BlogHandler) HandleAPIGetPost() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleAPIGetPost")
+		defer span.End()
+
+		post, err := h.DB.GetPostBySlugOrPublicID(ctx, r.PathValue("blog_slug"), r.PathValue("post_slug"))
+		if err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+		if !h.apiCanRead(w, r, post.BlogID, post.BlogVisibility, post.RequiresAuth) {
+			return
+		}
+
+		out := newAPIPost(post)
+		if out.Tags, err = h.DB.GetTagsForPost(ctx, post.ID); err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		// encrypted posts are unlocked on the website only, the api never holds passphrases
+		if !post.IsEncrypted {
+			html, err := h.renderPostBody(ctx, post)
+			if err != nil {
+				h.apiError(w, r, err)
+				return
+			}
+			out.HTML = new(string(html))
+		}
+
+		writeJSON(w, http.StatusOK, out)
+	})
+}
+
+// HandleAPICreatePost creates a post on a blog the token user writes for and uploads its markdown. Posts
+// without published_at are drafts, a future published_at schedules them
+func (h *BlogHandler) HandleAPICreatePost() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleAPICreatePost")
+		defer span.End()
+
+		token, ok := h.apiTokenFor(w, r, storage.ScopeWrite)
+		if !ok {
+			return
+		}
+
+		blog, err := h.writableBlog(ctx, r.PathValue("blog_slug"), token.UserID)
+		if err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		var in apiPostInput
+		if err := decodeJSON(w, r, &in); err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		body := derefOr(in.Body, "")
+		if len(body) > maxPostBodyLen {
+			h.apiError(w, r, &storage.ValidationError{Field: "body", Err: errPostBodyTooLong})
+			return
+		}
+
+		title := strings.TrimSpace(derefOr(in.Title, ""))
+		slug := strings.TrimSpace(derefOr(in.Slug, ""))
+		if slug == "" {
+			slug = utils.Slugify(title)
+		}
+
+		params := storage.CreatePostParams{
+			BlogID:        blog.ID,
+			AuthorID:      token.UserID,
+			Slug:          &slug,
+			Title:         title,
+			Description:   in.Description,
+			Category:      in.Category,
+			RequiresAuth:  derefOr(in.RequiresAuth, false),
+			IsListed:      derefOr(in.IsListed, true),
+			AllowComments: derefOr(in.AllowComments, true),
+			PublishedAt:   in.PublishedAt.Time,
+		}
+		post, err := h.DB.CreatePost(ctx, params)
+		if err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		if err := h.savePostContent(ctx, post, body, utils.NormaliseTags(derefOr(in.Tags, nil))); err != nil {
+			// a post without its markdown can't be read, don't leave it behind
+			if delErr := h.DB.DeletePost(ctx, post.ID); delErr != nil {
+				h.Logger.Error("could not remove post after failed save", "post_id", post.ID, "err", delErr)
+			}
+			h.apiError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("post created", "user_id", token.UserID, "blog_id", blog.ID, "post_id", post.ID, "via", "api")
+		h.writeAPIPost(w, r, post.ID, http.StatusCreated)
+	})
+}
+
+// HandleAPIUpdatePost changes a post the token user can edit, see editablePost
+func (h *BlogHandler) HandleAPIUpdatePost() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleAPIUpdatePost")
+		defer span.End()
+
+		token, ok := h.apiTokenFor(w, r, storage.ScopeWrite)
+		if !ok {
+			return
+		}
+
+		post, err := h.editablePost(ctx, r, token.UserID)
+		if err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		var in apiPostInput
+		if err := decodeJSON(w, r, &in); err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		// the markdown and the tags are saved again with the metadata, load what the request leaves out
+		var body string
+		if in.Body != nil {
+			body = *in.Body
+		} else {
+			b, err := h.readPostObject(ctx, post)
+			if err != nil {
+				h.apiError(w, r, err)
+				return
+			}
+			body = string(b)
+		}
+		if len(body) > maxPostBodyLen {
+			h.apiError(w, r, &storage.ValidationError{Field: "body", Err: errPostBodyTooLong})
+			return
+		}
+
+		var tags []string
+		if in.Tags != nil {
+			tags = utils.NormaliseTags(*in.Tags)
+		} else if tags, err = h.DB.GetTagsForPost(ctx, post.ID); err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		params := storage.UpdatePostParams{
+			PostID:        post.ID,
+			Slug:          post.Slug,
+			Title:         strings.TrimSpace(derefOr(in.Title, post.Title)),
+			Description:   post.Description,
+			Category:      post.Category,
+			RequiresAuth:  derefOr(in.RequiresAuth, post.RequiresAuth),
+			IsListed:      derefOr(in.IsListed, post.IsListed),
+			AllowComments: derefOr(in.AllowComments, post.AllowComments),
+			PublishedAt:   post.PublishedAt,
+		}
+		if in.Slug != nil {
+			params.Slug = new(strings.TrimSpace(*in.Slug))
+		}
+		if in.Description != nil {
+			params.Description = optionalString(strings.TrimSpace(*in.Description))
+		}
+		if in.Category != nil {
+			params.Category = optionalString(strings.TrimSpace(*in.Category))
+		}
+		if in.PublishedAt.Set {
+			params.PublishedAt = in.PublishedAt.Time
+		}
+
+		updated, err := h.DB.UpdatePost(ctx, params)
+		if err == nil {
+			err = h.savePostContent(ctx, updated, body, tags)
+		}
+		if err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("post updated", "user_id", token.UserID, "post_id", post.ID, "via", "api")
+		h.writeAPIPost(w, r, post.ID, http.StatusOK)
+	})
+}
+
+func (h *BlogHandler) HandleAPIDeletePost() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleAPIDeletePost")
+		defer span.End()
+
+		token, ok := h.apiTokenFor(w, r, storage.ScopeWrite)
+		if !ok {
+			return
+		}
+
+		post, err := h.editablePost(ctx, r, token.UserID)
+		if err == nil {
+			err = h.DB.DeletePost(ctx, post.ID)
+		}
+		if err != nil {
+			h.apiError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("post deleted", "user_id", token.UserID, "post_id", post.ID, "via", "api")
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// writeAPIPost answers a post write with the stored post, drafts included
+func (h *BlogHandler) writeAPIPost(w http.ResponseWriter, r *http.Request, postID int64, status int) {
+	post, err := h.DB.GetPostByID(r.Context(), postID)
+	if err != nil {
+		h.apiError(w, r, err)
+		return
+	}
+
+	out := newAPIPost(post)
+	if out.Tags, err = h.DB.GetTagsForPost(r.Context(), post.ID); err != nil {
+		h.apiError(w, r, err)
+		return
+	}
+
+	writeJSON(w, status, out)
+}
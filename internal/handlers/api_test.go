@@ -0,0 +1,224 @@
+package handlers
+
+import (
+	"blogengine/internal/storage"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPI(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		token      string
+		wantStatus int
+		wantBody   string
+		wantSaved  string // s3 key expected to hold "# hello"
+	}{
+		{
+			name:       "anonymous clients list public blogs",
+			method:     http.MethodGet,
+			target:     "/api/v1/blogs",
+			wantStatus: http.StatusOK,
+			wantBody:   `"slug":"another-blog"`,
+		},
+		{
+			name:       "full pages have a next cursor",
+			method:     http.MethodGet,
+			target:     "/api/v1/blogs?limit=1",
+			wantStatus: http.StatusOK,
+			wantBody:   `"next_cursor":"` + cursorToken(storage.Cursor{ID: 2}) + `"`,
+		},
+		{
+			name:       "cursors are checked",
+			method:     http.MethodGet,
+			target:     "/api/v1/blogs?cursor=nope",
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `"code":"bad_request"`,
+		},
+		{
+			name:       "unknown tokens are refused",
+			method:     http.MethodGet,
+			target:     "/api/v1/blogs",
+			token:      "be_unknown",
+			wantStatus: http.StatusUnauthorized,
+			wantBody:   `"code":"unauthorized"`,
+		},
+		{
+			name:       "writes need a token",
+			method:     http.MethodPost,
+			target:     "/api/v1/blogs",
+			body:       `{"slug":"a-new-blog","title":"A new blog"}`,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "read tokens can't write",
+			method:     http.MethodPost,
+			target:     "/api/v1/blogs",
+			body:       `{"slug":"a-new-blog","title":"A new blog"}`,
+			token:      "be_read",
+			wantStatus: http.StatusForbidden,
+			wantBody:   `"code":"insufficient_scope"`,
+		},
+		{
+			name:       "write tokens create blogs",
+			method:     http.MethodPost,
+			target:     "/api/v1/blogs",
+			body:       `{"slug":"a-new-blog","title":"A new blog"}`,
+			token:      "be_write",
+			wantStatus: http.StatusCreated,
+			wantBody:   `"owner_id":1`,
+		},
+		{
+			name:       "taken slugs conflict",
+			method:     http.MethodPost,
+			target:     "/api/v1/blogs",
+			body:       `{"slug":"a-blog-slug","title":"A new blog"}`,
+			token:      "be_write",
+			wantStatus: http.StatusConflict,
+			wantBody:   `"code":"conflict"`,
+		},
+		{
+			name:       "validation errors name the field",
+			method:     http.MethodPost,
+			target:     "/api/v1/blogs",
+			body:       `{"slug":"a","title":"A new blog"}`,
+			token:      "be_write",
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   `"field":"slug"`,
+		},
+		{
+			name:       "unknown fields are refused",
+			method:     http.MethodPost,
+			target:     "/api/v1/blogs",
+			body:       `{"slug":"a-new-blog","titel":"A new blog"}`,
+			token:      "be_write",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "private blogs need a token",
+			method:     http.MethodGet,
+			target:     "/api/v1/blogs/a-private-blog",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "private blogs need a member",
+			method:     http.MethodGet,
+			target:     "/api/v1/blogs/a-private-blog",
+			token:      "be_read",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "posts come with their html",
+			method:     http.MethodGet,
+			target:     "/api/v1/blogs/a-blog-slug/posts/a-post",
+			wantStatus: http.StatusOK,
+			wantBody:   `"html":"<p>the stored markdown`,
+		},
+		{
+			name:       "write tokens create posts",
+			method:     http.MethodPost,
+			target:     "/api/v1/blogs/a-blog-slug/posts",
+			body:       `{"title":"A new post","body":"# hello"}`,
+			token:      "be_write",
+			wantStatus: http.StatusCreated,
+			wantBody:   `"slug":"a-new-post"`,
+			wantSaved:  "posts/2.md",
+		},
+		{
+			name:       "only writers create posts",
+			method:     http.MethodPost,
+			target:     "/api/v1/blogs/a-blog-slug/posts",
+			body:       `{"title":"A new post","body":"# hello"}`,
+			token:      "be_other",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "missing things are json 404s",
+			method:     http.MethodGet,
+			target:     "/api/v1/blogs/no-such-blog",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `"code":"not_found"`,
+		},
+		{
+			name:       "unknown routes are json 404s",
+			method:     http.MethodGet,
+			target:     "/api/v1/nothing-here",
+			wantStatus: http.StatusNotFound,
+			wantBody:   `"code":"not_found"`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := newFakeStore(testPost("a-post", nil))
+			db.blogs = []*storage.Blog{
+				{ID: 1, OwnerID: 1, Slug: "a-blog-slug", Visibility: storage.VisibilityPublic},
+				{ID: 2, OwnerID: 1, Slug: "another-blog", Visibility: storage.VisibilityPublic},
+				{ID: 3, OwnerID: 1, Slug: "a-private-blog", Visibility: storage.VisibilityPrivate},
+			}
+			db.members = []*storage.BlogMember{
+				{BlogID: 1, UserID: 1, Role: storage.RoleOwner},
+				{BlogID: 3, UserID: 1, Role: storage.RoleOwner},
+			}
+			db.tokens = map[string]*storage.APIToken{
+				hashToken("be_write"): {ID: 1, UserID: 1, Scope: storage.ScopeWrite},
+				hashToken("be_read"):  {ID: 2, UserID: 2, Scope: storage.ScopeRead},
+				hashToken("be_other"): {ID: 3, UserID: 3, Scope: storage.ScopeWrite},
+			}
+			s3 := fakeS3{"a-blog-slug/a-post": []byte("the stored markdown")}
+			h := newTestHandler(db, s3)
+
+			mux := http.NewServeMux()
+			mux.Handle("GET /api/v1/blogs", h.HandleAPIListBlogs())
+			mux.Handle("POST /api/v1/blogs", h.HandleAPICreateBlog())
+			mux.Handle("GET /api/v1/blogs/{blog_slug}", h.HandleAPIGetBlog())
+			mux.Handle("POST /api/v1/blogs/{blog_slug}/posts", h.HandleAPICreatePost())
+			mux.Handle("GET /api/v1/blogs/{blog_slug}/posts/{post_slug}", h.HandleAPIGetPost())
+			mux.Handle("/api/v1/", h.HandleAPINotFound())
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			h.APIAuth(mux).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status: want %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Fatalf("content type: want application/json, got %q", ct)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Fatalf("body does not contain %q: %s", tt.wantBody, rec.Body)
+			}
+			if tt.wantSaved != "" && string(s3[tt.wantSaved]) != "# hello" {
+				t.Fatalf("s3 %q: want %q, got %q", tt.wantSaved, "# hello", s3[tt.wantSaved])
+			}
+		})
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	t.Parallel()
+
+	var doc struct {
+		OpenAPI string         `json:"openapi"`
+		Paths   map[string]any `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPIDocument, &doc); err != nil {
+		t.Fatalf("openapi.json is not valid json: %v", err)
+	}
+	if doc.OpenAPI == "" || len(doc.Paths) == 0 {
+		t.Fatal("openapi.json has no version or paths")
+	}
+}
@@ -2,10 +2,16 @@ package handlers
 
 import (
 	"blogengine/internal/components"
+	"blogengine/internal/mail"
 	"blogengine/internal/storage"
+	"context"
+	"crypto/rand"
 	"errors"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -65,13 +71,21 @@ func (h *BlogHandler) HandleRegister() http.Handler {
 			return
 		}
 
+		email := strings.TrimSpace(r.FormValue("email"))
+		if email != "" && !mail.IsAddress(email) {
+			w.WriteHeader(http.StatusBadRequest)
+			components.Register(common, "Invalid email address.", h.NeedsInvite).Render(r.Context(), w)
+			return
+		}
+
 		hashedPwd, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 		if err != nil {
 			h.InternalError(w, r, err)
 			return
 		}
 
-		if _, err = h.DB.CreateUser(r.Context(), username, string(hashedPwd)); err != nil {
+		user, err := h.DB.CreateUser(r.Context(), username, string(hashedPwd))
+		if err != nil {
 			switch {
 			case errors.Is(err, storage.ErrUniqueViolation):
 				w.WriteHeader(http.StatusConflict)
@@ -81,28 +95,52 @@ func (h *BlogHandler) HandleRegister() http.Handler {
 			}
 			return
 		}
+
+		h.Audit.Record(r, storage.AuditRegister, user.ID, map[string]any{"method": "password"})
+		if email != "" {
+			h.welcome(r, user, email)
+		}
 		http.Redirect(w, r, "/login", http.StatusSeeOther)
 	})
 }
 
+// welcome saves the email address of a new user and greets them there. The account exists already, so failures are
+// logged rather than shown
+func (h *BlogHandler) welcome(r *http.Request, user *storage.User, email string) {
+	ctx := r.Context()
+	if err := h.DB.SetUserEmail(ctx, user.ID, email); err != nil {
+		h.Logger.Error("could not save email of new user", "user_id", user.ID, "err", err)
+		return
+	}
+
+	welcome := mail.Welcome{SiteName: h.Title, Username: user.Username, LoginURL: h.baseURL(r) + "/login"}
+	if err := h.Mail.Send(ctx, email, welcome); err != nil {
+		h.Logger.Error("could not queue welcome email", "user_id", user.ID, "err", err)
+	}
+}
+
 func (h *BlogHandler) HandleLoginPage() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// user already logged in, send home
+		next := safeRedirectPath(r.URL.Query().Get("next"))
+
+		// user already logged in, send them on
 		if h.Sessions.Manager.Exists(r.Context(), "userID") {
-			http.Redirect(w, r, "/", http.StatusSeeOther)
+			http.Redirect(w, r, next, http.StatusSeeOther)
 			return
 		}
 		common := h.newCommonData(r)
-		components.Login(common, "").Render(r.Context(), w)
+		components.Login(common, "", next, h.singleSignOn(next)).Render(r.Context(), w)
 	})
 }
 
 // HandleLogin processes the login form submission
 func (h *BlogHandler) HandleLogin() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// user already logged in, send home
+		next := safeRedirectPath(r.FormValue("next"))
+
+		// user already logged in, send them on
 		if h.Sessions.Manager.Exists(r.Context(), "userID") {
-			http.Redirect(w, r, "/", http.StatusSeeOther)
+			http.Redirect(w, r, next, http.StatusSeeOther)
 			return
 		}
 
@@ -112,52 +150,145 @@ func (h *BlogHandler) HandleLogin() http.Handler {
 		password := r.FormValue("password")
 
 		common := h.newCommonData(r)
+		ctx := r.Context()
 
-		user, err := h.DB.GetUserByUsername(r.Context(), username)
+		user, err := h.checkPassword(r, username, password, "password")
 		if err != nil {
-			switch {
-			case errors.Is(err, storage.ErrNotFound):
-				w.WriteHeader(http.StatusUnauthorized)
-				components.Login(common, "Invalid username or password.").Render(r.Context(), w)
-			default:
-				h.InternalError(w, r, err)
-			}
+			h.InternalError(w, r, err)
 			return
 		}
-
-		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		if user == nil {
 			w.WriteHeader(http.StatusUnauthorized)
-			components.Login(common, "Invalid username or password.").Render(r.Context(), w)
+			components.Login(common, "Invalid username or password.", next, h.singleSignOn(next)).Render(ctx, w)
 			return
 		}
 
-		if err := h.Sessions.Manager.RenewToken(r.Context()); err != nil {
-			h.InternalError(w, r, err)
+		if user.HasTOTP() {
+			h.startTwoFactor(w, r, user, next)
 			return
 		}
 
-		h.Sessions.Manager.Put(r.Context(), "userID", user.ID)
-		h.Sessions.Manager.Put(r.Context(), "username", username)
+		if err := h.login(r, user, "password"); err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
 
 		h.Logger.Info("user logged in", "id", user.ID, "username", user.Username)
 
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		http.Redirect(w, r, next, http.StatusSeeOther)
 	})
 }
 
 func (h *BlogHandler) HandleLogout() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID := h.Sessions.Manager.GetInt64(r.Context(), "userID")
+
 		// destroy session in db and clear cookie
 		if err := h.Sessions.Manager.Destroy(r.Context()); err != nil {
 			h.InternalError(w, r, err)
 			return
 		}
+		if userID != 0 {
+			h.Audit.Record(r, storage.AuditLogout, userID, nil)
+		}
 
 		h.Logger.Info("user logged out")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	})
 }
 
+// checkPassword returns the user whose username and password were given, nil when they don't match. Blocked, unknown
+// and wrong all cost a bcrypt comparison and get the same answer, a form can't tell them apart. Failures count
+// towards the lockout of the username and are audited with the method of the form
+func (h *BlogHandler) checkPassword(r *http.Request, username, password, method string) (*storage.User, error) {
+	ctx := r.Context()
+
+	blocked, err := h.loginBlocked(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := h.DB.GetUserByUsername(ctx, username)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+
+	hash := dummyPasswordHash()
+	if user != nil && !blocked {
+		hash = []byte(user.PasswordHash)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err == nil && user != nil && !blocked {
+		return user, nil
+	}
+
+	if !blocked {
+		if _, err := h.DB.RecordLoginFailure(ctx, username, h.LoginPolicy); err != nil {
+			return nil, err
+		}
+	}
+	h.Logger.Info("login failed", "username", username, "method", method, "blocked", blocked)
+	var actorID int64
+	if user != nil {
+		actorID = user.ID
+	}
+	h.Audit.Record(r, storage.AuditLoginFailed, actorID, map[string]any{"method": method, "username": username, "blocked": blocked})
+	return nil, nil
+}
+
+// loginBlocked tells whether failed logins, passwords and second factors alike, lock username out for now
+func (h *BlogHandler) loginBlocked(ctx context.Context, username string) (bool, error) {
+	throttle, err := h.DB.GetLoginThrottle(ctx, username)
+	if errors.Is(err, storage.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return throttle.Blocked(time.Now()), nil
+}
+
+// dummyPasswordHash stands in for the hash of accounts that don't exist or are blocked, comparing against it takes as
+// long as against a real one
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte(rand.Text()), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// login starts the session of user, one who must change their password is held on that page until they do. Failed
+// logins to the username are forgotten and the login is audited along with its method
+func (h *BlogHandler) login(r *http.Request, user *storage.User, method string) error {
+	if err := h.DB.ClearLoginFailures(r.Context(), user.Username); err != nil {
+		return err
+	}
+	if err := h.Sessions.Login(r, user.ID, user.Username); err != nil {
+		return err
+	}
+	h.Sessions.RequirePasswordChange(r.Context(), user.MustChangePassword)
+	h.Audit.Record(r, storage.AuditLogin, user.ID, map[string]any{"method": method})
+	return nil
+}
+
+// loginURL builds the login link that brings the user back to next afterwards
+func loginURL(next string) string {
+	return "/login?next=" + url.QueryEscape(next)
+}
+
+// safeRedirectPath only lets local paths through so ?next= can't be used to bounce users to another site
+func safeRedirectPath(next string) string {
+	if next == "" || next[0] != '/' || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" {
+		return "/"
+	}
+	return next
+}
+
 // GetUserFromSession is a helper to get the logged-in username
 func (h *BlogHandler) GetUserFromSession(r *http.Request) string {
 	return h.Sessions.Manager.GetString(r.Context(), "username")
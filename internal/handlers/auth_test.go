@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"blogengine/internal/middleware"
+	"blogengine/internal/storage"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestSafeRedirectPath(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name string
+		next string
+		want string
+	}{
+		{name: "local path", next: "/blogs/tech/a-post", want: "/blogs/tech/a-post"},
+		{name: "local path with query", next: "/search?q=go", want: "/search?q=go"},
+		{name: "empty", next: "", want: "/"},
+		{name: "absolute url", next: "https://evil.example/", want: "/"},
+		{name: "protocol relative", next: "//evil.example/", want: "/"},
+		{name: "backslash trick", next: `/\evil.example`, want: "/"},
+		{name: "relative path", next: "blogs/tech", want: "/"},
+		{name: "javascript scheme", next: "javascript:alert(1)", want: "/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := safeRedirectPath(tt.next); got != tt.want {
+				t.Fatalf("want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestHandleLoginPageCarriesNext(t *testing.T) {
+	t.Parallel()
+
+	h := newTestHandler(newFakeStore(), fakeS3{})
+
+	target := "/login?next=" + url.QueryEscape("/blogs/a-blog-slug/a-post-slug")
+
+	// anonymous readers get the form with next preserved
+	rec := serve(h, h.HandleLoginPage(), httptest.NewRequest(http.MethodGet, target, nil), 0)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status: want %d, got %d", http.StatusOK, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), `name="next" value="/blogs/a-blog-slug/a-post-slug"`) {
+		t.Fatal("login form does not carry next")
+	}
+
+	// logged in readers go straight back
+	rec = serve(h, h.HandleLoginPage(), httptest.NewRequest(http.MethodGet, target, nil), 1)
+	if loc := rec.Header().Get("Location"); loc != "/blogs/a-blog-slug/a-post-slug" {
+		t.Fatalf("location: want %q, got %q", "/blogs/a-blog-slug/a-post-slug", loc)
+	}
+}
+
+func TestHandleRegister(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		form       url.Values
+		wantStatus int
+		wantUsers  int
+		wantEmail  string // saved on the new user and sent the welcome email
+	}{
+		{
+			name:       "without email",
+			form:       url.Values{"username": {"reader"}, "password": {"a password"}, "confirm_password": {"a password"}},
+			wantStatus: http.StatusSeeOther,
+			wantUsers:  1,
+		},
+		{
+			name:       "with email",
+			form:       url.Values{"username": {"reader"}, "email": {" reader@example.com "}, "password": {"a password"}, "confirm_password": {"a password"}},
+			wantStatus: http.StatusSeeOther,
+			wantUsers:  1,
+			wantEmail:  "reader@example.com",
+		},
+		{
+			name:       "invalid email",
+			form:       url.Values{"username": {"reader"}, "email": {"Reader <reader@example.com>"}, "password": {"a password"}, "confirm_password": {"a password"}},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "passwords differ",
+			form:       url.Values{"username": {"reader"}, "password": {"a password"}, "confirm_password": {"another one"}},
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := newFakeStore()
+			h := newTestHandler(db, fakeS3{})
+
+			req := httptest.NewRequest(http.MethodPost, "/register", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := serve(h, h.HandleRegister(), req, 0)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status: want %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if len(db.users) != tt.wantUsers {
+				t.Fatalf("users: want %d, got %d", tt.wantUsers, len(db.users))
+			}
+
+			sent := h.Mail.(*fakeMailer).sent
+			if tt.wantEmail == "" {
+				if len(sent) != 0 {
+					t.Fatalf("emails: want none, got %d", len(sent))
+				}
+				return
+			}
+			if email := db.users[0].Email; email == nil || *email != tt.wantEmail {
+				t.Fatalf("email: want %q, got %v", tt.wantEmail, email)
+			}
+			if len(sent) != 1 || sent[0].To != tt.wantEmail || !strings.Contains(sent[0].Text, "reader") {
+				t.Fatalf("welcome email: want one to %q, got %+v", tt.wantEmail, sent)
+			}
+		})
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("the password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("could not hash password: %v", err)
+	}
+
+	db := newFakeStore()
+	db.users = []*storage.User{
+		{ID: 1, Username: "bob", PasswordHash: string(hash)},
+		{ID: 2, Username: "admin", PasswordHash: string(hash), IsAdmin: true},
+	}
+	h := newTestHandler(db, fakeS3{})
+	h.LoginPolicy = storage.LoginPolicy{FreeAttempts: 2, LockAfter: 3, Lockout: time.Hour}
+	h.Audit = middleware.NewAudit(db, slog.New(slog.DiscardHandler), false)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /admin/logins", h.HandleAdminLoginsPage())
+	mux.Handle("POST /admin/logins/unlock", h.HandleUnlockLogin())
+	login := func(username, password string) *httptest.ResponseRecorder {
+		return serve(h, h.HandleLogin(), postForm("/login", url.Values{"username": {username}, "password": {password}}), 0)
+	}
+
+	// a success forgets the failures before it
+	login("bob", "a guess")
+	if rec := login("bob", "the password"); rec.Code != http.StatusSeeOther || db.logins["bob"] != nil {
+		t.Fatalf("login: want %d and no failures left, got %d %+v", http.StatusSeeOther, rec.Code, db.logins["bob"])
+	}
+
+	for range 3 {
+		login(" BOB", "a guess")
+	}
+	locked := login("bob", "the password")
+	unknown := login("nobody", "the password")
+	if locked.Code != http.StatusUnauthorized || locked.Body.String() != unknown.Body.String() {
+		t.Fatalf("locked account: want the answer of an unknown one, got %d", locked.Code)
+	}
+	if db.logins["bob"].Failures != 3 || db.logins["nobody"].Failures != 1 {
+		t.Fatalf("failures: want 3 for bob and 1 for nobody, got %+v", db.logins)
+	}
+
+	// only admins see the blocked usernames
+	if rec := serve(h, mux, httptest.NewRequest(http.MethodGet, "/admin/logins", nil), 1); rec.Code != http.StatusNotFound {
+		t.Fatalf("admin page as bob: want %d, got %d", http.StatusNotFound, rec.Code)
+	}
+	if rec := serve(h, mux, postForm("/admin/logins/unlock", url.Values{"username": {"bob"}}), 1); rec.Code != http.StatusNotFound {
+		t.Fatalf("unlock as bob: want %d, got %d", http.StatusNotFound, rec.Code)
+	}
+	rec := serve(h, mux, httptest.NewRequest(http.MethodGet, "/admin/logins", nil), 2)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `name="username" value="bob"`) {
+		t.Fatalf("admin page: want bob listed, got %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := serve(h, mux, postForm("/admin/logins/unlock", url.Values{"username": {"bob"}}), 2); rec.Code != http.StatusSeeOther {
+		t.Fatalf("unlock: want %d, got %d", http.StatusSeeOther, rec.Code)
+	}
+	unlock := db.audits[len(db.audits)-1]
+	if unlock.Kind != storage.AuditLoginUnlock || unlock.ActorID == nil || *unlock.ActorID != 2 || !strings.Contains(unlock.Payload, `"username":"bob"`) {
+		t.Fatalf("unlock event: want admin unlocking bob, got %+v", unlock)
+	}
+	if rec := login("bob", "the password"); rec.Code != http.StatusSeeOther {
+		t.Fatalf("login once unlocked: want %d, got %d", http.StatusSeeOther, rec.Code)
+	}
+}
@@ -3,7 +3,10 @@ package handlers
 import (
 	"blogengine/internal/components"
 	"blogengine/internal/content"
+	"blogengine/internal/mail"
 	"blogengine/internal/middleware"
+	"blogengine/internal/oidc"
+	"blogengine/internal/spam"
 	"blogengine/internal/storage"
 	"blogengine/internal/telemetry"
 	"errors"
@@ -17,49 +20,82 @@ import (
 
 // BlogHandler holds the state
 type BlogHandler struct {
-	Title       string
-	NeedsInvite bool
-	InviteCode  string
-	DB          storage.Store
-	S3          storage.S3Store
-	GeoStats    *middleware.GeoStats
-	Renderer    *content.MarkDownRenderer
-	Logger      *slog.Logger
-	Tracer      trace.Tracer
-	Metrics     *telemetry.Metrics
-	Sessions    *middleware.Sessions
-	StartTime   time.Time
+	Title             string
+	BaseURL           string
+	TrustedProxy      bool // forwarded headers come from our proxy and not from clients
+	NeedsInvite       bool
+	InviteCode        string
+	DB                storage.Store
+	S3                storage.Provider
+	Images            content.ImageProcessorService // scales avatars down, nil serves them as uploaded
+	GeoStats          *middleware.GeoStats
+	Renderer          *content.MarkDownRenderer
+	PostCache         *content.RenderCache // html of plain posts, nil renders every request
+	Logger            *slog.Logger
+	Tracer            trace.Tracer
+	Metrics           *telemetry.Metrics
+	Sessions          *middleware.Sessions
+	StartTime         time.Time
+	CommentEditWindow time.Duration
+	Mail              mail.Mailer
+	Spam              *spam.Classifier
+	TOTPKey           []byte              // seals two-factor secrets, nil disables enrolment
+	OIDC              *oidc.Provider      // single sign-on, nil turns it off
+	LoginPolicy       storage.LoginPolicy // the zero policy never blocks a username
+	Audit             *middleware.Audit   // nil records nothing
 }
 
 type HandlerConfig struct {
-	Title       string
-	NeedsInvite bool
-	InviteCode  string
-	DB          storage.Store
-	S3          storage.S3Store
-	GeoStats    *middleware.GeoStats
-	Renderer    *content.MarkDownRenderer
-	Logger      *slog.Logger
-	Tracer      trace.Tracer
-	Metrics     *telemetry.Metrics
-	Sessions    *middleware.Sessions
-	StartTime   time.Time
+	Title             string
+	BaseURL           string
+	TrustedProxy      bool
+	NeedsInvite       bool
+	InviteCode        string
+	DB                storage.Store
+	S3                storage.Provider
+	Images            content.ImageProcessorService
+	GeoStats          *middleware.GeoStats
+	Renderer          *content.MarkDownRenderer
+	PostCache         *content.RenderCache
+	Logger            *slog.Logger
+	Tracer            trace.Tracer
+	Metrics           *telemetry.Metrics
+	Sessions          *middleware.Sessions
+	StartTime         time.Time
+	CommentEditWindow time.Duration
+	Mail              mail.Mailer
+	Spam              *spam.Classifier
+	TOTPKey           []byte
+	OIDC              *oidc.Provider
+	LoginPolicy       storage.LoginPolicy
+	Audit             *middleware.Audit
 }
 
 func NewHandler(cfg HandlerConfig) *BlogHandler {
 	return &BlogHandler{
-		Title:       cfg.Title,
-		NeedsInvite: cfg.NeedsInvite,
-		InviteCode:  cfg.InviteCode,
-		DB:          cfg.DB,
-		S3:          cfg.S3,
-		GeoStats:    cfg.GeoStats,
-		Renderer:    cfg.Renderer,
-		Logger:      cfg.Logger,
-		Tracer:      cfg.Tracer,
-		Metrics:     cfg.Metrics,
-		Sessions:    cfg.Sessions,
-		StartTime:   cfg.StartTime,
+		Title:             cfg.Title,
+		BaseURL:           cfg.BaseURL,
+		TrustedProxy:      cfg.TrustedProxy,
+		NeedsInvite:       cfg.NeedsInvite,
+		InviteCode:        cfg.InviteCode,
+		DB:                cfg.DB,
+		S3:                cfg.S3,
+		Images:            cfg.Images,
+		GeoStats:          cfg.GeoStats,
+		Renderer:          cfg.Renderer,
+		PostCache:         cfg.PostCache,
+		Logger:            cfg.Logger,
+		Tracer:            cfg.Tracer,
+		Metrics:           cfg.Metrics,
+		Sessions:          cfg.Sessions,
+		StartTime:         cfg.StartTime,
+		CommentEditWindow: cfg.CommentEditWindow,
+		Mail:              cfg.Mail,
+		Spam:              cfg.Spam,
+		TOTPKey:           cfg.TOTPKey,
+		OIDC:              cfg.OIDC,
+		LoginPolicy:       cfg.LoginPolicy,
+		Audit:             cfg.Audit,
 	}
 }
 
@@ -68,28 +104,37 @@ func (h *BlogHandler) newCommonData(r *http.Request) components.CommonData {
 		Title:     h.Title,
 		Username:  h.GetUserFromSession(r),
 		CSRFToken: nosurf.Token(r),
+		FeedURL:   "/feed.xml",
 	}
 }
 
+const (
+	homeBlogs    = 5  // the newest blogs shown on the home page
+	listingPosts = 10 // posts on a page of the home page and of a blog
+)
+
 func (h *BlogHandler) HandleHome() http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx, span := h.Tracer.Start(r.Context(), "HandleHome")
 		defer span.End()
 		common := h.newCommonData(r)
 
-		blogs, err := h.DB.GetPublicBlogs(ctx, 0, 5)
+		blogs, err := h.DB.GetPublicBlogs(ctx, storage.Cursor{}, homeBlogs)
 		if err != nil {
 			h.InternalError(w, r, err)
 			return
 		}
 
-		posts, err := h.DB.GetLatestPublicPosts(ctx, 0, 10)
+		cursor := pageCursor(r)
+		posts, err := h.DB.GetLatestPublicPosts(ctx, cursor, listingPosts+1)
 		if err != nil {
 			h.InternalError(w, r, err)
 			return
 		}
+		var pager components.Pager
+		posts, pager.Newer, pager.Older = paginate(posts, cursor, listingPosts, (*storage.Post).Cursor)
 
-		components.Home(blogs, posts, common).Render(ctx, w)
+		components.Home(blogs, posts, pager, common).Render(ctx, w)
 	})
 }
 
@@ -111,13 +156,28 @@ func (h *BlogHandler) HandleBlog() http.Handler {
 			return
 		}
 
-		posts, err := h.DB.GetPostsByBlogID(ctx, blog.ID, 0, 10)
+		if !h.canReadBlog(w, r, blog.ID, blog.Visibility) {
+			return
+		}
+
+		cursor := pageCursor(r)
+		posts, err := h.DB.GetPostsByBlogID(ctx, blog.ID, cursor, listingPosts+1)
 		if err != nil {
 			h.InternalError(w, r, err)
 			return
 		}
+		var pager components.Pager
+		posts, pager.Newer, pager.Older = paginate(posts, cursor, listingPosts, (*storage.Post).Cursor)
+
+		tags, err := h.DB.GetTagCountsForBlog(ctx, blog.ID)
+		if err != nil {
+			h.Logger.Error("failed to fetch tags", "blog_id", blog.ID, "err", err)
+			tags = []*storage.TagCount{}
+		}
+
+		common.FeedURL = "/blogs/" + blog.Slug + "/feed.xml"
 
-		components.Blog(blog, posts, common).Render(ctx, w)
+		components.Blog(blog, posts, tags, pager, common).Render(ctx, w)
 	})
 }
 
@@ -1,12 +1,28 @@
 package handlers
 
 import (
+	"blogengine/internal/components"
+	"blogengine/internal/spam"
 	"blogengine/internal/storage"
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
+)
+
+const (
+	maxCommentLen  = 1000 // the longest comment the forms and the api accept, the database allows more
+	commentThreads = 20   // threads on a page of the comments of a post
+)
+
+var (
+	errReplyParent   = errors.New("replies must answer a published comment of the same post")
+	errReplyDepth    = errors.New("this thread is nested too deep to reply to")
+	errCommentLength = errors.New("comments must be between 1 and 1000 chars")
 )
 
 func (h *BlogHandler) HandleComment() http.Handler {
@@ -30,7 +46,21 @@ func (h *BlogHandler) HandleComment() http.Handler {
 
 		post, err := h.DB.GetPostBySlugOrPublicID(r.Context(), blogSlug, postSlug)
 		if err != nil {
-			h.NotFound(w, r)
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				h.NotFound(w, r)
+			default:
+				h.InternalError(w, r, err)
+			}
+			return
+		}
+
+		if !h.canReadBlog(w, r, post.BlogID, post.BlogVisibility) {
+			return
+		}
+
+		if !post.AllowComments || !h.isPostUnlocked(r.Context(), post) {
+			h.Forbidden(w, r)
 			return
 		}
 
@@ -38,24 +68,47 @@ func (h *BlogHandler) HandleComment() http.Handler {
 		redirectTo := fmt.Sprintf("/blogs/%s/%s", blogSlug, postSlug)
 
 		// validate
-		content := strings.TrimSpace(r.FormValue("content"))
-		if content == "" || len(content) > 1000 {
+		content, ok := commentContent(r.FormValue("content"))
+		if !ok {
 			// redirect to same page if validation fails
 			// TODO give feedback to user?
 			http.Redirect(w, r, redirectTo, http.StatusSeeOther)
 			return
 		}
 
+		// replies name the comment they answer
+		var parentID *int64
+		if raw := r.FormValue("parent_id"); raw != "" {
+			id, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				h.replyError(w, r, &storage.ValidationError{Field: "parent_id", Err: errReplyParent})
+				return
+			}
+			parentID = &id
+		}
+		if err := h.checkReplyParent(r.Context(), post, parentID); err != nil {
+			h.replyError(w, r, err)
+			return
+		}
+
 		// save if valid
-		if _, err := h.DB.CreateComment(r.Context(), post.ID, userID, content); err != nil {
-			h.InternalError(w, r, err)
+		params := storage.CreateCommentParams{
+			PostID:   post.ID,
+			UserID:   userID,
+			ParentID: parentID,
+			Content:  content,
+		}
+		h.scoreComment(r.Context(), &params)
+		comment, err := h.DB.CreateComment(r.Context(), params)
+		if err != nil {
+			h.replyError(w, r, err)
 			return
 		}
 
-		h.Logger.Info("new comment", "user_id", userID, "post_id", post.ID)
+		h.Logger.Info("new comment", "user_id", userID, "post_id", post.ID, "parent_id", derefOr(parentID, 0), "status", comment.Status, "spam_score", comment.SpamScore)
 
-		// all good, redirect to same page to show the page with newly created comment
-		http.Redirect(w, r, redirectTo, http.StatusSeeOther)
+		// all good, redirect to same page to show the page with newly created comment, pending ones are shown to their author
+		http.Redirect(w, r, fmt.Sprintf("%s#comment-%d", redirectTo, comment.ID), http.StatusSeeOther)
 	})
 }
 
@@ -90,7 +143,264 @@ func (h *BlogHandler) HandleDeleteComment() http.Handler {
 		}
 
 		h.Logger.Info("comment deleted", "user_id", userID, "comment_id", commentID)
+		h.Audit.Record(r, storage.AuditCommentDelete, userID, map[string]any{"comment_id": commentID})
 
 		http.Redirect(w, r, redirectTo, http.StatusSeeOther)
 	})
 }
+
+// checkReplyParent checks a reply answers a comment of post that still takes replies, top level comments have no parent
+func (h *BlogHandler) checkReplyParent(ctx context.Context, post *storage.Post, parentID *int64) error {
+	if parentID == nil {
+		return nil
+	}
+
+	parent, err := h.DB.GetCommentByID(ctx, *parentID)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return &storage.ValidationError{Field: "parent_id", Err: errReplyParent}
+	case err != nil:
+		return err
+	case parent.PostID != post.ID, parent.Status != storage.CommentApproved:
+		return &storage.ValidationError{Field: "parent_id", Err: errReplyParent}
+	case !parent.CanBeRepliedTo():
+		return &storage.ValidationError{Field: "parent_id", Err: errReplyDepth}
+	}
+	return nil
+}
+
+func (h *BlogHandler) replyError(w http.ResponseWriter, r *http.Request, err error) {
+	var invalid *storage.ValidationError
+	if errors.As(err, &invalid) {
+		h.RenderError(w, r, http.StatusBadRequest, "Cannot reply", invalid.Error())
+		return
+	}
+	h.InternalError(w, r, err)
+}
+
+// commentContent trims the content of a comment and checks its length
+func commentContent(raw string) (string, bool) {
+	content := strings.TrimSpace(raw)
+	return content, content != "" && len(content) <= maxCommentLen
+}
+
+// scoreComment records the spam score of a new comment and holds or files it as spam when the score is high enough.
+// Without a classifier the blog's comment policy alone decides
+func (h *BlogHandler) scoreComment(ctx context.Context, params *storage.CreateCommentParams) {
+	if h.Spam == nil {
+		return
+	}
+
+	verdict := h.Spam.Classify(ctx, spam.Comment{PostID: params.PostID, UserID: params.UserID, Content: params.Content})
+	params.Fingerprint = verdict.Fingerprint
+	params.SpamScore = verdict.Score
+	params.SpamReasons = verdict.Reasons
+	params.Flag = verdict.Flag
+}
+
+// scoreEdit is scoreComment for an edit of a comment of postID, the store decides what the score does to its status
+func (h *BlogHandler) scoreEdit(ctx context.Context, postID int64, params *storage.UpdateCommentParams) {
+	if h.Spam == nil {
+		return
+	}
+
+	verdict := h.Spam.Classify(ctx, spam.Comment{PostID: postID, UserID: params.UserID, Content: params.Content})
+	params.Fingerprint = verdict.Fingerprint
+	params.SpamScore = verdict.Score
+	params.SpamReasons = verdict.Reasons
+	params.Flag = verdict.Flag
+}
+
+// commentSection loads the page of comment threads of a post the viewer asked for among the ones they may read, with
+// the earlier versions of edited ones when the viewer moderates the blog. Failures are logged and leave the section
+// empty rather than failing the post page
+func (h *BlogHandler) commentSection(ctx context.Context, r *http.Request, post *storage.Post) components.CommentSection {
+	section := components.CommentSection{
+		BlogSlug:      post.BlogSlug,
+		PostSlug:      derefOr(post.Slug, post.PublicID),
+		AllowComments: post.AllowComments,
+		Comments:      []*storage.Comment{},
+		EditableAfter: time.Now().Add(-h.CommentEditWindow),
+	}
+
+	userID := h.Sessions.Manager.GetInt64(ctx, "userID")
+	cursor := pageCursor(r)
+	comments, err := h.DB.GetCommentsForPost(ctx, post.ID, userID, cursor, commentThreads+1)
+	if err != nil {
+		h.Logger.Error("failed to fetch comments", "post_id", post.ID, "err", err)
+		return section
+	}
+	section.Comments, section.Pager.Newer, section.Pager.Older = pageThreads(comments, cursor, commentThreads)
+
+	if userID == 0 {
+		return section
+	}
+	member, err := h.DB.GetBlogMember(ctx, post.BlogID, userID)
+	if err != nil || !member.Role.CanModerateComments() {
+		return section
+	}
+	section.Moderator = true
+
+	revisions, err := h.DB.GetCommentRevisionsForPost(ctx, post.ID)
+	if err != nil {
+		h.Logger.Error("failed to fetch comment revisions", "post_id", post.ID, "err", err)
+		return section
+	}
+	section.Revisions = make(map[int64][]*storage.CommentRevision)
+	for _, rev := range revisions {
+		section.Revisions[rev.CommentID] = append(section.Revisions[rev.CommentID], rev)
+	}
+	return section
+}
+
+// pageThreads is paginate for comment threads, each top level comment is followed by its replies
+func pageThreads(comments []*storage.Comment, c storage.Cursor, limit int64) (page []*storage.Comment, newer, older string) {
+	var roots []*storage.Comment
+	for _, comment := range comments {
+		if comment.ParentID == nil {
+			roots = append(roots, comment)
+		}
+	}
+	roots, newer, older = paginate(roots, c, limit, (*storage.Comment).Cursor)
+	if len(roots) == 0 {
+		return []*storage.Comment{}, newer, older
+	}
+
+	// the threads kept run from the first root kept to the root after the last one
+	isRoot := func(comment *storage.Comment) bool { return comment.ParentID == nil }
+	start, last := slices.Index(comments, roots[0]), slices.Index(comments, roots[len(roots)-1])
+	end := len(comments)
+	if next := slices.IndexFunc(comments[last+1:], isRoot); next != -1 {
+		end = last + 1 + next
+	}
+	return comments[start:end], newer, older
+}
+
+// HandleEditCommentPage shows the edit form of a comment to its author while the edit window is open
+func (h *BlogHandler) HandleEditCommentPage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleEditCommentPage")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID := h.Sessions.Manager.GetInt64(ctx, "userID")
+		if userID == 0 {
+			http.Redirect(w, r, loginURL(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+
+		post, comment, ok := h.editableComment(w, r, userID)
+		if !ok {
+			return
+		}
+
+		components.CommentEdit(common, post, comment, comment.Content, h.commentEditDeadline(comment), "").Render(ctx, w)
+	})
+}
+
+// HandleEditComment saves an edit, the previous content is kept as a revision by the store. New content is scored
+// again and may send the comment back to the moderation queue
+func (h *BlogHandler) HandleEditComment() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleEditComment")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID := h.Sessions.Manager.GetInt64(ctx, "userID")
+		if userID == 0 {
+			h.Unauthorised(w, r)
+			return
+		}
+
+		post, comment, ok := h.editableComment(w, r, userID)
+		if !ok {
+			return
+		}
+
+		content, ok := commentContent(r.FormValue("content"))
+		if !ok {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			components.CommentEdit(common, post, comment, r.FormValue("content"), h.commentEditDeadline(comment), errCommentLength.Error()).Render(ctx, w)
+			return
+		}
+
+		params := storage.UpdateCommentParams{CommentID: comment.ID, UserID: userID, Content: content}
+		if content != comment.Content {
+			h.scoreEdit(ctx, post.ID, &params)
+		}
+		edited, err := h.DB.UpdateComment(ctx, params)
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				h.NotFound(w, r)
+			default:
+				h.InternalError(w, r, err)
+			}
+			return
+		}
+
+		h.Logger.Info("comment edited", "user_id", userID, "comment_id", comment.ID, "status", edited.Status, "spam_score", edited.SpamScore)
+
+		http.Redirect(w, r, fmt.Sprintf("/blogs/%s/%s#comment-%d", post.BlogSlug, derefOr(post.Slug, post.PublicID), comment.ID), http.StatusSeeOther)
+	})
+}
+
+// editableComment loads the comment of the path for its author, it writes the error when the comment is not
+// theirs, comments are closed or the edit window has passed
+func (h *BlogHandler) editableComment(w http.ResponseWriter, r *http.Request, userID int64) (*storage.Post, *storage.Comment, bool) {
+	ctx := r.Context()
+
+	post, err := h.DB.GetPostBySlugOrPublicID(ctx, r.PathValue("blog_slug"), r.PathValue("post_slug"))
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			h.NotFound(w, r)
+		default:
+			h.InternalError(w, r, err)
+		}
+		return nil, nil, false
+	}
+
+	if !h.canReadBlog(w, r, post.BlogID, post.BlogVisibility) {
+		return nil, nil, false
+	}
+
+	if !post.AllowComments || !h.isPostUnlocked(ctx, post) {
+		h.Forbidden(w, r)
+		return nil, nil, false
+	}
+
+	commentID, err := strconv.ParseInt(r.PathValue("commentID"), 10, 64)
+	if err != nil {
+		h.NotFound(w, r)
+		return nil, nil, false
+	}
+
+	comment, err := h.DB.GetCommentByID(ctx, commentID)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			h.NotFound(w, r)
+		default:
+			h.InternalError(w, r, err)
+		}
+		return nil, nil, false
+	}
+
+	// comments of other users and other posts don't exist as far as this route is concerned
+	if comment.PostID != post.ID || comment.UserID == nil || *comment.UserID != userID {
+		h.NotFound(w, r)
+		return nil, nil, false
+	}
+
+	if !comment.CreatedAt.After(time.Now().Add(-h.CommentEditWindow)) {
+		h.RenderError(w, r, http.StatusForbidden, "Too late to edit", "Comments can only be edited for a short while after they are posted.")
+		return nil, nil, false
+	}
+
+	return post, comment, true
+}
+
+func (h *BlogHandler) commentEditDeadline(comment *storage.Comment) string {
+	return comment.CreatedAt.Add(h.CommentEditWindow).Format("02 Jan 2006, 15:04")
+}
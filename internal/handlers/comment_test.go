@@ -0,0 +1,285 @@
+package handlers
+
+import (
+	"blogengine/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleComment(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name         string
+		post         *storage.Post
+		comments     []*storage.Comment
+		target       string
+		userID       int64
+		content      string
+		parentID     string
+		wantStatus   int
+		wantComments int
+		wantHeld     bool // the new comment waits for a moderator
+	}{
+		{
+			name:         "nominal",
+			post:         testPost("a-post-slug", nil),
+			target:       "/blogs/a-blog-slug/a-post-slug/comment",
+			userID:       1,
+			content:      "nice post",
+			wantStatus:   http.StatusSeeOther,
+			wantComments: 1,
+		},
+		{
+			name:         "comments disabled",
+			post:         testPost("a-post-slug", func(p *storage.Post) { p.AllowComments = false }),
+			target:       "/blogs/a-blog-slug/a-post-slug/comment",
+			userID:       1,
+			content:      "nice post",
+			wantStatus:   http.StatusForbidden,
+			wantComments: 0,
+		},
+		{
+			name:         "anonymous",
+			post:         testPost("a-post-slug", nil),
+			target:       "/blogs/a-blog-slug/a-post-slug/comment",
+			content:      "nice post",
+			wantStatus:   http.StatusUnauthorized,
+			wantComments: 0,
+		},
+		{
+			name:         "unknown post",
+			post:         testPost("a-post-slug", nil),
+			target:       "/blogs/a-blog-slug/another-post/comment",
+			userID:       1,
+			content:      "nice post",
+			wantStatus:   http.StatusNotFound,
+			wantComments: 0,
+		},
+		{
+			name:         "reply",
+			post:         testPost("a-post-slug", nil),
+			comments:     []*storage.Comment{{ID: 1, PostID: 1, Status: storage.CommentApproved}},
+			target:       "/blogs/a-blog-slug/a-post-slug/comment",
+			userID:       1,
+			content:      "i agree",
+			parentID:     "1",
+			wantStatus:   http.StatusSeeOther,
+			wantComments: 2,
+		},
+		{
+			name:         "reply to another post",
+			post:         testPost("a-post-slug", nil),
+			comments:     []*storage.Comment{{ID: 1, PostID: 2, Status: storage.CommentApproved}},
+			target:       "/blogs/a-blog-slug/a-post-slug/comment",
+			userID:       1,
+			content:      "i agree",
+			parentID:     "1",
+			wantStatus:   http.StatusBadRequest,
+			wantComments: 1,
+		},
+		{
+			name:         "reply to a deleted comment",
+			post:         testPost("a-post-slug", nil),
+			comments:     []*storage.Comment{{ID: 1, PostID: 1, Status: storage.CommentApproved, DeletedAt: new(time.Now())}},
+			target:       "/blogs/a-blog-slug/a-post-slug/comment",
+			userID:       1,
+			content:      "i agree",
+			parentID:     "1",
+			wantStatus:   http.StatusBadRequest,
+			wantComments: 1,
+		},
+		{
+			name:         "reply too deep",
+			post:         testPost("a-post-slug", nil),
+			comments:     []*storage.Comment{{ID: 1, PostID: 1, Status: storage.CommentApproved, Depth: storage.MaxCommentDepth}},
+			target:       "/blogs/a-blog-slug/a-post-slug/comment",
+			userID:       1,
+			content:      "i agree",
+			parentID:     "1",
+			wantStatus:   http.StatusBadRequest,
+			wantComments: 1,
+		},
+		{
+			name:         "reply to a pending comment",
+			post:         testPost("a-post-slug", nil),
+			comments:     []*storage.Comment{{ID: 1, PostID: 1, Status: storage.CommentPending}},
+			target:       "/blogs/a-blog-slug/a-post-slug/comment",
+			userID:       1,
+			content:      "i agree",
+			parentID:     "1",
+			wantStatus:   http.StatusBadRequest,
+			wantComments: 1,
+		},
+		{
+			name:         "a few links",
+			post:         testPost("a-post-slug", nil),
+			target:       "/blogs/a-blog-slug/a-post-slug/comment",
+			userID:       1,
+			content:      "the same idea is explained well in https://example.com/an-article if you want to read more",
+			wantStatus:   http.StatusSeeOther,
+			wantComments: 1,
+		},
+		{
+			name:         "mostly links are held",
+			post:         testPost("a-post-slug", nil),
+			target:       "/blogs/a-blog-slug/a-post-slug/comment",
+			userID:       1,
+			content:      "cheap https://spam.example https://spam.example/watches",
+			wantStatus:   http.StatusSeeOther,
+			wantComments: 1,
+			wantHeld:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := newFakeStore(tt.post)
+			db.comments = tt.comments
+			h := newTestHandler(db, fakeS3{})
+
+			mux := http.NewServeMux()
+			mux.Handle("POST /blogs/{blog_slug}/{post_slug}/comment", h.HandleComment())
+
+			form := url.Values{"content": {tt.content}, "parent_id": {tt.parentID}}
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			rec := serve(h, mux, req, tt.userID)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status: want %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if len(db.comments) != tt.wantComments {
+				t.Fatalf("comments: want %d, got %d", tt.wantComments, len(db.comments))
+			}
+			if tt.wantStatus == http.StatusSeeOther {
+				if got := db.comments[len(db.comments)-1].IsPending(); got != tt.wantHeld {
+					t.Fatalf("held: want %t, got %t", tt.wantHeld, got)
+				}
+			}
+		})
+	}
+}
+
+func TestEditComment(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name         string
+		post         *storage.Post
+		method       string
+		userID       int64
+		postedAgo    time.Duration
+		content      string
+		wantStatus   int
+		wantLocation string
+		wantContent  string
+	}{
+		{
+			name:         "anonymous readers are sent to login",
+			post:         testPost("a-post-slug", nil),
+			method:       http.MethodGet,
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/login?next=" + url.QueryEscape("/blogs/a-blog-slug/a-post-slug/comment/1/edit"),
+			wantContent:  "first version",
+		},
+		{
+			name:        "anonymous edits are refused",
+			post:        testPost("a-post-slug", nil),
+			method:      http.MethodPost,
+			content:     "second version",
+			wantStatus:  http.StatusUnauthorized,
+			wantContent: "first version",
+		},
+		{
+			name:        "authors get the form",
+			post:        testPost("a-post-slug", nil),
+			method:      http.MethodGet,
+			userID:      1,
+			wantStatus:  http.StatusOK,
+			wantContent: "first version",
+		},
+		{
+			name:        "other users can't edit",
+			post:        testPost("a-post-slug", nil),
+			method:      http.MethodPost,
+			userID:      2,
+			content:     "second version",
+			wantStatus:  http.StatusNotFound,
+			wantContent: "first version",
+		},
+		{
+			name:         "authors edit",
+			post:         testPost("a-post-slug", nil),
+			method:       http.MethodPost,
+			userID:       1,
+			content:      "second version",
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/blogs/a-blog-slug/a-post-slug#comment-1",
+			wantContent:  "second version",
+		},
+		{
+			name:        "edits are length checked",
+			post:        testPost("a-post-slug", nil),
+			method:      http.MethodPost,
+			userID:      1,
+			content:     strings.Repeat("a", maxCommentLen+1),
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantContent: "first version",
+		},
+		{
+			name:        "the edit window closes",
+			post:        testPost("a-post-slug", nil),
+			method:      http.MethodPost,
+			userID:      1,
+			postedAgo:   time.Hour,
+			content:     "second version",
+			wantStatus:  http.StatusForbidden,
+			wantContent: "first version",
+		},
+		{
+			name:        "closed comments can't be edited",
+			post:        testPost("a-post-slug", func(p *storage.Post) { p.AllowComments = false }),
+			method:      http.MethodPost,
+			userID:      1,
+			content:     "second version",
+			wantStatus:  http.StatusForbidden,
+			wantContent: "first version",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := newFakeStore(tt.post)
+			db.comments = []*storage.Comment{{ID: 1, PostID: 1, UserID: new(int64(1)), Content: "first version", CreatedAt: time.Now().Add(-tt.postedAgo)}}
+			h := newTestHandler(db, fakeS3{})
+
+			mux := http.NewServeMux()
+			mux.Handle("GET /blogs/{blog_slug}/{post_slug}/comment/{commentID}/edit", h.HandleEditCommentPage())
+			mux.Handle("POST /blogs/{blog_slug}/{post_slug}/comment/{commentID}/edit", h.HandleEditComment())
+
+			form := url.Values{"content": {tt.content}}
+			req := httptest.NewRequest(tt.method, "/blogs/a-blog-slug/a-post-slug/comment/1/edit", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+			rec := serve(h, mux, req, tt.userID)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status: want %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if loc := rec.Header().Get("Location"); loc != tt.wantLocation {
+				t.Fatalf("location: want %q, got %q", tt.wantLocation, loc)
+			}
+			if got := db.comments[0].Content; got != tt.wantContent {
+				t.Fatalf("content: want %q, got %q", tt.wantContent, got)
+			}
+		})
+	}
+}
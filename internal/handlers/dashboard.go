@@ -0,0 +1,425 @@
+package handlers
+
+import (
+	"blogengine/internal/components"
+	"blogengine/internal/storage"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	dashboardBlogsLimit  = 100
+	dashboardDraftsLimit = 50
+)
+
+// HandleDashboard lists the blogs owned by the logged in user and their unpublished posts
+func (h *BlogHandler) HandleDashboard() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleDashboard")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		blogs, err := h.DB.GetBlogsByUserID(ctx, userID, 0, dashboardBlogsLimit)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		drafts, err := h.DB.GetDraftsByAuthor(ctx, userID, 0, dashboardDraftsLimit)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		components.Dashboard(common, blogs, drafts).Render(ctx, w)
+	})
+}
+
+func (h *BlogHandler) HandleNewBlogPage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleNewBlogPage")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		if _, ok := h.dashboardUser(w, r); !ok {
+			return
+		}
+
+		form := components.BlogForm{
+			Visibility:       storage.VisibilityPublic,
+			RegistrationMode: storage.RegistrationOpen,
+		}
+		components.BlogEditor(common, form).Render(ctx, w)
+	})
+}
+
+func (h *BlogHandler) HandleCreateBlog() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleCreateBlog")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		form := blogFormFromRequest(r)
+		limit, ok := parseRegistrationLimit(&form)
+		if !ok {
+			h.renderBlogForm(w, r, common, form)
+			return
+		}
+
+		params := storage.CreateBlogParams{
+			OwnerID:           userID,
+			Slug:              form.Slug,
+			Title:             form.Title,
+			Description:       optionalString(form.Description),
+			Visibility:        form.Visibility,
+			RegistrationMode:  form.RegistrationMode,
+			RegistrationLimit: limit,
+		}
+		blog, err := h.DB.CreateBlog(ctx, params)
+		if err != nil {
+			if !blogFormError(&form, err) {
+				h.InternalError(w, r, err)
+				return
+			}
+			h.renderBlogForm(w, r, common, form)
+			return
+		}
+
+		h.Logger.Info("blog created", "user_id", userID, "blog_id", blog.ID)
+		http.Redirect(w, r, dashboardBlogPath(blog.ID), http.StatusSeeOther)
+	})
+}
+
+func (h *BlogHandler) HandleEditBlogPage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleEditBlogPage")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		blog, err := h.ownedBlog(ctx, r, userID)
+		if err != nil {
+			h.dashboardError(w, r, err)
+			return
+		}
+
+		components.BlogEditor(common, blogFormFromBlog(blog)).Render(ctx, w)
+	})
+}
+
+func (h *BlogHandler) HandleUpdateBlog() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleUpdateBlog")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		blog, err := h.ownedBlog(ctx, r, userID)
+		if err != nil {
+			h.dashboardError(w, r, err)
+			return
+		}
+
+		// only the details are posted, the other forms keep showing the stored values
+		form := blogFormFromBlog(blog)
+		posted := blogFormFromRequest(r)
+		form.Slug, form.Title, form.Description = posted.Slug, posted.Title, posted.Description
+
+		params := storage.UpdateBlogParams{
+			BlogID:      blog.ID,
+			OwnerID:     userID,
+			Slug:        form.Slug,
+			Title:       form.Title,
+			Description: optionalString(form.Description),
+		}
+		if _, err := h.DB.UpdateBlog(ctx, params); err != nil {
+			if !blogFormError(&form, err) {
+				h.dashboardError(w, r, err)
+				return
+			}
+			h.renderBlogForm(w, r, common, form)
+			return
+		}
+
+		h.Logger.Info("blog updated", "user_id", userID, "blog_id", blog.ID)
+		http.Redirect(w, r, dashboardBlogPath(blog.ID), http.StatusSeeOther)
+	})
+}
+
+func (h *BlogHandler) HandleUpdateBlogVisibility() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleUpdateBlogVisibility")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		blog, err := h.ownedBlog(ctx, r, userID)
+		if err != nil {
+			h.dashboardError(w, r, err)
+			return
+		}
+
+		form := blogFormFromBlog(blog)
+		form.Visibility = storage.Visibility(r.FormValue("visibility"))
+
+		if err := h.DB.UpdateBlogVisibility(ctx, blog.ID, userID, form.Visibility); err != nil {
+			if !blogFormError(&form, err) {
+				h.dashboardError(w, r, err)
+				return
+			}
+			h.renderBlogForm(w, r, common, form)
+			return
+		}
+
+		h.Logger.Info("blog visibility changed", "user_id", userID, "blog_id", blog.ID, "visibility", form.Visibility)
+		http.Redirect(w, r, dashboardBlogPath(blog.ID), http.StatusSeeOther)
+	})
+}
+
+func (h *BlogHandler) HandleUpdateBlogRegistration() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleUpdateBlogRegistration")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		blog, err := h.ownedBlog(ctx, r, userID)
+		if err != nil {
+			h.dashboardError(w, r, err)
+			return
+		}
+
+		form := blogFormFromBlog(blog)
+		form.RegistrationMode = storage.RegistrationMode(r.FormValue("registration_mode"))
+		form.RegistrationLimit = strings.TrimSpace(r.FormValue("registration_limit"))
+
+		limit, ok := parseRegistrationLimit(&form)
+		if !ok {
+			h.renderBlogForm(w, r, common, form)
+			return
+		}
+
+		params := storage.UpdateBlogRegistrationParams{
+			BlogID:            blog.ID,
+			OwnerID:           userID,
+			RegistrationMode:  form.RegistrationMode,
+			RegistrationLimit: limit,
+		}
+		if err := h.DB.UpdateBlogRegistration(ctx, params); err != nil {
+			if !blogFormError(&form, err) {
+				h.dashboardError(w, r, err)
+				return
+			}
+			h.renderBlogForm(w, r, common, form)
+			return
+		}
+
+		h.Logger.Info("blog registration changed", "user_id", userID, "blog_id", blog.ID, "mode", form.RegistrationMode)
+		http.Redirect(w, r, dashboardBlogPath(blog.ID), http.StatusSeeOther)
+	})
+}
+
+// HandleUpdateBlogCommentPolicy changes which new comments of the blog wait in the moderation queue
+func (h *BlogHandler) HandleUpdateBlogCommentPolicy() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleUpdateBlogCommentPolicy")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		blog, err := h.ownedBlog(ctx, r, userID)
+		if err != nil {
+			h.dashboardError(w, r, err)
+			return
+		}
+
+		form := blogFormFromBlog(blog)
+		form.CommentPolicy = storage.CommentPolicy(r.FormValue("comment_policy"))
+
+		if err := h.DB.UpdateBlogCommentPolicy(ctx, blog.ID, userID, form.CommentPolicy); err != nil {
+			if !blogFormError(&form, err) {
+				h.dashboardError(w, r, err)
+				return
+			}
+			h.renderBlogForm(w, r, common, form)
+			return
+		}
+
+		h.Logger.Info("blog comment policy changed", "user_id", userID, "blog_id", blog.ID, "policy", form.CommentPolicy)
+		http.Redirect(w, r, dashboardBlogPath(blog.ID), http.StatusSeeOther)
+	})
+}
+
+// HandleDeleteBlog soft deletes a blog, its slug becomes available again
+func (h *BlogHandler) HandleDeleteBlog() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleDeleteBlog")
+		defer span.End()
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		blog, err := h.ownedBlog(ctx, r, userID)
+		if err == nil {
+			err = h.DB.DeleteBlog(ctx, blog.ID, userID)
+		}
+		if err != nil {
+			h.dashboardError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("blog deleted", "user_id", userID, "blog_id", blog.ID)
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+	})
+}
+
+// dashboardUser returns the logged in user, anonymous visitors are sent to login (GET) or refused
+func (h *BlogHandler) dashboardUser(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	userID := h.Sessions.Manager.GetInt64(r.Context(), "userID")
+	if userID != 0 {
+		return userID, true
+	}
+
+	if r.Method == http.MethodGet {
+		http.Redirect(w, r, loginURL(r.URL.RequestURI()), http.StatusSeeOther)
+	} else {
+		h.Unauthorised(w, r)
+	}
+	return 0, false
+}
+
+// ownedBlog loads the blog from the path, blogs of other users are reported as not found
+func (h *BlogHandler) ownedBlog(ctx context.Context, r *http.Request, userID int64) (*storage.Blog, error) {
+	blogID, err := strconv.ParseInt(r.PathValue("blog_id"), 10, 64)
+	if err != nil || blogID < 1 {
+		return nil, storage.ErrNotFound
+	}
+
+	blog, err := h.DB.GetBlogByID(ctx, blogID)
+	if err != nil {
+		return nil, err
+	}
+	if blog.OwnerID != userID {
+		return nil, storage.ErrNotFound
+	}
+	return blog, nil
+}
+
+func (h *BlogHandler) dashboardError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		h.NotFound(w, r)
+	default:
+		h.InternalError(w, r, err)
+	}
+}
+
+func (h *BlogHandler) renderBlogForm(w http.ResponseWriter, r *http.Request, common components.CommonData, form components.BlogForm) {
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	components.BlogEditor(common, form).Render(r.Context(), w)
+}
+
+// blogFormError puts validation and duplicate slug errors on the form, it reports false for any other error
+func blogFormError(form *components.BlogForm, err error) bool {
+	if form.Errors == nil {
+		form.Errors = make(map[string]string)
+	}
+
+	var invalid *storage.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		form.Errors[invalid.Field] = invalid.Error()
+	case errors.Is(err, storage.ErrUniqueViolation):
+		form.Errors["slug"] = "this slug is already taken"
+	default:
+		return false
+	}
+	return true
+}
+
+// parseRegistrationLimit reads the optional seat count, leaving range checks to the store
+func parseRegistrationLimit(form *components.BlogForm) (*int64, bool) {
+	if form.RegistrationLimit == "" {
+		return nil, true
+	}
+
+	limit, err := strconv.ParseInt(form.RegistrationLimit, 10, 64)
+	if err != nil {
+		form.Errors = map[string]string{"registration_limit": "seats must be a whole number"}
+		return nil, false
+	}
+	return &limit, true
+}
+
+func blogFormFromRequest(r *http.Request) components.BlogForm {
+	return components.BlogForm{
+		Slug:              strings.TrimSpace(r.FormValue("slug")),
+		Title:             strings.TrimSpace(r.FormValue("title")),
+		Description:       strings.TrimSpace(r.FormValue("description")),
+		Visibility:        storage.Visibility(r.FormValue("visibility")),
+		RegistrationMode:  storage.RegistrationMode(r.FormValue("registration_mode")),
+		RegistrationLimit: strings.TrimSpace(r.FormValue("registration_limit")),
+	}
+}
+
+func blogFormFromBlog(blog *storage.Blog) components.BlogForm {
+	form := components.BlogForm{
+		BlogID:           blog.ID,
+		Slug:             blog.Slug,
+		Title:            blog.Title,
+		Description:      derefOr(blog.Description, ""),
+		Visibility:       blog.Visibility,
+		RegistrationMode: blog.RegistrationMode,
+		CommentPolicy:    blog.CommentPolicy,
+	}
+	if blog.RegistrationLimit != nil {
+		form.RegistrationLimit = strconv.FormatInt(*blog.RegistrationLimit, 10)
+	}
+	return form
+}
+
+func dashboardBlogPath(blogID int64) string {
+	return "/dashboard/blogs/" + strconv.FormatInt(blogID, 10)
+}
+
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
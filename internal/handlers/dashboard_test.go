@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"blogengine/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestDashboardBlogForms(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name         string
+		method       string
+		target       string
+		form         url.Values
+		userID       int64
+		wantStatus   int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:         "anonymous readers are sent to login",
+			method:       http.MethodGet,
+			target:       "/dashboard/blogs/1",
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/login?next=%2Fdashboard%2Fblogs%2F1",
+		},
+		{
+			name:       "anonymous posts are refused",
+			method:     http.MethodPost,
+			target:     "/dashboard/blogs",
+			form:       url.Values{"slug": {"a-new-blog"}},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "create",
+			method: http.MethodPost,
+			target: "/dashboard/blogs",
+			form: url.Values{
+				"slug": {"a-new-blog"}, "title": {"A new blog"},
+				"visibility": {"public"}, "registration_mode": {"open"},
+			},
+			userID:       1,
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/dashboard/blogs/2",
+		},
+		{
+			name:       "invalid slug is shown on the form",
+			method:     http.MethodPost,
+			target:     "/dashboard/blogs",
+			form:       url.Values{"slug": {"abc"}, "title": {"A new blog"}},
+			userID:     1,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   "slug is too short",
+		},
+		{
+			name:       "duplicate slug is shown on the form",
+			method:     http.MethodPost,
+			target:     "/dashboard/blogs",
+			form:       url.Values{"slug": {"a-blog-slug"}, "title": {"A new blog"}},
+			userID:     1,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   "this slug is already taken",
+		},
+		{
+			name:       "seats must be a number",
+			method:     http.MethodPost,
+			target:     "/dashboard/blogs",
+			form:       url.Values{"slug": {"a-new-blog"}, "registration_mode": {"limited"}, "registration_limit": {"lots"}},
+			userID:     1,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   "seats must be a whole number",
+		},
+		{
+			name:       "owner edits their blog",
+			method:     http.MethodGet,
+			target:     "/dashboard/blogs/1",
+			userID:     1,
+			wantStatus: http.StatusOK,
+			wantBody:   `value="a-blog-slug"`,
+		},
+		{
+			name:       "blogs of other users are not found",
+			method:     http.MethodPost,
+			target:     "/dashboard/blogs/1",
+			form:       url.Values{"slug": {"stolen-blog"}, "title": {"Stolen blog"}},
+			userID:     2,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:         "update",
+			method:       http.MethodPost,
+			target:       "/dashboard/blogs/1",
+			form:         url.Values{"slug": {"a-blog-slug"}, "title": {"A better title"}},
+			userID:       1,
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/dashboard/blogs/1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := newFakeStore()
+			db.blogs = []*storage.Blog{{ID: 1, OwnerID: 1, Slug: "a-blog-slug", Title: "A blog title"}}
+			h := newTestHandler(db, fakeS3{})
+
+			mux := http.NewServeMux()
+			mux.Handle("POST /dashboard/blogs", h.HandleCreateBlog())
+			mux.Handle("GET /dashboard/blogs/{blog_id}", h.HandleEditBlogPage())
+			mux.Handle("POST /dashboard/blogs/{blog_id}", h.HandleUpdateBlog())
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := serve(h, mux, req, tt.userID)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status: want %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if loc := rec.Header().Get("Location"); loc != tt.wantLocation {
+				t.Fatalf("location: want %q, got %q", tt.wantLocation, loc)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Fatalf("body does not contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestMemberRoles(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name         string
+		method       string
+		target       string
+		form         url.Values
+		userID       int64
+		wantStatus   int
+		wantLocation string
+		wantBody     string
+		wantRole     storage.BlogRole // of user 2 afterwards
+	}{
+		{
+			name:       "owner lists the members",
+			method:     http.MethodGet,
+			target:     "/dashboard/blogs/1/members",
+			userID:     1,
+			wantStatus: http.StatusOK,
+			wantBody:   `action="/dashboard/blogs/1/members/2/role"`,
+			wantRole:   storage.RoleCommenter,
+		},
+		{
+			name:       "members of other users' blogs are not found",
+			method:     http.MethodGet,
+			target:     "/dashboard/blogs/1/members",
+			userID:     2,
+			wantStatus: http.StatusNotFound,
+			wantRole:   storage.RoleCommenter,
+		},
+		{
+			name:         "owner makes an editor",
+			method:       http.MethodPost,
+			target:       "/dashboard/blogs/1/members/2/role",
+			form:         url.Values{"role": {"editor"}},
+			userID:       1,
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/dashboard/blogs/1/members",
+			wantRole:     storage.RoleEditor,
+		},
+		{
+			name:         "owner makes an author and goes back to the page",
+			method:       http.MethodPost,
+			target:       "/dashboard/blogs/1/members/2/role",
+			form:         url.Values{"role": {"author"}, "page": {"2"}},
+			userID:       1,
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/dashboard/blogs/1/members?page=2",
+			wantRole:     storage.RoleAuthor,
+		},
+		{
+			name:       "members can't change roles",
+			method:     http.MethodPost,
+			target:     "/dashboard/blogs/1/members/2/role",
+			form:       url.Values{"role": {"editor"}},
+			userID:     2,
+			wantStatus: http.StatusNotFound,
+			wantRole:   storage.RoleCommenter,
+		},
+		{
+			name:       "owner role is not handed out",
+			method:     http.MethodPost,
+			target:     "/dashboard/blogs/1/members/2/role",
+			form:       url.Values{"role": {"owner"}},
+			userID:     1,
+			wantStatus: http.StatusBadRequest,
+			wantRole:   storage.RoleCommenter,
+		},
+		{
+			name:       "owner keeps their role",
+			method:     http.MethodPost,
+			target:     "/dashboard/blogs/1/members/1/role",
+			form:       url.Values{"role": {"commenter"}},
+			userID:     1,
+			wantStatus: http.StatusNotFound,
+			wantRole:   storage.RoleCommenter,
+		},
+		{
+			name:       "not a member",
+			method:     http.MethodPost,
+			target:     "/dashboard/blogs/1/members/3/role",
+			form:       url.Values{"role": {"editor"}},
+			userID:     1,
+			wantStatus: http.StatusNotFound,
+			wantRole:   storage.RoleCommenter,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := newFakeStore()
+			db.blogs = []*storage.Blog{{ID: 1, OwnerID: 1, Slug: "a-blog-slug", Title: "A blog title"}}
+			db.members = []*storage.BlogMember{
+				{BlogID: 1, UserID: 1, Username: "owner", Role: storage.RoleOwner},
+				{BlogID: 1, UserID: 2, Username: "member", Role: storage.RoleCommenter},
+			}
+			h := newTestHandler(db, fakeS3{})
+
+			mux := http.NewServeMux()
+			mux.Handle("GET /dashboard/blogs/{blog_id}/members", h.HandleMembersPage())
+			mux.Handle("POST /dashboard/blogs/{blog_id}/members/{user_id}/role", h.HandleSetMemberRole())
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := serve(h, mux, req, tt.userID)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status: want %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if loc := rec.Header().Get("Location"); loc != tt.wantLocation {
+				t.Fatalf("location: want %q, got %q", tt.wantLocation, loc)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Fatalf("body does not contain %q", tt.wantBody)
+			}
+			if role := db.members[1].Role; role != tt.wantRole {
+				t.Fatalf("role: want %q, got %q", tt.wantRole, role)
+			}
+		})
+	}
+}
@@ -0,0 +1,424 @@
+package handlers
+
+import (
+	"blogengine/internal/components"
+	"blogengine/internal/storage"
+	"blogengine/internal/utils"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const maxPostBodyLen = 1 << 20 // markdown bytes, for saving and previewing
+
+var (
+	errEditorForbidden   = errors.New("role can't write posts")
+	errEncryptedPostEdit = errors.New("encrypted posts can't be edited in the browser")
+)
+
+// HandleNewPostPage shows an empty editor for the blog given by the blog query parameter
+func (h *BlogHandler) HandleNewPostPage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleNewPostPage")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		blog, err := h.writableBlog(ctx, r.URL.Query().Get("blog"), userID)
+		if err != nil {
+			h.editorError(w, r, err)
+			return
+		}
+
+		form := components.PostForm{
+			BlogSlug:      blog.Slug,
+			IsListed:      true,
+			AllowComments: true,
+		}
+		components.PostEditor(common, form).Render(ctx, w)
+	})
+}
+
+// HandleCreatePost creates a post and uploads its markdown, the post is removed again if the upload fails
+func (h *BlogHandler) HandleCreatePost() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleCreatePost")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		form := postFormFromRequest(r)
+		blog, err := h.writableBlog(ctx, form.BlogSlug, userID)
+		if err != nil {
+			h.editorError(w, r, err)
+			return
+		}
+		if !checkPostBody(&form) {
+			h.renderPostForm(w, r, common, form)
+			return
+		}
+
+		slug := form.Slug
+		if slug == "" {
+			slug = utils.Slugify(form.Title)
+		}
+
+		params := storage.CreatePostParams{
+			BlogID:        blog.ID,
+			AuthorID:      userID,
+			Slug:          &slug,
+			Title:         form.Title,
+			Description:   optionalString(form.Description),
+			Category:      optionalString(form.Category),
+			RequiresAuth:  form.RequiresAuth,
+			IsListed:      form.IsListed,
+			AllowComments: form.AllowComments,
+			PublishedAt:   publishedAt(form.Publish, nil),
+		}
+		post, err := h.DB.CreatePost(ctx, params)
+		if err != nil {
+			if !postFormError(&form, err) {
+				h.InternalError(w, r, err)
+				return
+			}
+			h.renderPostForm(w, r, common, form)
+			return
+		}
+
+		if err := h.savePostContent(ctx, post, form.Body, formTags(form)); err != nil {
+			// a post without its markdown can't be read, don't leave it behind
+			if delErr := h.DB.DeletePost(ctx, post.ID); delErr != nil {
+				h.Logger.Error("could not remove post after failed save", "post_id", post.ID, "err", delErr)
+			}
+			if !postFormError(&form, err) {
+				h.InternalError(w, r, err)
+				return
+			}
+			h.renderPostForm(w, r, common, form)
+			return
+		}
+
+		h.Logger.Info("post created", "user_id", userID, "blog_id", blog.ID, "post_id", post.ID)
+		http.Redirect(w, r, dashboardPostPath(post.ID), http.StatusSeeOther)
+	})
+}
+
+func (h *BlogHandler) HandleEditPostPage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleEditPostPage")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		post, err := h.editablePost(ctx, r, userID)
+		if err != nil {
+			h.editorError(w, r, err)
+			return
+		}
+
+		body, err := h.readPostObject(ctx, post)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		tags, err := h.DB.GetTagsForPost(ctx, post.ID)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		form := postFormFromPost(post)
+		form.Body = string(body)
+		form.Tags = strings.Join(tags, ", ")
+
+		components.PostEditor(common, form).Render(ctx, w)
+	})
+}
+
+// HandleUpdatePost saves the editor form, the s3 key of a post never changes so the markdown is overwritten in place
+func (h *BlogHandler) HandleUpdatePost() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleUpdatePost")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		post, err := h.editablePost(ctx, r, userID)
+		if err != nil {
+			h.editorError(w, r, err)
+			return
+		}
+
+		form := postFormFromRequest(r)
+		form.PostID, form.BlogSlug, form.PublishedAt = post.ID, post.BlogSlug, post.PublishedAt
+		if !checkPostBody(&form) {
+			h.renderPostForm(w, r, common, form)
+			return
+		}
+
+		slug := form.Slug
+		if slug == "" {
+			slug = utils.Slugify(form.Title)
+		}
+
+		params := storage.UpdatePostParams{
+			PostID:        post.ID,
+			Slug:          &slug,
+			Title:         form.Title,
+			Description:   optionalString(form.Description),
+			Category:      optionalString(form.Category),
+			RequiresAuth:  form.RequiresAuth,
+			IsListed:      form.IsListed,
+			AllowComments: form.AllowComments,
+			PublishedAt:   publishedAt(form.Publish, post.PublishedAt),
+		}
+		updated, err := h.DB.UpdatePost(ctx, params)
+		if err == nil {
+			err = h.savePostContent(ctx, updated, form.Body, formTags(form))
+		}
+		if err != nil {
+			if !postFormError(&form, err) {
+				h.editorError(w, r, err)
+				return
+			}
+			h.renderPostForm(w, r, common, form)
+			return
+		}
+
+		h.Logger.Info("post updated", "user_id", userID, "post_id", post.ID)
+		http.Redirect(w, r, dashboardPostPath(post.ID), http.StatusSeeOther)
+	})
+}
+
+// HandleDeletePost soft deletes a post, its markdown stays in s3
+func (h *BlogHandler) HandleDeletePost() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleDeletePost")
+		defer span.End()
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		post, err := h.editablePost(ctx, r, userID)
+		if err == nil {
+			err = h.DB.DeletePost(ctx, post.ID)
+		}
+		if err != nil {
+			h.editorError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("post deleted", "user_id", userID, "post_id", post.ID)
+		http.Redirect(w, r, "/dashboard", http.StatusSeeOther)
+	})
+}
+
+// HandlePreview renders the posted markdown the same way published posts are, for the editor's live preview
+func (h *BlogHandler) HandlePreview() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := h.Tracer.Start(r.Context(), "HandlePreview")
+		defer span.End()
+
+		if h.Sessions.Manager.GetInt64(r.Context(), "userID") == 0 {
+			h.Unauthorised(w, r)
+			return
+		}
+
+		body := r.FormValue("body")
+		if len(body) > maxPostBodyLen {
+			http.Error(w, "post is too long", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		html, err := h.Renderer.Render([]byte(body))
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write(html)
+	})
+}
+
+// writableBlog loads a blog the user may create posts on, owners, editors and authors
+func (h *BlogHandler) writableBlog(ctx context.Context, blogSlug string, userID int64) (*storage.Blog, error) {
+	blog, err := h.DB.GetBlogBySlug(ctx, blogSlug)
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := h.DB.GetBlogMember(ctx, blog.ID, userID)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return nil, errEditorForbidden
+	case err != nil:
+		return nil, err
+	case !member.Role.CanWritePosts():
+		return nil, errEditorForbidden
+	}
+	return blog, nil
+}
+
+// editablePost loads the post from the path, owners and editors can edit every post of their blog and authors their own.
+// Posts the user can't edit are reported as not found, encrypted posts are managed by the seeder only
+func (h *BlogHandler) editablePost(ctx context.Context, r *http.Request, userID int64) (*storage.Post, error) {
+	postID, err := strconv.ParseInt(r.PathValue("post_id"), 10, 64)
+	if err != nil || postID < 1 {
+		return nil, storage.ErrNotFound
+	}
+
+	post, err := h.DB.GetPostByID(ctx, postID)
+	if err != nil {
+		return nil, err
+	}
+
+	member, err := h.DB.GetBlogMember(ctx, post.BlogID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !member.Role.CanEditAllPosts() && !(member.Role.CanWritePosts() && post.AuthorID == userID) {
+		return nil, storage.ErrNotFound
+	}
+
+	if post.IsEncrypted {
+		return nil, errEncryptedPostEdit
+	}
+	return post, nil
+}
+
+// savePostContent uploads the markdown then refreshes the tags and the search index of post
+func (h *BlogHandler) savePostContent(ctx context.Context, post *storage.Post, body string, tags []string) error {
+	if err := h.S3.Save(ctx, post.S3Key, strings.NewReader(body)); err != nil {
+		return err
+	}
+	// the new version already misses, dropping the old html frees its room right away
+	h.PostCache.Invalidate(post.S3Key)
+
+	if err := h.DB.SyncPostTaxonomy(ctx, post.ID, post.Category, tags); err != nil {
+		return err
+	}
+
+	// same rule as the seeder, protected posts are only searchable by their metadata
+	if post.RequiresAuth {
+		body = ""
+	}
+	return h.DB.IndexPostBody(ctx, post.ID, body)
+}
+
+func (h *BlogHandler) editorError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errEditorForbidden):
+		h.Forbidden(w, r)
+	case errors.Is(err, errEncryptedPostEdit):
+		h.RenderError(w, r, http.StatusConflict, "Encrypted post",
+			"Encrypted posts are managed from the sources directory, they can't be edited here.")
+	default:
+		h.dashboardError(w, r, err)
+	}
+}
+
+func (h *BlogHandler) renderPostForm(w http.ResponseWriter, r *http.Request, common components.CommonData, form components.PostForm) {
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	components.PostEditor(common, form).Render(r.Context(), w)
+}
+
+// postFormError puts validation and duplicate slug errors on the form, it reports false for any other error
+func postFormError(form *components.PostForm, err error) bool {
+	if form.Errors == nil {
+		form.Errors = make(map[string]string)
+	}
+
+	var invalid *storage.ValidationError
+	switch {
+	case errors.As(err, &invalid):
+		form.Errors[invalid.Field] = invalid.Error()
+	case errors.Is(err, storage.ErrUniqueViolation):
+		form.Errors["slug"] = "this slug is already used on this blog"
+	default:
+		return false
+	}
+	return true
+}
+
+func formTags(form components.PostForm) []string {
+	return utils.NormaliseTags(strings.Split(form.Tags, ","))
+}
+
+func checkPostBody(form *components.PostForm) bool {
+	if len(form.Body) <= maxPostBodyLen {
+		return true
+	}
+	form.Errors = map[string]string{"body": errPostBodyTooLong.Error()}
+	return false
+}
+
+// publishedAt keeps the date of a post that stays published, a newly published post is published now
+func publishedAt(publish bool, current *time.Time) *time.Time {
+	if !publish {
+		return nil
+	}
+	if current != nil {
+		return current
+	}
+	now := time.Now().UTC()
+	return &now
+}
+
+func postFormFromRequest(r *http.Request) components.PostForm {
+	return components.PostForm{
+		BlogSlug:      strings.TrimSpace(r.FormValue("blog")),
+		Title:         strings.TrimSpace(r.FormValue("title")),
+		Slug:          strings.TrimSpace(r.FormValue("slug")),
+		Description:   strings.TrimSpace(r.FormValue("description")),
+		Category:      strings.TrimSpace(r.FormValue("category")),
+		Tags:          strings.TrimSpace(r.FormValue("tags")),
+		Body:          r.FormValue("body"),
+		RequiresAuth:  r.FormValue("requires_auth") == "on",
+		IsListed:      r.FormValue("is_listed") == "on",
+		AllowComments: r.FormValue("allow_comments") == "on",
+		Publish:       r.FormValue("publish") == "on",
+	}
+}
+
+func postFormFromPost(post *storage.Post) components.PostForm {
+	return components.PostForm{
+		PostID:        post.ID,
+		BlogSlug:      post.BlogSlug,
+		Title:         post.Title,
+		Slug:          derefOr(post.Slug, ""),
+		Description:   derefOr(post.Description, ""),
+		Category:      derefOr(post.Category, ""),
+		RequiresAuth:  post.RequiresAuth,
+		IsListed:      post.IsListed,
+		AllowComments: post.AllowComments,
+		Publish:       post.PublishedAt != nil,
+		PublishedAt:   post.PublishedAt,
+	}
+}
+
+func dashboardPostPath(postID int64) string {
+	return "/dashboard/posts/" + strconv.FormatInt(postID, 10)
+}
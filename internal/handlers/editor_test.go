@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"blogengine/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPostEditor(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name         string
+		method       string
+		target       string
+		form         url.Values
+		userID       int64
+		wantStatus   int
+		wantLocation string
+		wantBody     string
+		wantSaved    string // s3 key expected to hold the posted body
+	}{
+		{
+			name:       "anonymous previews are refused",
+			method:     http.MethodPost,
+			target:     "/dashboard/preview",
+			form:       url.Values{"body": {"**bold**"}},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "preview renders markdown",
+			method:     http.MethodPost,
+			target:     "/dashboard/preview",
+			form:       url.Values{"body": {"some **bold** text"}},
+			userID:     3,
+			wantStatus: http.StatusOK,
+			wantBody:   "<strong>bold</strong>",
+		},
+		{
+			name:       "preview is limited",
+			method:     http.MethodPost,
+			target:     "/dashboard/preview",
+			form:       url.Values{"body": {strings.Repeat("a", maxPostBodyLen+1)}},
+			userID:     3,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "commenters can't write posts",
+			method:     http.MethodGet,
+			target:     "/dashboard/posts/new?blog=a-blog-slug",
+			userID:     3,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "authors get an empty editor",
+			method:     http.MethodGet,
+			target:     "/dashboard/posts/new?blog=a-blog-slug",
+			userID:     2,
+			wantStatus: http.StatusOK,
+			wantBody:   `name="blog" value="a-blog-slug"`,
+		},
+		{
+			name:         "create uploads the markdown",
+			method:       http.MethodPost,
+			target:       "/dashboard/posts",
+			form:         url.Values{"blog": {"a-blog-slug"}, "title": {"A new post"}, "body": {"# hello"}},
+			userID:       2,
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/dashboard/posts/3",
+			wantSaved:    "posts/3.md",
+		},
+		{
+			name:       "duplicate slug is shown on the form",
+			method:     http.MethodPost,
+			target:     "/dashboard/posts",
+			form:       url.Values{"blog": {"a-blog-slug"}, "title": {"A new post"}, "slug": {"a-post"}},
+			userID:     2,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   "this slug is already used on this blog",
+		},
+		{
+			name:       "authors edit their posts",
+			method:     http.MethodGet,
+			target:     "/dashboard/posts/1",
+			userID:     2,
+			wantStatus: http.StatusOK,
+			wantBody:   "the stored markdown",
+		},
+		{
+			name:       "authors can't edit other posts",
+			method:     http.MethodGet,
+			target:     "/dashboard/posts/1",
+			userID:     5,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:         "editors edit every post",
+			method:       http.MethodPost,
+			target:       "/dashboard/posts/1",
+			form:         url.Values{"title": {"An edited title"}, "slug": {"a-post"}, "body": {"edited"}},
+			userID:       4,
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/dashboard/posts/1",
+			wantSaved:    "a-blog-slug/a-post",
+		},
+		{
+			name:       "encrypted posts stay with the seeder",
+			method:     http.MethodGet,
+			target:     "/dashboard/posts/2",
+			userID:     1,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:         "owners delete posts",
+			method:       http.MethodPost,
+			target:       "/dashboard/posts/1/delete",
+			userID:       1,
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/dashboard",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := newFakeStore(
+				testPost("a-post", func(p *storage.Post) { p.AuthorID = 2 }),
+				testPost("a-locked-post", func(p *storage.Post) { p.ID, p.IsEncrypted = 2, true }),
+			)
+			db.blogs = []*storage.Blog{{ID: 1, OwnerID: 1, Slug: "a-blog-slug", Title: "A blog title"}}
+			db.members = []*storage.BlogMember{
+				{BlogID: 1, UserID: 1, Role: storage.RoleOwner},
+				{BlogID: 1, UserID: 2, Role: storage.RoleAuthor},
+				{BlogID: 1, UserID: 3, Role: storage.RoleCommenter},
+				{BlogID: 1, UserID: 4, Role: storage.RoleEditor},
+				{BlogID: 1, UserID: 5, Role: storage.RoleAuthor},
+			}
+			s3 := fakeS3{"a-blog-slug/a-post": []byte("the stored markdown")}
+			h := newTestHandler(db, s3)
+
+			mux := http.NewServeMux()
+			mux.Handle("GET /dashboard/posts/new", h.HandleNewPostPage())
+			mux.Handle("POST /dashboard/posts", h.HandleCreatePost())
+			mux.Handle("GET /dashboard/posts/{post_id}", h.HandleEditPostPage())
+			mux.Handle("POST /dashboard/posts/{post_id}", h.HandleUpdatePost())
+			mux.Handle("POST /dashboard/posts/{post_id}/delete", h.HandleDeletePost())
+			mux.Handle("POST /dashboard/preview", h.HandlePreview())
+
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := serve(h, mux, req, tt.userID)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status: want %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if loc := rec.Header().Get("Location"); loc != tt.wantLocation {
+				t.Fatalf("location: want %q, got %q", tt.wantLocation, loc)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Fatalf("body does not contain %q", tt.wantBody)
+			}
+			if tt.wantSaved != "" && string(s3[tt.wantSaved]) != tt.form.Get("body") {
+				t.Fatalf("s3 %q: want %q, got %q", tt.wantSaved, tt.form.Get("body"), s3[tt.wantSaved])
+			}
+		})
+	}
+}
@@ -23,6 +23,15 @@ func (h *BlogHandler) Unauthorised(w http.ResponseWriter, r *http.Request) {
 	)
 }
 
+// Forbidden handles 403 errors
+func (h *BlogHandler) Forbidden(w http.ResponseWriter, r *http.Request) {
+	h.Logger.Warn("403 forbidden", "path", r.URL.Path, "ip", r.RemoteAddr)
+	h.RenderError(w, r, http.StatusForbidden,
+		"Forbidden",
+		"You are not allowed to access this page or perform this action.",
+	)
+}
+
 // RenderNotFound is a helper to serve the custom 404 page
 func (h *BlogHandler) NotFound(w http.ResponseWriter, r *http.Request) {
 	h.Logger.Warn("404 not found", "path", r.URL.Path, "method", r.Method, "ip", r.RemoteAddr)
@@ -0,0 +1,286 @@
+package handlers
+
+import (
+	"blogengine/internal/feed"
+	"blogengine/internal/storage"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type FeedFormat int
+
+const (
+	FeedAtom FeedFormat = iota
+	FeedRSS
+)
+
+const feedEntryLimit = 20
+
+// HandleSiteFeed serves the latest posts across all public blogs
+func (h *BlogHandler) HandleSiteFeed(format FeedFormat) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleSiteFeed")
+		defer span.End()
+
+		posts, err := h.DB.GetLatestPublicPosts(ctx, storage.Cursor{}, feedEntryLimit)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		base := h.baseURL(r)
+		f := &feed.Feed{
+			Title:    h.Title,
+			Link:     base + "/",
+			SelfLink: base + r.URL.Path,
+			Updated:  h.StartTime,
+		}
+
+		h.serveFeed(ctx, w, r, f, posts, format)
+	})
+}
+
+// HandleBlogFeed serves the latest posts of a single public blog
+func (h *BlogHandler) HandleBlogFeed(format FeedFormat) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleBlogFeed")
+		defer span.End()
+
+		blog, err := h.DB.GetBlogBySlug(ctx, r.PathValue("blog_slug"))
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				h.NotFound(w, r)
+			default:
+				h.InternalError(w, r, err)
+			}
+			return
+		}
+
+		// feeds are read anonymously, private blogs have none
+		if blog.Visibility != storage.VisibilityPublic {
+			h.NotFound(w, r)
+			return
+		}
+
+		posts, err := h.DB.GetPostsByBlogID(ctx, blog.ID, storage.Cursor{}, feedEntryLimit)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		base := h.baseURL(r)
+		f := &feed.Feed{
+			Title:    blog.Title,
+			Subtitle: derefOr(blog.Description, ""),
+			Link:     base + "/blogs/" + blog.Slug,
+			SelfLink: base + r.URL.Path,
+			Updated:  blog.CreatedAt,
+		}
+		if blog.UpdatedAt != nil {
+			f.Updated = *blog.UpdatedAt
+		}
+
+		h.serveFeed(ctx, w, r, f, posts, format)
+	})
+}
+
+// serveFeed answers conditional requests from post metadata alone and only renders post bodies when the feed changed
+func (h *BlogHandler) serveFeed(ctx context.Context, w http.ResponseWriter, r *http.Request, f *feed.Feed, posts []*storage.Post, format FeedFormat) {
+	lastModified, etag := feedValidators(f.Updated, posts, format)
+	f.Updated = lastModified
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "public, max-age=300")
+
+	if notModified(r, etag, lastModified) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	base := h.baseURL(r)
+	f.Entries = make([]feed.Entry, 0, len(posts))
+	for _, p := range posts {
+		f.Entries = append(f.Entries, h.feedEntry(ctx, base, p))
+	}
+
+	var (
+		body        []byte
+		err         error
+		contentType string
+	)
+	switch format {
+	case FeedRSS:
+		body, err = f.RSS()
+		contentType = feed.RSSContentType
+	default:
+		body, err = f.Atom()
+		contentType = feed.AtomContentType
+	}
+	if err != nil {
+		h.InternalError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write(body)
+}
+
+func (h *BlogHandler) feedEntry(ctx context.Context, base string, p *storage.Post) feed.Entry {
+	entry := feed.Entry{
+		Link:    base + "/blogs/" + p.BlogSlug + "/" + derefOr(p.Slug, p.PublicID),
+		Title:   p.Title,
+		Author:  p.AuthorName,
+		Summary: derefOr(p.Description, ""),
+		Updated: postLastModified(p),
+	}
+	if p.PublishedAt != nil {
+		entry.Published = *p.PublishedAt
+	}
+
+	// protected posts only syndicate their metadata
+	if p.RequiresAuth || p.IsEncrypted {
+		return entry
+	}
+
+	html, err := h.renderPostBody(ctx, p)
+	if err != nil {
+		h.Logger.Error("could not render post for feed", "post_id", p.ID, "err", err)
+		return entry
+	}
+	entry.Content = string(feed.AbsoluteURLs(html, base))
+
+	return entry
+}
+
+// renderPostBody is the html of a plain post, from PostCache when the object wasn't written since it was rendered
+func (h *BlogHandler) renderPostBody(ctx context.Context, p *storage.Post) ([]byte, error) {
+	render := func(ctx context.Context) ([]byte, error) {
+		contentBytes, err := h.readPostObject(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		return h.Renderer.Render(contentBytes)
+	}
+	if h.PostCache == nil {
+		return render(ctx)
+	}
+
+	version, err := h.S3.Version(ctx, p.S3Key)
+	if err != nil {
+		return nil, err
+	}
+
+	// the render is shared with concurrent readers of the post, one of them leaving must not fail the others
+	html, hit, err := h.PostCache.Get(p.S3Key, version, func() ([]byte, error) {
+		return render(context.WithoutCancel(ctx))
+	})
+	if err != nil {
+		return nil, err
+	}
+	h.recordPostCache(ctx, hit)
+	return html, nil
+}
+
+func (h *BlogHandler) recordPostCache(ctx context.Context, hit bool) {
+	status := "miss"
+	if hit {
+		status = "hit"
+	}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("cache.status", status))
+
+	if h.Metrics == nil {
+		return
+	}
+	attrs := metric.WithAttributes(attribute.String("cache", "post_html"))
+	if hit {
+		h.Metrics.CacheHitsTotal.Add(ctx, 1, attrs)
+	} else {
+		h.Metrics.CacheMissesTotal.Add(ctx, 1, attrs)
+	}
+}
+
+// baseURL prefers the configured public url, falling back to what the request was addressed to. The scheme the proxy
+// saw is only taken from X-Forwarded-Proto behind a trusted proxy, like the client ip
+func (h *BlogHandler) baseURL(r *http.Request) string {
+	if h.BaseURL != "" {
+		return h.BaseURL
+	}
+
+	scheme := "http"
+	if r.TLS != nil || h.TrustedProxy && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+func postLastModified(p *storage.Post) time.Time {
+	var t time.Time
+	if p.PublishedAt != nil {
+		t = *p.PublishedAt
+	}
+	if p.UpdatedAt != nil && p.UpdatedAt.After(t) {
+		t = *p.UpdatedAt
+	}
+	return t
+}
+
+// feedValidators derives Last-Modified from the newest post and an ETag that also changes when posts drop out of the feed
+func feedValidators(fallback time.Time, posts []*storage.Post, format FeedFormat) (time.Time, string) {
+	lastModified := fallback
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d;", format)
+
+	for _, p := range posts {
+		modified := postLastModified(p)
+		if modified.After(lastModified) {
+			lastModified = modified
+		}
+		fmt.Fprintf(hash, "%d:%d;", p.ID, modified.Unix())
+	}
+
+	etag := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	return lastModified.Truncate(time.Second), etag
+}
+
+// notModified implements the If-None-Match / If-Modified-Since precedence from RFC 9110
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for candidate := range strings.SplitSeq(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		return !lastModified.After(t)
+	}
+	return false
+}
+
+func derefOr[T any](p *T, fallback T) T {
+	if p == nil {
+		return fallback
+	}
+	return *p
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"blogengine/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFeedValidators(t *testing.T) {
+	t.Parallel()
+
+	published := time.Date(2026, 1, 20, 10, 0, 0, 0, time.UTC)
+	updated := published.Add(2 * time.Hour)
+	fallback := published.Add(-24 * time.Hour)
+
+	posts := []*storage.Post{
+		{ID: 1, PublishedAt: &published},
+		{ID: 2, PublishedAt: &published, UpdatedAt: &updated},
+	}
+
+	lastModified, etag := feedValidators(fallback, posts, FeedAtom)
+	if !lastModified.Equal(updated) {
+		t.Fatalf("last modified: want %s, got %s", updated, lastModified)
+	}
+
+	if _, rssTag := feedValidators(fallback, posts, FeedRSS); rssTag == etag {
+		t.Fatal("atom and rss must not share an etag")
+	}
+	if _, fewerTag := feedValidators(fallback, posts[:1], FeedAtom); fewerTag == etag {
+		t.Fatal("etag must change when a post leaves the feed")
+	}
+
+	lastModified, _ = feedValidators(fallback, nil, FeedAtom)
+	if !lastModified.Equal(fallback) {
+		t.Fatalf("empty feed should fall back: want %s, got %s", fallback, lastModified)
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	t.Parallel()
+
+	etag := `"abc"`
+	lastModified := time.Date(2026, 1, 20, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    bool
+	}{
+		{name: "unconditional", want: false},
+		{name: "matching etag", headers: map[string]string{"If-None-Match": `"abc"`}, want: true},
+		{name: "weak matching etag in list", headers: map[string]string{"If-None-Match": `"zzz", W/"abc"`}, want: true},
+		{name: "stale etag", headers: map[string]string{"If-None-Match": `"zzz"`}, want: false},
+		{
+			name: "etag wins over date",
+			headers: map[string]string{
+				"If-None-Match":     `"zzz"`,
+				"If-Modified-Since": lastModified.Format(http.TimeFormat),
+			},
+			want: false,
+		},
+		{name: "same date", headers: map[string]string{"If-Modified-Since": lastModified.Format(http.TimeFormat)}, want: true},
+		{name: "older date", headers: map[string]string{"If-Modified-Since": lastModified.Add(-time.Hour).Format(http.TimeFormat)}, want: false},
+		{name: "garbage date", headers: map[string]string{"If-Modified-Since": "yesterday"}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := httptest.NewRequest(http.MethodGet, "/feed.xml", nil)
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+
+			if got := notModified(r, etag, lastModified); got != tt.want {
+				t.Fatalf("want %t, got %t", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name         string
+		baseURL      string
+		trustedProxy bool
+		want         string
+	}{
+		{name: "configured url wins", baseURL: "https://blog.example.com", want: "https://blog.example.com"},
+		{name: "forwarded scheme of a trusted proxy", trustedProxy: true, want: "https://example.com"},
+		{name: "forwarded scheme of a client is ignored", want: "http://example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			h := newTestHandler(newFakeStore(), fakeS3{})
+			h.BaseURL, h.TrustedProxy = tt.baseURL, tt.trustedProxy
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("X-Forwarded-Proto", "https")
+
+			if got := h.baseURL(req); got != tt.want {
+				t.Fatalf("base url: want %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,1006 @@
+package handlers
+
+import (
+	"blogengine/internal/content"
+	"blogengine/internal/mail"
+	"blogengine/internal/middleware"
+	"blogengine/internal/spam"
+	"blogengine/internal/storage"
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alexedwards/scs/v2"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// fakeStore embeds the interface so tests only implement what a handler touches, anything else panics
+type fakeStore struct {
+	storage.Store
+
+	mu       sync.Mutex
+	posts    map[string]*storage.Post // keyed by blog slug + "/" + post slug
+	comments []*storage.Comment
+	members  []*storage.BlogMember
+	blogs    []*storage.Blog
+	tokens   map[string]*storage.APIToken // keyed by token hash
+	pending  map[int64]bool               // users whose new comments wait for moderation
+	users    []*storage.User
+	resets   map[string]*storage.PasswordReset // keyed by token hash
+	recovery map[string]int64                  // unused recovery codes, user id keyed by code hash
+	passkeys []*storage.Passkey
+	idents   map[string]int64                  // user id keyed by issuer + " " + subject
+	logins   map[string]*storage.LoginThrottle // keyed by lower case username
+	audits   []*storage.AuditEvent
+}
+
+func newFakeStore(posts ...*storage.Post) *fakeStore {
+	fs := &fakeStore{posts: make(map[string]*storage.Post)}
+	for _, p := range posts {
+		fs.posts[p.BlogSlug+"/"+*p.Slug] = p
+	}
+	return fs
+}
+
+func (f *fakeStore) CreateUser(_ context.Context, username, passwordHash string) (*storage.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, u := range f.users {
+		if strings.EqualFold(u.Username, username) {
+			return nil, storage.ErrUniqueViolation
+		}
+	}
+	u := &storage.User{ID: int64(len(f.users) + 1), Username: username, PasswordHash: passwordHash, CreatedAt: time.Now()}
+	f.users = append(f.users, u)
+	return u, nil
+}
+
+func (f *fakeStore) SetUserEmail(_ context.Context, userID int64, email string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, u := range f.users {
+		if u.ID == userID {
+			u.Email = &email
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (f *fakeStore) GetUserByID(_ context.Context, id int64) (*storage.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, u := range f.users {
+		if u.ID == id && u.DeletedAt == nil {
+			return u, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (f *fakeStore) GetUserByUsername(_ context.Context, username string) (*storage.User, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, u := range f.users {
+		if u.Username == username && u.DeletedAt == nil {
+			return u, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (f *fakeStore) UpdateUserProfile(_ context.Context, userID int64, displayName, bio string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if utf8.RuneCountInString(displayName) > 50 {
+		return &storage.ValidationError{Field: "display_name", Err: errors.New("display name too long")}
+	}
+	for _, u := range f.users {
+		if u.ID == userID && u.DeletedAt == nil {
+			u.DisplayName, u.Bio = nilIfEmpty(displayName), nilIfEmpty(bio)
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (f *fakeStore) SetUserAvatar(_ context.Context, userID int64, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, u := range f.users {
+		if u.ID == userID && u.DeletedAt == nil {
+			u.AvatarKey = nilIfEmpty(key)
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func nilIfEmpty(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// ChangeUserPassword drops the reset tokens of the user like the sqlite trigger does
+func (f *fakeStore) ChangeUserPassword(_ context.Context, userID int64, newHash string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, u := range f.users {
+		if u.ID == userID {
+			u.PasswordHash = newHash
+			u.MustChangePassword, u.Passwordless = false, false
+			delete(f.logins, strings.ToLower(u.Username))
+			maps.DeleteFunc(f.resets, func(_ string, r *storage.PasswordReset) bool { return r.UserID == userID })
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (f *fakeStore) DeleteUser(_ context.Context, userID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, b := range f.blogs {
+		if b.OwnerID == userID {
+			return storage.ErrUserOwnsBlogs
+		}
+	}
+	for _, u := range f.users {
+		if u.ID == userID && u.DeletedAt == nil {
+			u.DeletedAt = new(time.Now())
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (f *fakeStore) CreatePasswordReset(_ context.Context, userID int64, tokenHash string, expiresAt time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.resets == nil {
+		f.resets = make(map[string]*storage.PasswordReset)
+	}
+	f.resets[tokenHash] = &storage.PasswordReset{ID: int64(len(f.resets) + 1), UserID: userID, ExpiresAt: expiresAt, CreatedAt: time.Now()}
+	return nil
+}
+
+func (f *fakeStore) GetPasswordReset(_ context.Context, tokenHash string) (*storage.PasswordReset, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r, ok := f.resets[tokenHash]; ok && r.ExpiresAt.After(time.Now()) {
+		return r, nil
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (f *fakeStore) UsePasswordReset(_ context.Context, tokenHash string) (*storage.PasswordReset, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	r, ok := f.resets[tokenHash]
+	if !ok || !r.ExpiresAt.After(time.Now()) {
+		return nil, storage.ErrNotFound
+	}
+	delete(f.resets, tokenHash)
+	return r, nil
+}
+
+func (f *fakeStore) EnableTOTP(_ context.Context, userID int64, sealedSecret []byte, step int64, recoveryCodeHashes []string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, u := range f.users {
+		if u.ID == userID && u.DeletedAt == nil {
+			u.TOTPSecret, u.TOTPEnabledAt, u.TOTPLastStep = sealedSecret, new(time.Now()), step
+			maps.DeleteFunc(f.recovery, func(_ string, id int64) bool { return id == userID })
+			if f.recovery == nil {
+				f.recovery = make(map[string]int64)
+			}
+			for _, hash := range recoveryCodeHashes {
+				f.recovery[hash] = userID
+			}
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (f *fakeStore) DisableTOTP(_ context.Context, userID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, u := range f.users {
+		if u.ID == userID && u.DeletedAt == nil {
+			u.TOTPSecret, u.TOTPEnabledAt, u.TOTPLastStep = nil, nil, 0
+			maps.DeleteFunc(f.recovery, func(_ string, id int64) bool { return id == userID })
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (f *fakeStore) UseTOTPStep(_ context.Context, userID, step int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, u := range f.users {
+		if u.ID == userID && u.HasTOTP() && u.TOTPLastStep < step {
+			u.TOTPLastStep = step
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (f *fakeStore) UseRecoveryCode(_ context.Context, userID int64, codeHash string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if id, ok := f.recovery[codeHash]; !ok || id != userID {
+		return storage.ErrNotFound
+	}
+	delete(f.recovery, codeHash)
+	return nil
+}
+
+func (f *fakeStore) CountRecoveryCodes(_ context.Context, userID int64) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var count int64
+	for _, id := range f.recovery {
+		if id == userID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (f *fakeStore) CreateUserWithIdentity(ctx context.Context, username, passwordHash, issuer, subject string) (*storage.User, error) {
+	f.mu.Lock()
+	_, linked := f.idents[issuer+" "+subject]
+	f.mu.Unlock()
+	if linked {
+		return nil, storage.ErrUniqueViolation
+	}
+
+	u, err := f.CreateUser(ctx, username, passwordHash)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	u.Passwordless = true
+	f.mu.Unlock()
+	return u, f.LinkUserIdentity(ctx, u.ID, issuer, subject)
+}
+
+func (f *fakeStore) GetUserByIdentity(ctx context.Context, issuer, subject string) (*storage.User, error) {
+	f.mu.Lock()
+	userID, ok := f.idents[issuer+" "+subject]
+	f.mu.Unlock()
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return f.GetUserByID(ctx, userID)
+}
+
+func (f *fakeStore) LinkUserIdentity(_ context.Context, userID int64, issuer, subject string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.idents[issuer+" "+subject]; ok {
+		return storage.ErrUniqueViolation
+	}
+	if f.idents == nil {
+		f.idents = make(map[string]int64)
+	}
+	f.idents[issuer+" "+subject] = userID
+	return nil
+}
+
+func (f *fakeStore) GetLoginThrottle(_ context.Context, username string) (*storage.LoginThrottle, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if t, ok := f.logins[strings.ToLower(username)]; ok {
+		return t, nil
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (f *fakeStore) RecordLoginFailure(_ context.Context, username string, policy storage.LoginPolicy) (*storage.LoginThrottle, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.logins == nil {
+		f.logins = make(map[string]*storage.LoginThrottle)
+	}
+	t, ok := f.logins[strings.ToLower(username)]
+	if !ok {
+		t = &storage.LoginThrottle{Username: strings.ToLower(username)}
+		f.logins[strings.ToLower(username)] = t
+	}
+	t.Failures++
+	t.LastFailureAt = time.Now()
+	t.BlockedUntil = t.LastFailureAt.Add(policy.Backoff(t.Failures))
+	return t, nil
+}
+
+func (f *fakeStore) ClearLoginFailures(_ context.Context, username string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.logins, strings.ToLower(username))
+	return nil
+}
+
+func (f *fakeStore) GetBlockedLogins(_ context.Context, offset, limit int64) ([]*storage.LoginThrottle, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	blocked := make([]*storage.LoginThrottle, 0)
+	for _, t := range f.logins {
+		if t.Blocked(time.Now()) {
+			blocked = append(blocked, t)
+		}
+	}
+	slices.SortFunc(blocked, func(a, b *storage.LoginThrottle) int { return strings.Compare(a.Username, b.Username) })
+	return blocked[min(offset, int64(len(blocked))):min(offset+limit, int64(len(blocked)))], nil
+}
+
+func (f *fakeStore) RecordAuditEvent(_ context.Context, p storage.CreateAuditEventParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	e := &storage.AuditEvent{
+		ID:        int64(len(f.audits) + 1),
+		Kind:      p.Kind,
+		IP:        p.IP,
+		UserAgent: p.UserAgent,
+		TraceID:   p.TraceID,
+		Payload:   cmp.Or(string(p.Payload), "{}"),
+		CreatedAt: time.Now(),
+	}
+	if p.ActorID != 0 {
+		e.ActorID = &p.ActorID
+		for _, u := range f.users {
+			if u.ID == p.ActorID {
+				e.Actor = &u.Username
+			}
+		}
+	}
+	f.audits = append(f.audits, e)
+	return nil
+}
+
+func (f *fakeStore) GetAuditEvents(_ context.Context, filter storage.AuditFilter, limit int64) ([]*storage.AuditEvent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	events := make([]*storage.AuditEvent, 0)
+	for _, e := range slices.Backward(f.audits) {
+		switch {
+		case filter.Kind != "" && e.Kind != filter.Kind,
+			filter.Actor != "" && (e.Actor == nil || *e.Actor != filter.Actor),
+			filter.IP != "" && e.IP != filter.IP,
+			filter.BeforeID != 0 && e.ID >= filter.BeforeID:
+			continue
+		}
+		if int64(len(events)) == limit {
+			break
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+func (f *fakeStore) GetBlogsByUserID(_ context.Context, ownerID, offset, limit int64) ([]*storage.Blog, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	blogs := make([]*storage.Blog, 0)
+	for _, b := range f.blogs {
+		if b.OwnerID == ownerID {
+			blogs = append(blogs, b)
+		}
+	}
+	return blogs[min(offset, int64(len(blogs))):min(offset+limit, int64(len(blogs)))], nil
+}
+
+// GetCommentsForUserID returns the approved comments of the user, the fake knows nothing of posts
+func (f *fakeStore) GetCommentsForUserID(_ context.Context, userID, offset, limit int64) ([]*storage.UserComment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	comments := make([]*storage.UserComment, 0)
+	for _, c := range slices.Backward(f.comments) {
+		if c.UserID != nil && *c.UserID == userID && c.Status == storage.CommentApproved && c.DeletedAt == nil {
+			comments = append(comments, &storage.UserComment{Comment: *c, BlogSlug: "a-blog", PostSlug: "a-post", PostTitle: "A post"})
+		}
+	}
+	return comments[min(offset, int64(len(comments))):min(offset+limit, int64(len(comments)))], nil
+}
+
+func (f *fakeStore) CreatePasskey(_ context.Context, p storage.CreatePasskeyParams) (*storage.Passkey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, k := range f.passkeys {
+		if bytes.Equal(k.CredentialID, p.CredentialID) {
+			return nil, storage.ErrUniqueViolation
+		}
+	}
+	k := &storage.Passkey{
+		ID: int64(len(f.passkeys) + 1), UserID: p.UserID, Name: p.Name,
+		CredentialID: p.CredentialID, PublicKey: p.PublicKey, SignCount: p.SignCount, CreatedAt: time.Now(),
+	}
+	f.passkeys = append(f.passkeys, k)
+	return k, nil
+}
+
+func (f *fakeStore) GetPasskeyByCredentialID(_ context.Context, credentialID []byte) (*storage.Passkey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, k := range f.passkeys {
+		if bytes.Equal(k.CredentialID, credentialID) {
+			return k, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (f *fakeStore) GetPasskeysForUser(_ context.Context, userID int64) ([]*storage.Passkey, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var passkeys []*storage.Passkey
+	for _, k := range f.passkeys {
+		if k.UserID == userID {
+			passkeys = append(passkeys, k)
+		}
+	}
+	return passkeys, nil
+}
+
+func (f *fakeStore) UsePasskey(_ context.Context, id, signCount int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, k := range f.passkeys {
+		if k.ID == id && (k.SignCount < signCount || signCount == 0) {
+			k.SignCount, k.LastUsedAt = signCount, new(time.Now())
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (f *fakeStore) DeletePasskey(_ context.Context, userID, id int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, k := range f.passkeys {
+		if k.ID == id && k.UserID == userID {
+			f.passkeys = slices.Delete(f.passkeys, i, i+1)
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (f *fakeStore) GetPostBySlugOrPublicID(_ context.Context, blogSlug, postIdentifier string) (*storage.Post, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	p, ok := f.posts[blogSlug+"/"+postIdentifier]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return p, nil
+}
+
+func (f *fakeStore) GetCommentsForPost(_ context.Context, postID, viewerID int64, _ storage.Cursor, _ int64) ([]*storage.Comment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	comments := make([]*storage.Comment, 0)
+	for _, c := range f.comments {
+		if c.PostID != postID || c.IsRemoved() {
+			continue
+		}
+		if c.IsPending() && (c.UserID == nil || *c.UserID != viewerID) {
+			continue
+		}
+		comments = append(comments, c)
+	}
+	return comments, nil
+}
+
+// CreateComment holds the comments of users listed in pending, everyone else is approved
+func (f *fakeStore) CreateComment(_ context.Context, p storage.CreateCommentParams) (*storage.Comment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := &storage.Comment{
+		ID:        int64(len(f.comments) + 1),
+		PostID:    p.PostID,
+		UserID:    &p.UserID,
+		ParentID:  p.ParentID,
+		Content:   p.Content,
+		Status:    storage.CommentApproved,
+		SpamScore: p.SpamScore,
+		CreatedAt: time.Now(),
+	}
+	if f.pending[p.UserID] {
+		c.Status = storage.CommentPending
+	}
+	if p.Flag != "" {
+		c.Status = p.Flag
+	}
+	if p.ParentID != nil {
+		c.Depth = f.comments[*p.ParentID-1].Depth + 1
+	}
+	f.comments = append(f.comments, c)
+	return c, nil
+}
+
+func (f *fakeStore) GetCommentByID(_ context.Context, commentID int64) (*storage.Comment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, c := range f.comments {
+		if c.ID == commentID && !c.IsDeleted() {
+			return c, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+// GetCommentsForModeration returns the comments with status of the posts on blogs the moderator owns or edits
+func (f *fakeStore) GetCommentsForModeration(_ context.Context, moderatorID int64, status storage.CommentStatus, _, _ int64) ([]*storage.PendingComment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	pending := make([]*storage.PendingComment, 0)
+	for _, c := range f.comments {
+		if c.Status != status || c.IsDeleted() {
+			continue
+		}
+		for _, p := range f.posts {
+			if p.ID == c.PostID && f.moderates(p.BlogID, moderatorID) {
+				pending = append(pending, &storage.PendingComment{Comment: *c, BlogSlug: p.BlogSlug, PostSlug: *p.Slug, PostTitle: p.Title})
+			}
+		}
+	}
+	return pending, nil
+}
+
+func (f *fakeStore) ModerateComments(_ context.Context, moderatorID int64, commentIDs []int64, status storage.CommentStatus) ([]*storage.Comment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	changed := make([]*storage.Comment, 0)
+	for _, c := range f.comments {
+		if !slices.Contains(commentIDs, c.ID) {
+			continue
+		}
+		for _, p := range f.posts {
+			if p.ID == c.PostID && f.moderates(p.BlogID, moderatorID) {
+				c.Status = status
+				changed = append(changed, c)
+			}
+		}
+	}
+	return changed, nil
+}
+
+// moderates is GetBlogMember and CanModerateComments, the caller holds the lock
+func (f *fakeStore) moderates(blogID, userID int64) bool {
+	for _, m := range f.members {
+		if m.BlogID == blogID && m.UserID == userID {
+			return m.Role.CanModerateComments()
+		}
+	}
+	return false
+}
+
+func (f *fakeStore) GetBlogMember(_ context.Context, blogID, userID int64) (*storage.BlogMember, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, m := range f.members {
+		if m.BlogID == blogID && m.UserID == userID {
+			return m, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (f *fakeStore) GetBlogMembers(_ context.Context, blogID, offset, limit int64) ([]*storage.BlogMember, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	members := make([]*storage.BlogMember, 0)
+	for _, m := range f.members {
+		if m.BlogID == blogID {
+			members = append(members, m)
+		}
+	}
+	members = members[min(offset, int64(len(members))):]
+	return members[:min(limit, int64(len(members)))], nil
+}
+
+func (f *fakeStore) SetBlogMemberRole(_ context.Context, blogID, ownerID, userID int64, role storage.BlogRole) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !role.IsValid() || role == storage.RoleOwner {
+		return &storage.ValidationError{Field: "role", Err: errors.New("role must be 'editor', 'author' or 'commenter'")}
+	}
+	owns := slices.ContainsFunc(f.blogs, func(b *storage.Blog) bool { return b.ID == blogID && b.OwnerID == ownerID })
+	for _, m := range f.members {
+		if owns && m.BlogID == blogID && m.UserID == userID && m.Role != storage.RoleOwner {
+			m.Role = role
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (f *fakeStore) GetTagsForPost(context.Context, int64) ([]string, error) {
+	return []string{}, nil
+}
+
+// fakeS3 is an in memory storage.Provider
+type fakeS3 map[string][]byte
+
+func (f fakeS3) Open(_ context.Context, key string) (io.ReadCloser, error) {
+	body, ok := f[key]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return io.NopCloser(bytes.NewReader(body)), nil
+}
+
+func (f fakeS3) Save(_ context.Context, key string, body io.ReadSeeker) error {
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	f[key] = b
+	return nil
+}
+
+func (f fakeS3) Exists(_ context.Context, key string) bool {
+	_, ok := f[key]
+	return ok
+}
+
+func (f fakeS3) Version(_ context.Context, key string) (string, error) {
+	body, ok := f[key]
+	if !ok {
+		return "", storage.ErrNotFound
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// fakeImages records the jobs handlers queue instead of processing them
+type fakeImages struct {
+	mu   sync.Mutex
+	jobs []content.ImageJob
+}
+
+func (p *fakeImages) Enqueue(_ context.Context, job content.ImageJob) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.jobs = append(p.jobs, job)
+	return nil
+}
+
+// fakeMailer keeps the emails handlers send instead of queueing them
+type fakeMailer struct {
+	mu   sync.Mutex
+	sent []mail.Message
+}
+
+func (m *fakeMailer) Send(ctx context.Context, to string, t mail.Template) error {
+	msg, err := mail.Render(ctx, to, t)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func newTestHandler(db storage.Store, s3 storage.Provider) *BlogHandler {
+	logger := slog.New(slog.DiscardHandler)
+	return NewHandler(HandlerConfig{
+		Title:             "test blog",
+		DB:                db,
+		S3:                s3,
+		Renderer:          content.NewMarkDownRenderer(nil),
+		Logger:            logger,
+		Tracer:            noop.NewTracerProvider().Tracer("test"),
+		Sessions:          &middleware.Sessions{Manager: scs.New()},
+		StartTime:         time.Now(),
+		CommentEditWindow: 15 * time.Minute,
+		Mail:              &fakeMailer{},
+		Spam: &spam.Classifier{
+			Scorers:     []spam.SpamScorer{spam.LinkDensity{MaxLinks: 3}},
+			HoldScore:   0.5,
+			RejectScore: 0.95,
+			Logger:      logger,
+		},
+	})
+}
+
+// serve runs req through the session middleware, logging in userID first when it's not 0
+func serve(h *BlogHandler, handler http.Handler, req *http.Request, userID int64) *httptest.ResponseRecorder {
+	sm := h.Sessions.Manager
+	rec := httptest.NewRecorder()
+
+	sm.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if userID != 0 {
+			sm.Put(r.Context(), "userID", userID)
+			sm.Put(r.Context(), "username", "reader")
+		}
+		handler.ServeHTTP(w, r)
+	})).ServeHTTP(rec, req)
+
+	return rec
+}
+
+func (f *fakeStore) GetBlogByID(_ context.Context, blogID int64) (*storage.Blog, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, b := range f.blogs {
+		if b.ID == blogID {
+			return b, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (f *fakeStore) CreateBlog(_ context.Context, p storage.CreateBlogParams) (*storage.Blog, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(p.Slug) < 5 {
+		return nil, &storage.ValidationError{Field: "slug", Err: errors.New("slug is too short")}
+	}
+	for _, b := range f.blogs {
+		if b.Slug == p.Slug {
+			return nil, storage.ErrUniqueViolation
+		}
+	}
+
+	b := &storage.Blog{
+		ID:               int64(len(f.blogs) + 1),
+		OwnerID:          p.OwnerID,
+		Slug:             p.Slug,
+		Title:            p.Title,
+		Visibility:       p.Visibility,
+		RegistrationMode: p.RegistrationMode,
+	}
+	f.blogs = append(f.blogs, b)
+	return b, nil
+}
+
+func (f *fakeStore) UpdateBlog(_ context.Context, p storage.UpdateBlogParams) (*storage.Blog, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, b := range f.blogs {
+		if b.ID == p.BlogID && b.OwnerID == p.OwnerID {
+			b.Slug, b.Title, b.Description = p.Slug, p.Title, p.Description
+			return b, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (f *fakeStore) GetBlogBySlug(_ context.Context, slug string) (*storage.Blog, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, b := range f.blogs {
+		if b.Slug == slug {
+			return b, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (f *fakeStore) GetPostByID(_ context.Context, postID int64) (*storage.Post, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, p := range f.posts {
+		if p.ID == postID {
+			return p, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (f *fakeStore) CreatePost(_ context.Context, p storage.CreatePostParams) (*storage.Post, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	blogSlug := ""
+	for _, b := range f.blogs {
+		if b.ID == p.BlogID {
+			blogSlug = b.Slug
+		}
+	}
+	if _, ok := f.posts[blogSlug+"/"+*p.Slug]; ok {
+		return nil, storage.ErrUniqueViolation
+	}
+
+	id := int64(len(f.posts) + 1)
+	post := &storage.Post{
+		ID:          id,
+		BlogID:      p.BlogID,
+		AuthorID:    p.AuthorID,
+		Slug:        p.Slug,
+		Title:       p.Title,
+		S3Key:       "posts/" + strconv.FormatInt(id, 10) + ".md",
+		BlogSlug:    blogSlug,
+		PublishedAt: p.PublishedAt,
+	}
+	f.posts[blogSlug+"/"+*p.Slug] = post
+	return post, nil
+}
+
+func (f *fakeStore) UpdatePost(_ context.Context, p storage.UpdatePostParams) (*storage.Post, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, post := range f.posts {
+		if post.ID == p.PostID {
+			post.Slug, post.Title, post.PublishedAt = p.Slug, p.Title, p.PublishedAt
+			return post, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
+
+func (f *fakeStore) DeletePost(_ context.Context, postID int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for key, p := range f.posts {
+		if p.ID == postID {
+			delete(f.posts, key)
+			return nil
+		}
+	}
+	return storage.ErrNotFound
+}
+
+func (f *fakeStore) SyncPostTaxonomy(context.Context, int64, *string, []string) error {
+	return nil
+}
+
+func (f *fakeStore) IndexPostBody(context.Context, int64, string) error {
+	return nil
+}
+
+func (f *fakeStore) GetPublicBlogs(_ context.Context, cursor storage.Cursor, limit int64) ([]*storage.Blog, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	blogs := make([]*storage.Blog, 0)
+	for _, b := range f.blogs {
+		if b.Visibility != storage.VisibilityPrivate {
+			blogs = append(blogs, b)
+		}
+	}
+	return keysetPage(blogs, cursor, limit, (*storage.Blog).Cursor), nil
+}
+
+func (f *fakeStore) GetPostsByBlogID(_ context.Context, blogID int64, cursor storage.Cursor, limit int64) ([]*storage.Post, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	posts := make([]*storage.Post, 0)
+	for _, p := range f.posts {
+		if p.BlogID == blogID && p.IsListed {
+			posts = append(posts, p)
+		}
+	}
+	return keysetPage(posts, cursor, limit, (*storage.Post).Cursor), nil
+}
+
+// GetLatestPublicPosts lists the listed posts of every blog, the fake keeps no visibility on posts
+func (f *fakeStore) GetLatestPublicPosts(_ context.Context, cursor storage.Cursor, limit int64) ([]*storage.Post, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	posts := make([]*storage.Post, 0)
+	for _, p := range f.posts {
+		if p.IsListed {
+			posts = append(posts, p)
+		}
+	}
+	return keysetPage(posts, cursor, limit, (*storage.Post).Cursor), nil
+}
+
+// keysetPage is the page at cursor of items sorted newest first, the way the sqlite store pages its listings
+func keysetPage[T any](items []T, cursor storage.Cursor, limit int64, key func(T) storage.Cursor) []T {
+	compare := func(a, b storage.Cursor) int {
+		return cmp.Or(a.Time.Compare(b.Time), cmp.Compare(a.ID, b.ID))
+	}
+	slices.SortFunc(items, func(a, b T) int { return compare(key(b), key(a)) })
+
+	page := make([]T, 0)
+	for _, item := range items {
+		switch n := compare(key(item), cursor); {
+		case cursor.IsZero(), !cursor.Before && n < 0, cursor.Before && n > 0:
+			page = append(page, item)
+		}
+	}
+	if cursor.Before {
+		return page[max(0, int64(len(page))-limit):]
+	}
+	return page[:min(limit, int64(len(page)))]
+}
+
+func (f *fakeStore) UseAPIToken(_ context.Context, tokenHash string) (*storage.APIToken, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t, ok := f.tokens[tokenHash]
+	if !ok {
+		return nil, storage.ErrNotFound
+	}
+	return t, nil
+}
+
+func (f *fakeStore) UpdateComment(_ context.Context, params storage.UpdateCommentParams) (*storage.Comment, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, c := range f.comments {
+		if c.ID == params.CommentID && c.UserID != nil && *c.UserID == params.UserID && !c.IsDeleted() {
+			if params.Flag != "" && c.Content != params.Content {
+				c.Status = params.Flag
+			}
+			c.Content, c.EditedAt, c.SpamScore = params.Content, new(time.Now()), params.SpamScore
+			return c, nil
+		}
+	}
+	return nil, storage.ErrNotFound
+}
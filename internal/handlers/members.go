@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"blogengine/internal/components"
+	"blogengine/internal/storage"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const inviteLifetime = 7 * 24 * time.Hour
+
+const (
+	membersPageSize = 50
+	maxMembersPage  = 100
+)
+
+// HandleMembershipPage shows how to join a blog, or how to leave it for members
+func (h *BlogHandler) HandleMembershipPage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleMembershipPage")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		page, err := h.newMembershipPage(ctx, r)
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				h.NotFound(w, r)
+			default:
+				h.InternalError(w, r, err)
+			}
+			return
+		}
+		page.Invite = r.URL.Query().Get("invite")
+
+		components.Membership(common, page).Render(ctx, w)
+	})
+}
+
+// HandleJoinBlog adds the logged in user to a blog according to its registration mode
+func (h *BlogHandler) HandleJoinBlog() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleJoinBlog")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID := h.Sessions.Manager.GetInt64(ctx, "userID")
+		if userID == 0 {
+			h.Unauthorised(w, r)
+			return
+		}
+
+		page, err := h.newMembershipPage(ctx, r)
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				h.NotFound(w, r)
+			default:
+				h.InternalError(w, r, err)
+			}
+			return
+		}
+		blogURL := "/blogs/" + page.Blog.Slug
+
+		page.Invite = r.FormValue("invite")
+		_, err = h.DB.JoinBlog(ctx, page.Blog.ID, userID, hashToken(page.Invite))
+
+		status := http.StatusOK
+		switch {
+		case err == nil:
+			h.Logger.Info("blog joined", "user_id", userID, "blog_id", page.Blog.ID)
+			http.Redirect(w, r, blogURL, http.StatusSeeOther)
+			return
+		case errors.Is(err, storage.ErrAlreadyMember):
+			http.Redirect(w, r, blogURL, http.StatusSeeOther)
+			return
+		case errors.Is(err, storage.ErrRegistrationClosed):
+			status, page.Error = http.StatusForbidden, "This blog is not accepting new members."
+		case errors.Is(err, storage.ErrInvalidInvite):
+			status, page.Error = http.StatusForbidden, "This invite is invalid, expired or was already used."
+		case errors.Is(err, storage.ErrBlogFull):
+			status, page.Error = http.StatusConflict, "Sorry, all the seats of this blog are taken."
+		case errors.Is(err, storage.ErrNotFound):
+			h.NotFound(w, r)
+			return
+		default:
+			h.InternalError(w, r, err)
+			return
+		}
+
+		w.WriteHeader(status)
+		components.Membership(common, page).Render(ctx, w)
+	})
+}
+
+// HandleLeaveBlog removes the logged in user from a blog and frees their seat
+func (h *BlogHandler) HandleLeaveBlog() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleLeaveBlog")
+		defer span.End()
+
+		userID := h.Sessions.Manager.GetInt64(ctx, "userID")
+		if userID == 0 {
+			h.Unauthorised(w, r)
+			return
+		}
+
+		blog, err := h.DB.GetBlogBySlug(ctx, r.PathValue("blog_slug"))
+		if err == nil {
+			err = h.DB.LeaveBlog(ctx, blog.ID, userID)
+		}
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				h.NotFound(w, r)
+			case errors.Is(err, storage.ErrOwnerCannotLeave):
+				h.Forbidden(w, r)
+			default:
+				h.InternalError(w, r, err)
+			}
+			return
+		}
+
+		h.Logger.Info("blog left", "user_id", userID, "blog_id", blog.ID)
+
+		// a private blog can't be read anymore
+		redirectTo := "/blogs/" + blog.Slug
+		if blog.Visibility == storage.VisibilityPrivate {
+			redirectTo = "/"
+		}
+		http.Redirect(w, r, redirectTo, http.StatusSeeOther)
+	})
+}
+
+// HandleCreateInvite lets the owner of an invite only blog create a single use invite link
+func (h *BlogHandler) HandleCreateInvite() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleCreateInvite")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID := h.Sessions.Manager.GetInt64(ctx, "userID")
+		if userID == 0 {
+			h.Unauthorised(w, r)
+			return
+		}
+
+		page, err := h.newMembershipPage(ctx, r)
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				h.NotFound(w, r)
+			default:
+				h.InternalError(w, r, err)
+			}
+			return
+		}
+		if page.Member == nil || page.Member.Role != storage.RoleOwner {
+			h.Forbidden(w, r)
+			return
+		}
+
+		token, err := newToken()
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		if err := h.DB.CreateBlogInvite(ctx, page.Blog.ID, userID, hashToken(token), time.Now().Add(inviteLifetime)); err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		page.InviteURL = h.baseURL(r) + "/blogs/" + page.Blog.Slug + "/join?invite=" + url.QueryEscape(token)
+
+		components.Membership(common, page).Render(ctx, w)
+	})
+}
+
+// HandleMembersPage lists the members of a blog to its owner, with their roles to change. Members are paged by number
+// like profiles, GetBlogMembers has no cursor
+func (h *BlogHandler) HandleMembersPage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleMembersPage")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		blog, err := h.ownedBlog(ctx, r, userID)
+		if err != nil {
+			h.dashboardError(w, r, err)
+			return
+		}
+
+		page := components.MembersPage{Blog: blog, Page: 1, Notice: h.Sessions.Manager.PopString(ctx, "notice")}
+		if n, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && n > 1 {
+			page.Page = min(n, maxMembersPage)
+		}
+		// one extra row tells whether there is a next page
+		offset := int64((page.Page - 1) * membersPageSize)
+		members, err := h.DB.GetBlogMembers(ctx, blog.ID, offset, membersPageSize+1)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		if len(members) > membersPageSize {
+			members = members[:membersPageSize]
+			page.HasNext = true
+		}
+		page.Members = members
+
+		components.Members(common, page).Render(ctx, w)
+	})
+}
+
+// HandleSetMemberRole lets the owner of a blog make a member an editor, an author or a commenter again
+func (h *BlogHandler) HandleSetMemberRole() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleSetMemberRole")
+		defer span.End()
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		blog, err := h.ownedBlog(ctx, r, userID)
+		if err != nil {
+			h.dashboardError(w, r, err)
+			return
+		}
+
+		memberID, err := strconv.ParseInt(r.PathValue("user_id"), 10, 64)
+		if err != nil || memberID < 1 {
+			h.NotFound(w, r)
+			return
+		}
+
+		role := storage.BlogRole(r.FormValue("role"))
+		err = h.DB.SetBlogMemberRole(ctx, blog.ID, userID, memberID, role)
+		var invalid *storage.ValidationError
+		switch {
+		case err == nil:
+		case errors.As(err, &invalid):
+			h.RenderError(w, r, http.StatusBadRequest, "Bad request", "Pick editor, author or commenter.")
+			return
+		default:
+			h.dashboardError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("member role changed", "owner_id", userID, "blog_id", blog.ID, "user_id", memberID, "role", role)
+		h.Sessions.Manager.Put(ctx, "notice", "Role changed to "+string(role)+".")
+
+		redirectTo := "/dashboard/blogs/" + strconv.FormatInt(blog.ID, 10) + "/members"
+		if n, err := strconv.Atoi(r.FormValue("page")); err == nil && n > 1 {
+			redirectTo += "?page=" + strconv.Itoa(min(n, maxMembersPage))
+		}
+		http.Redirect(w, r, redirectTo, http.StatusSeeOther)
+	})
+}
+
+// canReadBlog answers for private blogs, which only their members can read. It writes the response
+// when it returns false: anonymous readers are sent to login, everyone else is forbidden
+func (h *BlogHandler) canReadBlog(w http.ResponseWriter, r *http.Request, blogID int64, visibility storage.Visibility) bool {
+	if visibility != storage.VisibilityPrivate {
+		return true
+	}
+
+	userID := h.Sessions.Manager.GetInt64(r.Context(), "userID")
+	if userID == 0 {
+		http.Redirect(w, r, loginURL(r.URL.RequestURI()), http.StatusSeeOther)
+		return false
+	}
+
+	if _, err := h.DB.GetBlogMember(r.Context(), blogID, userID); err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			h.Forbidden(w, r)
+		default:
+			h.InternalError(w, r, err)
+		}
+		return false
+	}
+	return true
+}
+
+// newMembershipPage loads the blog from the path and the membership of the logged in user, if any
+func (h *BlogHandler) newMembershipPage(ctx context.Context, r *http.Request) (components.MembershipPage, error) {
+	blog, err := h.DB.GetBlogBySlug(ctx, r.PathValue("blog_slug"))
+	if err != nil {
+		return components.MembershipPage{}, err
+	}
+	page := components.MembershipPage{Blog: blog}
+
+	userID := h.Sessions.Manager.GetInt64(ctx, "userID")
+	if userID == 0 {
+		return page, nil
+	}
+
+	member, err := h.DB.GetBlogMember(ctx, blog.ID, userID)
+	switch {
+	case err == nil:
+		page.Member = member
+	case !errors.Is(err, storage.ErrNotFound):
+		return components.MembershipPage{}, err
+	}
+	return page, nil
+}
+
+// newToken returns a random url safe token for invites and api access
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is what gets stored and looked up, a leaked database doesn't leak usable invites or api tokens
+func hashToken(token string) string {
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"blogengine/internal/components"
+	"blogengine/internal/storage"
+	"net/http"
+	"strconv"
+)
+
+// moderationQueueLimit is how many pending comments the moderation page shows at once, oldest first
+const moderationQueueLimit = 100
+
+// moderationActions maps the buttons of the moderation forms to the status they give the checked comments
+var moderationActions = map[string]storage.CommentStatus{
+	"approve": storage.CommentApproved,
+	"reject":  storage.CommentRejected,
+	"spam":    storage.CommentSpam,
+}
+
+// HandleModerationPage lists the comments waiting for moderation on the blogs the user owns or edits, or with
+// ?status=spam the comments marked as spam so false positives can be approved
+func (h *BlogHandler) HandleModerationPage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleModerationPage")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		status := storage.CommentPending
+		if r.URL.Query().Get("status") == string(storage.CommentSpam) {
+			status = storage.CommentSpam
+		}
+
+		comments, err := h.DB.GetCommentsForModeration(ctx, userID, status, 0, moderationQueueLimit)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		components.Moderation(common, status, comments).Render(ctx, w)
+	})
+}
+
+// HandleModerateComments approves, rejects or marks as spam the checked comments, the store skips comments of blogs
+// the user doesn't moderate. The moderation page and the remove button of comment cards both post here
+func (h *BlogHandler) HandleModerateComments() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleModerateComments")
+		defer span.End()
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		status, ok := moderationActions[r.FormValue("action")]
+		if !ok {
+			h.RenderError(w, r, http.StatusBadRequest, "Unknown action", "Comments can be approved, rejected or marked as spam.")
+			return
+		}
+
+		commentIDs := make([]int64, 0, len(r.PostForm["comment_id"]))
+		for _, raw := range r.PostForm["comment_id"] {
+			id, err := strconv.ParseInt(raw, 10, 64)
+			if err != nil {
+				h.RenderError(w, r, http.StatusBadRequest, "Unknown comment", "The comments to moderate could not be read.")
+				return
+			}
+			commentIDs = append(commentIDs, id)
+		}
+
+		changed, err := h.DB.ModerateComments(ctx, userID, commentIDs, status)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("comments moderated", "user_id", userID, "status", status, "requested", len(commentIDs), "changed", len(changed))
+		if h.Spam != nil && len(changed) > 0 {
+			// the filter scores the comments of every blog and anyone can open a blog to moderate, only the decisions
+			// of site admins teach it
+			user, err := h.DB.GetUserByID(ctx, userID)
+			switch {
+			case err != nil:
+				h.Logger.Warn("could not check moderator before training the spam filter", "user_id", userID, "err", err)
+			case user.IsAdmin:
+				h.Spam.Learn(ctx, changed)
+			}
+		}
+
+		next := "/dashboard/moderation"
+		if raw := r.FormValue("next"); raw != "" {
+			next = safeRedirectPath(raw)
+		}
+		http.Redirect(w, r, next, http.StatusSeeOther)
+	})
+}
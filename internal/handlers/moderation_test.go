@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"blogengine/internal/spam"
+	"blogengine/internal/storage"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// countingTrainer counts the comments the spam filter learns from
+type countingTrainer struct{ trained atomic.Int32 }
+
+func (c *countingTrainer) Train(context.Context, int64, string, bool) error {
+	c.trained.Add(1)
+	return nil
+}
+
+func TestModeration(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name         string
+		method       string
+		query        string
+		form         url.Values
+		userID       int64
+		admin        bool // userID is a site admin
+		wantStatus   int
+		wantLocation string
+		wantBody     string
+		wantStatuses []storage.CommentStatus // of comments 1, 2 and 3
+		wantTrained  int32
+	}{
+		{
+			name:         "anonymous readers are sent to login",
+			method:       http.MethodGet,
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/login?next=%2Fdashboard%2Fmoderation",
+		},
+		{
+			name:       "owners see the queue",
+			method:     http.MethodGet,
+			userID:     1,
+			wantStatus: http.StatusOK,
+			wantBody:   "please approve me",
+		},
+		{
+			name:       "the spam queue is separate",
+			method:     http.MethodGet,
+			query:      "?status=spam",
+			userID:     1,
+			wantStatus: http.StatusOK,
+			wantBody:   "Spam score 0.96: same text on 4 other posts",
+		},
+		{
+			name:       "other members have nothing to moderate",
+			method:     http.MethodGet,
+			userID:     2,
+			wantStatus: http.StatusOK,
+			wantBody:   "No comments are waiting",
+		},
+		{
+			name:         "bulk approve",
+			method:       http.MethodPost,
+			form:         url.Values{"action": {"approve"}, "comment_id": {"1", "2"}},
+			userID:       1,
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/dashboard/moderation",
+			wantStatuses: []storage.CommentStatus{storage.CommentApproved, storage.CommentApproved},
+		},
+		{
+			name:         "site admins train the spam filter",
+			method:       http.MethodPost,
+			form:         url.Values{"action": {"approve"}, "comment_id": {"1", "2"}},
+			userID:       1,
+			admin:        true,
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/dashboard/moderation",
+			wantStatuses: []storage.CommentStatus{storage.CommentApproved, storage.CommentApproved},
+			wantTrained:  2,
+		},
+		{
+			name:         "spam",
+			method:       http.MethodPost,
+			form:         url.Values{"action": {"spam"}, "comment_id": {"2"}},
+			userID:       1,
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/dashboard/moderation",
+			wantStatuses: []storage.CommentStatus{storage.CommentPending, storage.CommentSpam},
+		},
+		{
+			name:         "false positives go back to the spam queue",
+			method:       http.MethodPost,
+			form:         url.Values{"action": {"approve"}, "comment_id": {"3"}, "next": {"/dashboard/moderation?status=spam"}},
+			userID:       1,
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/dashboard/moderation?status=spam",
+			wantStatuses: []storage.CommentStatus{storage.CommentPending, storage.CommentPending, storage.CommentApproved},
+		},
+		{
+			name:         "removing from the post goes back to it",
+			method:       http.MethodPost,
+			form:         url.Values{"action": {"reject"}, "comment_id": {"1"}, "next": {"/blogs/a-blog-slug/a-post"}},
+			userID:       1,
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/blogs/a-blog-slug/a-post",
+			wantStatuses: []storage.CommentStatus{storage.CommentRejected, storage.CommentPending},
+		},
+		{
+			name:         "next stays on the site",
+			method:       http.MethodPost,
+			form:         url.Values{"action": {"reject"}, "comment_id": {"1"}, "next": {"//evil.example"}},
+			userID:       1,
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/",
+		},
+		{
+			name:         "only moderators decide",
+			method:       http.MethodPost,
+			form:         url.Values{"action": {"approve"}, "comment_id": {"1", "2"}},
+			userID:       2,
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/dashboard/moderation",
+			wantStatuses: []storage.CommentStatus{storage.CommentPending, storage.CommentPending},
+		},
+		{
+			name:         "unknown actions are refused",
+			method:       http.MethodPost,
+			form:         url.Values{"action": {"pending"}, "comment_id": {"1"}},
+			userID:       1,
+			wantStatus:   http.StatusBadRequest,
+			wantStatuses: []storage.CommentStatus{storage.CommentPending, storage.CommentPending},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := newFakeStore(testPost("a-post", nil))
+			db.members = []*storage.BlogMember{
+				{BlogID: 1, UserID: 1, Role: storage.RoleOwner},
+				{BlogID: 1, UserID: 2, Role: storage.RoleCommenter},
+			}
+			db.comments = []*storage.Comment{
+				{ID: 1, PostID: 1, UserID: new(int64(3)), Content: "please approve me", Status: storage.CommentPending},
+				{ID: 2, PostID: 1, UserID: new(int64(3)), Content: "cheap watches", Status: storage.CommentPending},
+				{ID: 3, PostID: 1, UserID: new(int64(3)), Content: "first!", Status: storage.CommentSpam, SpamScore: 0.96, SpamReasons: "same text on 4 other posts"},
+			}
+			db.users = []*storage.User{{ID: 1, Username: "owner", IsAdmin: tt.admin}}
+			h := newTestHandler(db, fakeS3{})
+			trainer := &countingTrainer{}
+			h.Spam.Trainers = []spam.Trainer{trainer}
+
+			mux := http.NewServeMux()
+			mux.Handle("GET /dashboard/moderation", h.HandleModerationPage())
+			mux.Handle("POST /dashboard/moderation", h.HandleModerateComments())
+
+			req := httptest.NewRequest(tt.method, "/dashboard/moderation"+tt.query, strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			rec := serve(h, mux, req, tt.userID)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status: want %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if loc := rec.Header().Get("Location"); loc != tt.wantLocation {
+				t.Fatalf("location: want %q, got %q", tt.wantLocation, loc)
+			}
+			if !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Fatalf("body does not contain %q", tt.wantBody)
+			}
+			for i, want := range tt.wantStatuses {
+				if got := db.comments[i].Status; got != want {
+					t.Fatalf("comment %d: want %q, got %q", i+1, want, got)
+				}
+			}
+			// blog owners moderate their own blog, the filter of the whole site is not theirs to train
+			if got := trainer.trained.Load(); got != tt.wantTrained {
+				t.Fatalf("trained on: want %d comments, got %d", tt.wantTrained, got)
+			}
+		})
+	}
+}
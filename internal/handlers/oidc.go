@@ -0,0 +1,426 @@
+package handlers
+
+import (
+	"blogengine/internal/components"
+	"blogengine/internal/oidc"
+	"blogengine/internal/storage"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// oidcLoginLifetime is how long a trip to the provider, and then the choice between linking and a new account, may
+	// take
+	oidcLoginLifetime = 10 * time.Minute
+	// reauthLifetime is how long a passwordless user who logged in again at the provider may change their account
+	reauthLifetime = 5 * time.Minute
+)
+
+// HandleOIDCLogin sends the user to the provider to log in, the values tying their return to this browser wait in the
+// session
+func (h *BlogHandler) HandleOIDCLogin() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleOIDCLogin")
+		defer span.End()
+
+		if h.OIDC == nil {
+			h.NotFound(w, r)
+			return
+		}
+		next := safeRedirectPath(r.URL.Query().Get("next"))
+
+		// user already logged in, send them on
+		if h.Sessions.Manager.Exists(ctx, "userID") {
+			http.Redirect(w, r, next, http.StatusSeeOther)
+			return
+		}
+
+		h.sendToProvider(w, r, next, 0)
+	})
+}
+
+// HandleOIDCReauth sends a passwordless user back to the provider to confirm it is them, the account changes that ask
+// others for their password are open for reauthLifetime once the identity linked to them returns
+func (h *BlogHandler) HandleOIDCReauth() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := h.Tracer.Start(r.Context(), "HandleOIDCReauth")
+		defer span.End()
+
+		if h.OIDC == nil {
+			h.NotFound(w, r)
+			return
+		}
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		h.sendToProvider(w, r, "/account", userID)
+	})
+}
+
+// sendToProvider starts a trip to the provider that comes back to next, the values tying the return to this browser
+// wait in the session. A non zero reauthID makes it a confirmation of that logged in user rather than a login
+func (h *BlogHandler) sendToProvider(w http.ResponseWriter, r *http.Request, next string, reauthID int64) {
+	ctx := r.Context()
+
+	login, err := oidc.NewLogin()
+	if err != nil {
+		h.InternalError(w, r, err)
+		return
+	}
+	authURL, err := h.OIDC.AuthURL(ctx, h.oidcRedirectURI(r), login)
+	if err != nil {
+		h.Logger.Error("could not reach the oidc provider", "issuer", h.OIDC.Issuer, "err", err)
+		h.RenderError(w, r, http.StatusBadGateway, "Login unavailable", h.OIDC.Name+" can't be reached right now, try again later.")
+		return
+	}
+
+	h.Sessions.Manager.Put(ctx, "oidc_state", login.State)
+	h.Sessions.Manager.Put(ctx, "oidc_nonce", login.Nonce)
+	h.Sessions.Manager.Put(ctx, "oidc_verifier", login.Verifier)
+	h.Sessions.Manager.Put(ctx, "oidc_next", next)
+	h.Sessions.Manager.Put(ctx, "oidc_at", time.Now().Unix())
+	h.Sessions.Manager.Put(ctx, "oidc_reauth", reauthID)
+	http.Redirect(w, r, authURL, http.StatusSeeOther)
+}
+
+// HandleOIDCCallback checks the code the provider sent the user back with and logs in the user its identity is
+// linked to. Identities seen for the first time wait in the session until they are linked or get an account. A trip
+// started by HandleOIDCReauth only confirms the logged in user
+func (h *BlogHandler) HandleOIDCCallback() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleOIDCCallback")
+		defer span.End()
+
+		if h.OIDC == nil {
+			h.NotFound(w, r)
+			return
+		}
+
+		login, next, ok := h.popOIDCLogin(ctx)
+		reauthID := h.Sessions.Manager.GetInt64(ctx, "oidc_reauth")
+		h.Sessions.Manager.Remove(ctx, "oidc_reauth")
+		q := r.URL.Query()
+		if !ok || subtle.ConstantTimeCompare([]byte(q.Get("state")), []byte(login.State)) != 1 {
+			h.RenderError(w, r, http.StatusBadRequest, "Login expired", "Start the login again.")
+			return
+		}
+		if e := q.Get("error"); e != "" {
+			h.Logger.Info("oidc login refused by the provider", "error", e, "description", q.Get("error_description"))
+			h.RenderError(w, r, http.StatusUnauthorized, "Login cancelled", h.OIDC.Name+" did not log you in.")
+			return
+		}
+
+		claims, err := h.OIDC.Exchange(ctx, h.oidcRedirectURI(r), q.Get("code"), login)
+		if err != nil {
+			if errors.Is(err, oidc.ErrDiscovery) {
+				h.Logger.Error("could not reach the oidc provider", "issuer", h.OIDC.Issuer, "err", err)
+				h.RenderError(w, r, http.StatusBadGateway, "Login unavailable", h.OIDC.Name+" can't be reached right now, try again later.")
+				return
+			}
+			h.Logger.Warn("oidc login refused", "issuer", h.OIDC.Issuer, "err", err)
+			h.RenderError(w, r, http.StatusUnauthorized, "Login failed", "The answer of "+h.OIDC.Name+" could not be verified.")
+			return
+		}
+
+		user, err := h.DB.GetUserByIdentity(ctx, claims.Issuer, claims.Subject)
+		if reauthID != 0 {
+			h.finishReauth(w, r, reauthID, user, err, next)
+			return
+		}
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			h.startOIDCLink(w, r, claims, next)
+			return
+		case err != nil:
+			h.InternalError(w, r, err)
+			return
+		}
+
+		if user.HasTOTP() {
+			h.startTwoFactor(w, r, user, next)
+			return
+		}
+		if err := h.login(r, user, "oidc"); err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		h.Logger.Info("user logged in", "id", user.ID, "username", user.Username, "issuer", claims.Issuer)
+		http.Redirect(w, r, next, http.StatusSeeOther)
+	})
+}
+
+// finishReauth opens the account changes of reauthID when user, the one the returning identity is linked to, is the
+// one logged in. Any other identity is turned away
+func (h *BlogHandler) finishReauth(w http.ResponseWriter, r *http.Request, reauthID int64, user *storage.User, err error, next string) {
+	ctx := r.Context()
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		h.InternalError(w, r, err)
+		return
+	}
+	if user == nil || user.ID != reauthID || h.Sessions.Manager.GetInt64(ctx, "userID") != reauthID {
+		h.Logger.Warn("oidc reauthentication with another identity", "user_id", reauthID)
+		h.RenderError(w, r, http.StatusForbidden, "Wrong account", "Log in to "+h.OIDC.Name+" with the account linked to yours.")
+		return
+	}
+
+	h.Sessions.Manager.Put(ctx, "reauth_user_id", reauthID)
+	h.Sessions.Manager.Put(ctx, "reauth_at", time.Now().Unix())
+	h.Logger.Info("user reauthenticated", "user_id", reauthID)
+	h.Sessions.Manager.Put(ctx, "notice", "Confirmed, your account can be changed for the next few minutes.")
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// reauthenticated tells whether userID confirmed it is them at the provider less than reauthLifetime ago
+func (h *BlogHandler) reauthenticated(ctx context.Context, userID int64) bool {
+	at := h.Sessions.Manager.GetInt64(ctx, "reauth_at")
+	return at != 0 && h.Sessions.Manager.GetInt64(ctx, "reauth_user_id") == userID && time.Since(time.Unix(at, 0)) <= reauthLifetime
+}
+
+// HandleOIDCLinkPage offers to link a new identity to an existing account or to create one for it
+func (h *BlogHandler) HandleOIDCLinkPage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := h.pendingOIDCLink(r.Context()); !ok {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+		h.renderOIDCLink(w, r, http.StatusOK, components.OIDCLinkPage{})
+	})
+}
+
+// HandleOIDCLink links the waiting identity to the account whose password, and two-factor code when it has one, the
+// user knows, and logs them in
+func (h *BlogHandler) HandleOIDCLink() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleOIDCLink")
+		defer span.End()
+
+		identity, ok := h.pendingOIDCLink(ctx)
+		if !ok {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		// same throttle and lockout as the login form, linking is logging in with the password
+		user, err := h.checkPassword(r, strings.TrimSpace(r.FormValue("username")), r.FormValue("password"), "oidc-link")
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		if user == nil {
+			h.renderOIDCLink(w, r, http.StatusUnauthorized, components.OIDCLinkPage{LinkError: "Invalid username or password."})
+			return
+		}
+
+		// the identity logs in without the password from now on, so it is linked only once the whole login went through
+		if user.HasTOTP() {
+			method, ok, err := h.checkSecondFactor(ctx, user, r.FormValue("code"))
+			if err != nil {
+				h.InternalError(w, r, err)
+				return
+			}
+			if !ok {
+				h.Logger.Info("wrong two-factor code linking identity", "user_id", user.ID)
+				h.renderOIDCLink(w, r, http.StatusUnauthorized, components.OIDCLinkPage{LinkError: "This account needs the code of its authenticator app or a recovery code."})
+				return
+			}
+			h.Logger.Info("two-factor checked for identity link", "user_id", user.ID, "second_factor", method)
+		}
+
+		if err := h.DB.LinkUserIdentity(ctx, user.ID, identity.issuer, identity.subject); err != nil {
+			if errors.Is(err, storage.ErrUniqueViolation) {
+				h.clearOIDCLink(ctx)
+				h.RenderError(w, r, http.StatusConflict, "Already linked", "This "+h.OIDC.Name+" login was linked to an account in the meantime, log in again.")
+				return
+			}
+			h.InternalError(w, r, err)
+			return
+		}
+
+		h.clearOIDCLink(ctx)
+		if err := h.login(r, user, "oidc"); err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		h.Logger.Info("identity linked", "user_id", user.ID, "issuer", identity.issuer)
+		http.Redirect(w, r, identity.next, http.StatusSeeOther)
+	})
+}
+
+// HandleOIDCRegister creates an account for the waiting identity. Registration needs the invite code as with a
+// password, the account is passwordless: it gets a random password nobody knows and confirms account changes at the
+// provider until the user sets one
+func (h *BlogHandler) HandleOIDCRegister() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleOIDCRegister")
+		defer span.End()
+
+		identity, ok := h.pendingOIDCLink(ctx)
+		if !ok {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		if h.NeedsInvite && !strings.EqualFold(strings.TrimSpace(r.FormValue("invite_code")), h.InviteCode) {
+			h.renderOIDCLink(w, r, http.StatusUnauthorized, components.OIDCLinkPage{RegisterError: "Invalid invite code."})
+			return
+		}
+
+		username := strings.TrimSpace(r.FormValue("username"))
+		if len(username) < 3 || len(username) > 50 {
+			h.renderOIDCLink(w, r, http.StatusBadRequest, components.OIDCLinkPage{RegisterError: "Usernames are 3 to 50 characters."})
+			return
+		}
+
+		password, err := newToken()
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		hashedPwd, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		user, err := h.DB.CreateUserWithIdentity(ctx, username, string(hashedPwd), identity.issuer, identity.subject)
+		if err != nil {
+			if errors.Is(err, storage.ErrUniqueViolation) {
+				h.renderOIDCLink(w, r, http.StatusConflict, components.OIDCLinkPage{RegisterError: "Username already taken."})
+				return
+			}
+			h.InternalError(w, r, err)
+			return
+		}
+
+		h.clearOIDCLink(ctx)
+		h.Audit.Record(r, storage.AuditRegister, user.ID, map[string]any{"method": "oidc", "issuer": identity.issuer})
+		if identity.email != "" {
+			h.welcome(r, user, identity.email)
+		}
+		if err := h.login(r, user, "oidc"); err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		h.Logger.Info("user registered", "id", user.ID, "username", user.Username, "issuer", identity.issuer)
+		http.Redirect(w, r, identity.next, http.StatusSeeOther)
+	})
+}
+
+// singleSignOn is the provider button of the login page, it brings the user back to next
+func (h *BlogHandler) singleSignOn(next string) components.SingleSignOn {
+	if h.OIDC == nil {
+		return components.SingleSignOn{}
+	}
+	return components.SingleSignOn{Name: h.OIDC.Name, URL: "/login/oidc?next=" + url.QueryEscape(next)}
+}
+
+// oidcRedirectURI is where the provider sends users back, it must be registered with the provider as is
+func (h *BlogHandler) oidcRedirectURI(r *http.Request) string {
+	return h.baseURL(r) + "/login/oidc/callback"
+}
+
+// popOIDCLogin takes the login waiting for the provider out of the session, each one comes back once
+func (h *BlogHandler) popOIDCLogin(ctx context.Context) (oidc.Login, string, bool) {
+	login := oidc.Login{
+		State:    h.Sessions.Manager.PopString(ctx, "oidc_state"),
+		Nonce:    h.Sessions.Manager.PopString(ctx, "oidc_nonce"),
+		Verifier: h.Sessions.Manager.PopString(ctx, "oidc_verifier"),
+	}
+	next := safeRedirectPath(h.Sessions.Manager.PopString(ctx, "oidc_next"))
+	started := time.Unix(h.Sessions.Manager.GetInt64(ctx, "oidc_at"), 0)
+	h.Sessions.Manager.Remove(ctx, "oidc_at")
+
+	if login.State == "" || time.Since(started) > oidcLoginLifetime {
+		return oidc.Login{}, "", false
+	}
+	return login, next, true
+}
+
+// oidcIdentity is an identity of the provider waiting to be linked, email is only kept when the provider verified it
+type oidcIdentity struct {
+	issuer   string
+	subject  string
+	email    string
+	username string
+	next     string
+}
+
+// startOIDCLink holds a new identity in the session and asks what to do with it. The token is renewed as for a login
+func (h *BlogHandler) startOIDCLink(w http.ResponseWriter, r *http.Request, claims *oidc.Claims, next string) {
+	ctx := r.Context()
+	if err := h.Sessions.Manager.RenewToken(ctx); err != nil {
+		h.InternalError(w, r, err)
+		return
+	}
+
+	email := ""
+	if claims.EmailVerified {
+		email = claims.Email
+	}
+	h.Sessions.Manager.Put(ctx, "oidc_link_issuer", claims.Issuer)
+	h.Sessions.Manager.Put(ctx, "oidc_link_subject", claims.Subject)
+	h.Sessions.Manager.Put(ctx, "oidc_link_email", email)
+	h.Sessions.Manager.Put(ctx, "oidc_link_username", suggestUsername(claims))
+	h.Sessions.Manager.Put(ctx, "oidc_link_next", next)
+	h.Sessions.Manager.Put(ctx, "oidc_link_at", time.Now().Unix())
+
+	h.Logger.Info("new oidc identity, waiting for link", "issuer", claims.Issuer)
+	http.Redirect(w, r, "/login/oidc/link", http.StatusSeeOther)
+}
+
+// pendingOIDCLink returns the identity waiting to be linked, false once the wait is over
+func (h *BlogHandler) pendingOIDCLink(ctx context.Context) (oidcIdentity, bool) {
+	identity := oidcIdentity{
+		issuer:   h.Sessions.Manager.GetString(ctx, "oidc_link_issuer"),
+		subject:  h.Sessions.Manager.GetString(ctx, "oidc_link_subject"),
+		email:    h.Sessions.Manager.GetString(ctx, "oidc_link_email"),
+		username: h.Sessions.Manager.GetString(ctx, "oidc_link_username"),
+		next:     safeRedirectPath(h.Sessions.Manager.GetString(ctx, "oidc_link_next")),
+	}
+	if h.OIDC == nil || identity.subject == "" {
+		return oidcIdentity{}, false
+	}
+	if started := time.Unix(h.Sessions.Manager.GetInt64(ctx, "oidc_link_at"), 0); time.Since(started) > oidcLoginLifetime {
+		h.clearOIDCLink(ctx)
+		return oidcIdentity{}, false
+	}
+	return identity, true
+}
+
+func (h *BlogHandler) clearOIDCLink(ctx context.Context) {
+	for _, key := range []string{"oidc_link_issuer", "oidc_link_subject", "oidc_link_email", "oidc_link_username", "oidc_link_next", "oidc_link_at"} {
+		h.Sessions.Manager.Remove(ctx, key)
+	}
+}
+
+func (h *BlogHandler) renderOIDCLink(w http.ResponseWriter, r *http.Request, status int, p components.OIDCLinkPage) {
+	identity, _ := h.pendingOIDCLink(r.Context())
+	p.Provider = h.OIDC.Name
+	p.Username = identity.username
+	p.NeedsInvite = h.NeedsInvite
+
+	w.WriteHeader(status)
+	components.OIDCLink(h.newCommonData(r), p).Render(r.Context(), w)
+}
+
+// suggestUsername picks a username for a new account from what the provider knows about the user
+func suggestUsername(claims *oidc.Claims) string {
+	name := claims.PreferredUsername
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	name = strings.TrimSpace(name)
+	if len(name) < 3 || len(name) > 50 {
+		return ""
+	}
+	return name
+}
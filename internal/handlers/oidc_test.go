@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"blogengine/internal/encryption"
+	"blogengine/internal/oidc"
+	"blogengine/internal/oidc/oidctest"
+	"blogengine/internal/storage"
+	"blogengine/internal/totp"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestOIDCLogin(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("the password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("could not hash password: %v", err)
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatalf("could not make secret: %v", err)
+	}
+	sealed, err := encryption.Seal(secret, testTOTPKey, totpAdditionalData(2))
+	if err != nil {
+		t.Fatalf("could not seal secret: %v", err)
+	}
+
+	issuer := oidctest.New("blog", "s3cret")
+	t.Cleanup(issuer.Close)
+
+	db := newFakeStore()
+	db.users = []*storage.User{
+		{ID: 1, Username: "bob", PasswordHash: string(hash)},
+		{ID: 2, Username: "carol", PasswordHash: string(hash), TOTPSecret: sealed, TOTPEnabledAt: new(time.Now())},
+	}
+	h := newTestHandler(db, fakeS3{})
+	h.NeedsInvite, h.InviteCode = true, "letmein"
+	h.TOTPKey = testTOTPKey
+	h.OIDC = oidc.New(oidc.Config{Name: "Example ID", Issuer: issuer.URL, ClientID: "blog", ClientSecret: "s3cret"}, issuer.Client())
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /login", h.HandleLoginPage())
+	mux.Handle("GET /login/oidc", h.HandleOIDCLogin())
+	mux.Handle("GET /login/oidc/callback", h.HandleOIDCCallback())
+	mux.Handle("GET /login/oidc/link", h.HandleOIDCLinkPage())
+	mux.Handle("POST /login/oidc/link", h.HandleOIDCLink())
+	mux.Handle("POST /login/oidc/register", h.HandleOIDCRegister())
+	mux.Handle("GET /account", h.HandleAccountPage())
+	mux.Handle("POST /account/reauth", h.HandleOIDCReauth())
+	mux.Handle("POST /account/delete", h.HandleDeleteAccount())
+
+	// login goes to the issuer as user and back, returning the callback's answer and the session
+	login := func(user oidctest.User) (*httptest.ResponseRecorder, []*http.Cookie) {
+		t.Helper()
+		issuer.User = &user
+		rec, cookies := serveWith(h, mux, httptest.NewRequest(http.MethodGet, "/login/oidc?next=/dashboard", nil), nil)
+		if rec.Code != http.StatusSeeOther || !strings.HasPrefix(rec.Header().Get("Location"), issuer.URL+"/authorize?") {
+			t.Fatalf("start: want a redirect to the issuer, got %d %s", rec.Code, rec.Header().Get("Location"))
+		}
+		back, err := issuer.Authorize(rec.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("issuer refused the login: %v", err)
+		}
+		return serveWith(h, mux, httptest.NewRequest(http.MethodGet, back, nil), cookies)
+	}
+
+	rec, _ := serveWith(h, mux, httptest.NewRequest(http.MethodGet, "/login?next=/dashboard", nil), nil)
+	if !strings.Contains(rec.Body.String(), "Log in with Example ID") || !strings.Contains(rec.Body.String(), `href="/login/oidc?next=%2Fdashboard"`) {
+		t.Fatalf("login page: want the provider button, got %s", rec.Body)
+	}
+
+	t.Run("new account", func(t *testing.T) {
+		rec, cookies := login(oidctest.User{Subject: "sub-new", Email: "dora@example.com", EmailVerified: true, PreferredUsername: "dora"})
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/login/oidc/link" {
+			t.Fatalf("new identity: want a redirect to the link page, got %d %s", rec.Code, rec.Header().Get("Location"))
+		}
+		rec, cookies = serveWith(h, mux, httptest.NewRequest(http.MethodGet, "/login/oidc/link", nil), cookies)
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `value="dora"`) || !strings.Contains(rec.Body.String(), "invite_code") {
+			t.Fatalf("link page: want the suggested username and the invite field, got %d %s", rec.Code, rec.Body)
+		}
+
+		rec, cookies = serveWith(h, mux, postForm("/login/oidc/register", url.Values{"username": {"dora"}, "invite_code": {"wrong"}}), cookies)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong invite code: want %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+		rec, _ = serveWith(h, mux, postForm("/login/oidc/register", url.Values{"username": {"dora"}, "invite_code": {"LETMEIN"}}), cookies)
+		if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/dashboard" {
+			t.Fatalf("register: want a redirect to /dashboard, got %d %s", rec.Code, rec.Body)
+		}
+		dora, err := db.GetUserByUsername(t.Context(), "dora")
+		if err != nil || dora.Email == nil || *dora.Email != "dora@example.com" {
+			t.Fatalf("new user: want dora with the verified email, got %+v (%v)", dora, err)
+		}
+		if n := loggedIn(t, h, dora.ID); n != 1 {
+			t.Fatalf("sessions of dora: want 1, got %d", n)
+		}
+
+		// the identity logs in straight away from now on
+		if rec, _ = login(oidctest.User{Subject: "sub-new"}); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/dashboard" {
+			t.Fatalf("second login: want a redirect to /dashboard, got %d %s", rec.Code, rec.Header().Get("Location"))
+		}
+		if n := loggedIn(t, h, dora.ID); n != 2 {
+			t.Fatalf("sessions of dora: want 2, got %d", n)
+		}
+	})
+
+	t.Run("link account", func(t *testing.T) {
+		rec, cookies := login(oidctest.User{Subject: "sub-bob", Email: "bob@elsewhere.example", PreferredUsername: "bob"})
+		if rec.Header().Get("Location") != "/login/oidc/link" {
+			t.Fatalf("new identity: want a redirect to the link page, got %d %s", rec.Code, rec.Header().Get("Location"))
+		}
+
+		rec, cookies = serveWith(h, mux, postForm("/login/oidc/link", url.Values{"username": {"bob"}, "password": {"wrong password"}}), cookies)
+		if rec.Code != http.StatusUnauthorized || len(db.idents) != 1 || db.logins["bob"].Failures != 1 {
+			t.Fatalf("wrong password: want %d, nothing linked and a failure counted, got %d %+v", http.StatusUnauthorized, rec.Code, db.logins["bob"])
+		}
+
+		// a locked username can't be linked either, even with the right password
+		db.logins["bob"].BlockedUntil = time.Now().Add(time.Hour)
+		rec, cookies = serveWith(h, mux, postForm("/login/oidc/link", url.Values{"username": {"bob"}, "password": {"the password"}}), cookies)
+		if rec.Code != http.StatusUnauthorized || len(db.idents) != 1 {
+			t.Fatalf("locked username: want %d and nothing linked, got %d", http.StatusUnauthorized, rec.Code)
+		}
+		db.logins["bob"].BlockedUntil = time.Time{}
+
+		rec, _ = serveWith(h, mux, postForm("/login/oidc/link", url.Values{"username": {"bob"}, "password": {"the password"}}), cookies)
+		if rec.Code != http.StatusSeeOther || db.idents[issuer.URL+" sub-bob"] != 1 || db.logins["bob"] != nil {
+			t.Fatalf("link: want %d, the identity linked to bob and no failures left, got %d", http.StatusSeeOther, rec.Code)
+		}
+		if n := loggedIn(t, h, 1); n != 1 {
+			t.Fatalf("sessions of bob: want 1, got %d", n)
+		}
+		// an unverified email is not taken over
+		if bob, _ := db.GetUserByID(t.Context(), 1); bob.Email != nil {
+			t.Fatalf("bob's email: want none, got %s", *bob.Email)
+		}
+	})
+
+	t.Run("link account with two-factor", func(t *testing.T) {
+		rec, cookies := login(oidctest.User{Subject: "sub-carol"})
+		rec, cookies = serveWith(h, mux, postForm("/login/oidc/link", url.Values{"username": {"carol"}, "password": {"the password"}}), cookies)
+		if rec.Code != http.StatusUnauthorized || db.idents[issuer.URL+" sub-carol"] != 0 {
+			t.Fatalf("no code: want %d and nothing linked, got %d", http.StatusUnauthorized, rec.Code)
+		}
+		code := totp.Code(secret, totp.Step(time.Now()))
+		rec, _ = serveWith(h, mux, postForm("/login/oidc/link", url.Values{"username": {"carol"}, "password": {"the password"}, "code": {code}}), cookies)
+		if rec.Code != http.StatusSeeOther || db.idents[issuer.URL+" sub-carol"] != 2 {
+			t.Fatalf("link: want %d and the identity linked to carol, got %d %s", http.StatusSeeOther, rec.Code, rec.Body)
+		}
+
+		// later logins still ask for the code
+		if rec, _ = login(oidctest.User{Subject: "sub-carol"}); rec.Header().Get("Location") != "/login/2fa" {
+			t.Fatalf("login: want a redirect to /login/2fa, got %d %s", rec.Code, rec.Header().Get("Location"))
+		}
+		if n := loggedIn(t, h, 2); n != 1 {
+			t.Fatalf("sessions of carol: want 1, got %d", n)
+		}
+	})
+
+	t.Run("callback checks", func(t *testing.T) {
+		issuer.User = &oidctest.User{Subject: "sub-new"}
+		rec, cookies := serveWith(h, mux, httptest.NewRequest(http.MethodGet, "/login/oidc", nil), nil)
+		back, err := issuer.Authorize(rec.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("issuer refused the login: %v", err)
+		}
+
+		// another browser can't finish the login
+		if rec, _ = serveWith(h, mux, httptest.NewRequest(http.MethodGet, back, nil), nil); rec.Code != http.StatusBadRequest {
+			t.Fatalf("callback without the session: want %d, got %d", http.StatusBadRequest, rec.Code)
+		}
+		forged := strings.Replace(back, "state=", "state=x", 1)
+		if rec, _ = serveWith(h, mux, httptest.NewRequest(http.MethodGet, forged, nil), cookies); rec.Code != http.StatusBadRequest {
+			t.Fatalf("other state: want %d, got %d", http.StatusBadRequest, rec.Code)
+		}
+		// the state went with the first answer
+		if rec, _ = serveWith(h, mux, httptest.NewRequest(http.MethodGet, back, nil), cookies); rec.Code != http.StatusBadRequest {
+			t.Fatalf("callback replayed: want %d, got %d", http.StatusBadRequest, rec.Code)
+		}
+
+		// tokens of another client are refused
+		issuer.Modify = func(claims map[string]any) { claims["aud"] = "another-client" }
+		defer func() { issuer.Modify = nil }()
+		if rec, _ = login(oidctest.User{Subject: "sub-new"}); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("token for another client: want %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+
+		if rec, _ = serveWith(h, mux, httptest.NewRequest(http.MethodGet, "/login/oidc/link", nil), nil); rec.Code != http.StatusSeeOther {
+			t.Fatalf("link page without an identity: want %d, got %d", http.StatusSeeOther, rec.Code)
+		}
+	})
+
+	t.Run("passwordless account", func(t *testing.T) {
+		dora, err := db.GetUserByUsername(t.Context(), "dora")
+		if err != nil || !dora.Passwordless {
+			t.Fatalf("account made at the provider: want passwordless, got %+v (%v)", dora, err)
+		}
+		_, cookies := login(oidctest.User{Subject: "sub-new"})
+		// reauth confirms the logged in user at the issuer as user
+		reauth := func(user oidctest.User) *httptest.ResponseRecorder {
+			t.Helper()
+			issuer.User = &user
+			rec, _ := serveWith(h, mux, postForm("/account/reauth", nil), cookies)
+			back, err := issuer.Authorize(rec.Header().Get("Location"))
+			if err != nil {
+				t.Fatalf("issuer refused the login: %v", err)
+			}
+			rec, _ = serveWith(h, mux, httptest.NewRequest(http.MethodGet, back, nil), cookies)
+			return rec
+		}
+
+		rec, _ := serveWith(h, mux, httptest.NewRequest(http.MethodGet, "/account", nil), cookies)
+		if body := rec.Body.String(); !strings.Contains(body, "Confirm with Example ID") || strings.Contains(body, `name="delete_password"`) {
+			t.Fatalf("account page: want the provider in place of the password, got %s", body)
+		}
+		if rec, _ = serveWith(h, mux, postForm("/account/delete", nil), cookies); rec.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("delete before confirming: want %d, got %d", http.StatusUnprocessableEntity, rec.Code)
+		}
+
+		// only the identity linked to the account confirms it
+		if rec = reauth(oidctest.User{Subject: "sub-bob"}); rec.Code != http.StatusForbidden {
+			t.Fatalf("confirmation as bob: want %d, got %d", http.StatusForbidden, rec.Code)
+		}
+		if rec, _ = serveWith(h, mux, postForm("/account/delete", nil), cookies); rec.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("delete after a confirmation as bob: want %d, got %d", http.StatusUnprocessableEntity, rec.Code)
+		}
+
+		if rec = reauth(oidctest.User{Subject: "sub-new"}); rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/account" {
+			t.Fatalf("confirmation: want a redirect to /account, got %d %s", rec.Code, rec.Header().Get("Location"))
+		}
+		if rec, _ = serveWith(h, mux, postForm("/account/delete", nil), cookies); rec.Code != http.StatusSeeOther {
+			t.Fatalf("delete once confirmed: want %d, got %d", http.StatusSeeOther, rec.Code)
+		}
+		if _, err := db.GetUserByID(t.Context(), dora.ID); err == nil {
+			t.Fatal("delete once confirmed: want dora gone")
+		}
+	})
+}
+
+func TestOIDCDisabled(t *testing.T) {
+	t.Parallel()
+	h := newTestHandler(newFakeStore(), fakeS3{})
+
+	rec := serve(h, h.HandleOIDCLogin(), httptest.NewRequest(http.MethodGet, "/login/oidc", nil), 0)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("no provider: want %d, got %d", http.StatusNotFound, rec.Code)
+	}
+	rec = serve(h, h.HandleLoginPage(), httptest.NewRequest(http.MethodGet, "/login", nil), 0)
+	if strings.Contains(rec.Body.String(), "/login/oidc") {
+		t.Fatalf("login page: want no provider button")
+	}
+}
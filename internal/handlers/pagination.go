@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"blogengine/internal/storage"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errBadCursor = errors.New("malformed cursor")
+
+// cursorToken is the position of c as it goes in links and api cursors, opaque to readers
+func cursorToken(c storage.Cursor) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.ID, 10) + ":" + c.Time.UTC().Format(time.RFC3339Nano)))
+}
+
+// parseCursor reads a cursorToken, before tells which side of it the page is on
+func parseCursor(token string, before bool) (storage.Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return storage.Cursor{}, errBadCursor
+	}
+	id, ts, ok := strings.Cut(string(b), ":")
+	if !ok {
+		return storage.Cursor{}, errBadCursor
+	}
+	c := storage.Cursor{Before: before}
+	if c.ID, err = strconv.ParseInt(id, 10, 64); err != nil || c.ID < 1 {
+		return storage.Cursor{}, errBadCursor
+	}
+	if c.Time, err = time.Parse(time.RFC3339Nano, ts); err != nil {
+		return storage.Cursor{}, errBadCursor
+	}
+	return c, nil
+}
+
+// pageCursor reads the page of a listing from ?after= or ?before=, the top of the listing when there is neither. Like
+// page numbers, cursors that don't parse are ignored
+func pageCursor(r *http.Request) storage.Cursor {
+	q := r.URL.Query()
+	if token := q.Get("before"); token != "" {
+		c, _ := parseCursor(token, true)
+		return c
+	}
+	c, _ := parseCursor(q.Get("after"), false)
+	return c
+}
+
+// paginate trims the extra item listings fetch to know whether there is more on the side they page to, items come
+// newest first. newer and older are the tokens of the pages around, "" when there is none
+func paginate[T any](items []T, c storage.Cursor, limit int64, key func(T) storage.Cursor) (page []T, newer, older string) {
+	more := int64(len(items)) > limit
+	switch {
+	case c.Before && more:
+		items = items[1:]
+	case more:
+		items = items[:limit]
+	}
+	if len(items) == 0 {
+		return items, "", ""
+	}
+
+	// coming from a cursor there is the page it came from on the other side
+	if c.Before && more || !c.Before && !c.IsZero() {
+		newer = cursorToken(key(items[0]))
+	}
+	if c.Before || more {
+		older = cursorToken(key(items[len(items)-1]))
+	}
+	return items, newer, older
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"blogengine/internal/storage"
+	"fmt"
+	"html"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestListingPages(t *testing.T) {
+	t.Parallel()
+
+	// the posts were seeded together and share their publication time
+	publishedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	var posts []*storage.Post
+	for i := 1; i <= 25; i++ {
+		posts = append(posts, testPost(fmt.Sprintf("post-%02d", i), func(p *storage.Post) {
+			p.ID = int64(i)
+			p.Title = fmt.Sprintf("Post %02d", i)
+			p.PublishedAt = &publishedAt
+		}))
+	}
+	h := newTestHandler(newFakeStore(posts...), fakeS3{})
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /{$}", h.HandleHome())
+
+	titles := regexp.MustCompile(`Post \d\d`)
+	// links only carry the query, they are relative to the home page
+	link := func(body, rel string) string {
+		m := regexp.MustCompile(`href="([^"]+)" rel="` + rel + `"`).FindStringSubmatch(body)
+		if m == nil {
+			return ""
+		}
+		return "/" + html.UnescapeString(m[1])
+	}
+	get := func(target string) (string, []string) {
+		t.Helper()
+		rec := serve(h, mux, httptest.NewRequest(http.MethodGet, target, nil), 0)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: want %d, got %d", target, http.StatusOK, rec.Code)
+		}
+		return rec.Body.String(), titles.FindAllString(rec.Body.String(), -1)
+	}
+
+	var pages [][]string
+	var bodies []string
+	for target := "/"; target != ""; {
+		body, page := get(target)
+		pages, bodies = append(pages, page), append(bodies, body)
+		target = link(body, "next")
+		if len(pages) > 3 {
+			t.Fatalf("pages: want 3, got more")
+		}
+	}
+	want := []string{"Post 25", "Post 16", "Post 15", "Post 06", "Post 05", "Post 01"}
+	if len(pages) != 3 || len(pages[0]) != 10 || len(pages[2]) != 5 {
+		t.Fatalf("pages: want 10, 10 and 5 posts, got %v", pages)
+	}
+	for i, page := range pages {
+		if page[0] != want[2*i] || page[len(page)-1] != want[2*i+1] {
+			t.Fatalf("page %d: want %s to %s, got %v", i+1, want[2*i], want[2*i+1], page)
+		}
+	}
+	if link(bodies[0], "prev") != "" || link(bodies[2], "next") != "" {
+		t.Fatal("first and last page: want no link beyond them")
+	}
+
+	// newer pages come back as they were
+	for i := 2; i > 0; i-- {
+		if _, page := get(link(bodies[i], "prev")); !slices.Equal(page, pages[i-1]) {
+			t.Fatalf("back from page %d: want %v, got %v", i+1, pages[i-1], page)
+		}
+	}
+}
+
+func TestPageThreads(t *testing.T) {
+	t.Parallel()
+
+	// three threads newest first, each root with one reply
+	created := time.Now()
+	var comments []*storage.Comment
+	for _, id := range []int64{5, 3, 1} {
+		comments = append(comments,
+			&storage.Comment{ID: id, CreatedAt: created},
+			&storage.Comment{ID: id + 1, ParentID: new(id), CreatedAt: created},
+		)
+	}
+	ids := func(comments []*storage.Comment) []int64 {
+		out := make([]int64, len(comments))
+		for i, c := range comments {
+			out[i] = c.ID
+		}
+		return out
+	}
+
+	page, newer, older := pageThreads(comments, storage.Cursor{}, 2)
+	if !slices.Equal(ids(page), []int64{5, 6, 3, 4}) || newer != "" || older != cursorToken(comments[2].Cursor()) {
+		t.Fatalf("first page: want the two newest threads and a link to older ones, got %v %q %q", ids(page), newer, older)
+	}
+
+	before := comments[4].Cursor()
+	before.Before = true
+	page, newer, older = pageThreads(comments[:4], before, 2)
+	if !slices.Equal(ids(page), []int64{5, 6, 3, 4}) || newer != "" || older != cursorToken(comments[2].Cursor()) {
+		t.Fatalf("back to the top: want the two newest threads, got %v %q %q", ids(page), newer, older)
+	}
+
+	page, newer, older = pageThreads(comments[4:], comments[2].Cursor(), 2)
+	if !slices.Equal(ids(page), []int64{1, 2}) || newer != cursorToken(comments[4].Cursor()) || older != "" {
+		t.Fatalf("last page: want the oldest thread and a link to newer ones, got %v %q %q", ids(page), newer, older)
+	}
+}
@@ -0,0 +1,269 @@
+package handlers
+
+import (
+	"blogengine/internal/storage"
+	"blogengine/internal/webauthn"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// passkeyResult tells the passkey script where to go once a ceremony went through
+type passkeyResult struct {
+	Redirect string `json:"redirect"`
+}
+
+// HandlePasskeyLoginOptions starts a passkey login, the challenge waits in the session for the signed answer
+func (h *BlogHandler) HandlePasskeyLoginOptions() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := h.Tracer.Start(r.Context(), "HandlePasskeyLoginOptions")
+		defer span.End()
+
+		challenge, err := h.newPasskeyChallenge(r.Context(), "webauthn_login")
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, h.relyingParty(r).RequestOptions(challenge))
+	})
+}
+
+// HandlePasskeyLogin logs in the owner of the passkey that signed the challenge. Passkeys verify the user on their
+// device, so they stand in for both the password and the two-factor code
+func (h *BlogHandler) HandlePasskeyLogin() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandlePasskeyLogin")
+		defer span.End()
+
+		var body struct {
+			Credential webauthn.AuthenticationResponse `json:"credential"`
+			Next       string                          `json:"next"`
+		}
+		if err := decodeJSON(w, r, &body); err != nil {
+			passkeyError(w, http.StatusBadRequest, "The passkey answer could not be read.")
+			return
+		}
+
+		challenge, ok := h.popPasskeyChallenge(ctx, "webauthn_login")
+		if !ok {
+			passkeyError(w, http.StatusBadRequest, "The passkey request expired, try again.")
+			return
+		}
+
+		passkey, err := h.DB.GetPasskeyByCredentialID(ctx, body.Credential.ID)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				passkeyError(w, http.StatusUnauthorized, "This passkey is not registered here.")
+				return
+			}
+			h.InternalError(w, r, err)
+			return
+		}
+
+		cred := webauthn.Credential{ID: passkey.CredentialID, PublicKey: passkey.PublicKey, SignCount: uint32(passkey.SignCount)}
+		signCount, err := h.relyingParty(r).VerifyAuthentication(challenge, cred, passkeyUserHandle(passkey.UserID), body.Credential)
+		if err != nil {
+			h.Logger.Warn("passkey login refused", "user_id", passkey.UserID, "passkey_id", passkey.ID, "err", err)
+			passkeyError(w, http.StatusUnauthorized, "The passkey could not be verified.")
+			return
+		}
+		if err := h.DB.UsePasskey(ctx, passkey.ID, int64(signCount)); err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				h.Logger.Warn("passkey counter did not go up", "user_id", passkey.UserID, "passkey_id", passkey.ID)
+				passkeyError(w, http.StatusUnauthorized, "The passkey could not be verified.")
+				return
+			}
+			h.InternalError(w, r, err)
+			return
+		}
+
+		user, err := h.DB.GetUserByID(ctx, passkey.UserID)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		if err := h.login(r, user, "passkey"); err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("user logged in", "id", user.ID, "username", user.Username, "passkey_id", passkey.ID)
+		writeJSON(w, http.StatusOK, passkeyResult{Redirect: safeRedirectPath(body.Next)})
+	})
+}
+
+// HandlePasskeyRegisterOptions starts adding a passkey to the logged in user, the authenticators they registered
+// already are excluded
+func (h *BlogHandler) HandlePasskeyRegisterOptions() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandlePasskeyRegisterOptions")
+		defer span.End()
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+		user, err := h.DB.GetUserByID(ctx, userID)
+		if err != nil {
+			h.dashboardError(w, r, err)
+			return
+		}
+		passkeys, err := h.DB.GetPasskeysForUser(ctx, userID)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		exclude := make([][]byte, len(passkeys))
+		for i, p := range passkeys {
+			exclude[i] = p.CredentialID
+		}
+
+		challenge, err := h.newPasskeyChallenge(ctx, "webauthn_register")
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		account := webauthn.User{ID: passkeyUserHandle(user.ID), Name: user.Username, DisplayName: user.Username}
+		writeJSON(w, http.StatusOK, h.relyingParty(r).CreationOptions(account, challenge, exclude))
+	})
+}
+
+// HandleRegisterPasskey stores the passkey the user's authenticator created for the challenge
+func (h *BlogHandler) HandleRegisterPasskey() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleRegisterPasskey")
+		defer span.End()
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		var body struct {
+			Name       string                        `json:"name"`
+			Credential webauthn.RegistrationResponse `json:"credential"`
+		}
+		if err := decodeJSON(w, r, &body); err != nil {
+			passkeyError(w, http.StatusBadRequest, "The passkey could not be read.")
+			return
+		}
+
+		challenge, ok := h.popPasskeyChallenge(ctx, "webauthn_register")
+		if !ok {
+			passkeyError(w, http.StatusBadRequest, "The passkey request expired, try again.")
+			return
+		}
+
+		cred, err := h.relyingParty(r).VerifyRegistration(challenge, body.Credential)
+		if err != nil {
+			h.Logger.Warn("passkey registration refused", "user_id", userID, "err", err)
+			passkeyError(w, http.StatusBadRequest, "The passkey could not be verified.")
+			return
+		}
+
+		name := strings.TrimSpace(body.Name)
+		if name == "" {
+			name = "Passkey"
+		}
+		passkey, err := h.DB.CreatePasskey(ctx, storage.CreatePasskeyParams{
+			UserID:       userID,
+			Name:         name,
+			CredentialID: cred.ID,
+			PublicKey:    cred.PublicKey,
+			SignCount:    int64(cred.SignCount),
+		})
+		var invalid *storage.ValidationError
+		switch {
+		case errors.As(err, &invalid):
+			passkeyError(w, http.StatusUnprocessableEntity, "Passkey names are 1 to 64 characters.")
+			return
+		case errors.Is(err, storage.ErrUniqueViolation):
+			passkeyError(w, http.StatusConflict, "This passkey is registered already.")
+			return
+		case err != nil:
+			h.InternalError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("passkey added", "user_id", userID, "passkey_id", passkey.ID)
+		h.Sessions.Manager.Put(ctx, "notice", "Passkey added, you can log in with it now.")
+		writeJSON(w, http.StatusOK, passkeyResult{Redirect: "/account"})
+	})
+}
+
+// HandleDeletePasskey removes one of the user's passkeys
+func (h *BlogHandler) HandleDeletePasskey() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleDeletePasskey")
+		defer span.End()
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		passkeyID, err := strconv.ParseInt(r.PathValue("passkey_id"), 10, 64)
+		if err != nil {
+			h.NotFound(w, r)
+			return
+		}
+		if err := h.DB.DeletePasskey(ctx, userID, passkeyID); err != nil {
+			h.dashboardError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("passkey removed", "user_id", userID, "passkey_id", passkeyID)
+		h.Sessions.Manager.Put(ctx, "notice", "Passkey removed.")
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+	})
+}
+
+// relyingParty scopes passkeys to the host of the site, the origin checks use the same base url as links in emails
+func (h *BlogHandler) relyingParty(r *http.Request) webauthn.RelyingParty {
+	rp := webauthn.RelyingParty{Name: h.Title}
+	if u, err := url.Parse(h.baseURL(r)); err == nil {
+		rp.ID = u.Hostname()
+		rp.Origins = []string{u.Scheme + "://" + u.Host}
+	}
+	return rp
+}
+
+// newPasskeyChallenge keeps a new challenge under key until the answer comes back
+func (h *BlogHandler) newPasskeyChallenge(ctx context.Context, key string) ([]byte, error) {
+	challenge, err := webauthn.NewChallenge()
+	if err != nil {
+		return nil, err
+	}
+	h.Sessions.Manager.Put(ctx, key, challenge)
+	h.Sessions.Manager.Put(ctx, key+"_at", time.Now().Unix())
+	return challenge, nil
+}
+
+// popPasskeyChallenge takes the challenge under key out of the session, each one is answered once and only while the
+// browser would still wait for the authenticator
+func (h *BlogHandler) popPasskeyChallenge(ctx context.Context, key string) ([]byte, bool) {
+	challenge := h.Sessions.Manager.PopBytes(ctx, key)
+	issued := time.Unix(h.Sessions.Manager.GetInt64(ctx, key+"_at"), 0)
+	h.Sessions.Manager.Remove(ctx, key+"_at")
+	if len(challenge) == 0 || time.Since(issued) > webauthn.Timeout {
+		return nil, false
+	}
+	return challenge, true
+}
+
+// passkeyUserHandle is the WebAuthn user handle of a user, their id says nothing about them outside the site
+func passkeyUserHandle(userID int64) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userID))
+}
+
+// passkeyError answers the passkey script with a message it can show
+func passkeyError(w http.ResponseWriter, status int, message string) {
+	var body apiErrorBody
+	body.Error.Code, body.Error.Message = "passkey", message
+	writeJSON(w, status, body)
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"blogengine/internal/storage"
+	"blogengine/internal/webauthn"
+	"blogengine/internal/webauthn/webauthntest"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func postJSON(target string, body any) *http.Request {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(string(data)))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestPasskeys(t *testing.T) {
+	t.Parallel()
+
+	db := newFakeStore()
+	db.users = []*storage.User{{ID: 1, Username: "alice"}, {ID: 2, Username: "bob"}}
+	h := newTestHandler(db, fakeS3{})
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /login/passkey/options", h.HandlePasskeyLoginOptions())
+	mux.Handle("POST /login/passkey", h.HandlePasskeyLogin())
+	mux.Handle("POST /account/passkeys/options", h.HandlePasskeyRegisterOptions())
+	mux.Handle("POST /account/passkeys", h.HandleRegisterPasskey())
+	mux.Handle("POST /account/passkeys/{passkey_id}/delete", h.HandleDeletePasskey())
+
+	// httptest requests come from http://example.com
+	authenticator := webauthntest.New("http://example.com")
+
+	_, cookies := serveWith(h, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.Sessions.Login(r, 1, "alice")
+	}), httptest.NewRequest(http.MethodPost, "/login", nil), nil)
+
+	// registration
+	rec, cookies := serveWith(h, mux, postJSON("/account/passkeys/options", nil), cookies)
+	var creation webauthn.CreationOptions
+	if err := json.Unmarshal(rec.Body.Bytes(), &creation); err != nil || creation.RP.ID != "example.com" {
+		t.Fatalf("creation options: want options for example.com, got %d %s", rec.Code, rec.Body)
+	}
+	created, err := authenticator.Create(creation)
+	if err != nil {
+		t.Fatalf("authenticator could not create: %v", err)
+	}
+	rec, cookies = serveWith(h, mux, postJSON("/account/passkeys", map[string]any{"name": "Laptop", "credential": created}), cookies)
+	if rec.Code != http.StatusOK || len(db.passkeys) != 1 || db.passkeys[0].Name != "Laptop" {
+		t.Fatalf("registration: want %d and the passkey stored, got %d %s", http.StatusOK, rec.Code, rec.Body)
+	}
+
+	// the challenge is answered once
+	rec, _ = serveWith(h, mux, postJSON("/account/passkeys", map[string]any{"name": "Laptop", "credential": created}), cookies)
+	if rec.Code != http.StatusBadRequest || len(db.passkeys) != 1 {
+		t.Fatalf("registration replayed: want %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	login := func(cookies []*http.Cookie) (*httptest.ResponseRecorder, webauthn.AuthenticationResponse) {
+		t.Helper()
+		rec, cookies := serveWith(h, mux, postJSON("/login/passkey/options", nil), cookies)
+		var request webauthn.RequestOptions
+		if err := json.Unmarshal(rec.Body.Bytes(), &request); err != nil {
+			t.Fatalf("request options: %v", err)
+		}
+		assertion, err := authenticator.Get(request)
+		if err != nil {
+			t.Fatalf("authenticator could not sign: %v", err)
+		}
+		rec, _ = serveWith(h, mux, postJSON("/login/passkey", map[string]any{"next": "/dashboard", "credential": assertion}), cookies)
+		return rec, assertion
+	}
+
+	rec, assertion := login(nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"redirect":"/dashboard"`) {
+		t.Fatalf("login: want %d and a redirect, got %d %s", http.StatusOK, rec.Code, rec.Body)
+	}
+	if n := loggedIn(t, h, 1); n != 2 {
+		t.Fatalf("sessions of alice: want 2, got %d", n)
+	}
+
+	// an assertion seen once can't log in another session
+	rec, cookies2 := serveWith(h, mux, postJSON("/login/passkey/options", nil), nil)
+	rec, _ = serveWith(h, mux, postJSON("/login/passkey", map[string]any{"credential": assertion}), cookies2)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("replayed assertion: want %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+	if rec, _ = serveWith(h, mux, postJSON("/login/passkey", map[string]any{"credential": assertion}), nil); rec.Code != http.StatusBadRequest {
+		t.Fatalf("login without a challenge: want %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+
+	// passkeys are removed by their owner only
+	if rec = serve(h, mux, httptest.NewRequest(http.MethodPost, "/account/passkeys/1/delete", nil), 2); rec.Code != http.StatusNotFound {
+		t.Fatalf("passkey of another user: want %d, got %d", http.StatusNotFound, rec.Code)
+	}
+	if rec = serve(h, mux, httptest.NewRequest(http.MethodPost, "/account/passkeys/1/delete", nil), 1); rec.Code != http.StatusSeeOther || len(db.passkeys) != 0 {
+		t.Fatalf("remove passkey: want %d and none left, got %d", http.StatusSeeOther, rec.Code)
+	}
+	if rec, _ = login(nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("login with a removed passkey: want %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+}
@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"blogengine/internal/components"
+	"blogengine/internal/mail"
+	"blogengine/internal/storage"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordResetLifetime is how long a reset link works, short as whoever reads the mailbox can take the account
+const passwordResetLifetime = time.Hour
+
+func (h *BlogHandler) HandleForgotPasswordPage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// user already logged in, send home
+		if h.Sessions.Manager.Exists(r.Context(), "userID") {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		common := h.newCommonData(r)
+		components.ForgotPassword(common, false).Render(r.Context(), w)
+	})
+}
+
+// HandleForgotPassword emails a reset link to the account of the given username. Every outcome gets the same page so
+// the form can't be used to find out which accounts exist or have an email address, the route keeps the auth delay
+// for the same reason
+func (h *BlogHandler) HandleForgotPassword() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleForgotPassword")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		username := strings.TrimSpace(r.FormValue("username"))
+
+		user, err := h.DB.GetUserByUsername(ctx, username)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			h.Logger.Info("password reset for unknown user")
+		case err != nil:
+			h.Logger.Error("could not look up user for password reset", "err", err)
+		case user.Email == nil:
+			h.Logger.Info("password reset for user without email", "user_id", user.ID)
+		default:
+			h.sendPasswordReset(r, user)
+		}
+
+		components.ForgotPassword(common, true).Render(ctx, w)
+	})
+}
+
+// sendPasswordReset stores a new reset token for the user and emails them the link, failures are logged rather than
+// shown as the page must not differ. Links are only built on the configured url, the Host header is whatever the
+// requester wants it to be and the link carries the token
+func (h *BlogHandler) sendPasswordReset(r *http.Request, user *storage.User) {
+	ctx := r.Context()
+
+	if h.BaseURL == "" {
+		h.Logger.Error("password reset needs APP_BASE_URL to build its link", "user_id", user.ID)
+		return
+	}
+
+	token, err := newToken()
+	if err != nil {
+		h.Logger.Error("could not generate password reset token", "user_id", user.ID, "err", err)
+		return
+	}
+	if err := h.DB.CreatePasswordReset(ctx, user.ID, hashToken(token), time.Now().Add(passwordResetLifetime)); err != nil {
+		h.Logger.Error("could not create password reset", "user_id", user.ID, "err", err)
+		return
+	}
+
+	reset := mail.PasswordReset{
+		SiteName: h.Title,
+		Username: user.Username,
+		ResetURL: h.BaseURL + "/reset-password/" + token,
+		ValidFor: passwordResetLifetime,
+	}
+	if err := h.Mail.Send(ctx, *user.Email, reset); err != nil {
+		h.Logger.Error("could not queue password reset email", "user_id", user.ID, "err", err)
+		return
+	}
+
+	h.Logger.Info("password reset sent", "user_id", user.ID)
+}
+
+// HandleResetPasswordPage shows the new password form of a reset link, or why the link no longer works
+func (h *BlogHandler) HandleResetPasswordPage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleResetPasswordPage")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		token := r.PathValue("token")
+
+		if _, err := h.DB.GetPasswordReset(ctx, hashToken(token)); err != nil {
+			h.resetLinkError(w, r, err)
+			return
+		}
+
+		components.ResetPassword(common, token, "").Render(ctx, w)
+	})
+}
+
+// HandleResetPassword sets the new password of a reset link and uses the link up. Every other session of the user is
+// destroyed, and this one is logged in as them, through the two-factor step if they have it
+func (h *BlogHandler) HandleResetPassword() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleResetPassword")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		token := r.PathValue("token")
+		password := r.FormValue("password")
+		confirm := r.FormValue("confirm_password")
+
+		if password != confirm {
+			w.WriteHeader(http.StatusBadRequest)
+			components.ResetPassword(common, token, "Passwords do not match.").Render(ctx, w)
+			return
+		}
+		if len(password) < 8 {
+			w.WriteHeader(http.StatusBadRequest)
+			components.ResetPassword(common, token, "Password too short.").Render(ctx, w)
+			return
+		}
+
+		hashedPwd, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		reset, err := h.DB.UsePasswordReset(ctx, hashToken(token))
+		if err != nil {
+			h.resetLinkError(w, r, err)
+			return
+		}
+
+		// the store drops the other reset links of the user along with the old password
+		if err := h.DB.ChangeUserPassword(ctx, reset.UserID, string(hashedPwd)); err != nil {
+			h.resetLinkError(w, r, err)
+			return
+		}
+
+		user, err := h.DB.GetUserByID(ctx, reset.UserID)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		destroyed, err := h.Sessions.DestroyOtherSessions(ctx, user.ID)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("password reset", "user_id", user.ID, "sessions_destroyed", destroyed)
+		h.Audit.Record(r, storage.AuditPasswordChange, user.ID, map[string]any{"via": "reset", "sessions_destroyed": destroyed})
+
+		// the mailbox alone doesn't get past two-factor
+		if user.HasTOTP() {
+			h.startTwoFactor(w, r, user, "/")
+			return
+		}
+
+		if err := h.login(r, user, "password reset"); err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	})
+}
+
+// resetLinkError tells apart dead reset links, used, expired or never sent, from failures of the store
+func (h *BlogHandler) resetLinkError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, storage.ErrNotFound) {
+		h.RenderError(w, r, http.StatusNotFound, "Link expired", "Reset links work once and expire quickly, ask for a new one from the login page.")
+		return
+	}
+	h.InternalError(w, r, err)
+}
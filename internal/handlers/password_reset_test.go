@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordReset(t *testing.T) {
+	t.Parallel()
+
+	db := newFakeStore()
+	db.users = []*storage.User{
+		{ID: 1, Username: "alice", PasswordHash: "old hash", Email: new("alice@example.com")},
+		{ID: 2, Username: "bob", PasswordHash: "old hash"},
+	}
+	h := newTestHandler(db, fakeS3{})
+	h.BaseURL = "https://blog.example.com"
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /forgot-password", h.HandleForgotPassword())
+	mux.Handle("GET /reset-password/{token}", h.HandleResetPasswordPage())
+	mux.Handle("POST /reset-password/{token}", h.HandleResetPassword())
+	post := func(target string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return serve(h, mux, req, 0)
+	}
+
+	// the page is the same whether the account exists, has an email or not
+	var pages []string
+	for _, username := range []string{"alice", "bob", "nobody"} {
+		rec := post("/forgot-password", url.Values{"username": {username}})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("forgot %s: want %d, got %d", username, http.StatusOK, rec.Code)
+		}
+		pages = append(pages, rec.Body.String())
+	}
+	if pages[0] != pages[1] || pages[0] != pages[2] {
+		t.Fatal("forgot password: pages differ between accounts")
+	}
+
+	sent := h.Mail.(*fakeMailer).sent
+	if len(sent) != 1 || sent[0].To != "alice@example.com" {
+		t.Fatalf("reset email: want one to alice, got %+v", sent)
+	}
+	match := regexp.MustCompile(`https://blog\.example\.com(/reset-password/\S+)`).FindStringSubmatch(sent[0].Text)
+	if match == nil {
+		t.Fatalf("reset email has no link on the base url: %s", sent[0].Text)
+	}
+	resetURL := match[1]
+
+	// alice and bob are logged in elsewhere
+	for _, userID := range []int64{1, 2} {
+		serve(h, http.NotFoundHandler(), httptest.NewRequest(http.MethodGet, "/", nil), userID)
+	}
+
+	tests := []struct {
+		name         string
+		method       string
+		target       string
+		form         url.Values
+		wantStatus   int
+		wantLocation string
+	}{
+		{name: "the link shows the form", method: http.MethodGet, target: resetURL, wantStatus: http.StatusOK},
+		{name: "unknown links", method: http.MethodGet, target: "/reset-password/nope", wantStatus: http.StatusNotFound},
+		{name: "passwords differ", method: http.MethodPost, target: resetURL, form: url.Values{"password": {"a new password"}, "confirm_password": {"another one"}}, wantStatus: http.StatusBadRequest},
+		{name: "too short", method: http.MethodPost, target: resetURL, form: url.Values{"password": {"short"}, "confirm_password": {"short"}}, wantStatus: http.StatusBadRequest},
+		{name: "new password", method: http.MethodPost, target: resetURL, form: url.Values{"password": {"a new password"}, "confirm_password": {"a new password"}}, wantStatus: http.StatusSeeOther, wantLocation: "/"},
+		{name: "links work once", method: http.MethodPost, target: resetURL, form: url.Values{"password": {"a new password"}, "confirm_password": {"a new password"}}, wantStatus: http.StatusNotFound},
+	}
+
+	// sequential, each step depends on the one before
+	for _, tt := range tests {
+		var rec *httptest.ResponseRecorder
+		if tt.method == http.MethodGet {
+			rec = serve(h, mux, httptest.NewRequest(tt.method, tt.target, nil), 0)
+		} else {
+			rec = post(tt.target, tt.form)
+		}
+
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("%s: status: want %d, got %d", tt.name, tt.wantStatus, rec.Code)
+		}
+		if loc := rec.Header().Get("Location"); loc != tt.wantLocation {
+			t.Fatalf("%s: location: want %q, got %q", tt.name, tt.wantLocation, loc)
+		}
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(db.users[0].PasswordHash), []byte("a new password")); err != nil {
+		t.Fatalf("password was not changed: %v", err)
+	}
+
+	// alice's old session is gone, the reset one remains and so does bob's
+	sessions := map[int64]int{}
+	sm := h.Sessions.Manager
+	if err := sm.Iterate(context.Background(), func(ctx context.Context) error {
+		sessions[sm.GetInt64(ctx, "userID")]++
+		return nil
+	}); err != nil {
+		t.Fatalf("could not list sessions: %v", err)
+	}
+	if sessions[1] != 1 || sessions[2] != 1 {
+		t.Fatalf("sessions: want one each for alice and bob, got %v", sessions)
+	}
+}
+
+func TestPasswordResetHost(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name     string
+		baseURL  string
+		wantSent bool
+	}{
+		{name: "links are built on the configured url", baseURL: "https://blog.example.com", wantSent: true},
+		{name: "no configured url sends nothing", baseURL: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := newFakeStore()
+			db.users = []*storage.User{{ID: 1, Username: "alice", PasswordHash: "old hash", Email: new("alice@example.com")}}
+			h := newTestHandler(db, fakeS3{})
+			h.BaseURL = tt.baseURL
+			h.TrustedProxy = true
+
+			form := url.Values{"username": {"alice"}}
+			req := httptest.NewRequest(http.MethodPost, "/forgot-password", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Host = "evil.example"
+			req.Header.Set("X-Forwarded-Proto", "https")
+			if rec := serve(h, h.HandleForgotPassword(), req, 0); rec.Code != http.StatusOK {
+				t.Fatalf("status: want %d, got %d", http.StatusOK, rec.Code)
+			}
+
+			sent := h.Mail.(*fakeMailer).sent
+			if got := len(sent) != 0; got != tt.wantSent {
+				t.Fatalf("reset email: want sent %v, got %v (%d emails)", tt.wantSent, got, len(sent))
+			}
+			for _, m := range sent {
+				if strings.Contains(m.Text, "evil.example") || strings.Contains(m.HTML, "evil.example") {
+					t.Fatalf("reset email links to the forged host: %s", m.Text)
+				}
+			}
+		})
+	}
+}
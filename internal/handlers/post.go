@@ -2,10 +2,13 @@ package handlers
 
 import (
 	"blogengine/internal/components"
+	"blogengine/internal/encryption"
 	"blogengine/internal/storage"
+	"context"
 	"errors"
 	"io"
 	"net/http"
+	"strconv"
 
 	"github.com/a-h/templ"
 )
@@ -30,34 +33,161 @@ func (h *BlogHandler) HandlePost() http.Handler {
 			return
 		}
 
-		rc, err := h.S3.Open(ctx, post.S3Key)
+		if !h.canReadBlog(w, r, post.BlogID, post.BlogVisibility) {
+			return
+		}
+
+		// protected posts send anonymous readers to login and back here afterwards
+		if post.RequiresAuth && !h.Sessions.Manager.Exists(ctx, "userID") {
+			http.Redirect(w, r, loginURL(r.URL.RequestURI()), http.StatusSeeOther)
+			return
+		}
+
+		// encrypted posts need the passphrase once per session
+		var htmlBytes []byte
+		if post.IsEncrypted {
+			key := h.Sessions.Manager.GetBytes(ctx, postKeySessionKey(post.ID))
+			if key == nil {
+				components.PostLocked(common, post, "").Render(ctx, w)
+				return
+			}
+
+			htmlBytes, err = h.renderEncryptedPostBody(ctx, post, key)
+			if errors.Is(err, encryption.ErrDecrypt) {
+				// the post was encrypted again since it was unlocked
+				h.Sessions.Manager.Remove(ctx, postKeySessionKey(post.ID))
+				components.PostLocked(common, post, "").Render(ctx, w)
+				return
+			}
+		} else {
+			// render the fetched markdown to html
+			htmlBytes, err = h.renderPostBody(ctx, post)
+		}
 		if err != nil {
 			h.InternalError(w, r, err)
 			return
 		}
-		defer rc.Close()
 
-		contentBytes, err := io.ReadAll(rc)
+		body := templ.Raw(string(htmlBytes))
+
+		comments := h.commentSection(ctx, r, post)
+
+		tags, err := h.DB.GetTagsForPost(ctx, post.ID)
 		if err != nil {
-			h.InternalError(w, r, err)
+			h.Logger.Error("failed to fetch tags", "post_id", post.ID, "err", err)
+			tags = []string{}
+		}
+
+		components.Post(common, post, tags, body, comments).Render(ctx, w)
+	})
+}
+
+// HandleUnlockPost checks the passphrase of an encrypted post and remembers the derived key in the session
+func (h *BlogHandler) HandleUnlockPost() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleUnlockPost")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		blogSlug := r.PathValue("blog_slug")
+		postSlug := r.PathValue("post_slug")
+		redirectTo := "/blogs/" + blogSlug + "/" + postSlug
+
+		post, err := h.DB.GetPostBySlugOrPublicID(ctx, blogSlug, postSlug)
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				h.NotFound(w, r)
+			default:
+				h.InternalError(w, r, err)
+			}
 			return
 		}
 
-		// render the fetched markdown to html
-		htmlBytes, err := h.Renderer.Render(contentBytes)
+		if !h.canReadBlog(w, r, post.BlogID, post.BlogVisibility) {
+			return
+		}
+
+		if post.RequiresAuth && !h.Sessions.Manager.Exists(ctx, "userID") {
+			http.Redirect(w, r, loginURL(redirectTo), http.StatusSeeOther)
+			return
+		}
+		if !post.IsEncrypted {
+			http.Redirect(w, r, redirectTo, http.StatusSeeOther)
+			return
+		}
+
+		passphrase := r.FormValue("passphrase")
+		if passphrase == "" || len(passphrase) > maxPassphraseLen {
+			w.WriteHeader(http.StatusUnauthorized)
+			components.PostLocked(common, post, "Wrong passphrase").Render(ctx, w)
+			return
+		}
+
+		ciphertext, err := h.readPostObject(ctx, post)
 		if err != nil {
 			h.InternalError(w, r, err)
 			return
 		}
 
-		body := templ.Raw(string(htmlBytes))
-
-		comments, err := h.DB.GetCommentsForPost(ctx, post.ID, 0, 100)
+		// decrypting once is the only way to know the passphrase is right
+		key, err := encryption.UnlockKey(ciphertext, passphrase)
+		if err == nil {
+			_, err = encryption.Decrypt(ciphertext, key, derefOr(post.EncryptionIV, ""), []byte(post.S3Key))
+		}
 		if err != nil {
-			h.Logger.Error("failed to fetch comments", "post_id", post.ID, "err", err)
-			comments = []*storage.Comment{}
+			if errors.Is(err, encryption.ErrDecrypt) {
+				h.Logger.Warn("wrong post passphrase", "post_id", post.ID, "ip", r.RemoteAddr)
+				w.WriteHeader(http.StatusUnauthorized)
+				components.PostLocked(common, post, "Wrong passphrase").Render(ctx, w)
+				return
+			}
+			h.InternalError(w, r, err)
+			return
 		}
 
-		components.Post(common, post, body, comments).Render(ctx, w)
+		// privilege change, same as login
+		if err := h.Sessions.Manager.RenewToken(ctx); err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		h.Sessions.Manager.Put(ctx, postKeySessionKey(post.ID), key)
+
+		http.Redirect(w, r, redirectTo, http.StatusSeeOther)
 	})
 }
+
+const maxPassphraseLen = 1024
+
+func postKeySessionKey(postID int64) string {
+	return "post_key:" + strconv.FormatInt(postID, 10)
+}
+
+// isPostUnlocked reports whether the session may read post, plain posts are always unlocked
+func (h *BlogHandler) isPostUnlocked(ctx context.Context, post *storage.Post) bool {
+	return !post.IsEncrypted || h.Sessions.Manager.Exists(ctx, postKeySessionKey(post.ID))
+}
+
+func (h *BlogHandler) readPostObject(ctx context.Context, p *storage.Post) ([]byte, error) {
+	rc, err := h.S3.Open(ctx, p.S3Key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+func (h *BlogHandler) renderEncryptedPostBody(ctx context.Context, p *storage.Post, key []byte) ([]byte, error) {
+	ciphertext, err := h.readPostObject(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	contentBytes, err := encryption.Decrypt(ciphertext, key, derefOr(p.EncryptionIV, ""), []byte(p.S3Key))
+	if err != nil {
+		return nil, err
+	}
+
+	return h.Renderer.Render(contentBytes)
+}
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"blogengine/internal/content"
+	"blogengine/internal/encryption"
+	"blogengine/internal/storage"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testPost(slug string, mutate func(p *storage.Post)) *storage.Post {
+	p := &storage.Post{
+		ID:             1,
+		BlogID:         1,
+		BlogSlug:       "a-blog-slug",
+		BlogVisibility: storage.VisibilityPublic,
+		AuthorName:     "admin",
+		PublicID:       "abcdefghijkl",
+		Slug:           new(slug),
+		Title:          "Title of the post",
+		S3Key:          "a-blog-slug/" + slug,
+		IsListed:       true,
+		AllowComments:  true,
+		PublishedAt:    new(time.Now().Add(-time.Hour)),
+	}
+	if mutate != nil {
+		mutate(p)
+	}
+	return p
+}
+
+func TestHandlePost(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name              string
+		post              *storage.Post
+		target            string
+		userID            int64
+		members           []*storage.BlogMember
+		wantStatus        int
+		wantLocation      string
+		wantBody          string
+		wantNoCommentForm bool
+	}{
+		{
+			name:       "nominal",
+			post:       testPost("a-post-slug", nil),
+			target:     "/blogs/a-blog-slug/a-post-slug",
+			userID:     1,
+			wantStatus: http.StatusOK,
+			wantBody:   "post body",
+		},
+		{
+			name:       "unlisted post is served by direct link",
+			post:       testPost("a-post-slug", func(p *storage.Post) { p.IsListed = false }),
+			target:     "/blogs/a-blog-slug/a-post-slug",
+			wantStatus: http.StatusOK,
+			wantBody:   "post body",
+		},
+		{
+			name:         "requires auth redirects anonymous readers to login",
+			post:         testPost("a-post-slug", func(p *storage.Post) { p.RequiresAuth = true }),
+			target:       "/blogs/a-blog-slug/a-post-slug",
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/login?next=%2Fblogs%2Fa-blog-slug%2Fa-post-slug",
+		},
+		{
+			name:       "requires auth is served to logged in readers",
+			post:       testPost("a-post-slug", func(p *storage.Post) { p.RequiresAuth = true }),
+			target:     "/blogs/a-blog-slug/a-post-slug",
+			userID:     1,
+			wantStatus: http.StatusOK,
+			wantBody:   "post body",
+		},
+		{
+			name:              "comments disabled hides the form",
+			post:              testPost("a-post-slug", func(p *storage.Post) { p.AllowComments = false }),
+			target:            "/blogs/a-blog-slug/a-post-slug",
+			userID:            1,
+			wantStatus:        http.StatusOK,
+			wantBody:          "Comments are closed",
+			wantNoCommentForm: true,
+		},
+		{
+			name:         "private blog redirects anonymous readers to login",
+			post:         testPost("a-post-slug", func(p *storage.Post) { p.BlogVisibility = storage.VisibilityPrivate }),
+			target:       "/blogs/a-blog-slug/a-post-slug",
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/login?next=%2Fblogs%2Fa-blog-slug%2Fa-post-slug",
+		},
+		{
+			name:       "private blog forbids non members",
+			post:       testPost("a-post-slug", func(p *storage.Post) { p.BlogVisibility = storage.VisibilityPrivate }),
+			target:     "/blogs/a-blog-slug/a-post-slug",
+			userID:     2,
+			members:    []*storage.BlogMember{{BlogID: 1, UserID: 1, Role: storage.RoleCommenter}},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "private blog is served to members",
+			post:       testPost("a-post-slug", func(p *storage.Post) { p.BlogVisibility = storage.VisibilityPrivate }),
+			target:     "/blogs/a-blog-slug/a-post-slug",
+			userID:     1,
+			members:    []*storage.BlogMember{{BlogID: 1, UserID: 1, Role: storage.RoleCommenter}},
+			wantStatus: http.StatusOK,
+			wantBody:   "post body",
+		},
+		{
+			name:       "unknown post",
+			post:       testPost("a-post-slug", nil),
+			target:     "/blogs/a-blog-slug/another-post",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			s3 := fakeS3{tt.post.S3Key: []byte("# Title\n\npost body")}
+			db := newFakeStore(tt.post)
+			db.members = tt.members
+			h := newTestHandler(db, s3)
+
+			mux := http.NewServeMux()
+			mux.Handle("GET /blogs/{blog_slug}/{post_slug}", h.HandlePost())
+
+			rec := serve(h, mux, httptest.NewRequest(http.MethodGet, tt.target, nil), tt.userID)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status: want %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if loc := rec.Header().Get("Location"); loc != tt.wantLocation {
+				t.Fatalf("location: want %q, got %q", tt.wantLocation, loc)
+			}
+			body := rec.Body.String()
+			if !strings.Contains(body, tt.wantBody) {
+				t.Fatalf("body does not contain %q", tt.wantBody)
+			}
+			if tt.wantNoCommentForm && strings.Contains(body, `name="content"`) {
+				t.Fatal("comment form rendered for a post with comments disabled")
+			}
+		})
+	}
+}
+
+func TestHandleUnlockPost(t *testing.T) {
+	t.Parallel()
+
+	iv, err := encryption.NewIV()
+	if err != nil {
+		t.Fatalf("could not create iv: %s", err)
+	}
+	post := testPost("a-post-slug", func(p *storage.Post) {
+		p.IsEncrypted = true
+		p.EncryptionIV = &iv
+	})
+	ciphertext, err := encryption.Encrypt([]byte("# Title\n\nsecret body"), "correct horse", iv, []byte(post.S3Key))
+	if err != nil {
+		t.Fatalf("could not encrypt: %s", err)
+	}
+
+	h := newTestHandler(newFakeStore(post), fakeS3{post.S3Key: ciphertext})
+	mux := http.NewServeMux()
+	mux.Handle("GET /blogs/{blog_slug}/{post_slug}", h.HandlePost())
+	mux.Handle("POST /blogs/{blog_slug}/{post_slug}/unlock", h.HandleUnlockPost())
+
+	unlock := func(passphrase string) *httptest.ResponseRecorder {
+		form := url.Values{"passphrase": {passphrase}}
+		req := httptest.NewRequest(http.MethodPost, "/blogs/a-blog-slug/a-post-slug/unlock", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return serve(h, mux, req, 0)
+	}
+
+	// locked until the passphrase is given
+	rec := serve(h, mux, httptest.NewRequest(http.MethodGet, "/blogs/a-blog-slug/a-post-slug", nil), 0)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("locked status: want %d, got %d", http.StatusOK, rec.Code)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, `name="passphrase"`) || strings.Contains(body, "secret body") {
+		t.Fatal("locked post must show the unlock form and not the content")
+	}
+
+	rec = unlock("battery staple")
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("wrong passphrase status: want %d, got %d", http.StatusUnauthorized, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "Wrong passphrase") {
+		t.Fatal("wrong passphrase message not rendered")
+	}
+
+	rec = unlock("correct horse")
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("unlock status: want %d, got %d", http.StatusSeeOther, rec.Code)
+	}
+	if loc := rec.Header().Get("Location"); loc != "/blogs/a-blog-slug/a-post-slug" {
+		t.Fatalf("unlock location: want %q, got %q", "/blogs/a-blog-slug/a-post-slug", loc)
+	}
+
+	// the unlocked session reads the post
+	req := httptest.NewRequest(http.MethodGet, "/blogs/a-blog-slug/a-post-slug", nil)
+	for _, c := range rec.Result().Cookies() {
+		req.AddCookie(c)
+	}
+	rec = serve(h, mux, req, 0)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unlocked status: want %d, got %d", http.StatusOK, rec.Code)
+	}
+	if !strings.Contains(rec.Body.String(), "secret body") {
+		t.Fatal("unlocked post content not rendered")
+	}
+}
+
+// countingS3 counts the objects read, to tell cached renders from fresh ones
+type countingS3 struct {
+	fakeS3
+	opens atomic.Int32
+}
+
+func (c *countingS3) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	c.opens.Add(1)
+	return c.fakeS3.Open(ctx, key)
+}
+
+func TestPostCache(t *testing.T) {
+	t.Parallel()
+
+	post := testPost("a-post-slug", nil)
+	db := newFakeStore(post)
+	db.blogs = []*storage.Blog{{ID: 1, OwnerID: 1, Slug: "a-blog-slug", Title: "A blog title"}}
+	db.members = []*storage.BlogMember{{BlogID: 1, UserID: 1, Role: storage.RoleOwner}}
+	s3 := &countingS3{fakeS3: fakeS3{post.S3Key: []byte("# Title\n\npost body")}}
+	h := newTestHandler(db, s3)
+	h.PostCache = content.NewRenderCache(1 << 20)
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /blogs/{blog_slug}/{post_slug}", h.HandlePost())
+	mux.Handle("POST /dashboard/posts/{post_id}", h.HandleUpdatePost())
+
+	read := func(wantBody string, wantOpens int32) {
+		t.Helper()
+		rec := serve(h, mux, httptest.NewRequest(http.MethodGet, "/blogs/a-blog-slug/a-post-slug", nil), 0)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status: want %d, got %d", http.StatusOK, rec.Code)
+		}
+		if !strings.Contains(rec.Body.String(), wantBody) {
+			t.Fatalf("body does not contain %q", wantBody)
+		}
+		if n := s3.opens.Load(); n != wantOpens {
+			t.Fatalf("objects read: want %d, got %d", wantOpens, n)
+		}
+	}
+
+	read("post body", 1)
+	read("post body", 1)
+
+	form := url.Values{"title": {"Title of the post"}, "slug": {"a-post-slug"}, "body": {"edited body"}}
+	req := httptest.NewRequest(http.MethodPost, "/dashboard/posts/1", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if rec := serve(h, mux, req, 1); rec.Code != http.StatusSeeOther {
+		t.Fatalf("edit status: want %d, got %d", http.StatusSeeOther, rec.Code)
+	}
+	read("edited body", 2)
+	read("edited body", 2)
+
+	// re-seeding writes the bucket behind the handlers' back, the new version is enough
+	s3.fakeS3[post.S3Key] = []byte("# Title\n\nseeded body")
+	read("seeded body", 3)
+}
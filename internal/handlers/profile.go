@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"blogengine/internal/components"
+	"blogengine/internal/content"
+	"blogengine/internal/identicon"
+	"blogengine/internal/storage"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gofrs/uuid/v5"
+
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+const (
+	profileBlogsLimit   = 20
+	profileCommentsSize = 10
+	maxProfilePage      = 50
+
+	avatarWidth    = 256     // pixels, the processor scales uploads down to it
+	maxAvatarBytes = 5 << 20 // of the upload
+	maxAvatarSide  = 4096    // pixels, bigger images take too much memory to scale
+	avatarMaxAge   = 86400   // seconds, avatar urls change with the upload
+
+	// MaxAvatarRequestBytes caps the whole upload request, the image and the rest of its form, see middleware.MaxBody
+	MaxAvatarRequestBytes = maxAvatarBytes + 64<<10
+)
+
+// avatarTypes maps the images the processor can decode to the extension their upload is kept with
+var avatarTypes = map[string]string{"image/png": ".png", "image/jpeg": ".jpg", "image/gif": ".gif"}
+
+// HandleProfile shows the public profile of a user: their public blogs and a page of the comments anyone can read
+func (h *BlogHandler) HandleProfile() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleProfile")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		// deleted users are not found either
+		user, err := h.DB.GetUserByUsername(ctx, r.PathValue("username"))
+		if err != nil {
+			h.dashboardError(w, r, err)
+			return
+		}
+
+		blogs, err := h.DB.GetBlogsByUserID(ctx, user.ID, 0, profileBlogsLimit)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		page := components.ProfilePage{User: user, Page: 1, Blogs: make([]*storage.Blog, 0, len(blogs))}
+		for _, b := range blogs {
+			if b.Visibility == storage.VisibilityPublic {
+				page.Blogs = append(page.Blogs, b)
+			}
+		}
+
+		if n, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && n > 1 {
+			page.Page = min(n, maxProfilePage)
+		}
+		// one extra row tells whether there is a next page
+		offset := int64((page.Page - 1) * profileCommentsSize)
+		comments, err := h.DB.GetCommentsForUserID(ctx, user.ID, offset, profileCommentsSize+1)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		if len(comments) > profileCommentsSize {
+			comments = comments[:profileCommentsSize]
+			page.HasNext = true
+		}
+		page.Comments = comments
+
+		components.Profile(common, page).Render(ctx, w)
+	})
+}
+
+// HandleAvatar serves the avatar of a user: the scaled upload once the processor made it, the upload as is until
+// then, and an identicon of the username when there is none
+func (h *BlogHandler) HandleAvatar() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleAvatar")
+		defer span.End()
+
+		user, err := h.DB.GetUserByUsername(ctx, r.PathValue("username"))
+		if err != nil {
+			h.dashboardError(w, r, err)
+			return
+		}
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", avatarMaxAge))
+
+		if user.AvatarKey == nil {
+			w.Header().Set("Content-Type", "image/svg+xml")
+			w.Write(identicon.SVG(user.Username))
+			return
+		}
+
+		job := avatarJob(*user.AvatarKey)
+		key, contentType := fmt.Sprintf("%s_%d.webp", job.ID, job.Width), "image/webp"
+		if !h.S3.Exists(ctx, key) {
+			// the processor may have lost the job on a restart, asking again is a no-op otherwise
+			job.ParentSpan = span.SpanContext()
+			h.resizeAvatar(r, job)
+			key, contentType = *user.AvatarKey, mime.TypeByExtension(path.Ext(*user.AvatarKey))
+		}
+
+		reader, err := h.S3.Open(ctx, key)
+		if err != nil {
+			h.Logger.Error("could not open avatar", "key", key, "err", err)
+			h.NotFound(w, r)
+			return
+		}
+		defer reader.Close()
+
+		w.Header().Set("Content-Type", contentType)
+		if _, err := io.Copy(w, reader); err != nil {
+			h.Logger.Warn("avatar stream interrupted", "err", err)
+		}
+	})
+}
+
+// HandleUpdateProfile saves the display name and bio of the logged in user
+func (h *BlogHandler) HandleUpdateProfile() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleUpdateProfile")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		displayName := strings.TrimSpace(r.FormValue("display_name"))
+		bio := strings.TrimSpace(r.FormValue("bio"))
+		if err := h.DB.UpdateUserProfile(ctx, userID, displayName, bio); err != nil {
+			var invalid *storage.ValidationError
+			if errors.As(err, &invalid) {
+				page := components.AccountPage{Errors: map[string]string{invalid.Field: invalid.Error()}}
+				h.renderAccountPage(w, r, common, userID, http.StatusUnprocessableEntity, page)
+				return
+			}
+			h.dashboardError(w, r, err)
+			return
+		}
+
+		h.Sessions.Manager.Put(ctx, "notice", "Profile saved.")
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+	})
+}
+
+// HandleUploadAvatar stores an uploaded image in the bucket as the avatar of the logged in user and has the processor
+// scale it down
+func (h *BlogHandler) HandleUploadAvatar() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleUploadAvatar")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		avatarError := func(status int, msg string) {
+			page := components.AccountPage{Errors: map[string]string{"avatar": msg}}
+			h.renderAccountPage(w, r, common, userID, status, page)
+		}
+
+		file, header, err := r.FormFile("avatar")
+		if err != nil {
+			avatarError(http.StatusUnprocessableEntity, "Choose an image to upload.")
+			return
+		}
+		defer file.Close()
+		if header.Size > maxAvatarBytes {
+			avatarError(http.StatusRequestEntityTooLarge, "Avatars can be 5 MB at most.")
+			return
+		}
+
+		// what the file is, not what the browser says it is
+		head := make([]byte, 512)
+		n, _ := io.ReadFull(file, head)
+		ext, known := avatarTypes[http.DetectContentType(head[:n])]
+		if !known {
+			avatarError(http.StatusUnsupportedMediaType, "Upload a PNG, JPEG or GIF image.")
+			return
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		config, _, err := image.DecodeConfig(file)
+		if err != nil || config.Width > maxAvatarSide || config.Height > maxAvatarSide {
+			avatarError(http.StatusUnprocessableEntity, fmt.Sprintf("Upload an image of at most %d pixels a side.", maxAvatarSide))
+			return
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		id, err := uuid.NewV4()
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		key := "avatars/" + id.String() + ext
+		if err := h.S3.Save(ctx, key, file); err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		if err := h.DB.SetUserAvatar(ctx, userID, key); err != nil {
+			h.dashboardError(w, r, err)
+			return
+		}
+
+		job := avatarJob(key)
+		job.ParentSpan = span.SpanContext()
+		h.resizeAvatar(r, job)
+
+		h.Logger.Info("avatar uploaded", "user_id", userID, "key", key)
+		h.Sessions.Manager.Put(ctx, "notice", "Avatar updated.")
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+	})
+}
+
+// HandleRemoveAvatar goes back to the identicon, the upload stays in the bucket
+func (h *BlogHandler) HandleRemoveAvatar() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleRemoveAvatar")
+		defer span.End()
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		if err := h.DB.SetUserAvatar(ctx, userID, ""); err != nil {
+			h.dashboardError(w, r, err)
+			return
+		}
+
+		h.Sessions.Manager.Put(ctx, "notice", "Avatar removed.")
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+	})
+}
+
+// avatarJob is the processor job scaling the upload at key, the variant lands next to it as <key without ext>_<width>.webp
+func avatarJob(key string) content.ImageJob {
+	return content.ImageJob{SourcePath: key, ID: strings.TrimSuffix(key, path.Ext(key)), Width: avatarWidth}
+}
+
+// resizeAvatar queues job, avatars are served as uploaded until it is done
+func (h *BlogHandler) resizeAvatar(r *http.Request, job content.ImageJob) {
+	if h.Images == nil {
+		return
+	}
+	if err := h.Images.Enqueue(r.Context(), job); err != nil {
+		h.Logger.Warn("could not queue avatar", "key", job.SourcePath, "err", err)
+	}
+}
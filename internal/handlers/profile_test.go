@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"blogengine/internal/storage"
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProfile(t *testing.T) {
+	t.Parallel()
+
+	db := newFakeStore()
+	db.users = []*storage.User{
+		{ID: 1, Username: "bob", CreatedAt: time.Now()},
+		{ID: 2, Username: "carol", DeletedAt: new(time.Now())},
+	}
+	db.blogs = []*storage.Blog{
+		{ID: 1, OwnerID: 1, Slug: "bobs-public-blog", Title: "Bob in public", Visibility: storage.VisibilityPublic},
+		{ID: 2, OwnerID: 1, Slug: "bobs-private-blog", Title: "Bob in private", Visibility: storage.VisibilityPrivate},
+	}
+	for i := range profileCommentsSize + 1 {
+		db.comments = append(db.comments, &storage.Comment{ID: int64(i + 1), UserID: new(int64(1)), Content: "comment " + string(rune('a'+i)), Status: storage.CommentApproved})
+	}
+	h := newTestHandler(db, fakeS3{})
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /users/{username}", h.HandleProfile())
+
+	rec := serve(h, mux, httptest.NewRequest(http.MethodGet, "/users/bob", nil), 0)
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(body, "Bob in public") || strings.Contains(body, "Bob in private") {
+		t.Fatalf("profile: want the public blog only, got %d %s", rec.Code, body)
+	}
+	if !strings.Contains(body, "comment k") || strings.Contains(body, "comment a<") || !strings.Contains(body, `href="/users/bob?page=2"`) {
+		t.Fatalf("profile: want the latest comments and a link to the older ones, got %s", body)
+	}
+	if rec = serve(h, mux, httptest.NewRequest(http.MethodGet, "/users/bob?page=2", nil), 0); !strings.Contains(rec.Body.String(), "comment a<") {
+		t.Fatalf("second page: want the oldest comment, got %s", rec.Body)
+	}
+
+	for _, username := range []string{"carol", "nobody"} {
+		if rec := serve(h, mux, httptest.NewRequest(http.MethodGet, "/users/"+username, nil), 0); rec.Code != http.StatusNotFound {
+			t.Fatalf("profile of %s: want %d, got %d", username, http.StatusNotFound, rec.Code)
+		}
+	}
+
+	if rec := serve(h, h.HandleUpdateProfile(), postForm("/account/profile", url.Values{"display_name": {strings.Repeat("b", 51)}}), 1); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("long display name: want %d, got %d", http.StatusUnprocessableEntity, rec.Code)
+	}
+	if rec := serve(h, h.HandleUpdateProfile(), postForm("/account/profile", url.Values{"display_name": {" Bob B. "}, "bio": {"Writes <b>things</b>."}}), 1); rec.Code != http.StatusSeeOther {
+		t.Fatalf("update profile: want %d, got %d", http.StatusSeeOther, rec.Code)
+	}
+	body = serve(h, mux, httptest.NewRequest(http.MethodGet, "/users/bob", nil), 0).Body.String()
+	if !strings.Contains(body, "<h1>Bob B.</h1>") || !strings.Contains(body, "Writes &lt;b&gt;things&lt;/b&gt;.") {
+		t.Fatalf("profile: want the display name and the escaped bio, got %s", body)
+	}
+}
+
+func TestAvatar(t *testing.T) {
+	t.Parallel()
+
+	db := newFakeStore()
+	db.users = []*storage.User{{ID: 1, Username: "bob"}}
+	s3 := fakeS3{}
+	images := &fakeImages{}
+	h := newTestHandler(db, s3)
+	h.Images = images
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /users/{username}/avatar", h.HandleAvatar())
+	avatar := func() *httptest.ResponseRecorder {
+		return serve(h, mux, httptest.NewRequest(http.MethodGet, "/users/bob/avatar", nil), 0)
+	}
+	upload := func(data []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, _ := form.CreateFormFile("avatar", "me.png")
+		part.Write(data)
+		form.Close()
+		req := httptest.NewRequest(http.MethodPost, "/account/avatar", &body)
+		req.Header.Set("Content-Type", form.FormDataContentType())
+		return serve(h, h.HandleUploadAvatar(), req, 1)
+	}
+
+	if rec := avatar(); rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Fatalf("no upload: want an identicon, got %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	if rec := upload([]byte("GIF89a is what it says, but it is text")); rec.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("broken image: want %d, got %d", http.StatusUnprocessableEntity, rec.Code)
+	}
+	if rec := upload([]byte("just some text")); rec.Code != http.StatusUnsupportedMediaType {
+		t.Fatalf("text: want %d, got %d", http.StatusUnsupportedMediaType, rec.Code)
+	}
+	if len(s3) != 0 || db.users[0].AvatarKey != nil {
+		t.Fatalf("refused uploads: want nothing stored, got %d objects", len(s3))
+	}
+
+	var img bytes.Buffer
+	png.Encode(&img, image.NewGray(image.Rect(0, 0, 300, 200)))
+	if rec := upload(img.Bytes()); rec.Code != http.StatusSeeOther {
+		t.Fatalf("upload: want %d, got %d %s", http.StatusSeeOther, rec.Code, rec.Body)
+	}
+	key := db.users[0].AvatarKey
+	if key == nil || !strings.HasPrefix(*key, "avatars/") || !strings.HasSuffix(*key, ".png") || !bytes.Equal(s3[*key], img.Bytes()) {
+		t.Fatalf("upload: want the image stored under avatars/, got %v", key)
+	}
+	if len(images.jobs) != 1 || images.jobs[0].SourcePath != *key || images.jobs[0].Width != avatarWidth {
+		t.Fatalf("upload: want a job scaling it to %d, got %+v", avatarWidth, images.jobs)
+	}
+
+	// the upload is served until the processor is done with it
+	if rec := avatar(); rec.Header().Get("Content-Type") != "image/png" || !bytes.Equal(rec.Body.Bytes(), img.Bytes()) {
+		t.Fatalf("before scaling: want the upload, got %s", rec.Header().Get("Content-Type"))
+	}
+	s3[strings.TrimSuffix(*key, ".png")+"_256.webp"] = []byte("webp")
+	if rec := avatar(); rec.Header().Get("Content-Type") != "image/webp" || rec.Body.String() != "webp" {
+		t.Fatalf("after scaling: want the webp, got %s", rec.Header().Get("Content-Type"))
+	}
+
+	if rec := serve(h, h.HandleRemoveAvatar(), postForm("/account/avatar/remove", nil), 1); rec.Code != http.StatusSeeOther {
+		t.Fatalf("remove: want %d, got %d", http.StatusSeeOther, rec.Code)
+	}
+	if rec := avatar(); rec.Header().Get("Content-Type") != "image/svg+xml" {
+		t.Fatalf("removed: want the identicon again, got %s", rec.Header().Get("Content-Type"))
+	}
+}
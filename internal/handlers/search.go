@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"blogengine/internal/components"
+	"blogengine/internal/storage"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	searchPageSize    = 20
+	maxSearchPage     = 50
+	maxSearchQueryLen = 200
+)
+
+// HandleSearch searches posts across all public blogs
+func (h *BlogHandler) HandleSearch() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleSearch")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		page := searchPageFromRequest(r)
+		page.Action = "/search"
+
+		if err := h.runSearch(ctx, 0, &page); err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		components.Search(common, nil, page).Render(ctx, w)
+	})
+}
+
+// HandleBlogSearch searches posts of a single blog
+func (h *BlogHandler) HandleBlogSearch() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleBlogSearch")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		blog, err := h.DB.GetBlogBySlug(ctx, r.PathValue("blog_slug"))
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				h.NotFound(w, r)
+			default:
+				h.InternalError(w, r, err)
+			}
+			return
+		}
+
+		if !h.canReadBlog(w, r, blog.ID, blog.Visibility) {
+			return
+		}
+
+		page := searchPageFromRequest(r)
+		page.Action = "/blogs/" + blog.Slug + "/search"
+
+		if err := h.runSearch(ctx, blog.ID, &page); err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		common.FeedURL = "/blogs/" + blog.Slug + "/feed.xml"
+
+		components.Search(common, blog, page).Render(ctx, w)
+	})
+}
+
+// runSearch fills in the results, fetching one extra row to know whether there is a next page
+func (h *BlogHandler) runSearch(ctx context.Context, blogID int64, page *components.SearchPage) error {
+	if page.Query == "" {
+		return nil
+	}
+
+	offset := int64((page.Page - 1) * searchPageSize)
+	results, err := h.DB.SearchPosts(ctx, page.Query, blogID, offset, searchPageSize+1)
+	if err != nil {
+		return err
+	}
+
+	if len(results) > searchPageSize {
+		results = results[:searchPageSize]
+		page.HasNext = true
+	}
+	page.Results = results
+	return nil
+}
+
+func searchPageFromRequest(r *http.Request) components.SearchPage {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if runes := []rune(query); len(runes) > maxSearchQueryLen {
+		query = string(runes[:maxSearchQueryLen])
+	}
+
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	page = min(page, maxSearchPage)
+
+	return components.SearchPage{
+		Query: query,
+		Page:  page,
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSearchPageFromRequest(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		target    string
+		wantQuery string
+		wantPage  int
+	}{
+		{
+			name:      "nominal",
+			target:    "/search?q=sqlite&page=2",
+			wantQuery: "sqlite", wantPage: 2,
+		},
+		{
+			name:      "trimmed query, default page",
+			target:    "/search?q=++go+",
+			wantQuery: "go", wantPage: 1,
+		},
+		{
+			name:      "invalid page",
+			target:    "/search?q=go&page=-3",
+			wantQuery: "go", wantPage: 1,
+		},
+		{
+			name:      "overflowing page",
+			target:    "/search?q=go&page=99999999999999999999",
+			wantQuery: "go", wantPage: 1,
+		},
+		{
+			name:      "page above max",
+			target:    "/search?q=go&page=1000",
+			wantQuery: "go", wantPage: maxSearchPage,
+		},
+		{
+			name:      "long query is truncated on runes",
+			target:    "/search?q=" + strings.Repeat("é", maxSearchQueryLen+5),
+			wantQuery: strings.Repeat("é", maxSearchQueryLen), wantPage: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := searchPageFromRequest(httptest.NewRequest("GET", tt.target, nil))
+			if got.Query != tt.wantQuery {
+				t.Fatalf("query: want %q, got %q", tt.wantQuery, got.Query)
+			}
+			if got.Page != tt.wantPage {
+				t.Fatalf("page: want %d, got %d", tt.wantPage, got.Page)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"blogengine/internal/components"
+	"blogengine/internal/storage"
+	"errors"
+	"net/http"
+)
+
+const tagPageSize = 20
+
+// HandleTag lists posts with a tag across all public blogs
+func (h *BlogHandler) HandleTag() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleTag")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		tag := r.PathValue("tag")
+		posts, err := h.DB.GetPostsByTag(ctx, tag, 0, 0, tagPageSize)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		if len(posts) == 0 {
+			h.NotFound(w, r)
+			return
+		}
+
+		components.TagPage(common, nil, tag, posts).Render(ctx, w)
+	})
+}
+
+// HandleBlogTag lists posts with a tag in a single blog
+func (h *BlogHandler) HandleBlogTag() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleBlogTag")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		blog, err := h.DB.GetBlogBySlug(ctx, r.PathValue("blog_slug"))
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrNotFound):
+				h.NotFound(w, r)
+			default:
+				h.InternalError(w, r, err)
+			}
+			return
+		}
+
+		if !h.canReadBlog(w, r, blog.ID, blog.Visibility) {
+			return
+		}
+
+		tag := r.PathValue("tag")
+		posts, err := h.DB.GetPostsByTag(ctx, tag, blog.ID, 0, tagPageSize)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		if len(posts) == 0 {
+			h.NotFound(w, r)
+			return
+		}
+
+		common.FeedURL = "/blogs/" + blog.Slug + "/feed.xml"
+
+		components.TagPage(common, blog, tag, posts).Render(ctx, w)
+	})
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"blogengine/internal/components"
+	"blogengine/internal/storage"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiTokenPrefix makes leaked tokens easy to spot in logs and by secret scanners
+const apiTokenPrefix = "be_"
+
+// HandleTokensPage lists the api tokens of the logged in user
+func (h *BlogHandler) HandleTokensPage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleTokensPage")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		page := components.TokensPage{Scope: storage.ScopeRead, ExpiresIn: "90"}
+		if err := h.loadTokens(r, userID, &page); err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		components.Tokens(common, page).Render(ctx, w)
+	})
+}
+
+// HandleCreateToken creates an api token and shows it once, only its hash is stored
+func (h *BlogHandler) HandleCreateToken() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleCreateToken")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		page := components.TokensPage{
+			Name:      strings.TrimSpace(r.FormValue("name")),
+			Scope:     storage.TokenScope(r.FormValue("scope")),
+			ExpiresIn: r.FormValue("expires_in"),
+		}
+
+		params := storage.CreateAPITokenParams{UserID: userID, Name: page.Name, Scope: page.Scope}
+		if page.ExpiresIn != "" {
+			days, err := strconv.Atoi(page.ExpiresIn)
+			if err != nil || days < 1 || days > 365 {
+				page.Errors = map[string]string{"expires_in": "pick an expiry from the list"}
+				h.renderTokensPage(w, r, common, userID, page)
+				return
+			}
+			params.ExpiresAt = new(time.Now().AddDate(0, 0, days))
+		}
+
+		token, err := newToken()
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		token = apiTokenPrefix + token
+		params.TokenHash = hashToken(token)
+
+		created, err := h.DB.CreateAPIToken(ctx, params)
+		if err != nil {
+			var invalid *storage.ValidationError
+			if !errors.As(err, &invalid) {
+				h.InternalError(w, r, err)
+				return
+			}
+			page.Errors = map[string]string{invalid.Field: invalid.Error()}
+			h.renderTokensPage(w, r, common, userID, page)
+			return
+		}
+
+		h.Logger.Info("api token created", "user_id", userID, "token_id", created.ID, "scope", created.Scope)
+
+		page = components.TokensPage{Scope: storage.ScopeRead, ExpiresIn: "90", NewToken: token}
+		if err := h.loadTokens(r, userID, &page); err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		// the plaintext token must not linger in a cache
+		w.Header().Set("Cache-Control", "no-store")
+		components.Tokens(common, page).Render(ctx, w)
+	})
+}
+
+func (h *BlogHandler) HandleRevokeToken() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleRevokeToken")
+		defer span.End()
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		tokenID, err := strconv.ParseInt(r.PathValue("token_id"), 10, 64)
+		if err != nil || tokenID < 1 {
+			h.NotFound(w, r)
+			return
+		}
+
+		if err := h.DB.RevokeAPIToken(ctx, tokenID, userID); err != nil {
+			h.dashboardError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("api token revoked", "user_id", userID, "token_id", tokenID)
+		http.Redirect(w, r, "/dashboard/tokens", http.StatusSeeOther)
+	})
+}
+
+func (h *BlogHandler) loadTokens(r *http.Request, userID int64, page *components.TokensPage) error {
+	tokens, err := h.DB.GetAPITokensByUserID(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+	page.Tokens = tokens
+	return nil
+}
+
+func (h *BlogHandler) renderTokensPage(w http.ResponseWriter, r *http.Request, common components.CommonData, userID int64, page components.TokensPage) {
+	if err := h.loadTokens(r, userID, &page); err != nil {
+		h.InternalError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	components.Tokens(common, page).Render(r.Context(), w)
+}
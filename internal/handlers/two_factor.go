@@ -0,0 +1,368 @@
+package handlers
+
+import (
+	"blogengine/internal/components"
+	"blogengine/internal/encryption"
+	"blogengine/internal/storage"
+	"blogengine/internal/totp"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// twoFactorLoginLifetime is how long the code can be entered once the password checked out
+	twoFactorLoginLifetime = 5 * time.Minute
+	// twoFactorMaxAttempts is how many wrong codes send the user back to the password, the auth limiter slows them
+	// down on top. Wrong codes also count towards the lockout of the username, which outlasts the session
+	twoFactorMaxAttempts = 5
+	// recoveryCodeCount is how many recovery codes an enrolment hands out
+	recoveryCodeCount = 10
+)
+
+// HandleTwoFactorPage asks for the authenticator code of a login whose password checked out
+func (h *BlogHandler) HandleTwoFactorPage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// user already logged in, send home
+		if h.Sessions.Manager.Exists(r.Context(), "userID") {
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		if _, ok := h.pendingTwoFactor(r.Context()); !ok {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		common := h.newCommonData(r)
+		components.TwoFactorLogin(common, "").Render(r.Context(), w)
+	})
+}
+
+// HandleTwoFactor finishes a login with a code of the user's authenticator or one of their recovery codes. Only then
+// does userID go into the session. A locked username gets no code checked, a fresh password login can't reset that
+func (h *BlogHandler) HandleTwoFactor() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleTwoFactor")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.pendingTwoFactor(ctx)
+		if !ok {
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		attempts := h.Sessions.Manager.GetInt(ctx, "2fa_attempts") + 1
+		if attempts > twoFactorMaxAttempts {
+			h.clearTwoFactor(ctx)
+			h.Logger.Warn("too many two-factor attempts", "user_id", userID)
+			h.RenderError(w, r, http.StatusTooManyRequests, "Too many attempts", "Log in again with your password.")
+			return
+		}
+		h.Sessions.Manager.Put(ctx, "2fa_attempts", attempts)
+
+		user, err := h.DB.GetUserByID(ctx, userID)
+		if err != nil || !user.HasTOTP() {
+			// deleted or turned two-factor off in the meantime, start over
+			h.clearTwoFactor(ctx)
+			http.Redirect(w, r, "/login", http.StatusSeeOther)
+			return
+		}
+
+		blocked, err := h.loginBlocked(ctx, user.Username)
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		if blocked {
+			h.clearTwoFactor(ctx)
+			h.Logger.Warn("two-factor code for a locked username", "user_id", user.ID)
+			h.Audit.Record(r, storage.AuditLoginFailed, user.ID, map[string]any{"method": "two-factor", "blocked": true})
+			h.RenderError(w, r, http.StatusTooManyRequests, "Too many attempts", "Log in again later.")
+			return
+		}
+
+		method, ok, err := h.checkSecondFactor(ctx, user, r.FormValue("code"))
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		if !ok {
+			if _, err := h.DB.RecordLoginFailure(ctx, user.Username, h.LoginPolicy); err != nil {
+				h.InternalError(w, r, err)
+				return
+			}
+			h.Logger.Info("wrong two-factor code", "user_id", user.ID, "attempt", attempts)
+			h.Audit.Record(r, storage.AuditLoginFailed, user.ID, map[string]any{"method": "two-factor", "attempt": attempts})
+			w.WriteHeader(http.StatusUnauthorized)
+			components.TwoFactorLogin(common, "Invalid code.").Render(ctx, w)
+			return
+		}
+
+		next := safeRedirectPath(h.Sessions.Manager.GetString(ctx, "2fa_next"))
+		h.clearTwoFactor(ctx)
+		if err := h.login(r, user, "two-factor "+method); err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("user logged in", "id", user.ID, "username", user.Username, "second_factor", method)
+		http.Redirect(w, r, next, http.StatusSeeOther)
+	})
+}
+
+// startTwoFactor holds a login whose password checked out until the user enters a code, the session only knows who
+// is trying until then. The token is renewed as for a full login
+func (h *BlogHandler) startTwoFactor(w http.ResponseWriter, r *http.Request, user *storage.User, next string) {
+	ctx := r.Context()
+	if err := h.Sessions.Manager.RenewToken(ctx); err != nil {
+		h.InternalError(w, r, err)
+		return
+	}
+
+	h.Sessions.Manager.Put(ctx, "2fa_user_id", user.ID)
+	h.Sessions.Manager.Put(ctx, "2fa_at", time.Now().Unix())
+	h.Sessions.Manager.Put(ctx, "2fa_next", next)
+	h.Sessions.Manager.Put(ctx, "2fa_attempts", 0)
+
+	h.Logger.Info("password checked, waiting for two-factor", "user_id", user.ID)
+	http.Redirect(w, r, "/login/2fa", http.StatusSeeOther)
+}
+
+// pendingTwoFactor returns the user of a login waiting for its code, false once the wait is over
+func (h *BlogHandler) pendingTwoFactor(ctx context.Context) (int64, bool) {
+	userID := h.Sessions.Manager.GetInt64(ctx, "2fa_user_id")
+	if userID == 0 {
+		return 0, false
+	}
+	if started := time.Unix(h.Sessions.Manager.GetInt64(ctx, "2fa_at"), 0); time.Since(started) > twoFactorLoginLifetime {
+		h.clearTwoFactor(ctx)
+		return 0, false
+	}
+	return userID, true
+}
+
+func (h *BlogHandler) clearTwoFactor(ctx context.Context) {
+	for _, key := range []string{"2fa_user_id", "2fa_at", "2fa_next", "2fa_attempts"} {
+		h.Sessions.Manager.Remove(ctx, key)
+	}
+}
+
+// checkSecondFactor checks code as a code of the user's authenticator when it looks like one, as a recovery code
+// otherwise, and uses it up. It reports which one matched
+func (h *BlogHandler) checkSecondFactor(ctx context.Context, user *storage.User, code string) (string, bool, error) {
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return "", false, nil
+	}
+
+	// recovery codes are longer than the codes of authenticators
+	if len(strings.ReplaceAll(code, " ", "")) == totp.Digits {
+		secret, err := encryption.Open(user.TOTPSecret, h.TOTPKey, totpAdditionalData(user.ID))
+		if err != nil {
+			// a missing or changed key, recovery codes still get the user in
+			h.Logger.Error("could not open totp secret", "user_id", user.ID, "err", err)
+			return "", false, nil
+		}
+		step, ok := totp.Validate(secret, code, time.Now())
+		if !ok {
+			return "", false, nil
+		}
+		if err := h.DB.UseTOTPStep(ctx, user.ID, step); err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				h.Logger.Warn("two-factor code replayed", "user_id", user.ID)
+				return "", false, nil
+			}
+			return "", false, err
+		}
+		return "totp", true, nil
+	}
+
+	if err := h.DB.UseRecoveryCode(ctx, user.ID, hashToken(normaliseRecoveryCode(code))); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return "", false, nil
+		}
+		return "", false, err
+	}
+	return "recovery_code", true, nil
+}
+
+// HandleTwoFactorSetupPage shows a new authenticator secret as a QR code. The secret waits in the session until a
+// code of it comes back, reloading the page keeps it so an app that scanned it already stays in sync
+func (h *BlogHandler) HandleTwoFactorSetupPage() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleTwoFactorSetupPage")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		user, ok := h.twoFactorSetupUser(w, r)
+		if !ok {
+			return
+		}
+
+		secret := h.Sessions.Manager.GetString(ctx, "totp_pending")
+		if secret == "" {
+			raw, err := totp.NewSecret()
+			if err != nil {
+				h.InternalError(w, r, err)
+				return
+			}
+			secret = totp.EncodeSecret(raw)
+			h.Sessions.Manager.Put(ctx, "totp_pending", secret)
+		}
+
+		h.renderTwoFactorSetup(w, r, common, user, secret, http.StatusOK, "")
+	})
+}
+
+// HandleEnableTwoFactor turns two-factor on once a code of the pending secret checks out, and shows the recovery
+// codes the one time they exist in plain text
+func (h *BlogHandler) HandleEnableTwoFactor() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleEnableTwoFactor")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		user, ok := h.twoFactorSetupUser(w, r)
+		if !ok {
+			return
+		}
+
+		encoded := h.Sessions.Manager.GetString(ctx, "totp_pending")
+		secret, err := totp.DecodeSecret(encoded)
+		if encoded == "" || err != nil {
+			http.Redirect(w, r, "/account/totp", http.StatusSeeOther)
+			return
+		}
+
+		step, ok := totp.Validate(secret, r.FormValue("code"), time.Now())
+		if !ok {
+			h.renderTwoFactorSetup(w, r, common, user, encoded, http.StatusUnprocessableEntity, "Wrong code, check the clock of your device and try the next one.")
+			return
+		}
+
+		sealed, err := encryption.Seal(secret, h.TOTPKey, totpAdditionalData(user.ID))
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			h.InternalError(w, r, err)
+			return
+		}
+		if err := h.DB.EnableTOTP(ctx, user.ID, sealed, step, hashes); err != nil {
+			h.dashboardError(w, r, err)
+			return
+		}
+		h.Sessions.Manager.Remove(ctx, "totp_pending")
+
+		h.Logger.Info("two-factor enabled", "user_id", user.ID)
+		w.Header().Set("Cache-Control", "no-store")
+		components.RecoveryCodes(common, codes).Render(ctx, w)
+	})
+}
+
+// HandleDisableTwoFactor turns two-factor off once the password checks out, see accountUser
+func (h *BlogHandler) HandleDisableTwoFactor() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, span := h.Tracer.Start(r.Context(), "HandleDisableTwoFactor")
+		defer span.End()
+		common := h.newCommonData(r)
+
+		userID, ok := h.dashboardUser(w, r)
+		if !ok {
+			return
+		}
+
+		user, problem := h.accountUser(r, userID, r.FormValue("totp_password"))
+		if user == nil {
+			page := components.AccountPage{Errors: map[string]string{"totp_password": problem}}
+			h.renderAccountPage(w, r, common, userID, http.StatusUnprocessableEntity, page)
+			return
+		}
+
+		if err := h.DB.DisableTOTP(ctx, user.ID); err != nil {
+			h.dashboardError(w, r, err)
+			return
+		}
+
+		h.Logger.Info("two-factor disabled", "user_id", user.ID)
+		h.Sessions.Manager.Put(ctx, "notice", "Two-factor turned off.")
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+	})
+}
+
+// twoFactorSetupUser loads the logged in user for enrolment, which needs a key to seal their secret and a user
+// without an authenticator yet
+func (h *BlogHandler) twoFactorSetupUser(w http.ResponseWriter, r *http.Request) (*storage.User, bool) {
+	userID, ok := h.dashboardUser(w, r)
+	if !ok {
+		return nil, false
+	}
+	if h.TOTPKey == nil {
+		h.RenderError(w, r, http.StatusNotFound, "Two-factor unavailable", "Two-factor is not set up on this site.")
+		return nil, false
+	}
+
+	user, err := h.DB.GetUserByID(r.Context(), userID)
+	if err != nil {
+		h.dashboardError(w, r, err)
+		return nil, false
+	}
+	if user.HasTOTP() {
+		http.Redirect(w, r, "/account", http.StatusSeeOther)
+		return nil, false
+	}
+	return user, true
+}
+
+func (h *BlogHandler) renderTwoFactorSetup(w http.ResponseWriter, r *http.Request, common components.CommonData, user *storage.User, secret string, status int, errorMessage string) {
+	raw, err := totp.DecodeSecret(secret)
+	if err != nil {
+		h.InternalError(w, r, err)
+		return
+	}
+	qrCode, err := totp.QRCodeSVG(totp.URI(h.Title, user.Username, raw))
+	if err != nil {
+		h.InternalError(w, r, err)
+		return
+	}
+
+	// the secret is on the page, keep it out of caches
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	page := components.TwoFactorSetupPage{QRCode: qrCode, Secret: secret, Error: errorMessage}
+	components.TwoFactorSetup(common, page).Render(r.Context(), w)
+}
+
+// totpAdditionalData binds a sealed secret to its user, a secret copied onto another row won't open
+func totpAdditionalData(userID int64) []byte {
+	return []byte("totp:user:" + strconv.FormatInt(userID, 10))
+}
+
+// newRecoveryCodes returns recovery codes to show the user once and the hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+		codes[i] = code[:4] + "-" + code[4:]
+		hashes[i] = hashToken(normaliseRecoveryCode(codes[i]))
+	}
+	return codes, hashes, nil
+}
+
+// normaliseRecoveryCode lets users type recovery codes without the dash and in any case
+func normaliseRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
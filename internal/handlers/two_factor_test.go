@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"blogengine/internal/encryption"
+	"blogengine/internal/storage"
+	"blogengine/internal/totp"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testTOTPKey = []byte("0123456789abcdef0123456789abcdef")
+
+// serveWith runs req in the session of cookies, then returns the cookies the session continues with
+func serveWith(h *BlogHandler, handler http.Handler, req *http.Request, cookies []*http.Cookie) (*httptest.ResponseRecorder, []*http.Cookie) {
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	rec := serve(h, handler, req, 0)
+	if set := rec.Result().Cookies(); len(set) > 0 {
+		cookies = set
+	}
+	return rec, cookies
+}
+
+func postForm(target string, form url.Values) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req
+}
+
+// loggedIn counts the sessions of userID
+func loggedIn(t *testing.T, h *BlogHandler, userID int64) int {
+	t.Helper()
+	sessions := 0
+	sm := h.Sessions.Manager
+	if err := sm.Iterate(context.Background(), func(ctx context.Context) error {
+		if sm.GetInt64(ctx, "userID") == userID {
+			sessions++
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("could not list sessions: %v", err)
+	}
+	return sessions
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("the password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("could not hash password: %v", err)
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatalf("could not make secret: %v", err)
+	}
+	sealed, err := encryption.Seal(secret, testTOTPKey, totpAdditionalData(1))
+	if err != nil {
+		t.Fatalf("could not seal secret: %v", err)
+	}
+	now := totp.Step(time.Now())
+
+	tests := []struct {
+		name         string
+		codes        []string // entered one after the other, the last one is checked
+		lastStep     int64
+		wantStatus   int
+		wantLocation string
+		wantLoggedIn bool
+	}{
+		{
+			name:         "authenticator code",
+			codes:        []string{totp.Code(secret, now)},
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/dashboard",
+			wantLoggedIn: true,
+		},
+		{
+			name:       "codes work once",
+			codes:      []string{totp.Code(secret, now)},
+			lastStep:   now,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "wrong code",
+			codes:      []string{"000000"},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:         "recovery code without the dash",
+			codes:        []string{"ABCDEFGH"},
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/dashboard",
+			wantLoggedIn: true,
+		},
+		{
+			name:         "a wrong code can be followed by a right one",
+			codes:        []string{"123456", "abcd-efgh"},
+			wantStatus:   http.StatusSeeOther,
+			wantLocation: "/dashboard",
+			wantLoggedIn: true,
+		},
+		{
+			name:       "too many attempts go back to the password",
+			codes:      []string{"000000", "000000", "000000", "000000", "000000", "abcd-efgh"},
+			wantStatus: http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			db := newFakeStore()
+			db.users = []*storage.User{{
+				ID: 1, Username: "admin", PasswordHash: string(hash),
+				TOTPSecret: sealed, TOTPEnabledAt: new(time.Now()), TOTPLastStep: tt.lastStep,
+			}}
+			db.recovery = map[string]int64{hashToken("abcdefgh"): 1}
+			h := newTestHandler(db, fakeS3{})
+			h.TOTPKey = testTOTPKey
+
+			// the password alone only gets as far as the second step
+			form := url.Values{"username": {"admin"}, "password": {"the password"}, "next": {"/dashboard"}}
+			rec, cookies := serveWith(h, h.HandleLogin(), postForm("/login", form), nil)
+			if loc := rec.Header().Get("Location"); loc != "/login/2fa" {
+				t.Fatalf("login: want a redirect to /login/2fa, got %d %q", rec.Code, loc)
+			}
+			if n := loggedIn(t, h, 1); n != 0 {
+				t.Fatalf("sessions after the password: want 0, got %d", n)
+			}
+
+			for _, code := range tt.codes {
+				rec, cookies = serveWith(h, h.HandleTwoFactor(), postForm("/login/2fa", url.Values{"code": {code}}), cookies)
+			}
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status: want %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if loc := rec.Header().Get("Location"); loc != tt.wantLocation {
+				t.Fatalf("location: want %q, got %q", tt.wantLocation, loc)
+			}
+			if n := loggedIn(t, h, 1); (n == 1) != tt.wantLoggedIn {
+				t.Fatalf("logged in: want %v, got %d sessions", tt.wantLoggedIn, n)
+			}
+		})
+	}
+}
+
+func TestTwoFactorLockout(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("the password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("could not hash password: %v", err)
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatalf("could not make secret: %v", err)
+	}
+	sealed, err := encryption.Seal(secret, testTOTPKey, totpAdditionalData(1))
+	if err != nil {
+		t.Fatalf("could not seal secret: %v", err)
+	}
+
+	db := newFakeStore()
+	db.users = []*storage.User{{
+		ID: 1, Username: "admin", PasswordHash: string(hash), TOTPSecret: sealed, TOTPEnabledAt: new(time.Now()),
+	}}
+	h := newTestHandler(db, fakeS3{})
+	h.TOTPKey = testTOTPKey
+	h.LoginPolicy = storage.LoginPolicy{FreeAttempts: 2, LockAfter: 3, Lockout: time.Hour}
+
+	// every attempt starts over from a fresh session, as one from another address would
+	password := func() []*http.Cookie {
+		t.Helper()
+		form := url.Values{"username": {"admin"}, "password": {"the password"}}
+		rec, cookies := serveWith(h, h.HandleLogin(), postForm("/login", form), nil)
+		if loc := rec.Header().Get("Location"); loc != "/login/2fa" {
+			t.Fatalf("login: want a redirect to /login/2fa, got %d %q", rec.Code, loc)
+		}
+		return cookies
+	}
+	code := func(cookies []*http.Cookie, code string) *httptest.ResponseRecorder {
+		rec, _ := serveWith(h, h.HandleTwoFactor(), postForm("/login/2fa", url.Values{"code": {code}}), cookies)
+		return rec
+	}
+
+	for range 2 {
+		if rec := code(password(), "000000"); rec.Code != http.StatusUnauthorized {
+			t.Fatalf("wrong code: want %d, got %d", http.StatusUnauthorized, rec.Code)
+		}
+	}
+	cookies := password()
+	code(cookies, "000000")
+	if db.logins["admin"].Failures != 3 {
+		t.Fatalf("failures: want 3 wrong codes counted, got %+v", db.logins["admin"])
+	}
+
+	// locked, the right code is refused and the password no longer gets to the second step
+	if rec := code(cookies, totp.Code(secret, totp.Step(time.Now()))); rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("right code once locked: want %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	form := url.Values{"username": {"admin"}, "password": {"the password"}}
+	if rec := serve(h, h.HandleLogin(), postForm("/login", form), 0); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("password once locked: want %d, got %d %q", http.StatusUnauthorized, rec.Code, rec.Header().Get("Location"))
+	}
+	if n := loggedIn(t, h, 1); n != 0 {
+		t.Fatalf("sessions once locked: want 0, got %d", n)
+	}
+}
+
+func TestTwoFactorSetup(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("the password"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("could not hash password: %v", err)
+	}
+	db := newFakeStore()
+	db.users = []*storage.User{{ID: 1, Username: "admin", PasswordHash: string(hash)}}
+	h := newTestHandler(db, fakeS3{})
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /account", h.HandleAccountPage())
+	mux.Handle("GET /account/totp", h.HandleTwoFactorSetupPage())
+	mux.Handle("POST /account/totp", h.HandleEnableTwoFactor())
+	mux.Handle("POST /account/totp/disable", h.HandleDisableTwoFactor())
+
+	// without a key there is nothing to seal secrets with
+	if rec := serve(h, mux, httptest.NewRequest(http.MethodGet, "/account/totp", nil), 1); rec.Code != http.StatusNotFound {
+		t.Fatalf("setup without a key: want %d, got %d", http.StatusNotFound, rec.Code)
+	}
+	h.TOTPKey = testTOTPKey
+
+	// the session is the one of a logged in user from here on
+	login := httptest.NewRequest(http.MethodPost, "/login", nil)
+	_, cookies := serveWith(h, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.Sessions.Login(r, 1, "admin")
+	}), login, nil)
+
+	rec, cookies := serveWith(h, mux, httptest.NewRequest(http.MethodGet, "/account/totp", nil), cookies)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "<svg") {
+		t.Fatalf("setup page: want a QR code, got %d", rec.Code)
+	}
+	match := regexp.MustCompile(`readonly value="([A-Z2-7]+)"`).FindStringSubmatch(rec.Body.String())
+	if match == nil {
+		t.Fatal("setup page shows no secret")
+	}
+	secret, err := totp.DecodeSecret(match[1])
+	if err != nil {
+		t.Fatalf("could not decode secret: %v", err)
+	}
+
+	rec, cookies = serveWith(h, mux, postForm("/account/totp", url.Values{"code": {"000000"}}), cookies)
+	if rec.Code != http.StatusUnprocessableEntity || db.users[0].HasTOTP() {
+		t.Fatalf("wrong code: want %d and two-factor off, got %d", http.StatusUnprocessableEntity, rec.Code)
+	}
+
+	rec, cookies = serveWith(h, mux, postForm("/account/totp", url.Values{"code": {totp.Code(secret, totp.Step(time.Now()))}}), cookies)
+	if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Fatalf("enrolment: want %d and no-store, got %d %q", http.StatusOK, rec.Code, rec.Header().Get("Cache-Control"))
+	}
+	codes := regexp.MustCompile(`<li>([a-z2-7]{4}-[a-z2-7]{4})</li>`).FindAllStringSubmatch(rec.Body.String(), -1)
+	if len(codes) != recoveryCodeCount || len(db.recovery) != recoveryCodeCount {
+		t.Fatalf("recovery codes: want %d shown and stored, got %d and %d", recoveryCodeCount, len(codes), len(db.recovery))
+	}
+	if _, ok := db.recovery[hashToken(normaliseRecoveryCode(codes[0][1]))]; !ok {
+		t.Fatal("shown recovery code is not stored")
+	}
+	stored, err := encryption.Open(db.users[0].TOTPSecret, testTOTPKey, totpAdditionalData(1))
+	if err != nil || string(stored) != string(secret) {
+		t.Fatalf("stored secret: want the enrolled one sealed, got %v", err)
+	}
+
+	rec, cookies = serveWith(h, mux, httptest.NewRequest(http.MethodGet, "/account", nil), cookies)
+	if !strings.Contains(rec.Body.String(), "10 recovery codes left") {
+		t.Fatal("account page does not show two-factor on")
+	}
+
+	rec, cookies = serveWith(h, mux, postForm("/account/totp/disable", url.Values{"totp_password": {"a guess"}}), cookies)
+	if rec.Code != http.StatusUnprocessableEntity || !db.users[0].HasTOTP() {
+		t.Fatalf("disable with a wrong password: want %d and two-factor on, got %d", http.StatusUnprocessableEntity, rec.Code)
+	}
+	rec, _ = serveWith(h, mux, postForm("/account/totp/disable", url.Values{"totp_password": {"the password"}}), cookies)
+	if rec.Code != http.StatusSeeOther || db.users[0].HasTOTP() || len(db.recovery) != 0 {
+		t.Fatalf("disable: want %d and two-factor off, got %d", http.StatusSeeOther, rec.Code)
+	}
+}
@@ -0,0 +1,52 @@
+// Package identicon draws the avatar of users who haven't uploaded one: a symmetric 5x5 pattern in a colour, both
+// taken from a hash of the seed so the same username always gets the same picture
+package identicon
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+const (
+	Size = 5 // cells per side
+
+	// the pattern is mirrored around the middle column, so only the left half and the middle come from the hash
+	halfWidth = (Size + 1) / 2
+)
+
+// Pattern reports which cells of the identicon of seed are filled, by row then column
+func Pattern(seed string) [Size][Size]bool {
+	sum := sha256.Sum256([]byte(seed))
+
+	var cells [Size][Size]bool
+	for row := range Size {
+		for col := range halfWidth {
+			filled := sum[row*halfWidth+col]&1 == 1
+			cells[row][col] = filled
+			cells[row][Size-1-col] = filled
+		}
+	}
+	return cells
+}
+
+// SVG draws the identicon of seed as a square SVG image
+func SVG(seed string) []byte {
+	sum := sha256.Sum256([]byte(seed))
+	// the last bytes are not used by the pattern, the hue comes from them and saturation and lightness stay readable
+	hue := (int(sum[30])<<8 | int(sum[31])) % 360
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="-1 -1 %d %d" shape-rendering="crispEdges">`, Size+2, Size+2)
+	fmt.Fprintf(&b, `<rect x="-1" y="-1" width="%d" height="%d" fill="hsl(%d, 25%%, 92%%)"/>`, Size+2, Size+2, hue)
+	fmt.Fprintf(&b, `<g fill="hsl(%d, 55%%, 50%%)">`, hue)
+	for row, cells := range Pattern(seed) {
+		for col, filled := range cells {
+			if filled {
+				fmt.Fprintf(&b, `<rect x="%d" y="%d" width="1" height="1"/>`, col, row)
+			}
+		}
+	}
+	b.WriteString(`</g></svg>`)
+	return b.Bytes()
+}
@@ -0,0 +1,55 @@
+package identicon
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+)
+
+func TestPattern(t *testing.T) {
+	t.Parallel()
+
+	alice := Pattern("alice")
+	if alice != Pattern("alice") {
+		t.Fatalf("same seed: want the same pattern")
+	}
+	if alice == Pattern("bob") {
+		t.Fatalf("other seed: want another pattern")
+	}
+	for row, cells := range alice {
+		for col := range Size {
+			if cells[col] != cells[Size-1-col] {
+				t.Fatalf("row %d: want it mirrored, got %v", row, cells)
+			}
+		}
+	}
+}
+
+func TestSVG(t *testing.T) {
+	t.Parallel()
+
+	svg := SVG("alice")
+	if !bytes.Equal(svg, SVG("alice")) {
+		t.Fatalf("same seed: want the same image")
+	}
+
+	var doc struct {
+		XMLName xml.Name
+		Rects   []struct{} `xml:"g>rect"`
+	}
+	if err := xml.Unmarshal(svg, &doc); err != nil || doc.XMLName.Local != "svg" {
+		t.Fatalf("want an svg document, got %s (%v)", svg, err)
+	}
+
+	filled := 0
+	for _, cells := range Pattern("alice") {
+		for _, f := range cells {
+			if f {
+				filled++
+			}
+		}
+	}
+	if len(doc.Rects) != filled {
+		t.Fatalf("cells: want %d, got %d", filled, len(doc.Rects))
+	}
+}
@@ -0,0 +1,46 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileSender writes every message to an .eml file of Dir, to open in a mail client during development
+type FileSender struct {
+	Dir  string
+	From string
+}
+
+func (f *FileSender) Send(_ context.Context, m Message) error {
+	now := time.Now()
+	msg, err := m.Bytes(f.From, now)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(f.Dir, 0o750); err != nil {
+		return fmt.Errorf("could not create mail dir: %w", err)
+	}
+	name := filepath.Join(f.Dir, fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000"), randomHex(4)))
+	return os.WriteFile(name, msg, 0o640)
+}
+
+// ConsoleSender prints the text of every message, for development without a mail server
+type ConsoleSender struct {
+	Out io.Writer
+
+	mu sync.Mutex
+}
+
+func (c *ConsoleSender) Send(_ context.Context, m Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := fmt.Fprintf(c.Out, "--- email to %s ---\nSubject: %s\n\n%s\n--- end of email ---\n", m.To, m.Subject, m.Text)
+	return err
+}
@@ -0,0 +1,74 @@
+package mail
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/a-h/templ"
+)
+
+// Welcome greets a new user who gave an email address when registering
+type Welcome struct {
+	SiteName string
+	Username string
+	LoginURL string
+}
+
+func (w Welcome) Subject() string {
+	return "Welcome to " + w.SiteName
+}
+
+func (w Welcome) Text() string {
+	return fmt.Sprintf(`Hi %s,
+
+Welcome to %s! Your account is ready, you can log in at %s
+
+You get this email because this address was given when the account was created.
+`, w.Username, w.SiteName, w.LoginURL)
+}
+
+func (w Welcome) HTML() templ.Component {
+	return welcomeHTML(w)
+}
+
+// PasswordReset carries the link to set a new password, sent on request from the forgot password page
+type PasswordReset struct {
+	SiteName string
+	Username string
+	ResetURL string
+	ValidFor time.Duration
+}
+
+func (p PasswordReset) Subject() string {
+	return "Reset your " + p.SiteName + " password"
+}
+
+func (p PasswordReset) Text() string {
+	return fmt.Sprintf(`Hi %s,
+
+Someone asked to reset the password of your %s account. To choose a new one, open this link within %s:
+
+%s
+
+The link works once. If you didn't ask for it, ignore this email and your password stays as it is.
+`, p.Username, p.SiteName, p.validFor(), p.ResetURL)
+}
+
+func (p PasswordReset) HTML() templ.Component {
+	return passwordResetHTML(p)
+}
+
+// validFor spells out how long the link works, in hours when it's a whole number of them
+func (p PasswordReset) validFor() string {
+	if p.ValidFor%time.Hour == 0 {
+		return plural(int64(p.ValidFor/time.Hour), "hour")
+	}
+	return plural(int64(p.ValidFor.Round(time.Minute)/time.Minute), "minute")
+}
+
+func plural(n int64, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
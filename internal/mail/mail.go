@@ -0,0 +1,153 @@
+// Package mail renders the emails the engine sends and delivers them through a persistent outbox, over SMTP in
+// production or to files and the console in development
+package mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/a-h/templ"
+)
+
+// Message is a rendered email ready for a transport
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string // optional alternative to Text
+}
+
+// Sender is a mail transport
+type Sender interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// Template is an email the engine knows how to write. Every email has a plain text body, the html one is optional
+type Template interface {
+	Subject() string
+	Text() string
+	HTML() templ.Component // nil for text only emails
+}
+
+// Mailer queues an email to a recipient, handlers depend on this rather than on the outbox
+type Mailer interface {
+	Send(ctx context.Context, to string, t Template) error
+}
+
+// ErrPermanent marks delivery failures that retrying won't fix, such as a rejected recipient
+var ErrPermanent = errors.New("permanent delivery failure")
+
+// IsAddress accepts bare addresses like name@example.com, the only kind users may give
+func IsAddress(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email && len(email) <= 254
+}
+
+// Render writes a template for a recipient
+func Render(ctx context.Context, to string, t Template) (Message, error) {
+	m := Message{To: to, Subject: t.Subject(), Text: t.Text()}
+
+	if html := t.HTML(); html != nil {
+		var buf bytes.Buffer
+		if err := html.Render(ctx, &buf); err != nil {
+			return Message{}, fmt.Errorf("could not render %q: %w", m.Subject, err)
+		}
+		m.HTML = buf.String()
+	}
+	return m, nil
+}
+
+// Bytes is the message as an RFC 5322 email from the given address, a multipart/alternative one when it has an
+// html body
+func (m Message) Bytes(from string, date time.Time) ([]byte, error) {
+	sender, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid from address %q: %w", from, err)
+	}
+	if strings.ContainsAny(m.To, "\r\n") {
+		return nil, fmt.Errorf("%w: invalid recipient %q", ErrPermanent, m.To)
+	}
+
+	var buf bytes.Buffer
+	header := func(key, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", key, value)
+	}
+	header("From", sender.String())
+	header("To", m.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("Message-ID", messageID(sender.Address))
+	header("MIME-Version", "1.0")
+
+	if m.HTML == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		buf.WriteString("\r\n")
+		if err := writeQuotedPrintable(&buf, m.Text); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	parts := multipart.NewWriter(&buf)
+	header("Content-Type", fmt.Sprintf("multipart/alternative; boundary=%q", parts.Boundary()))
+	buf.WriteString("\r\n")
+
+	// the last part is the preferred one
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(w, part.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, body string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(body)); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+// messageID is a unique Message-ID on the domain of the sender
+func messageID(from string) string {
+	domain := "localhost"
+	if at := strings.LastIndexByte(from, '@'); at >= 0 {
+		domain = from[at+1:]
+	}
+
+	return fmt.Sprintf("<%s@%s>", randomHex(16), domain)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b) // never fails, see crypto/rand
+	return hex.EncodeToString(b)
+}
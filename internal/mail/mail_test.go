@@ -0,0 +1,166 @@
+package mail
+
+import (
+	"blogengine/internal/storage"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessageBytes(t *testing.T) {
+	t.Parallel()
+
+	welcome := Welcome{SiteName: "A blog", Username: "reader", LoginURL: "https://blog.example.com/login"}
+	m, err := Render(context.Background(), "reader@example.com", welcome)
+	if err != nil {
+		t.Fatalf("could not render: %v", err)
+	}
+
+	raw, err := m.Bytes("A blog <noreply@blog.example.com>", time.Now())
+	if err != nil {
+		t.Fatalf("could not build message: %v", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("not a valid email: %v", err)
+	}
+
+	if subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject")); subject != "Welcome to A blog" {
+		t.Fatalf("subject: want %q, got %q", "Welcome to A blog", subject)
+	}
+	if id := msg.Header.Get("Message-ID"); !strings.HasSuffix(id, "@blog.example.com>") {
+		t.Fatalf("message id: want one on the sender domain, got %q", id)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("content type: want multipart/alternative, got %q (%v)", mediaType, err)
+	}
+	var types []string
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart() // decodes quoted-printable
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("could not read part: %v", err)
+		}
+		body, _ := io.ReadAll(part)
+		if !strings.Contains(string(body), "https://blog.example.com/login") {
+			t.Fatalf("%s part has no login link", part.Header.Get("Content-Type"))
+		}
+		types = append(types, part.Header.Get("Content-Type"))
+	}
+	if len(types) != 2 || !strings.HasPrefix(types[0], "text/plain") || !strings.HasPrefix(types[1], "text/html") {
+		t.Fatalf("parts: want text then html, got %v", types)
+	}
+
+	if _, err := (Message{To: "a@example.com\r\nBcc: b@example.com", Subject: "hi", Text: "hi"}).Bytes("noreply@example.com", time.Now()); !errors.Is(err, ErrPermanent) {
+		t.Fatalf("header injection: want %v, got %v", ErrPermanent, err)
+	}
+}
+
+func TestFileSender(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "mail")
+	f := &FileSender{Dir: dir, From: "noreply@example.com"}
+	if err := f.Send(context.Background(), Message{To: "reader@example.com", Subject: "hi", Text: "hello"}); err != nil {
+		t.Fatalf("could not send: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("files: want 1, got %d", len(files))
+	}
+	if raw, _ := os.ReadFile(files[0]); !bytes.Contains(raw, []byte("To: reader@example.com")) {
+		t.Fatalf("file has no recipient: %s", raw)
+	}
+}
+
+// fakeOutboxStore is the outbox table of the store
+type fakeOutboxStore struct {
+	storage.Store
+	due     []*storage.OutboxEmail
+	sent    []int64
+	failed  []int64
+	retries map[int64]time.Duration
+}
+
+func (f *fakeOutboxStore) ClaimDueEmails(_ context.Context, limit int64, _ time.Duration) ([]*storage.OutboxEmail, error) {
+	n := min(int(limit), len(f.due))
+	claimed := f.due[:n]
+	f.due = f.due[n:]
+	for _, e := range claimed {
+		e.Attempts++
+	}
+	return claimed, nil
+}
+
+func (f *fakeOutboxStore) MarkEmailSent(_ context.Context, emailID int64) error {
+	f.sent = append(f.sent, emailID)
+	return nil
+}
+
+func (f *fakeOutboxStore) RetryEmail(_ context.Context, emailID int64, _ string, after time.Duration) error {
+	f.retries[emailID] = after
+	return nil
+}
+
+func (f *fakeOutboxStore) MarkEmailFailed(_ context.Context, emailID int64, _ string) error {
+	f.failed = append(f.failed, emailID)
+	return nil
+}
+
+// fakeSender fails for the recipients it has an error for
+type fakeSender map[string]error
+
+func (f fakeSender) Send(_ context.Context, m Message) error {
+	return f[m.To]
+}
+
+func TestOutboxFlush(t *testing.T) {
+	t.Parallel()
+
+	db := &fakeOutboxStore{retries: make(map[int64]time.Duration)}
+	for i, attempts := range []int64{0, 0, 2, 0, 7} {
+		db.due = append(db.due, &storage.OutboxEmail{ID: int64(i + 1), Recipient: fmt.Sprintf("r%d@example.com", i+1), Attempts: attempts})
+	}
+	sender := fakeSender{
+		"r2@example.com": errors.New("connection refused"),
+		"r3@example.com": errors.New("connection refused"),
+		"r4@example.com": fmt.Errorf("%w: no such user", ErrPermanent),
+		"r5@example.com": errors.New("connection refused"),
+	}
+
+	o := NewOutbox(db, sender, slog.New(slog.DiscardHandler))
+	o.BatchSize = 2 // takes several batches
+	o.Flush(context.Background())
+
+	if len(db.sent) != 1 || db.sent[0] != 1 {
+		t.Fatalf("sent: want [1], got %v", db.sent)
+	}
+	// the permanent failure and the one out of attempts are given up on
+	if len(db.failed) != 2 || db.failed[0] != 4 || db.failed[1] != 5 {
+		t.Fatalf("failed: want [4 5], got %v", db.failed)
+	}
+	// backoff doubles with every attempt
+	if db.retries[2] != time.Minute || db.retries[3] != 4*time.Minute {
+		t.Fatalf("retries: want 1m and 4m, got %v", db.retries)
+	}
+	if o.backoff(20) != o.MaxBackoff {
+		t.Fatalf("backoff: want it capped at %s, got %s", o.MaxBackoff, o.backoff(20))
+	}
+}
@@ -0,0 +1,133 @@
+package mail
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+)
+
+// Outbox queues emails in the database for a worker to deliver, retrying failures with exponential backoff. Queued
+// emails survive restarts and the ones a dead worker had claimed are picked up again once their lease is over
+type Outbox struct {
+	db     storage.Store
+	sender Sender
+	logger *slog.Logger
+	wake   chan struct{}
+
+	Interval    time.Duration // how often the worker looks for due emails when nothing was queued
+	BatchSize   int64
+	Lease       time.Duration // how long a claimed email is left to its worker, longer than any send takes
+	MaxAttempts int64
+	BaseBackoff time.Duration // wait after the first failure, doubled after every other one
+	MaxBackoff  time.Duration
+}
+
+func NewOutbox(db storage.Store, sender Sender, logger *slog.Logger) *Outbox {
+	return &Outbox{
+		db:          db,
+		sender:      sender,
+		logger:      logger,
+		wake:        make(chan struct{}, 1),
+		Interval:    30 * time.Second,
+		BatchSize:   20,
+		Lease:       5 * time.Minute,
+		MaxAttempts: 8,
+		BaseBackoff: time.Minute,
+		MaxBackoff:  6 * time.Hour,
+	}
+}
+
+// Send renders t for to and queues it, the worker delivers it shortly after
+func (o *Outbox) Send(ctx context.Context, to string, t Template) error {
+	m, err := Render(ctx, to, t)
+	if err != nil {
+		return err
+	}
+
+	email, err := o.db.EnqueueEmail(ctx, storage.EnqueueEmailParams{Recipient: m.To, Subject: m.Subject, TextBody: m.Text, HTMLBody: m.HTML})
+	if err != nil {
+		return err
+	}
+	o.logger.Info("email queued", "email_id", email.ID, "subject", email.Subject)
+
+	select {
+	case o.wake <- struct{}{}:
+	default: // the worker is already due to look
+	}
+	return nil
+}
+
+// Run delivers due emails until ctx is done
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(o.Interval)
+	defer ticker.Stop()
+
+	for {
+		o.Flush(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// Flush delivers the emails due now, batch after batch
+func (o *Outbox) Flush(ctx context.Context) {
+	for ctx.Err() == nil {
+		emails, err := o.db.ClaimDueEmails(ctx, o.BatchSize, o.Lease)
+		if err != nil {
+			o.logger.Error("could not claim emails", "err", err)
+			return
+		}
+
+		for _, email := range emails {
+			o.deliver(ctx, email)
+		}
+		if int64(len(emails)) < o.BatchSize {
+			return
+		}
+	}
+}
+
+func (o *Outbox) deliver(ctx context.Context, email *storage.OutboxEmail) {
+	logger := o.logger.With("email_id", email.ID, "attempt", email.Attempts)
+
+	err := o.sender.Send(ctx, Message{To: email.Recipient, Subject: email.Subject, Text: email.TextBody, HTML: email.HTMLBody})
+	if err == nil {
+		if err := o.db.MarkEmailSent(ctx, email.ID); err != nil {
+			// the lease runs out and the email goes again, better twice than never
+			logger.Error("email sent but not marked", "err", err)
+			return
+		}
+		logger.Info("email sent")
+		return
+	}
+
+	if errors.Is(err, ErrPermanent) || email.Attempts >= o.MaxAttempts {
+		logger.Error("giving up on email", "err", err)
+		if err := o.db.MarkEmailFailed(ctx, email.ID, err.Error()); err != nil {
+			logger.Error("could not mark email failed", "err", err)
+		}
+		return
+	}
+
+	after := o.backoff(email.Attempts)
+	logger.Warn("email not sent, retrying later", "err", err, "retry_in", after)
+	if err := o.db.RetryEmail(ctx, email.ID, err.Error(), after); err != nil {
+		logger.Error("could not reschedule email", "err", err)
+	}
+}
+
+// backoff is how long to wait after a failed attempt
+func (o *Outbox) backoff(attempts int64) time.Duration {
+	after := o.BaseBackoff
+	for i := int64(1); i < attempts && after < o.MaxBackoff; i++ {
+		after *= 2
+	}
+	return min(after, o.MaxBackoff)
+}
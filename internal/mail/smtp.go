@@ -0,0 +1,115 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+// TLS modes of the SMTP transport
+const (
+	TLSStartTLS = "starttls" // upgrade a plain connection, usually on port 587
+	TLSImplicit = "tls"      // connect over TLS, usually on port 465
+	TLSNone     = "none"     // local relays only, credentials are never sent in the clear
+)
+
+// SMTPSender delivers messages to an SMTP server, one connection per message
+type SMTPSender struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	TLS      string
+	Timeout  time.Duration
+}
+
+func (s *SMTPSender) Send(ctx context.Context, m Message) error {
+	msg, err := m.Bytes(s.From, time.Now())
+	if err != nil {
+		return err
+	}
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return err
+	}
+
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("could not connect to %s: %w", s.Host, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("could not start smtp session: %w", err)
+	}
+	defer c.Close()
+
+	if s.TLS == TLSStartTLS {
+		if err := c.StartTLS(&tls.Config{ServerName: s.Host}); err != nil {
+			return fmt.Errorf("could not start tls: %w", err)
+		}
+	}
+	if s.Username != "" {
+		// PlainAuth refuses to send credentials without tls unless the server is local
+		if err := c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return classify(fmt.Errorf("could not authenticate: %w", err))
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return classify(fmt.Errorf("sender refused: %w", err))
+	}
+	if err := c.Rcpt(m.To); err != nil {
+		return classify(fmt.Errorf("recipient refused: %w", err))
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return classify(err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return classify(fmt.Errorf("message refused: %w", err))
+	}
+
+	return c.Quit()
+}
+
+func (s *SMTPSender) dial(ctx context.Context) (net.Conn, error) {
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	if s.TLS == TLSImplicit {
+		d := tls.Dialer{Config: &tls.Config{ServerName: s.Host}}
+		return d.DialContext(ctx, "tcp", addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+// classify marks 5xx replies as permanent, 4xx ones and network errors are worth retrying
+func classify(err error) error {
+	var reply *textproto.Error
+	if errors.As(err, &reply) && reply.Code >= 500 {
+		return fmt.Errorf("%w: %w", ErrPermanent, err)
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// AuditStore keeps audit events, see storage.Store
+type AuditStore interface {
+	RecordAuditEvent(ctx context.Context, params storage.CreateAuditEventParams) error
+}
+
+// Audit records security relevant events along with where the request came from. Recording never fails a request, an
+// event the store refuses is logged instead. A nil *Audit records nothing
+type Audit struct {
+	store    AuditStore
+	logger   *slog.Logger
+	clientIP ipClientGetter
+}
+
+func NewAudit(store AuditStore, logger *slog.Logger, trustedProxy bool) *Audit {
+	return &Audit{store: store, logger: logger, clientIP: getClientIPFactory(trustedProxy)}
+}
+
+// Record stores an event of kind about r, actorID is the account it is about or 0. The event is kept even when the
+// client has gone already
+func (a *Audit) Record(r *http.Request, kind storage.AuditKind, actorID int64, payload map[string]any) {
+	if a == nil {
+		return
+	}
+
+	params := storage.CreateAuditEventParams{
+		Kind:      kind,
+		ActorID:   actorID,
+		IP:        a.clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	if len(params.UserAgent) > maxUserAgentLength {
+		params.UserAgent = params.UserAgent[:maxUserAgentLength]
+	}
+	if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+		params.TraceID = sc.TraceID().String()
+	}
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			a.logger.Error("could not encode audit payload", "kind", kind, "err", err)
+			return
+		}
+		params.Payload = data
+	}
+
+	if err := a.store.RecordAuditEvent(context.WithoutCancel(r.Context()), params); err != nil {
+		a.logger.Error("could not record audit event", "kind", kind, "actor_id", actorID, "err", err)
+	}
+}
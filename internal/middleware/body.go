@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+)
+
+// MaxBody caps the request bodies of the routes in limits, keyed by method and path as in "POST /account/avatar",
+// before any later middleware reads them. Their multipart forms are parsed here, with files over the limit turned away
+// with a 413 instead of spilling to temporary files or failing the CSRF check further down
+func MaxBody(limits map[string]int64, renderer ErrorRenderer) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit, ok := limits[r.Method+" "+r.URL.Path]
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			tooLarge := func() {
+				renderer(w, r, http.StatusRequestEntityTooLarge, "Too large", "The upload is too large, go back and pick a smaller file.")
+			}
+			if r.ContentLength > limit {
+				tooLarge()
+				return
+			}
+
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+			if err := r.ParseMultipartForm(limit); err != nil {
+				var maxBytes *http.MaxBytesError
+				if errors.As(err, &maxBytes) {
+					tooLarge()
+					return
+				}
+				// not a multipart form or a broken one, the handler tells the user
+			}
+			if r.MultipartForm != nil {
+				// the server only cleans up the form of the request it made, not of the copies middlewares make
+				defer r.MultipartForm.RemoveAll()
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaxBody(t *testing.T) {
+	t.Parallel()
+
+	// upload is a multipart form with a file of size bytes
+	upload := func(target string, size int) (io.Reader, string) {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		form.WriteField("csrf_token", "token")
+		file, _ := form.CreateFormFile("avatar", "avatar.png")
+		file.Write(bytes.Repeat([]byte{'x'}, size))
+		form.Close()
+		return &body, form.FormDataContentType()
+	}
+	renderer := func(w http.ResponseWriter, r *http.Request, code int, title, message string) {
+		http.Error(w, message, code)
+	}
+	handler := MaxBody(map[string]int64{"POST /account/avatar": 1 << 10}, renderer)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// what a csrf check does before the handler reads the file
+			if r.FormValue("csrf_token") != "token" {
+				http.Error(w, "no token", http.StatusBadRequest)
+				return
+			}
+			file, header, err := r.FormFile("avatar")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+				return
+			}
+			defer file.Close()
+			io.WriteString(w, strings.Repeat("x", int(header.Size)))
+		}),
+	)
+
+	tests := []struct {
+		name       string
+		target     string
+		size       int
+		chunked    bool // no Content-Length, the limit is only found reading
+		wantStatus int
+	}{
+		{name: "small upload", target: "/account/avatar", size: 100, wantStatus: http.StatusOK},
+		{name: "large upload", target: "/account/avatar", size: 2 << 10, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "large upload without a length", target: "/account/avatar", size: 2 << 10, chunked: true, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "other routes", target: "/dashboard/posts", size: 2 << 10, wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			body, contentType := upload(tt.target, tt.size)
+			req := httptest.NewRequest(http.MethodPost, tt.target, body)
+			req.Header.Set("Content-Type", contentType)
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status: want %d, got %d %s", tt.wantStatus, rec.Code, rec.Body)
+			}
+			if rec.Code == http.StatusOK && rec.Body.Len() != tt.size {
+				t.Fatalf("file: want %d bytes, got %d", tt.size, rec.Body.Len())
+			}
+		})
+	}
+}
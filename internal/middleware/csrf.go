@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"blogengine/internal/storage"
 	"log/slog"
 	"net/http"
 
@@ -13,12 +14,14 @@ type ErrorRenderer func(w http.ResponseWriter, r *http.Request, code int, title,
 type CSRF struct {
 	isProd        bool
 	errorRenderer ErrorRenderer
+	audit         *Audit
 }
 
-func NewCSRF(isProd bool, renderer ErrorRenderer) *CSRF {
+func NewCSRF(isProd bool, renderer ErrorRenderer, audit *Audit) *CSRF {
 	return &CSRF{
 		isProd:        isProd,
 		errorRenderer: renderer,
+		audit:         audit,
 	}
 }
 
@@ -48,6 +51,11 @@ func (c *CSRF) Middleware(logger *slog.Logger, tracer trace.Tracer) Middleware {
 					"ip", r.RemoteAddr,
 					"reason", nosurf.Reason(r),
 				)
+				c.audit.Record(r, storage.AuditCSRFFailure, 0, map[string]any{
+					"method": r.Method,
+					"path":   r.URL.Path,
+					"reason": csrfReason(r),
+				})
 
 				msg := "This form has expired. Please go back, refresh the page, and try again."
 				c.errorRenderer(w, r, http.StatusBadRequest, "Bad Request", msg)
@@ -62,3 +70,11 @@ func (c *CSRF) Middleware(logger *slog.Logger, tracer trace.Tracer) Middleware {
 		})
 	}
 }
+
+// csrfReason is why nosurf turned r down, for the audit log
+func csrfReason(r *http.Request) string {
+	if err := nosurf.Reason(r); err != nil {
+		return err.Error()
+	}
+	return ""
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"blogengine/internal/storage"
 	"blogengine/internal/telemetry"
 	"context"
 	"errors"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.opentelemetry.io/otel/trace"
@@ -18,6 +20,7 @@ import (
 type client struct {
 	limiter  *rate.Limiter
 	lastSeen time.Time
+	limited  atomic.Bool // turned down last time, a run of rejections is audited once
 }
 
 type IPRateLimiter struct {
@@ -27,6 +30,7 @@ type IPRateLimiter struct {
 	burst        int
 	trustedProxy bool
 	Metrics      *telemetry.Metrics
+	Audit        *Audit // nil records no rejections
 }
 
 var (
@@ -76,7 +80,7 @@ func (i *IPRateLimiter) cleanup() {
 	}
 }
 
-func (i *IPRateLimiter) getLimiter(ip string) (*rate.Limiter, error) {
+func (i *IPRateLimiter) getClient(ip string) (*client, error) {
 	parsedIP := net.ParseIP(ip)
 	if parsedIP == nil {
 		return nil, ErrInvalidIP
@@ -95,11 +99,11 @@ func (i *IPRateLimiter) getLimiter(ip string) (*rate.Limiter, error) {
 			lastSeen: time.Now().UTC(),
 		}
 		i.ips[canonicalIP] = c
-		return limiter, nil
+		return c, nil
 	}
 
 	c.lastSeen = time.Now().UTC()
-	return c.limiter, nil
+	return c, nil
 }
 
 type ipClientGetter func(r *http.Request) string
@@ -122,11 +126,12 @@ func (i *IPRateLimiter) Middleware(logger *slog.Logger, tracer trace.Tracer) Mid
 			// grab the source ip address
 			ip := getClientIP(r)
 
-			limiter, err := i.getLimiter(ip)
+			c, err := i.getClient(ip)
 			if err != nil {
 				http.Error(w, "invalid ip address", http.StatusBadRequest)
 				return
 			}
+			limiter := c.limiter
 
 			if !limiter.Allow() {
 				// Peek at when next token available (without consuming)
@@ -138,6 +143,9 @@ func (i *IPRateLimiter) Middleware(logger *slog.Logger, tracer trace.Tracer) Mid
 				retrySeconds = max(1, retrySeconds)
 
 				i.Metrics.RateLimitHitsTotal.Add(r.Context(), 1)
+				if !c.limited.Swap(true) {
+					i.Audit.Record(r, storage.AuditRateLimited, 0, map[string]any{"path": r.URL.Path, "burst": i.burst})
+				}
 
 				w.Header().Set("Retry-After", strconv.Itoa(retrySeconds))
 				w.Header().Set("X-RateLimit-Limit", strconv.Itoa(i.burst))
@@ -147,6 +155,7 @@ func (i *IPRateLimiter) Middleware(logger *slog.Logger, tracer trace.Tracer) Mid
 				return
 			}
 
+			c.limited.Store(false)
 			tokens := int(limiter.Tokens()) // Current available tokens
 			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(i.burst))
 			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(tokens))
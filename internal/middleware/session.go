@@ -1,9 +1,16 @@
 package middleware
 
 import (
+	"blogengine/internal/storage"
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"log/slog"
 	"net/http"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/alexedwards/scs/sqlite3store"
@@ -13,10 +20,34 @@ import (
 )
 
 type Sessions struct {
-	Manager *scs.SessionManager
+	Manager  *scs.SessionManager
+	Users    UserStore // nil trusts the password change flag the login put in the session
+	clientIP ipClientGetter
 }
 
-func NewSessionManager(ttl time.Duration, secure bool, db *sql.DB) *Sessions {
+// UserStore looks up the account of a session, see storage.Store
+type UserStore interface {
+	GetUserByID(ctx context.Context, id int64) (*storage.User, error)
+}
+
+// SessionInfo describes a logged in session for the account page
+type SessionInfo struct {
+	ID        string // hash of the token, the token itself never leaves the cookie
+	IP        string
+	UserAgent string
+	LoginAt   time.Time // zero for sessions started before it was recorded
+	Expiry    time.Time
+	Current   bool
+}
+
+// maxUserAgentLength keeps a client sending a huge header from bloating its session
+const maxUserAgentLength = 255
+
+// PasswordChangePath is where sessions of users who must change their password are sent, until they do they can
+// only log out
+const PasswordChangePath = "/account/password"
+
+func NewSessionManager(ttl time.Duration, secure, trustedProxy bool, db *sql.DB) *Sessions {
 	sm := scs.New()
 
 	sm.Lifetime = ttl
@@ -28,7 +59,119 @@ func NewSessionManager(ttl time.Duration, secure bool, db *sql.DB) *Sessions {
 	sm.Cookie.Secure = secure
 	sm.Cookie.Persist = true // desired for a blog
 
-	return &Sessions{Manager: sm}
+	return &Sessions{Manager: sm, clientIP: getClientIPFactory(trustedProxy)}
+}
+
+// Login starts a fresh session for the user and records the device it comes from. The token is renewed so a session
+// fixed before the login is worthless
+func (s *Sessions) Login(r *http.Request, userID int64, username string) error {
+	ctx := r.Context()
+	if err := s.Manager.RenewToken(ctx); err != nil {
+		return err
+	}
+
+	clientIP := s.clientIP
+	if clientIP == nil {
+		clientIP = getDirectClientIPValidated
+	}
+	userAgent := r.UserAgent()
+	if len(userAgent) > maxUserAgentLength {
+		userAgent = userAgent[:maxUserAgentLength]
+	}
+
+	s.Manager.Put(ctx, "userID", userID)
+	s.Manager.Put(ctx, "username", username)
+	s.Manager.Put(ctx, "ip", clientIP(r))
+	s.Manager.Put(ctx, "user_agent", userAgent)
+	s.Manager.Put(ctx, "login_at", time.Now().Unix())
+	return nil
+}
+
+// RequirePasswordChange holds the session of ctx on PasswordChangePath until required is cleared again
+func (s *Sessions) RequirePasswordChange(ctx context.Context, required bool) {
+	if required {
+		s.Manager.Put(ctx, "must_change_password", true)
+		return
+	}
+	s.Manager.Remove(ctx, "must_change_password")
+}
+
+// UserSessions lists the sessions the user is logged in with, the one of ctx first and then the latest logins
+func (s *Sessions) UserSessions(ctx context.Context, userID int64) ([]SessionInfo, error) {
+	current := s.Manager.Token(ctx)
+	var sessions []SessionInfo
+
+	err := s.Manager.Iterate(ctx, func(ctx context.Context) error {
+		if s.Manager.GetInt64(ctx, "userID") != userID {
+			return nil
+		}
+
+		info := SessionInfo{
+			ID:        sessionID(s.Manager.Token(ctx)),
+			IP:        s.Manager.GetString(ctx, "ip"),
+			UserAgent: s.Manager.GetString(ctx, "user_agent"),
+			Expiry:    s.Manager.Deadline(ctx),
+			Current:   s.Manager.Token(ctx) == current,
+		}
+		if loginAt := s.Manager.GetInt64(ctx, "login_at"); loginAt > 0 {
+			info.LoginAt = time.Unix(loginAt, 0)
+		}
+		sessions = append(sessions, info)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(sessions, func(a, b SessionInfo) int {
+		if a.Current != b.Current {
+			if a.Current {
+				return -1
+			}
+			return 1
+		}
+		return b.LoginAt.Compare(a.LoginAt)
+	})
+	return sessions, nil
+}
+
+// DestroyUserSession logs the user out of the session with the given id, it reports false when the user has no such
+// session. The session of ctx is left alone, logging out is for that
+func (s *Sessions) DestroyUserSession(ctx context.Context, userID int64, id string) (bool, error) {
+	current := s.Manager.Token(ctx)
+	found := false
+
+	err := s.Manager.Iterate(ctx, func(ctx context.Context) error {
+		token := s.Manager.Token(ctx)
+		if found || token == current || sessionID(token) != id || s.Manager.GetInt64(ctx, "userID") != userID {
+			return nil
+		}
+		found = true
+		return s.Manager.Destroy(ctx)
+	})
+	return found, err
+}
+
+// DestroyOtherSessions logs the user out of every session but the one of ctx, for when their password changes. It
+// returns how many sessions were destroyed
+func (s *Sessions) DestroyOtherSessions(ctx context.Context, userID int64) (int, error) {
+	current := s.Manager.Token(ctx)
+	destroyed := 0
+
+	err := s.Manager.Iterate(ctx, func(ctx context.Context) error {
+		if s.Manager.Token(ctx) == current || s.Manager.GetInt64(ctx, "userID") != userID {
+			return nil
+		}
+		destroyed++
+		return s.Manager.Destroy(ctx)
+	})
+	return destroyed, err
+}
+
+// sessionID names a session on pages and in forms without giving its token away
+func sessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:12])
 }
 
 func (s *Sessions) Middleware(logger *slog.Logger, tracer trace.Tracer) Middleware {
@@ -40,7 +183,48 @@ func (s *Sessions) Middleware(logger *slog.Logger, tracer trace.Tracer) Middlewa
 			// tag span with cookie name
 			span.SetAttributes(attribute.String("session.cookie", s.Manager.Cookie.Name))
 
-			s.Manager.LoadAndSave(next).ServeHTTP(w, r.WithContext(ctx))
+			s.Manager.LoadAndSave(s.passwordChange(logger, next)).ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// mustChangePassword reads the flag of the session's account, the one in the session when the account can't be read
+func (s *Sessions) mustChangePassword(ctx context.Context, logger *slog.Logger) bool {
+	flagged := s.Manager.GetBool(ctx, "must_change_password")
+
+	userID := s.Manager.GetInt64(ctx, "userID")
+	if s.Users == nil || userID == 0 {
+		return flagged
+	}
+	user, err := s.Users.GetUserByID(ctx, userID)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			logger.Error("could not check the password change flag of a session", "user_id", userID, "err", err)
+		}
+		return flagged
+	}
+
+	// only written when it differs, a put saves the session again
+	if user.MustChangePassword != flagged {
+		s.RequirePasswordChange(ctx, user.MustChangePassword)
+	}
+	return user.MustChangePassword
+}
+
+// passwordChange sends sessions that must change their password to PasswordChangePath, whatever they asked for. The
+// flag is refreshed from the account on every request, sessions that started before it was set are held too
+func (s *Sessions) passwordChange(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.mustChangePassword(r.Context(), logger) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch path := r.URL.Path; {
+		case path == PasswordChangePath, path == "/logout", strings.HasPrefix(path, "/static/"):
+			next.ServeHTTP(w, r)
+		default:
+			http.Redirect(w, r, PasswordChangePath, http.StatusSeeOther)
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexedwards/scs/v2"
+)
+
+func TestPasswordChange(t *testing.T) {
+	t.Parallel()
+	s := &Sessions{Manager: scs.New()}
+
+	// the first request flags the session, the others come back with its cookie
+	flag := s.Manager.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.RequirePasswordChange(r.Context(), true)
+	}))
+	rec := httptest.NewRecorder()
+	flag.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/login", nil))
+	cookies := rec.Result().Cookies()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := s.Manager.LoadAndSave(s.passwordChange(slog.New(slog.DiscardHandler), ok))
+
+	tests := []struct {
+		name, method, target string
+		flagged              bool
+		wantStatus           int
+	}{
+		{name: "other pages are off limits", method: http.MethodGet, target: "/dashboard", flagged: true, wantStatus: http.StatusSeeOther},
+		{name: "forms too", method: http.MethodPost, target: "/account/delete", flagged: true, wantStatus: http.StatusSeeOther},
+		{name: "the change password page", method: http.MethodGet, target: PasswordChangePath, flagged: true, wantStatus: http.StatusOK},
+		{name: "its form", method: http.MethodPost, target: PasswordChangePath, flagged: true, wantStatus: http.StatusOK},
+		{name: "logging out", method: http.MethodPost, target: "/logout", flagged: true, wantStatus: http.StatusOK},
+		{name: "static files", method: http.MethodGet, target: "/static/css/style.css", flagged: true, wantStatus: http.StatusOK},
+		{name: "sessions without the flag", method: http.MethodGet, target: "/dashboard", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			req := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.flagged {
+				for _, c := range cookies {
+					req.AddCookie(c)
+				}
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status: want %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if rec.Code == http.StatusSeeOther && rec.Header().Get("Location") != PasswordChangePath {
+				t.Fatalf("location: want %s, got %s", PasswordChangePath, rec.Header().Get("Location"))
+			}
+		})
+	}
+}
+
+// fakeUsers is the account table of the sessions, keyed by id
+type fakeUsers map[int64]*storage.User
+
+func (f fakeUsers) GetUserByID(_ context.Context, id int64) (*storage.User, error) {
+	if u, ok := f[id]; ok {
+		return u, nil
+	}
+	return nil, storage.ErrNotFound
+}
+
+func TestPasswordChangeFlaggedAfterLogin(t *testing.T) {
+	t.Parallel()
+	users := fakeUsers{1: {ID: 1, Username: "admin"}}
+	s := &Sessions{Manager: scs.New(), Users: users}
+
+	// the session was logged in before the account was flagged
+	login := s.Manager.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Manager.Put(r.Context(), "userID", int64(1))
+	}))
+	rec := httptest.NewRecorder()
+	login.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", nil))
+	cookies := rec.Result().Cookies()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := s.Manager.LoadAndSave(s.passwordChange(slog.New(slog.DiscardHandler), ok))
+	get := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("before the flag: want %d, got %d", http.StatusOK, code)
+	}
+	users[1].MustChangePassword = true
+	if code := get(); code != http.StatusSeeOther {
+		t.Fatalf("once flagged: want %d, got %d", http.StatusSeeOther, code)
+	}
+	users[1].MustChangePassword = false
+	if code := get(); code != http.StatusOK {
+		t.Fatalf("once changed: want %d, got %d", http.StatusOK, code)
+	}
+}
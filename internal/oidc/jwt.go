@@ -0,0 +1,207 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// minRSABits is the smallest RSA key accepted from the provider
+const minRSABits = 2048
+
+// jwk is a key of the provider's JWKS, RFC 7517
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// verifyJWT checks the signature of the compact JWS raw and decodes its payload into claims
+func (p *Provider) verifyJWT(ctx context.Context, raw string, claims any) error {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("%w: not a signed jwt", ErrIDToken)
+	}
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("%w: header: %w", ErrIDToken, err)
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("%w: payload: %w", ErrIDToken, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return fmt.Errorf("%w: signature: %w", ErrIDToken, err)
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return fmt.Errorf("%w: header: %w", ErrIDToken, err)
+	}
+	// the algorithm comes from the token, so it is checked against the key rather than trusted: no "none", no HMAC
+	if header.Alg != "RS256" && header.Alg != "ES256" {
+		return fmt.Errorf("%w: algorithm %q", ErrSignature, header.Alg)
+	}
+
+	key, err := p.key(ctx, header.Kid, header.Alg)
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+			return ErrSignature
+		}
+	case *ecdsa.PublicKey:
+		// JWS signatures are r and s side by side rather than ASN.1
+		if len(sig) != 64 || !ecdsa.Verify(key, digest[:], new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])) {
+			return ErrSignature
+		}
+	}
+
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return fmt.Errorf("%w: claims: %w", ErrIDToken, err)
+	}
+	return nil
+}
+
+// key returns the provider's key kid for alg. Keys the engine hasn't seen send it back to the JWKS, providers
+// rotate keys by publishing the new one before signing with it. A key missing from a fresh JWKS holds off the next
+// fetch for a while, so made up key ids can't have the site hammer the provider
+func (p *Provider) key(ctx context.Context, kid, alg string) (any, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key := findKey(p.keys, kid, alg); key != nil {
+		return key, nil
+	}
+	if time.Since(p.keysMissedAt) < keysRefreshInterval {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrSignature, kid)
+	}
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.keys = keys
+
+	if key := findKey(p.keys, kid, alg); key != nil {
+		return key, nil
+	}
+	p.keysMissedAt = time.Now()
+	return nil, fmt.Errorf("%w: unknown key %q", ErrSignature, kid)
+}
+
+// findKey picks key kid if it fits alg, a token without a key id takes the only key of its type
+func findKey(keys map[string]any, kid, alg string) any {
+	fits := func(key any) bool {
+		switch key.(type) {
+		case *rsa.PublicKey:
+			return alg == "RS256"
+		case *ecdsa.PublicKey:
+			return alg == "ES256"
+		}
+		return false
+	}
+
+	if kid != "" {
+		if key, ok := keys[kid]; ok && fits(key) {
+			return key
+		}
+		return nil
+	}
+	var found any
+	for _, key := range keys {
+		if fits(key) {
+			if found != nil {
+				return nil
+			}
+			found = key
+		}
+	}
+	return found
+}
+
+// fetchKeys reads the provider's JWKS, keys of other types or for encryption are left out. Callers hold p.mu
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]any, error) {
+	if p.meta == nil {
+		return nil, ErrDiscovery
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.meta.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	status, err := p.getJSON(req, &set)
+	if err != nil {
+		return nil, fmt.Errorf("%w: jwks: %w", ErrDiscovery, err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("%w: jwks status %d", ErrDiscovery, status)
+	}
+
+	keys := make(map[string]any, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("rsa exponent")
+		}
+		key := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if key.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("rsa key of %d bits", key.N.BitLen())
+		}
+		return key, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != 32 {
+			return nil, fmt.Errorf("ec x")
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil || len(y) != 32 {
+			return nil, fmt.Errorf("ec y")
+		}
+		return ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append(append([]byte{4}, x...), y...))
+	}
+	return nil, fmt.Errorf("key type %q", k.Kty)
+}
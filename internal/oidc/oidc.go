@@ -0,0 +1,288 @@
+// Package oidc implements the relying party side of OpenID Connect logins: discovery of the provider, the
+// authorization code flow with PKCE (S256), and the checks of the ID token against the keys the provider publishes.
+// Only RS256 and ES256 signed ID tokens are accepted, the token endpoint is trusted for nothing but handing them out
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Leeway is the clock skew tolerated between the provider and the site when checking token times
+	Leeway = time.Minute
+	// maxResponseSize caps what is read from the provider
+	maxResponseSize = 1 << 20
+	// keysRefreshInterval is how long a key id missing from the JWKS keeps unknown key ids from fetching it again
+	keysRefreshInterval = time.Minute
+)
+
+var (
+	ErrDiscovery     = errors.New("could not discover the provider")
+	ErrTokenEndpoint = errors.New("token endpoint refused the code")
+	ErrIDToken       = errors.New("malformed or unexpected id token")
+	ErrSignature     = errors.New("id token signature does not verify")
+	ErrExpired       = errors.New("id token expired")
+	ErrNonce         = errors.New("id token was issued for another login")
+)
+
+// Config is a provider and the client registered with it. ClientSecret may be empty for public clients, PKCE
+// protects the code either way
+type Config struct {
+	Name         string // shown on the login button
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+}
+
+// Claims are the ID token claims the engine reads
+type Claims struct {
+	Issuer            string   `json:"iss"`
+	Subject           string   `json:"sub"`
+	Audience          audience `json:"aud"`
+	AuthorizedParty   string   `json:"azp"`
+	Expiry            int64    `json:"exp"`
+	IssuedAt          int64    `json:"iat"`
+	Nonce             string   `json:"nonce"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	PreferredUsername string   `json:"preferred_username"`
+	Name              string   `json:"name"`
+}
+
+// audience is the aud claim, a single string or a list of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// Login is what a login keeps between sending the user to the provider and their return: State ties the callback
+// to the browser, Nonce the ID token to the login and Verifier the code to the client
+type Login struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+// NewLogin returns fresh random values for a login
+func NewLogin() (Login, error) {
+	var l Login
+	for _, v := range []*string{&l.State, &l.Nonce, &l.Verifier} {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return Login{}, err
+		}
+		*v = base64.RawURLEncoding.EncodeToString(b)
+	}
+	return l, nil
+}
+
+// codeChallenge is the S256 PKCE challenge of verifier
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// metadata is the part of the discovery document the engine uses
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider talks to one OpenID provider. Discovery happens on first use and is retried until it works, so a
+// provider that is down at start up doesn't keep the site from starting
+type Provider struct {
+	Config
+	client *http.Client
+
+	mu           sync.Mutex
+	meta         *metadata
+	keys         map[string]any // public keys by key id
+	keysMissedAt time.Time
+}
+
+// New returns a provider for cfg, client defaults to one with a 10 second timeout
+func New(cfg Config, client *http.Client) *Provider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if !slices.Contains(cfg.Scopes, "openid") {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+	cfg.Issuer = strings.TrimRight(cfg.Issuer, "/")
+	return &Provider{Config: cfg, client: client}
+}
+
+// AuthURL is where the user is sent to log in at the provider, they come back to redirectURI with a code
+func (p *Provider) AuthURL(ctx context.Context, redirectURI string, l Login) (string, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrDiscovery, err)
+	}
+
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.ClientID)
+	q.Set("redirect_uri", redirectURI)
+	q.Set("scope", strings.Join(p.Scopes, " "))
+	q.Set("state", l.State)
+	q.Set("nonce", l.Nonce)
+	q.Set("code_challenge", codeChallenge(l.Verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange trades the code the user came back with for an ID token and returns its claims once they checked out
+func (p *Provider) Exchange(ctx context.Context, redirectURI, code string, l Login) (*Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"code_verifier": {l.Verifier},
+	}
+	if p.ClientSecret == "" {
+		form.Set("client_id", p.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.ClientSecret != "" {
+		// RFC 6749 2.3.1, both are form encoded before going into the header
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.getJSON(req, &body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTokenEndpoint, err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("%w: %d %s %s", ErrTokenEndpoint, status, body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id token in the answer", ErrTokenEndpoint)
+	}
+	return p.Verify(ctx, body.IDToken, l.Nonce)
+}
+
+// Verify checks the signature of an ID token against the provider's keys and that it was issued by the provider,
+// for this client and this login, and hasn't expired
+func (p *Provider) Verify(ctx context.Context, raw, nonce string) (*Claims, error) {
+	meta, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := p.verifyJWT(ctx, raw, &claims); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case claims.Issuer != meta.Issuer:
+		return nil, fmt.Errorf("%w: issued by %q", ErrIDToken, claims.Issuer)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrIDToken)
+	case !slices.Contains(claims.Audience, p.ClientID):
+		return nil, fmt.Errorf("%w: issued for %v", ErrIDToken, []string(claims.Audience))
+	case (len(claims.Audience) > 1 || claims.AuthorizedParty != "") && claims.AuthorizedParty != p.ClientID:
+		return nil, fmt.Errorf("%w: authorized party %q", ErrIDToken, claims.AuthorizedParty)
+	case claims.Expiry == 0 || now.After(time.Unix(claims.Expiry, 0).Add(Leeway)):
+		return nil, ErrExpired
+	case time.Unix(claims.IssuedAt, 0).After(now.Add(Leeway)):
+		return nil, fmt.Errorf("%w: issued in the future", ErrIDToken)
+	case nonce == "" || claims.Nonce != nonce:
+		return nil, ErrNonce
+	}
+	return &claims, nil
+}
+
+// discover fetches the discovery document once, the issuer it names must be the configured one
+func (p *Provider) discover(ctx context.Context) (*metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDiscovery, err)
+	}
+	var meta metadata
+	status, err := p.getJSON(req, &meta)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("%w: %w", ErrDiscovery, err)
+	case status != http.StatusOK:
+		return nil, fmt.Errorf("%w: status %d", ErrDiscovery, status)
+	case meta.Issuer != p.Issuer:
+		return nil, fmt.Errorf("%w: document is for issuer %q", ErrDiscovery, meta.Issuer)
+	case meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "":
+		return nil, fmt.Errorf("%w: endpoints missing", ErrDiscovery)
+	}
+	p.meta = &meta
+	return p.meta, nil
+}
+
+// getJSON sends req and decodes the answer into v whatever its status, error answers carry JSON too
+func (p *Provider) getJSON(req *http.Request, v any) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return 0, err
+	}
+	if err := json.Unmarshal(data, v); err != nil && resp.StatusCode == http.StatusOK {
+		return 0, err
+	}
+	return resp.StatusCode, nil
+}
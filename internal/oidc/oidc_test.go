@@ -0,0 +1,230 @@
+package oidc_test
+
+import (
+	"blogengine/internal/oidc"
+	"blogengine/internal/oidc/oidctest"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const redirectURI = "https://blog.example.com/login/oidc/callback"
+
+func setup(t *testing.T, secret string) (*oidctest.Issuer, *oidc.Provider) {
+	t.Helper()
+	issuer := oidctest.New("blog", secret)
+	t.Cleanup(issuer.Close)
+	issuer.User = &oidctest.User{Subject: "user-1", Email: "alice@example.com", EmailVerified: true}
+	p := oidc.New(oidc.Config{Issuer: issuer.URL, ClientID: "blog", ClientSecret: secret, Scopes: []string{"email"}}, issuer.Client())
+	return issuer, p
+}
+
+// login runs the code flow up to the exchange, the callback is checked the way the handler checks it
+func login(t *testing.T, issuer *oidctest.Issuer, p *oidc.Provider) (*oidc.Claims, error) {
+	t.Helper()
+	ctx := context.Background()
+	l, err := oidc.NewLogin()
+	if err != nil {
+		t.Fatalf("could not start login: %v", err)
+	}
+	authURL, err := p.AuthURL(ctx, redirectURI, l)
+	if err != nil {
+		t.Fatalf("could not build auth url: %v", err)
+	}
+	back, err := issuer.Authorize(authURL)
+	if err != nil {
+		t.Fatalf("issuer refused the authorization request: %v", err)
+	}
+	u, _ := url.Parse(back)
+	if u.Query().Get("state") != l.State {
+		t.Fatalf("state: want %q, got %q", l.State, u.Query().Get("state"))
+	}
+	return p.Exchange(ctx, redirectURI, u.Query().Get("code"), l)
+}
+
+func TestCodeFlow(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct{ alg, secret string }{{"ES256", "s3cret"}, {"RS256", "s3cret"}, {"ES256", ""}} {
+		t.Run(tt.alg+"/secret="+tt.secret, func(t *testing.T) {
+			t.Parallel()
+			issuer, p := setup(t, tt.secret)
+			issuer.Alg = tt.alg
+
+			claims, err := login(t, issuer, p)
+			if err != nil {
+				t.Fatalf("could not log in: %v", err)
+			}
+			if claims.Subject != "user-1" || claims.Email != "alice@example.com" || !claims.EmailVerified || claims.Issuer != issuer.URL {
+				t.Fatalf("claims: got %+v", claims)
+			}
+
+			// new keys are picked up from the JWKS
+			issuer.Rotate()
+			if _, err := login(t, issuer, p); err != nil {
+				t.Fatalf("login after key rotation: %v", err)
+			}
+		})
+	}
+}
+
+func TestAuthURL(t *testing.T) {
+	t.Parallel()
+	issuer, p := setup(t, "s3cret")
+
+	l := oidc.Login{State: "state", Nonce: "nonce", Verifier: "verifier"}
+	authURL, err := p.AuthURL(context.Background(), redirectURI, l)
+	if err != nil {
+		t.Fatalf("could not build auth url: %v", err)
+	}
+	u, _ := url.Parse(authURL)
+	q := u.Query()
+	if !strings.HasPrefix(authURL, issuer.URL+"/authorize?") || q.Get("scope") != "openid email" || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("auth url: got %s", authURL)
+	}
+	// RFC 7636 appendix B
+	l.Verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	authURL, _ = p.AuthURL(context.Background(), redirectURI, l)
+	u, _ = url.Parse(authURL)
+	if got := u.Query().Get("code_challenge"); got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Fatalf("code challenge: got %s", got)
+	}
+}
+
+func TestIDTokenChecks(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		modify  func(claims map[string]any)
+		wantErr error
+	}{
+		{name: "other issuer", modify: func(c map[string]any) { c["iss"] = "https://evil.example" }, wantErr: oidc.ErrIDToken},
+		{name: "other audience", modify: func(c map[string]any) { c["aud"] = "someone-else" }, wantErr: oidc.ErrIDToken},
+		{name: "shared audience", modify: func(c map[string]any) { c["aud"] = []string{"blog", "someone-else"} }, wantErr: oidc.ErrIDToken},
+		{name: "other authorized party", modify: func(c map[string]any) { c["aud"] = []string{"blog", "x"}; c["azp"] = "x" }, wantErr: oidc.ErrIDToken},
+		{name: "expired", modify: func(c map[string]any) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, wantErr: oidc.ErrExpired},
+		{name: "issued in the future", modify: func(c map[string]any) { c["iat"] = time.Now().Add(time.Hour).Unix() }, wantErr: oidc.ErrIDToken},
+		{name: "other login", modify: func(c map[string]any) { c["nonce"] = "another" }, wantErr: oidc.ErrNonce},
+		{name: "no subject", modify: func(c map[string]any) { delete(c, "sub") }, wantErr: oidc.ErrIDToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			issuer, p := setup(t, "s3cret")
+			issuer.Modify = tt.modify
+
+			if _, err := login(t, issuer, p); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	// the list of audiences is fine when the token was handed to us
+	issuer, p := setup(t, "s3cret")
+	issuer.Modify = func(c map[string]any) { c["aud"] = []string{"blog", "x"}; c["azp"] = "blog" }
+	if _, err := login(t, issuer, p); err != nil {
+		t.Fatalf("token with azp: %v", err)
+	}
+}
+
+func TestForgedTokens(t *testing.T) {
+	t.Parallel()
+	issuer, p := setup(t, "s3cret")
+	ctx := context.Background()
+
+	// a token signed for nonce "n" checks out and can be taken apart
+	issuer.Modify = func(c map[string]any) { c["nonce"] = "n" }
+	l, _ := oidc.NewLogin()
+	authURL, _ := p.AuthURL(ctx, redirectURI, l)
+	back, _ := issuer.Authorize(authURL)
+	u, _ := url.Parse(back)
+	raw := exchangeRaw(t, issuer, u.Query().Get("code"), l.Verifier)
+	if _, err := p.Verify(ctx, raw, "n"); err != nil {
+		t.Fatalf("untouched token: %v", err)
+	}
+
+	parts := strings.Split(raw, ".")
+	tests := map[string]string{
+		"alg none":         "eyJhbGciOiJub25lIn0." + parts[1] + ".",
+		"alg HS256":        "eyJhbGciOiJIUzI1NiJ9." + parts[1] + "." + parts[2],
+		"changed payload":  parts[0] + "." + parts[1][:len(parts[1])-2] + "fQ." + parts[2],
+		"other signature":  parts[0] + "." + parts[1] + "." + parts[2][:len(parts[2])-2] + "AA",
+		"unknown key":      "eyJhbGciOiJFUzI1NiIsImtpZCI6Im5vcGUifQ." + parts[1] + "." + parts[2],
+		"missing segments": parts[0] + "." + parts[1],
+	}
+	for name, token := range tests {
+		if _, err := p.Verify(ctx, token, "n"); err == nil {
+			t.Fatalf("%s: want an error", name)
+		}
+	}
+}
+
+// exchangeRaw trades code at the issuer's token endpoint and returns the ID token as is
+func exchangeRaw(t *testing.T, issuer *oidctest.Issuer, code, verifier string) string {
+	t.Helper()
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {redirectURI}, "code_verifier": {verifier}, "client_id": {"blog"}}
+	req, _ := http.NewRequest(http.MethodPost, issuer.URL+"/token", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth("blog", "s3cret")
+	resp, err := issuer.Client().Do(req)
+	if err != nil {
+		t.Fatalf("token request: %v", err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.IDToken == "" {
+		t.Fatalf("token response: %d %v", resp.StatusCode, err)
+	}
+	return body.IDToken
+}
+
+func TestPKCE(t *testing.T) {
+	t.Parallel()
+	issuer, p := setup(t, "s3cret")
+	ctx := context.Background()
+
+	l, _ := oidc.NewLogin()
+	authURL, _ := p.AuthURL(ctx, redirectURI, l)
+	back, _ := issuer.Authorize(authURL)
+	u, _ := url.Parse(back)
+	code := u.Query().Get("code")
+
+	// a stolen code is useless without the verifier
+	stolen := l
+	stolen.Verifier = "another verifier that is long enough to be one"
+	if _, err := p.Exchange(ctx, redirectURI, code, stolen); !errors.Is(err, oidc.ErrTokenEndpoint) {
+		t.Fatalf("other verifier: want %v, got %v", oidc.ErrTokenEndpoint, err)
+	}
+	// and codes work once
+	if _, err := p.Exchange(ctx, redirectURI, code, l); !errors.Is(err, oidc.ErrTokenEndpoint) {
+		t.Fatalf("used code: want %v, got %v", oidc.ErrTokenEndpoint, err)
+	}
+
+	wrongSecret := oidc.New(oidc.Config{Issuer: issuer.URL, ClientID: "blog", ClientSecret: "nope"}, issuer.Client())
+	authURL, _ = wrongSecret.AuthURL(ctx, redirectURI, l)
+	back, _ = issuer.Authorize(authURL)
+	u, _ = url.Parse(back)
+	if _, err := wrongSecret.Exchange(ctx, redirectURI, u.Query().Get("code"), l); !errors.Is(err, oidc.ErrTokenEndpoint) {
+		t.Fatalf("wrong client secret: want %v, got %v", oidc.ErrTokenEndpoint, err)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	t.Parallel()
+	issuer, _ := setup(t, "")
+
+	// the document must be about the configured issuer
+	p := oidc.New(oidc.Config{Issuer: issuer.URL + "/other", ClientID: "blog"}, issuer.Client())
+	if _, err := p.AuthURL(context.Background(), redirectURI, oidc.Login{}); !errors.Is(err, oidc.ErrDiscovery) {
+		t.Fatalf("other issuer: want %v, got %v", oidc.ErrDiscovery, err)
+	}
+}
@@ -0,0 +1,303 @@
+// Package oidctest provides an in-process OpenID provider that answers discovery, JWKS and token requests and logs in
+// whoever it is told to at its authorization endpoint, so OpenID Connect logins can be tested without a real one
+package oidctest
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var (
+	ErrClient   = errors.New("unknown client or redirect")
+	ErrPKCE     = errors.New("authorization requests need an S256 code challenge")
+	ErrNoUser   = errors.New("no user to log in")
+	errBadGrant = errors.New("invalid_grant")
+)
+
+// User is who the issuer logs in, the claims go into the ID tokens it signs
+type User struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+}
+
+// Issuer is an OpenID provider with one registered client. Tokens are signed with ES256 unless Alg says "RS256",
+// Modify sees the claims of each ID token before they are signed
+type Issuer struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+	Alg          string
+	User         *User
+	Modify       func(claims map[string]any)
+
+	server *httptest.Server
+	mu     sync.Mutex
+	keys   map[string]crypto.Signer // by key id, the newest signs
+	kid    string
+	grants map[string]grant
+}
+
+// grant is an authorization code waiting to be exchanged
+type grant struct {
+	user          User
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+}
+
+// New starts an issuer for the client, Close stops it
+func New(clientID, clientSecret string) *Issuer {
+	i := &Issuer{ClientID: clientID, ClientSecret: clientSecret, Alg: "ES256", grants: map[string]grant{}, keys: map[string]crypto.Signer{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", i.handleDiscovery)
+	mux.HandleFunc("GET /jwks", i.handleJWKS)
+	mux.HandleFunc("GET /authorize", i.handleAuthorize)
+	mux.HandleFunc("POST /token", i.handleToken)
+	i.server = httptest.NewServer(mux)
+	i.URL = i.server.URL
+	return i
+}
+
+// Client returns an http client for talking to the issuer
+func (i *Issuer) Client() *http.Client {
+	return i.server.Client()
+}
+
+func (i *Issuer) Close() {
+	i.server.Close()
+}
+
+// Rotate makes a new signing key, the old ones stay in the JWKS
+func (i *Issuer) Rotate() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.kid = ""
+}
+
+// Authorize logs User in for the authorization request authURL and returns the redirect back to the client with
+// the code, as the provider's login page would once the user agreed
+func (i *Issuer) Authorize(authURL string) (string, error) {
+	u, err := url.Parse(authURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	if q.Get("client_id") != i.ClientID || q.Get("response_type") != "code" || q.Get("redirect_uri") == "" {
+		return "", ErrClient
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		return "", ErrPKCE
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.User == nil {
+		return "", ErrNoUser
+	}
+	code := rand.Text()
+	i.grants[code] = grant{user: *i.User, redirectURI: q.Get("redirect_uri"), nonce: q.Get("nonce"), codeChallenge: q.Get("code_challenge")}
+
+	back, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		return "", ErrClient
+	}
+	bq := back.Query()
+	bq.Set("code", code)
+	bq.Set("state", q.Get("state"))
+	back.RawQuery = bq.Encode()
+	return back.String(), nil
+}
+
+func (i *Issuer) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                i.URL,
+		"authorization_endpoint":                i.URL + "/authorize",
+		"token_endpoint":                        i.URL + "/token",
+		"jwks_uri":                              i.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"ES256", "RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (i *Issuer) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if _, err := i.signer(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	keys := make([]map[string]string, 0, len(i.keys))
+	for kid, key := range i.keys {
+		switch pub := key.Public().(type) {
+		case *ecdsa.PublicKey:
+			point, _ := pub.Bytes()
+			keys = append(keys, map[string]string{"kty": "EC", "kid": kid, "use": "sig", "crv": "P-256",
+				"x": b64(point[1:33]), "y": b64(point[33:])})
+		case *rsa.PublicKey:
+			keys = append(keys, map[string]string{"kty": "RSA", "kid": kid, "use": "sig",
+				"n": b64(pub.N.Bytes()), "e": b64(big.NewInt(int64(pub.E)).Bytes())})
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"keys": keys})
+}
+
+func (i *Issuer) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	back, err := i.Authorize(r.URL.String())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, back, http.StatusFound)
+}
+
+func (i *Issuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+
+	clientID, secret, basic := r.BasicAuth()
+	if basic {
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = r.PostForm.Get("client_id")
+	}
+	if clientID != i.ClientID || (i.ClientSecret != "" && secret != i.ClientSecret) {
+		tokenError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	// codes work once
+	g, ok := i.grants[r.PostForm.Get("code")]
+	delete(i.grants, r.PostForm.Get("code"))
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || g.redirectURI != r.PostForm.Get("redirect_uri") || b64(sum[:]) != g.codeChallenge {
+		tokenError(w, http.StatusBadRequest, errBadGrant.Error())
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]any{
+		"iss":   i.URL,
+		"sub":   g.user.Subject,
+		"aud":   i.ClientID,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+		"nonce": g.nonce,
+	}
+	if g.user.Email != "" {
+		claims["email"] = g.user.Email
+		claims["email_verified"] = g.user.EmailVerified
+	}
+	if g.user.PreferredUsername != "" {
+		claims["preferred_username"] = g.user.PreferredUsername
+	}
+	if i.Modify != nil {
+		i.Modify(claims)
+	}
+
+	idToken, err := i.sign(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"access_token": rand.Text(), "token_type": "Bearer", "expires_in": 300, "id_token": idToken})
+}
+
+// signer returns the current signing key, making one of Alg when there is none. Callers hold i.mu
+func (i *Issuer) signer() (crypto.Signer, error) {
+	if key, ok := i.keys[i.kid]; ok {
+		return key, nil
+	}
+
+	var key crypto.Signer
+	var err error
+	switch i.Alg {
+	case "ES256":
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "RS256":
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return nil, fmt.Errorf("unsupported alg %q", i.Alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+	i.kid = rand.Text()
+	i.keys[i.kid] = key
+	return key, nil
+}
+
+// sign makes a compact JWS of claims with the current key. Callers hold i.mu
+func (i *Issuer) sign(claims map[string]any) (string, error) {
+	key, err := i.signer()
+	if err != nil {
+		return "", err
+	}
+	alg := "ES256"
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		alg = "RS256"
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": i.kid, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := b64(header) + "." + b64(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch key := key.(type) {
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			return "", err
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case *rsa.PrivateKey:
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			return "", err
+		}
+	}
+	return signed + "." + b64(sig), nil
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func tokenError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
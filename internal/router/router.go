@@ -49,14 +49,101 @@ func NewRouter(deps RouterDependencies) http.Handler {
 	appMux.Handle("POST /register", authStack(deps.BlogHandler.HandleRegister()))
 	appMux.Handle("GET /login", deps.BlogHandler.HandleLoginPage())
 	appMux.Handle("POST /login", authStack(deps.BlogHandler.HandleLogin()))
+	appMux.Handle("GET /login/2fa", deps.BlogHandler.HandleTwoFactorPage())
+	appMux.Handle("POST /login/2fa", authStack(deps.BlogHandler.HandleTwoFactor()))
+	appMux.Handle("POST /login/passkey/options", authStack(deps.BlogHandler.HandlePasskeyLoginOptions()))
+	appMux.Handle("POST /login/passkey", authStack(deps.BlogHandler.HandlePasskeyLogin()))
+	appMux.Handle("GET /login/oidc", deps.BlogHandler.HandleOIDCLogin())
+	appMux.Handle("GET /login/oidc/callback", deps.BlogHandler.HandleOIDCCallback())
+	appMux.Handle("GET /login/oidc/link", deps.BlogHandler.HandleOIDCLinkPage())
+	appMux.Handle("POST /login/oidc/link", authStack(deps.BlogHandler.HandleOIDCLink()))
+	appMux.Handle("POST /login/oidc/register", authStack(deps.BlogHandler.HandleOIDCRegister()))
 	appMux.Handle("POST /logout", authStack(deps.BlogHandler.HandleLogout()))
+	appMux.Handle("GET /forgot-password", deps.BlogHandler.HandleForgotPasswordPage())
+	appMux.Handle("POST /forgot-password", authStack(deps.BlogHandler.HandleForgotPassword()))
+	appMux.Handle("GET /reset-password/{token}", deps.BlogHandler.HandleResetPasswordPage())
+	appMux.Handle("POST /reset-password/{token}", authStack(deps.BlogHandler.HandleResetPassword()))
 	appMux.Handle("POST /blogs/{blog_slug}/{post_slug}/comment", authStack(deps.BlogHandler.HandleComment()))
 	appMux.Handle("POST /blogs/{blog_slug}/{post_slug}/comment/{commentID}/delete", authStack(deps.BlogHandler.HandleDeleteComment()))
+	appMux.Handle("GET /blogs/{blog_slug}/{post_slug}/comment/{commentID}/edit", deps.BlogHandler.HandleEditCommentPage())
+	appMux.Handle("POST /blogs/{blog_slug}/{post_slug}/comment/{commentID}/edit", authStack(deps.BlogHandler.HandleEditComment()))
+	appMux.Handle("POST /blogs/{blog_slug}/{post_slug}/unlock", authStack(deps.BlogHandler.HandleUnlockPost()))
+
+	// account
+	appMux.Handle("GET /account", deps.BlogHandler.HandleAccountPage())
+	appMux.Handle("GET /account/password", deps.BlogHandler.HandleChangePasswordPage())
+	appMux.Handle("POST /account/password", authStack(deps.BlogHandler.HandleChangePassword()))
+	appMux.Handle("POST /account/sessions/{session_id}/revoke", authStack(deps.BlogHandler.HandleRevokeSession()))
+	appMux.Handle("POST /account/delete", authStack(deps.BlogHandler.HandleDeleteAccount()))
+	appMux.Handle("POST /account/reauth", authStack(deps.BlogHandler.HandleOIDCReauth()))
+	appMux.Handle("POST /account/profile", deps.BlogHandler.HandleUpdateProfile())
+	appMux.Handle("POST /account/avatar", deps.BlogHandler.HandleUploadAvatar())
+	appMux.Handle("POST /account/avatar/remove", deps.BlogHandler.HandleRemoveAvatar())
+	appMux.Handle("GET /account/totp", deps.BlogHandler.HandleTwoFactorSetupPage())
+	appMux.Handle("POST /account/totp", authStack(deps.BlogHandler.HandleEnableTwoFactor()))
+	appMux.Handle("POST /account/totp/disable", authStack(deps.BlogHandler.HandleDisableTwoFactor()))
+	appMux.Handle("POST /account/passkeys/options", authStack(deps.BlogHandler.HandlePasskeyRegisterOptions()))
+	appMux.Handle("POST /account/passkeys", authStack(deps.BlogHandler.HandleRegisterPasskey()))
+	appMux.Handle("POST /account/passkeys/{passkey_id}/delete", authStack(deps.BlogHandler.HandleDeletePasskey()))
+
+	// admin
+	appMux.Handle("GET /admin/logins", deps.BlogHandler.HandleAdminLoginsPage())
+	appMux.Handle("POST /admin/logins/unlock", deps.BlogHandler.HandleUnlockLogin())
+	appMux.Handle("GET /admin/audit", deps.BlogHandler.HandleAdminAuditPage())
+	appMux.Handle("GET /admin/audit/export", deps.BlogHandler.HandleAdminAuditExport())
+
+	// dashboard
+	appMux.Handle("GET /dashboard", deps.BlogHandler.HandleDashboard())
+	appMux.Handle("GET /dashboard/blogs/new", deps.BlogHandler.HandleNewBlogPage())
+	appMux.Handle("POST /dashboard/blogs", deps.BlogHandler.HandleCreateBlog())
+	appMux.Handle("GET /dashboard/blogs/{blog_id}", deps.BlogHandler.HandleEditBlogPage())
+	appMux.Handle("POST /dashboard/blogs/{blog_id}", deps.BlogHandler.HandleUpdateBlog())
+	appMux.Handle("POST /dashboard/blogs/{blog_id}/visibility", deps.BlogHandler.HandleUpdateBlogVisibility())
+	appMux.Handle("POST /dashboard/blogs/{blog_id}/registration", deps.BlogHandler.HandleUpdateBlogRegistration())
+	appMux.Handle("POST /dashboard/blogs/{blog_id}/moderation", deps.BlogHandler.HandleUpdateBlogCommentPolicy())
+	appMux.Handle("POST /dashboard/blogs/{blog_id}/delete", deps.BlogHandler.HandleDeleteBlog())
+	appMux.Handle("GET /dashboard/blogs/{blog_id}/members", deps.BlogHandler.HandleMembersPage())
+	appMux.Handle("POST /dashboard/blogs/{blog_id}/members/{user_id}/role", deps.BlogHandler.HandleSetMemberRole())
+	appMux.Handle("GET /dashboard/posts/new", deps.BlogHandler.HandleNewPostPage())
+	appMux.Handle("POST /dashboard/posts", deps.BlogHandler.HandleCreatePost())
+	appMux.Handle("GET /dashboard/posts/{post_id}", deps.BlogHandler.HandleEditPostPage())
+	appMux.Handle("POST /dashboard/posts/{post_id}", deps.BlogHandler.HandleUpdatePost())
+	appMux.Handle("POST /dashboard/posts/{post_id}/delete", deps.BlogHandler.HandleDeletePost())
+	appMux.Handle("POST /dashboard/preview", deps.BlogHandler.HandlePreview())
+	appMux.Handle("GET /dashboard/moderation", deps.BlogHandler.HandleModerationPage())
+	appMux.Handle("POST /dashboard/moderation", deps.BlogHandler.HandleModerateComments())
+	appMux.Handle("GET /dashboard/tokens", deps.BlogHandler.HandleTokensPage())
+	appMux.Handle("POST /dashboard/tokens", deps.BlogHandler.HandleCreateToken())
+	appMux.Handle("POST /dashboard/tokens/{token_id}/revoke", deps.BlogHandler.HandleRevokeToken())
+
+	// membership
+	appMux.Handle("GET /blogs/{blog_slug}/join", deps.BlogHandler.HandleMembershipPage())
+	appMux.Handle("POST /blogs/{blog_slug}/join", authStack(deps.BlogHandler.HandleJoinBlog()))
+	appMux.Handle("POST /blogs/{blog_slug}/leave", deps.BlogHandler.HandleLeaveBlog())
+	appMux.Handle("POST /blogs/{blog_slug}/invites", deps.BlogHandler.HandleCreateInvite())
 
 	// routes
 	appMux.Handle("GET /{$}", deps.BlogHandler.HandleHome())
 	appMux.Handle("GET /blogs/{blog_slug}", deps.BlogHandler.HandleBlog())
 	appMux.Handle("GET /blogs/{blog_slug}/{post_slug}", deps.BlogHandler.HandlePost())
+
+	// profiles
+	appMux.Handle("GET /users/{username}", deps.BlogHandler.HandleProfile())
+	appMux.Handle("GET /users/{username}/avatar", deps.BlogHandler.HandleAvatar())
+
+	// search
+	appMux.Handle("GET /search", deps.BlogHandler.HandleSearch())
+	appMux.Handle("GET /blogs/{blog_slug}/search", deps.BlogHandler.HandleBlogSearch())
+
+	// tags
+	appMux.Handle("GET /tags/{tag}", deps.BlogHandler.HandleTag())
+	appMux.Handle("GET /blogs/{blog_slug}/tags/{tag}", deps.BlogHandler.HandleBlogTag())
+
+	// feeds
+	appMux.Handle("GET /feed.xml", deps.BlogHandler.HandleSiteFeed(handlers.FeedAtom))
+	appMux.Handle("GET /rss.xml", deps.BlogHandler.HandleSiteFeed(handlers.FeedRSS))
+	appMux.Handle("GET /blogs/{blog_slug}/feed.xml", deps.BlogHandler.HandleBlogFeed(handlers.FeedAtom))
+	appMux.Handle("GET /blogs/{blog_slug}/rss.xml", deps.BlogHandler.HandleBlogFeed(handlers.FeedRSS))
 	// appMux.Handle("GET /post/{id}", deps.BlogHandler.HandlePost())
 
 	appMux.HandleFunc("/", deps.BlogHandler.NotFound)
@@ -81,12 +168,46 @@ func NewRouter(deps RouterDependencies) http.Handler {
 		deps.Limiter.Middleware(deps.Logger, deps.Tracer),
 		deps.GeoStats.Middleware(deps.Logger, deps.Tracer),
 		deps.Session.Middleware(deps.Logger, deps.Tracer),
+		// uploads are capped before the csrf check parses them
+		middleware.MaxBody(map[string]int64{"POST /account/avatar": handlers.MaxAvatarRequestBytes}, deps.BlogHandler.RenderError),
 		deps.CSRF.Middleware(deps.Logger, deps.Tracer),
 		middleware.Logger(deps.Logger, deps.Tracer), // Inner logger (shows simple text logs)
 	)
 
 	appHandler := middleware.Chain(appMux, middlewareStack...)
 
+	// json api, token authenticated so it skips the session and csrf middlewares but keeps the rate limiter
+	apiMux := http.NewServeMux()
+	apiMux.Handle("GET /api/v1/openapi.json", deps.BlogHandler.HandleOpenAPI())
+	apiMux.Handle("GET /api/v1/blogs", deps.BlogHandler.HandleAPIListBlogs())
+	apiMux.Handle("POST /api/v1/blogs", deps.BlogHandler.HandleAPICreateBlog())
+	apiMux.Handle("GET /api/v1/blogs/{blog_slug}", deps.BlogHandler.HandleAPIGetBlog())
+	apiMux.Handle("PATCH /api/v1/blogs/{blog_slug}", deps.BlogHandler.HandleAPIUpdateBlog())
+	apiMux.Handle("DELETE /api/v1/blogs/{blog_slug}", deps.BlogHandler.HandleAPIDeleteBlog())
+	apiMux.Handle("GET /api/v1/blogs/{blog_slug}/posts", deps.BlogHandler.HandleAPIListPosts())
+	apiMux.Handle("POST /api/v1/blogs/{blog_slug}/posts", deps.BlogHandler.HandleAPICreatePost())
+	apiMux.Handle("GET /api/v1/blogs/{blog_slug}/posts/{post_slug}", deps.BlogHandler.HandleAPIGetPost())
+	apiMux.Handle("GET /api/v1/blogs/{blog_slug}/posts/{post_slug}/comments", deps.BlogHandler.HandleAPIListComments())
+	apiMux.Handle("POST /api/v1/blogs/{blog_slug}/posts/{post_slug}/comments", deps.BlogHandler.HandleAPICreateComment())
+	apiMux.Handle("PATCH /api/v1/posts/{post_id}", deps.BlogHandler.HandleAPIUpdatePost())
+	apiMux.Handle("DELETE /api/v1/posts/{post_id}", deps.BlogHandler.HandleAPIDeletePost())
+	apiMux.Handle("DELETE /api/v1/comments/{comment_id}", deps.BlogHandler.HandleAPIDeleteComment())
+	apiMux.Handle("/api/v1/", deps.BlogHandler.HandleAPINotFound())
+
+	apiStack := []middleware.Middleware{
+		middleware.Recover(deps.Logger),
+	}
+	if deps.Cfg.Metrics.EnableTelemetry {
+		apiStack = append(apiStack, middleware.Observability(deps.Tracer, deps.Metrics, deps.Logger))
+	}
+	apiStack = append(apiStack,
+		deps.Limiter.Middleware(deps.Logger, deps.Tracer),
+		middleware.Logger(deps.Logger, deps.Tracer),
+		deps.BlogHandler.APIAuth,
+	)
+
+	apiHandler := middleware.Chain(apiMux, apiStack...)
+
 	rootMux := http.NewServeMux()
 
 	rootMux.Handle("GET /metrics", deps.BlogHandler.HandleMetrics())
@@ -98,6 +219,7 @@ func NewRouter(deps RouterDependencies) http.Handler {
 		w.Write([]byte("OK"))
 	})
 
+	rootMux.Handle("/api/v1/", apiHandler)
 	rootMux.Handle("/", appHandler)
 
 	return rootMux
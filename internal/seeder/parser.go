@@ -2,12 +2,14 @@ package seeder
 
 import (
 	"blogengine/internal/storage"
+	"blogengine/internal/utils"
 	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/adrg/frontmatter"
 	"gopkg.in/yaml.v3"
@@ -21,6 +23,7 @@ var (
 	ErrParseToFrontmatter   = errors.New("could not parse post frontmatter")
 	ErrNoPostTitle          = errors.New("post missing title")
 	ErrReadingFile          = errors.New("could not read from file")
+	ErrMissingPassphrase    = errors.New("encrypted post missing passphrase")
 )
 
 const maxChunkReadSize = 32 * 1024 // 32KB
@@ -84,5 +87,13 @@ func ParsePostFile(path string) (*PostFrontmatter, []byte, error) {
 	if fm.Title == "" {
 		return nil, nil, ErrNoPostTitle
 	}
+	if fm.IsEncrypted && fm.Passphrase == "" {
+		return nil, nil, ErrMissingPassphrase
+	}
+	fm.IsEncrypted = fm.Passphrase != ""
+
+	fm.Tags = utils.NormaliseTags(fm.Tags)
+	fm.Category = strings.TrimSpace(fm.Category)
+
 	return &fm, body, nil
 }
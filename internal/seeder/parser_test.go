@@ -5,6 +5,7 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 )
 
@@ -76,6 +77,21 @@ slug: "my-draft"
 # My Draft Post
 
 This is a draft.
+`),
+	"tagged": []byte(`---
+title: "My Tagged Post"
+category: " Programming "
+tags: ["Go", "go", "SQLite FTS5", "", "!!"]
+---
+
+# My Tagged Post
+`),
+	"encrypted_no_passphrase": []byte(`---
+title: "My Secret Post"
+is_encrypted: true
+---
+
+# My Secret Post
 `),
 	"will_be_deleted": nil,
 }
@@ -172,6 +188,8 @@ func TestParsePostFile(t *testing.T) {
 		content         []byte
 		wantDeletedFile bool
 		wantDraft       bool
+		wantTags        []string
+		wantCategory    string
 		wantErr         error
 	}{
 		{
@@ -196,6 +214,18 @@ func TestParsePostFile(t *testing.T) {
 			wantDraft: true,
 			wantErr:   nil,
 		},
+		{
+			name:         "tags are normalised",
+			content:      postMarkdown["tagged"],
+			wantTags:     []string{"go", "sqlite-fts5"},
+			wantCategory: "Programming",
+			wantErr:      nil,
+		},
+		{
+			name:    "encrypted without passphrase",
+			content: postMarkdown["encrypted_no_passphrase"],
+			wantErr: ErrMissingPassphrase,
+		},
 		{
 			name:            "deleted file",
 			content:         postMarkdown["will_be_deleted"],
@@ -234,6 +264,12 @@ func TestParsePostFile(t *testing.T) {
 			if tt.wantDraft && fm.PublishedAt != nil {
 				t.Fatal("expected nil published_at for draft")
 			}
+			if tt.wantTags != nil && !slices.Equal(fm.Tags, tt.wantTags) {
+				t.Fatalf("tags: want %q, got %q", tt.wantTags, fm.Tags)
+			}
+			if fm.Category != tt.wantCategory {
+				t.Fatalf("category: want %q, got %q", tt.wantCategory, fm.Category)
+			}
 
 		})
 	}
@@ -1,6 +1,7 @@
 package seeder
 
 import (
+	"blogengine/internal/encryption"
 	"blogengine/internal/storage"
 	"blogengine/internal/utils"
 	"bytes"
@@ -26,6 +27,9 @@ var (
 	ErrMoveBlogFile         = errors.New("could not move blog file")
 	ErrResolveOwner         = errors.New("could not resolve blog owner")
 	ErrUploadPost           = errors.New("could not upload post to S3")
+	ErrIndexPost            = errors.New("could not index post for search")
+	ErrSyncTags             = errors.New("could not sync post tags")
+	ErrEncryptPost          = errors.New("could not encrypt post")
 	ErrOwnerNotFound        = errors.New("owner not found, create user first")
 	ErrInvalidPublishedTime = errors.New("invalid published_at format, use RFC3339")
 )
@@ -274,6 +278,16 @@ func (s *Seeder) seedPost(ctx context.Context, blogSlug, postPath, publicID stri
 		desc = &fm.Description
 	}
 
+	// the iv is needed to create the post, the body is encrypted once its s3 key is known
+	var encIV *string
+	if fm.IsEncrypted {
+		iv, err := encryption.NewIV()
+		if err != nil {
+			return fmt.Errorf("%w: %w: %w", ErrSeedPost, ErrEncryptPost, err)
+		}
+		encIV = &iv
+	}
+
 	params := storage.CreatePostParams{
 		PublicID:      publicID,
 		BlogID:        blog.ID,
@@ -281,8 +295,9 @@ func (s *Seeder) seedPost(ctx context.Context, blogSlug, postPath, publicID stri
 		Slug:          postSlug,
 		Title:         fm.Title,
 		Description:   desc,
-		IsEncrypted:   false,
-		EncryptionIV:  nil,
+		Category:      fm.category(),
+		IsEncrypted:   fm.IsEncrypted,
+		EncryptionIV:  encIV,
 		RequiresAuth:  fm.RequiresAuth,
 		IsListed:      fm.IsListed,
 		AllowComments: fm.AllowComments,
@@ -294,6 +309,10 @@ func (s *Seeder) seedPost(ctx context.Context, blogSlug, postPath, publicID stri
 		return fmt.Errorf("%w: %w", ErrSeedPost, err)
 	}
 
+	if err := s.DB.SyncPostTaxonomy(ctx, post.ID, post.Category, fm.Tags); err != nil {
+		return fmt.Errorf("%w: %w: %w", ErrSeedPost, ErrSyncTags, err)
+	}
+
 	// only create folder and move file for new posts (loose .md files) in the blog root folder
 	if publicID == "" {
 		// create subfolder
@@ -308,15 +327,50 @@ func (s *Seeder) seedPost(ctx context.Context, blogSlug, postPath, publicID stri
 		}
 	}
 
+	// plaintext of protected posts never leaves this machine
+	payload := body
+	if post.IsEncrypted {
+		payload, err = encryption.Encrypt(body, fm.Passphrase, *post.EncryptionIV, []byte(post.S3Key))
+		if err != nil {
+			return fmt.Errorf("%w: %w: %w", ErrSeedPost, ErrEncryptPost, err)
+		}
+	}
+
 	// upload to object storage
-	if err := s.S3.Save(ctx, post.S3Key, bytes.NewReader(body)); err != nil {
+	if err := s.S3.Save(ctx, post.S3Key, bytes.NewReader(payload)); err != nil {
 		return fmt.Errorf("%w: %w: %w", ErrSeedPost, ErrUploadPost, err)
 	}
 
+	// protected posts are only searchable by their metadata so snippets can't leak the body
+	if !post.IsEncrypted && !post.RequiresAuth {
+		if err := s.DB.IndexPostBody(ctx, post.ID, string(body)); err != nil {
+			return fmt.Errorf("%w: %w: %w", ErrSeedPost, ErrIndexPost, err)
+		}
+	}
+
 	s.Logger.Info("post seeded", "blog", blogSlug, "title", fm.Title, "public_id", post.PublicID)
 	return nil
 }
 
+func (s *Seeder) resyncPostTaxonomy(ctx context.Context, postPath, publicID string) error {
+	fm, _, err := ParsePostFile(postPath)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSyncTags, err)
+	}
+
+	post, err := s.DB.GetPostByPublicID(ctx, publicID)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSyncTags, err)
+	}
+
+	if err := s.DB.SyncPostTaxonomy(ctx, post.ID, fm.category(), fm.Tags); err != nil {
+		return fmt.Errorf("%w: %w", ErrSyncTags, err)
+	}
+
+	s.Logger.Info("post already exists, synced tags", "public_id", publicID, "tags", fm.Tags)
+	return nil
+}
+
 func (s *Seeder) loadExistingPostIDs(ctx context.Context) (map[string]struct{}, error) {
 	existingIDs, err := s.DB.GetAllPostPublicIDs(ctx)
 	if err != nil {
@@ -378,11 +432,6 @@ func (s *Seeder) seedStructuredPosts(ctx context.Context, blogSlug, blogPath str
 		if len(postDir.Name()) != storage.PublicIDLen || !validPublicID.MatchString(postDir.Name()) {
 			continue
 		}
-		// already in DB
-		if _, exists := existing[postDir.Name()]; exists {
-			s.Logger.Info("post already exists, skipping", "public_id", postDir.Name())
-			continue
-		}
 		postSubRoot, err := os.OpenRoot(filepath.Join(blogPath, postDir.Name()))
 		if err != nil {
 			continue
@@ -394,6 +443,15 @@ func (s *Seeder) seedStructuredPosts(ctx context.Context, blogSlug, blogPath str
 		}
 
 		postPath := filepath.Join(postSubRoot.Name(), mdFiles[0])
+
+		// already in DB, only pick up frontmatter changes to its tags
+		if _, exists := existing[postDir.Name()]; exists {
+			if err := s.resyncPostTaxonomy(ctx, postPath, postDir.Name()); err != nil {
+				s.Logger.Error("failed to sync tags of existing post", "file", postPath, "err", err)
+			}
+			continue
+		}
+
 		if err := s.seedPost(ctx, blogSlug, postPath, postDir.Name()); err != nil {
 			s.Logger.Error("failed to seed post, skipping", "file", postPath, "err", err)
 		}
@@ -12,13 +12,23 @@ type BlogManifest struct {
 }
 
 type PostFrontmatter struct {
-	Title         string  `yaml:"title"`
-	Description   string  `yaml:"description"`
-	Slug          string  `yaml:"slug"`
-	IsListed      bool    `yaml:"is_listed"`
-	PublishedAt   *string `yaml:"published_at"`
-	IsEncrypted   bool    `yaml:"is_encrypted"`
-	EncryptionIV  *string `yaml:"encryption_iv"`
-	RequiresAuth  bool    `yaml:"requires_auth"`
-	AllowComments bool    `yaml:"allow_comments"`
+	Title         string   `yaml:"title"`
+	Description   string   `yaml:"description"`
+	Slug          string   `yaml:"slug"`
+	IsListed      bool     `yaml:"is_listed"`
+	PublishedAt   *string  `yaml:"published_at"`
+	IsEncrypted   bool     `yaml:"is_encrypted"`
+	EncryptionIV  *string  `yaml:"encryption_iv"`
+	Passphrase    string   `yaml:"passphrase"` // encrypts the body before upload, implies is_encrypted
+	RequiresAuth  bool     `yaml:"requires_auth"`
+	AllowComments bool     `yaml:"allow_comments"`
+	Tags          []string `yaml:"tags"`
+	Category      string   `yaml:"category"`
+}
+
+func (fm *PostFrontmatter) category() *string {
+	if fm.Category == "" {
+		return nil
+	}
+	return &fm.Category
 }
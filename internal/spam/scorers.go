@@ -0,0 +1,133 @@
+package spam
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// linkPattern matches links by their url, so a markdown link counts once
+var linkPattern = regexp.MustCompile(`(?i)https?://\S+|\bwww\.\S+`)
+
+// LinkDensity scores comments that are mostly links. A link is never spam on its own, at most it is held for a
+// moderator
+type LinkDensity struct {
+	MaxLinks int // comments with more links score the most
+}
+
+func (LinkDensity) Name() string { return "link_density" }
+
+func (l LinkDensity) Score(_ context.Context, c Comment) (Score, error) {
+	links := len(linkPattern.FindAllString(c.Content, -1))
+	if links == 0 {
+		return Score{}, nil
+	}
+	words := max(len(strings.Fields(c.Content)), 1)
+
+	value := min(2*float64(links)/float64(words), 0.9)
+	if links > l.MaxLinks {
+		value = 0.9
+	}
+	return Score{Value: value, Reason: fmt.Sprintf("%d links in %d words", links, words)}, nil
+}
+
+// Repeated scores comments whose text was recently posted on other posts, the more posts the higher
+type Repeated struct {
+	DB     storage.Store
+	Window time.Duration
+}
+
+func (Repeated) Name() string { return "repeated" }
+
+func (r Repeated) Score(ctx context.Context, c Comment) (Score, error) {
+	fingerprint := Fingerprint(c.Content)
+	if fingerprint == "" {
+		return Score{}, nil
+	}
+
+	posts, err := r.DB.CountPostsWithFingerprint(ctx, fingerprint, c.PostID, r.Window)
+	if err != nil || posts == 0 {
+		return Score{}, err
+	}
+	return Score{
+		Value:  float64(posts) / float64(posts+2),
+		Reason: fmt.Sprintf("same text on %d other posts", posts),
+	}, nil
+}
+
+// AccountAge scores comments by accounts younger than MinAge, newer accounts score higher. Being new is never enough
+// to hold a comment, it only adds to other signals
+type AccountAge struct {
+	DB     storage.Store
+	MinAge time.Duration
+}
+
+func (AccountAge) Name() string { return "account_age" }
+
+func (a AccountAge) Score(ctx context.Context, c Comment) (Score, error) {
+	if a.MinAge <= 0 {
+		return Score{}, nil
+	}
+
+	user, err := a.DB.GetUserByID(ctx, c.UserID)
+	if err != nil {
+		return Score{}, err
+	}
+
+	age := time.Since(user.CreatedAt)
+	if age >= a.MinAge {
+		return Score{}, nil
+	}
+	return Score{
+		Value:  0.4 * (1 - max(age, 0).Seconds()/a.MinAge.Seconds()),
+		Reason: fmt.Sprintf("account is %s old", age.Round(time.Minute)),
+	}, nil
+}
+
+// Bayes is a naive bayes classifier trained by moderators marking comments as spam or approving them. It stays quiet
+// until it has seen MinDocs comments of each kind
+type Bayes struct {
+	DB      storage.Store
+	MinDocs int64
+}
+
+func (Bayes) Name() string { return "bayes" }
+
+func (b Bayes) Score(ctx context.Context, c Comment) (Score, error) {
+	tokens := Tokenize(c.Content)
+	stats, err := b.DB.GetSpamFilterStats(ctx, tokens)
+	if err != nil {
+		return Score{}, err
+	}
+	if stats.SpamDocs < max(b.MinDocs, 1) || stats.HamDocs < max(b.MinDocs, 1) {
+		return Score{}, nil
+	}
+
+	// log odds of spam with add one smoothing, words never seen in training say nothing either way
+	spamDocs, hamDocs := float64(stats.SpamDocs), float64(stats.HamDocs)
+	logOdds := math.Log(spamDocs / hamDocs)
+	for _, token := range tokens {
+		count, ok := stats.Tokens[token]
+		if !ok {
+			continue
+		}
+		pSpam := (float64(count.Spam) + 1) / (spamDocs + 2)
+		pHam := (float64(count.Ham) + 1) / (hamDocs + 2)
+		logOdds += math.Log(pSpam / pHam)
+	}
+
+	score := Score{Value: 1 / (1 + math.Exp(-logOdds))}
+	if score.Value >= 0.5 {
+		score.Reason = fmt.Sprintf("reads like spam (%.0f%%)", 100*score.Value)
+	}
+	return score, nil
+}
+
+// Train records a moderator's decision about a comment
+func (b Bayes) Train(ctx context.Context, commentID int64, content string, spam bool) error {
+	return b.DB.TrainSpamFilter(ctx, commentID, Tokenize(content), spam)
+}
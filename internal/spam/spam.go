@@ -0,0 +1,127 @@
+// Package spam scores new comments so likely spam can be held for moderation, or hidden, before anyone reads it
+package spam
+
+import (
+	"blogengine/internal/storage"
+	"blogengine/internal/telemetry"
+	"context"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+// Comment is what scorers get to look at, it hasn't been saved yet
+type Comment struct {
+	PostID  int64
+	UserID  int64
+	Content string
+}
+
+// Score is one scorer's opinion of a comment, from 0 for ham to 1 for spam. Reason explains non zero scores to
+// moderators
+type Score struct {
+	Value  float64
+	Reason string
+}
+
+// SpamScorer is a single spam signal. Scorers should be cheap, they run before every comment is saved
+type SpamScorer interface {
+	Name() string
+	Score(ctx context.Context, c Comment) (Score, error)
+}
+
+// Trainer is a scorer that learns from site admins marking comments as spam or approving them
+type Trainer interface {
+	Train(ctx context.Context, commentID int64, content string, spam bool) error
+}
+
+// Verdict is the combined score of a comment and what should happen to it
+type Verdict struct {
+	Score       float64
+	Reasons     string
+	Fingerprint string
+	Flag        storage.CommentStatus // "" lets the blog's comment policy decide
+}
+
+// Classifier runs every scorer over a comment and combines their scores. Comments scoring HoldScore or more wait for
+// a moderator, comments scoring RejectScore or more are filed as spam straight away
+type Classifier struct {
+	Scorers     []SpamScorer
+	Trainers    []Trainer
+	HoldScore   float64
+	RejectScore float64
+	Metrics     *telemetry.Metrics
+	Logger      *slog.Logger
+}
+
+// Classify scores a comment. Signals add up as independent odds, so two weak signals score more than either of them
+// alone but never reach 1 on their own. A failing scorer is logged and left out rather than blocking the comment
+func (c *Classifier) Classify(ctx context.Context, comment Comment) Verdict {
+	ham := 1.0
+	var reasons []string
+	for _, scorer := range c.Scorers {
+		score, err := scorer.Score(ctx, comment)
+		if err != nil {
+			c.Logger.Warn("spam scorer failed", "scorer", scorer.Name(), "error", err)
+			continue
+		}
+		score.Value = min(max(score.Value, 0), 1)
+		c.recordScore(ctx, scorer.Name(), score.Value)
+
+		ham *= 1 - score.Value
+		if score.Value > 0 && score.Reason != "" {
+			reasons = append(reasons, score.Reason)
+		}
+	}
+
+	verdict := Verdict{
+		Score:       1 - ham,
+		Reasons:     strings.Join(reasons, "; "),
+		Fingerprint: Fingerprint(comment.Content),
+	}
+	switch {
+	case verdict.Score >= c.RejectScore:
+		verdict.Flag = storage.CommentSpam
+	case verdict.Score >= c.HoldScore:
+		verdict.Flag = storage.CommentPending
+	}
+	c.recordScore(ctx, "combined", verdict.Score)
+	c.recordVerdict(ctx, verdict)
+
+	return verdict
+}
+
+// Learn trains the trainers on moderated comments, approved ones are ham and spam ones are spam. Rejected comments
+// are off topic or rude rather than spam, so they teach nothing
+func (c *Classifier) Learn(ctx context.Context, comments []*storage.Comment) {
+	for _, comment := range comments {
+		if comment.Status != storage.CommentApproved && comment.Status != storage.CommentSpam {
+			continue
+		}
+		for _, trainer := range c.Trainers {
+			if err := trainer.Train(ctx, comment.ID, comment.Content, comment.Status == storage.CommentSpam); err != nil {
+				c.Logger.Warn("could not train spam filter", "comment_id", comment.ID, "error", err)
+			}
+		}
+	}
+}
+
+func (c *Classifier) recordScore(ctx context.Context, scorer string, value float64) {
+	if c.Metrics == nil {
+		return
+	}
+	c.Metrics.CommentSpamScore.Record(ctx, value, metric.WithAttributes(attribute.String("scorer", scorer)))
+}
+
+func (c *Classifier) recordVerdict(ctx context.Context, v Verdict) {
+	if c.Metrics == nil {
+		return
+	}
+	verdict := "allowed"
+	if v.Flag != "" {
+		verdict = string(v.Flag)
+	}
+	c.Metrics.CommentSpamVerdicts.Add(ctx, 1, metric.WithAttributes(attribute.String("verdict", verdict)))
+}
@@ -0,0 +1,174 @@
+package spam
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"log/slog"
+	"slices"
+	"testing"
+	"time"
+)
+
+// fakeStore answers the store calls of the scorers
+type fakeStore struct {
+	storage.Store
+	posts int64
+	user  *storage.User
+	stats *storage.SpamFilterStats
+}
+
+func (f fakeStore) CountPostsWithFingerprint(context.Context, string, int64, time.Duration) (int64, error) {
+	return f.posts, nil
+}
+
+func (f fakeStore) GetUserByID(context.Context, int64) (*storage.User, error) {
+	return f.user, nil
+}
+
+func (f fakeStore) GetSpamFilterStats(context.Context, []string) (*storage.SpamFilterStats, error) {
+	return f.stats, nil
+}
+
+// fixed always gives the same score
+type fixed float64
+
+func (fixed) Name() string { return "fixed" }
+
+func (f fixed) Score(context.Context, Comment) (Score, error) {
+	return Score{Value: float64(f), Reason: "fixed"}, nil
+}
+
+func TestText(t *testing.T) {
+	t.Parallel()
+
+	long := "Buy cheap watches at our shop, the best prices on the whole internet!"
+	if Fingerprint(long) == "" || Fingerprint(long) != Fingerprint("  buy CHEAP watches at our shop -- the best prices on the whole internet") {
+		t.Fatal("fingerprint: want the same fingerprint regardless of case, punctuation and spacing")
+	}
+	if Fingerprint(long) == Fingerprint("Buy cheap clocks at our shop, the best prices on the whole internet!") {
+		t.Fatal("fingerprint: want different texts to differ")
+	}
+	if fp := Fingerprint("Great post, thanks!"); fp != "" {
+		t.Fatalf("fingerprint: want none for short texts, got %q", fp)
+	}
+
+	want := []string{"cheap", "watches", "https", "shop", "example"}
+	if got := Tokenize("Cheap watches! CHEAP https://shop.example a b"); !slices.Equal(got, want) {
+		t.Fatalf("tokens: want %v, got %v", want, got)
+	}
+}
+
+func TestScorers(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		scorer  SpamScorer
+		content string
+		want    float64
+	}{
+		{name: "no links", scorer: LinkDensity{MaxLinks: 3}, content: "nice post", want: 0},
+		{name: "a link in a sentence", scorer: LinkDensity{MaxLinks: 3}, content: "see https://example.com for all the rest of it", want: 0.25},
+		{name: "only links", scorer: LinkDensity{MaxLinks: 3}, content: "https://example.com", want: 0.9},
+		{name: "too many links", scorer: LinkDensity{MaxLinks: 1}, content: "one www.a.example two www.b.example and more words to dilute them well", want: 0.9},
+		{
+			name:    "text repeated on two posts",
+			scorer:  Repeated{DB: fakeStore{posts: 2}, Window: time.Hour},
+			content: "Buy cheap watches at our shop, the best prices on the whole internet!",
+			want:    0.5,
+		},
+		{name: "short texts are not compared", scorer: Repeated{DB: fakeStore{posts: 2}}, content: "first!", want: 0},
+		{
+			name:   "brand new account",
+			scorer: AccountAge{DB: fakeStore{user: &storage.User{CreatedAt: time.Now()}}, MinAge: 24 * time.Hour},
+			want:   0.4,
+		},
+		{
+			name:   "old account",
+			scorer: AccountAge{DB: fakeStore{user: &storage.User{CreatedAt: time.Now().Add(-48 * time.Hour)}}, MinAge: 24 * time.Hour},
+			want:   0,
+		},
+		{
+			name:    "untrained bayes",
+			scorer:  Bayes{DB: fakeStore{stats: &storage.SpamFilterStats{SpamDocs: 2, HamDocs: 100}}, MinDocs: 10},
+			content: "cheap watches",
+			want:    0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			score, err := tt.scorer.Score(ctx, Comment{PostID: 1, UserID: 1, Content: tt.content})
+			if err != nil {
+				t.Fatalf("could not score: %v", err)
+			}
+			if diff := score.Value - tt.want; diff < -0.01 || diff > 0.01 {
+				t.Fatalf("score: want %.2f, got %.2f", tt.want, score.Value)
+			}
+			if (score.Value > 0) != (score.Reason != "") {
+				t.Fatalf("reason: want one for non zero scores only, got %q for %.2f", score.Reason, score.Value)
+			}
+		})
+	}
+}
+
+func TestBayes(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	b := Bayes{MinDocs: 10, DB: fakeStore{stats: &storage.SpamFilterStats{
+		SpamDocs: 20,
+		HamDocs:  20,
+		Tokens: map[string]storage.SpamTokenCount{
+			"cheap":   {Token: "cheap", Spam: 18, Ham: 1},
+			"watches": {Token: "watches", Spam: 15, Ham: 0},
+			"article": {Token: "article", Spam: 1, Ham: 12},
+			"thanks":  {Token: "thanks", Spam: 2, Ham: 14},
+		},
+	}}}
+
+	spam, err := b.Score(ctx, Comment{Content: "cheap watches here"})
+	if err != nil || spam.Value < 0.95 {
+		t.Fatalf("spammy comment: want a score above 0.95, got %.2f (%v)", spam.Value, err)
+	}
+	ham, err := b.Score(ctx, Comment{Content: "thanks for the article"})
+	if err != nil || ham.Value > 0.05 || ham.Reason != "" {
+		t.Fatalf("hammy comment: want a score below 0.05 and no reason, got %.2f %q (%v)", ham.Value, ham.Reason, err)
+	}
+}
+
+func TestClassifier(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	tests := []struct {
+		name     string
+		scorers  []SpamScorer
+		want     float64
+		wantFlag storage.CommentStatus
+	}{
+		{name: "no scorers", want: 0},
+		{name: "weak signals add up", scorers: []SpamScorer{fixed(0.3), fixed(0.3)}, want: 0.51, wantFlag: storage.CommentPending},
+		{name: "a weak signal alone passes", scorers: []SpamScorer{fixed(0.3), fixed(0)}, want: 0.3},
+		{name: "strong signals reject", scorers: []SpamScorer{fixed(0.9), fixed(0.8)}, want: 0.98, wantFlag: storage.CommentSpam},
+		{name: "scores are clamped", scorers: []SpamScorer{fixed(-1), fixed(2)}, want: 1, wantFlag: storage.CommentSpam},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := Classifier{Scorers: tt.scorers, HoldScore: 0.5, RejectScore: 0.95, Logger: slog.New(slog.DiscardHandler)}
+			verdict := c.Classify(ctx, Comment{Content: "some content"})
+			if diff := verdict.Score - tt.want; diff < -0.01 || diff > 0.01 {
+				t.Fatalf("score: want %.2f, got %.2f", tt.want, verdict.Score)
+			}
+			if verdict.Flag != tt.wantFlag {
+				t.Fatalf("flag: want %q, got %q", tt.wantFlag, verdict.Flag)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package spam
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// minFingerprintLength is the shortest normalised text worth fingerprinting, "thanks, great post!" is said on
+	// many posts by honest readers
+	minFingerprintLength = 40
+	// maxTokens caps the words of a comment the bayes filter looks at
+	maxTokens = 200
+)
+
+// normalise lowercases text and keeps only its letters and digits, single space separated, so that trivially
+// varied copies of a text compare equal
+func normalise(text string) string {
+	return strings.Join(words(text), " ")
+}
+
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Fingerprint identifies the text of a comment regardless of case, punctuation and spacing. Short texts have no
+// fingerprint
+func Fingerprint(text string) string {
+	norm := normalise(text)
+	if utf8.RuneCountInString(norm) < minFingerprintLength {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(norm))
+	return hex.EncodeToString(sum[:])
+}
+
+// Tokenize returns the distinct words of a text the bayes filter learns from, in order of appearance
+func Tokenize(text string) []string {
+	seen := make(map[string]bool)
+	tokens := make([]string, 0)
+	for _, w := range words(text) {
+		if n := utf8.RuneCountInString(w); n < 3 || n > 24 || seen[w] {
+			continue
+		}
+		seen[w] = true
+		tokens = append(tokens, w)
+		if len(tokens) == maxTokens {
+			break
+		}
+	}
+	return tokens
+}
@@ -9,4 +9,6 @@ type Provider interface {
 	Open(ctx context.Context, key string) (io.ReadCloser, error)
 	Save(ctx context.Context, key string, body io.ReadSeeker) error
 	Exists(ctx context.Context, key string) bool
+	// Version identifies the content of the object at key, it changes whenever the object is written
+	Version(ctx context.Context, key string) (string, error)
 }
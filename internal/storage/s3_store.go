@@ -82,6 +82,25 @@ func (s *S3Store) Exists(ctx context.Context, key string) bool {
 	return err == nil
 }
 
+// Version is the ETag of the object, which S3 derives from its body
+func (s *S3Store) Version(ctx context.Context, key string) (string, error) {
+	if key == "" {
+		return "", fmt.Errorf("key cannot be empty")
+	}
+
+	key = strings.TrimSpace(key)
+
+	out, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return aws.ToString(out.ETag), nil
+}
+
 func (s *S3Store) Save(ctx context.Context, key string, body io.ReadSeeker) error {
 
 	ctx, span := s.tracer.Start(ctx, "S3.Save", trace.WithAttributes(attribute.String("s3.key", key)))
@@ -0,0 +1,59 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// RecordAuditEvent appends an event to the audit log, the table refuses to change or delete it afterwards
+func (s *Store) RecordAuditEvent(ctx context.Context, p storage.CreateAuditEventParams) error {
+	if !p.Kind.IsValid() {
+		return fmt.Errorf("%w: %w", ErrAuditLog, ErrAuditKind)
+	}
+	payload := "{}"
+	if p.Payload != nil {
+		var object map[string]any
+		if err := json.Unmarshal(p.Payload, &object); err != nil || object == nil {
+			return fmt.Errorf("%w: %w", ErrAuditLog, ErrAuditPayload)
+		}
+		payload = string(p.Payload)
+	}
+	var actorID *int64
+	if p.ActorID > 0 {
+		actorID = &p.ActorID
+	}
+
+	query := `INSERT INTO audit_events (kind, actor_id, ip, user_agent, trace_id, payload)
+		VALUES (?, ?, ?, ?, ?, ?)`
+
+	if _, err := s.db.ExecContext(ctx, query, p.Kind, actorID, p.IP, p.UserAgent, p.TraceID, payload); err != nil {
+		return fmt.Errorf("%w: %w", ErrAuditLog, mapSqlError(err))
+	}
+	return nil
+}
+
+// GetAuditEvents returns up to limit events matching filter, the latest first
+func (s *Store) GetAuditEvents(ctx context.Context, f storage.AuditFilter, limit int64) ([]*storage.AuditEvent, error) {
+	if limit <= 0 || f.BeforeID < 0 {
+		return nil, fmt.Errorf("%w: %w", ErrAuditLog, ErrLimitOffset)
+	}
+
+	query := `SELECT e.*, u.username AS actor
+		FROM audit_events AS e
+		LEFT JOIN users AS u ON u.id = e.actor_id
+		WHERE (? = '' OR e.kind = ?)
+			AND (? = '' OR u.username = ?)
+			AND (? = '' OR e.ip = ?)
+			AND (? = 0 OR e.id < ?)
+		ORDER BY e.id DESC
+		LIMIT ?`
+	args := []any{f.Kind, f.Kind, f.Actor, f.Actor, f.IP, f.IP, f.BeforeID, f.BeforeID, limit}
+
+	events := make([]*storage.AuditEvent, 0)
+	if err := s.db.SelectContext(ctx, &events, query, args...); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAuditLog, mapSqlError(err))
+	}
+	return events, nil
+}
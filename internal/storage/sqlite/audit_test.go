@@ -0,0 +1,88 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestAuditEvents(t *testing.T) {
+	t.Parallel()
+	store := setupTestStore(t)
+	ctx := context.Background()
+	users := createTestUsers(t, store, 1)
+
+	events := []storage.CreateAuditEventParams{
+		{Kind: storage.AuditLoginFailed, IP: "192.0.2.1", Payload: []byte(`{"username":"nobody"}`)},
+		{Kind: storage.AuditLogin, ActorID: users[0].ID, IP: "192.0.2.1", UserAgent: "curl/8.0", TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", Payload: []byte(`{"method":"password"}`)},
+		{Kind: storage.AuditLogout, ActorID: users[0].ID, IP: "192.0.2.2"},
+	}
+	for _, e := range events {
+		if err := store.RecordAuditEvent(ctx, e); err != nil {
+			t.Fatalf("could not record %s: %v", e.Kind, err)
+		}
+	}
+
+	invalid := []struct {
+		params  storage.CreateAuditEventParams
+		wantErr error
+	}{
+		{params: storage.CreateAuditEventParams{Kind: "made_up"}, wantErr: ErrAuditKind},
+		{params: storage.CreateAuditEventParams{Kind: storage.AuditLogin, Payload: []byte(`[1, 2]`)}, wantErr: ErrAuditPayload},
+		{params: storage.CreateAuditEventParams{Kind: storage.AuditLogin, Payload: []byte(`null`)}, wantErr: ErrAuditPayload},
+	}
+	for _, tt := range invalid {
+		if err := store.RecordAuditEvent(ctx, tt.params); !errors.Is(err, tt.wantErr) {
+			t.Fatalf("%s %s: want %v, got %v", tt.params.Kind, tt.params.Payload, tt.wantErr, err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		filter    storage.AuditFilter
+		wantKinds []storage.AuditKind
+	}{
+		{name: "everything, latest first", wantKinds: []storage.AuditKind{storage.AuditLogout, storage.AuditLogin, storage.AuditLoginFailed}},
+		{name: "by kind", filter: storage.AuditFilter{Kind: storage.AuditLogin}, wantKinds: []storage.AuditKind{storage.AuditLogin}},
+		{name: "by actor", filter: storage.AuditFilter{Actor: users[0].Username}, wantKinds: []storage.AuditKind{storage.AuditLogout, storage.AuditLogin}},
+		{name: "by ip", filter: storage.AuditFilter{IP: "192.0.2.1"}, wantKinds: []storage.AuditKind{storage.AuditLogin, storage.AuditLoginFailed}},
+		{name: "unknown actor", filter: storage.AuditFilter{Actor: "nobody"}},
+	}
+	for _, tt := range tests {
+		got, err := store.GetAuditEvents(ctx, tt.filter, 10)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(got) != len(tt.wantKinds) {
+			t.Fatalf("%s: want %d events, got %d", tt.name, len(tt.wantKinds), len(got))
+		}
+		for i, e := range got {
+			if e.Kind != tt.wantKinds[i] {
+				t.Fatalf("%s: event %d: want %s, got %s", tt.name, i, tt.wantKinds[i], e.Kind)
+			}
+		}
+	}
+
+	// pages follow on from the last event seen
+	page, _ := store.GetAuditEvents(ctx, storage.AuditFilter{}, 2)
+	rest, err := store.GetAuditEvents(ctx, storage.AuditFilter{BeforeID: page[1].ID}, 2)
+	if err != nil || len(rest) != 1 || rest[0].Kind != storage.AuditLoginFailed {
+		t.Fatalf("second page: want the failed login, got %+v (%v)", rest, err)
+	}
+	login := page[1]
+	if login.Actor == nil || *login.Actor != users[0].Username || login.UserAgent != "curl/8.0" || login.Payload != `{"method":"password"}` || login.TraceID == "" {
+		t.Fatalf("login event: got %+v", login)
+	}
+	if _, err := store.GetAuditEvents(ctx, storage.AuditFilter{}, 0); !errors.Is(err, ErrLimitOffset) {
+		t.Fatalf("no limit: want %v, got %v", ErrLimitOffset, err)
+	}
+
+	// the log is append only
+	if _, err := store.db.ExecContext(ctx, `UPDATE audit_events SET kind = 'logout'`); err == nil {
+		t.Fatal("update: want it refused")
+	}
+	if _, err := store.db.ExecContext(ctx, `DELETE FROM audit_events`); err == nil {
+		t.Fatal("delete: want it refused")
+	}
+}
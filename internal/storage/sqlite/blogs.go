@@ -5,6 +5,9 @@ import (
 	"context"
 	"fmt"
 	"regexp"
+	"slices"
+
+	"github.com/jmoiron/sqlx"
 )
 
 const (
@@ -33,34 +36,48 @@ func (s *Store) CreateBlog(ctx context.Context, p storage.CreateBlogParams) (*st
 
 	query := `INSERT INTO blogs (owner_id, slug, title, description, visibility, registration_mode, registration_limit)
 				VALUES (?, ?, ?, ?, ?, ?, ?)
-				RETURNING id, owner_id, slug, title, description, visibility, registration_mode, registration_limit, created_at`
+				RETURNING id, owner_id, slug, title, description, visibility, registration_mode, registration_limit, comment_policy, created_at`
 
 	var blog storage.Blog
-	if err := s.db.GetContext(ctx, &blog, query, p.OwnerID, p.Slug, p.Title, p.Description, p.Visibility, p.RegistrationMode, p.RegistrationLimit); err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrCreateBlog, mapSqlError(err))
+	err := s.WithTx(ctx, func(tx *sqlx.Tx) error {
+		if err := tx.GetContext(ctx, &blog, query, p.OwnerID, p.Slug, p.Title, p.Description, p.Visibility, p.RegistrationMode, p.RegistrationLimit); err != nil {
+			return mapSqlError(err)
+		}
+
+		// the owner is the first member and doesn't take a seat
+		_, err := tx.ExecContext(ctx, `INSERT INTO blog_members (blog_id, user_id, role) VALUES (?, ?, ?)`, blog.ID, p.OwnerID, storage.RoleOwner)
+		return mapSqlError(err)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCreateBlog, err)
 	}
 
 	return &blog, nil
 }
 
-func (s *Store) GetPublicBlogs(ctx context.Context, offset, limit int64) ([]*storage.Blog, error) {
-	if offset < 0 || limit <= 0 {
-		return nil, ErrLimitOffset
+// GetPublicBlogs returns a page of the public blogs, the newest first
+func (s *Store) GetPublicBlogs(ctx context.Context, cursor storage.Cursor, limit int64) ([]*storage.Blog, error) {
+	if err := validCursor(cursor, limit); err != nil {
+		return nil, err
 	}
 
-	query := `SELECT b.id, b.owner_id, b.slug, b.title, b.description, b.visibility, b.registration_mode, b.registration_limit, b.created_at, b.updated_at,
+	where, order, args := keyset(cursor, "b.created_at", "b.id")
+	query := fmt.Sprintf(`SELECT b.id, b.owner_id, b.slug, b.title, b.description, b.visibility, b.registration_mode, b.registration_limit, b.comment_policy, b.created_at, b.updated_at,
 		u.username AS owner_name
 				FROM blogs AS b
 				JOIN users AS u ON u.id = b.owner_id
 				WHERE b.visibility = 'public' AND b.deleted_at IS NULL
-				ORDER BY b.created_at DESC
-				LIMIT ?
-				OFFSET ?`
+				AND %s
+				ORDER BY %s
+				LIMIT ?`, where, order)
 
 	blogs := make([]*storage.Blog, 0)
-	if err := s.db.SelectContext(ctx, &blogs, query, limit, offset); err != nil {
+	if err := s.db.SelectContext(ctx, &blogs, query, append(args, limit)...); err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrAllPublicBlogs, mapSqlError(err))
 	}
+	if cursor.Before {
+		slices.Reverse(blogs)
+	}
 	return blogs, nil
 }
 
@@ -69,7 +86,7 @@ func (s *Store) GetBlogByID(ctx context.Context, blogID int64) (*storage.Blog, e
 		return nil, ErrInvalidBlogID
 	}
 
-	query := `SELECT id, owner_id, slug, title, description, visibility, registration_mode, registration_limit, created_at, updated_at
+	query := `SELECT id, owner_id, slug, title, description, visibility, registration_mode, registration_limit, comment_policy, seats_taken, created_at, updated_at
 				FROM blogs
 				WHERE id = ? AND deleted_at IS NULL
 				LIMIT 1`
@@ -90,7 +107,7 @@ func (s *Store) GetBlogsByUserID(ctx context.Context, ownerID, offset, limit int
 		return nil, fmt.Errorf("%w: %w", ErrBlogsByUserID, ErrInvalidOwnerID)
 	}
 
-	query := `SELECT id, owner_id, slug, title, description, visibility, registration_mode, registration_limit, created_at, updated_at
+	query := `SELECT id, owner_id, slug, title, description, visibility, registration_mode, registration_limit, comment_policy, seats_taken, created_at, updated_at
 				FROM blogs
 				WHERE owner_id = ? AND deleted_at IS NULL
 				ORDER BY created_at DESC
@@ -99,7 +116,7 @@ func (s *Store) GetBlogsByUserID(ctx context.Context, ownerID, offset, limit int
 
 	blogs := make([]*storage.Blog, 0)
 	if err := s.db.SelectContext(ctx, &blogs, query, ownerID, limit, offset); err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrBlogsByUserID, err)
+		return nil, fmt.Errorf("%w: %w", ErrBlogsByUserID, mapSqlError(err))
 	}
 	return blogs, nil
 }
@@ -109,7 +126,7 @@ func (s *Store) GetBlogBySlug(ctx context.Context, slug string) (*storage.Blog,
 		return nil, err
 	}
 
-	query := `SELECT id, owner_id, slug, title, description, visibility, registration_mode, registration_limit, created_at, updated_at
+	query := `SELECT id, owner_id, slug, title, description, visibility, registration_mode, registration_limit, comment_policy, seats_taken, created_at, updated_at
 				FROM blogs
 				WHERE slug = ? AND deleted_at IS NULL
 				LIMIT 1`
@@ -132,7 +149,7 @@ func (s *Store) UpdateBlog(ctx context.Context, p storage.UpdateBlogParams) (*st
 
 	query := `UPDATE blogs SET slug = ?, title = ?, description = ?
 				WHERE id = ? AND owner_id = ? AND deleted_at IS NULL
-				RETURNING id, owner_id, slug, title, description, visibility, registration_mode, registration_limit, created_at, updated_at`
+				RETURNING id, owner_id, slug, title, description, visibility, registration_mode, registration_limit, comment_policy, created_at, updated_at`
 
 	var blog storage.Blog
 	if err := s.db.GetContext(ctx, &blog, query, p.Slug, p.Title, p.Description, p.BlogID, p.OwnerID); err != nil {
@@ -194,6 +211,33 @@ func (s *Store) UpdateBlogRegistration(ctx context.Context, p storage.UpdateBlog
 	return nil
 }
 
+// UpdateBlogCommentPolicy changes which new comments wait for a moderator, comments already posted keep their status
+func (s *Store) UpdateBlogCommentPolicy(ctx context.Context, blogID, ownerID int64, policy storage.CommentPolicy) error {
+	if blogID < 1 || ownerID < 1 {
+		return ErrNegativeIDs
+	}
+	if !policy.IsValid() {
+		return invalid("comment_policy", ErrBlogCommentPolicy)
+	}
+
+	query := `UPDATE blogs SET comment_policy = ?
+				WHERE id = ? AND owner_id = ? AND deleted_at IS NULL`
+
+	result, err := s.db.ExecContext(ctx, query, policy, blogID, ownerID)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUpdateBlogCommentPolicy, mapSqlError(err))
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrUpdateBlogCommentPolicy, mapSqlError(err))
+	}
+	if rows == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
 func (s *Store) DeleteBlog(ctx context.Context, blogID, ownerID int64) error {
 	if blogID < 1 || ownerID < 1 {
 		return ErrNegativeIDs
@@ -219,7 +263,7 @@ func (s *Store) DeleteBlog(ctx context.Context, blogID, ownerID int64) error {
 
 func validateBlogSlug(slug string) error {
 	if len(slug) < minSlugLen || len(slug) > maxSlugLen || !validSlug.MatchString(slug) {
-		return ErrBlogSlug
+		return invalid("slug", ErrBlogSlug)
 	}
 	return nil
 }
@@ -229,37 +273,42 @@ func validateBlog(slug, title string, description *string) error {
 		return err
 	}
 	if len(title) < minTitleLen || len(title) > maxTitleLen {
-		return ErrBlogTitle
+		return invalid("title", ErrBlogTitle)
 	}
 	if description != nil && len(*description) > maxDescriptionLen {
-		return ErrBlogDescription
+		return invalid("description", ErrBlogDescription)
 	}
 	return nil
 }
 
 func validateBlogVisibility(visibility storage.Visibility) error {
 	if !visibility.IsValid() {
-		return ErrBlogVisibility
+		return invalid("visibility", ErrBlogVisibility)
 	}
 	return nil
 }
 
 func validateBlogRegistration(registrationMode storage.RegistrationMode, registrationLimit *int64) error {
 	if !registrationMode.IsValid() {
-		return ErrBlogRegistrationMode
+		return invalid("registration_mode", ErrBlogRegistrationMode)
 	}
 
 	if registrationMode == storage.RegistrationLimited {
 		if registrationLimit == nil {
-			return fmt.Errorf("%w: required for limited registration", ErrBlogRegistrationLimit)
+			return invalid("registration_limit", fmt.Errorf("%w: required for limited registration", ErrBlogRegistrationLimit))
 		}
 		if *registrationLimit < 1 || *registrationLimit > maxRegistrationQueue {
-			return fmt.Errorf("%w: min 1, max %d", ErrBlogRegistrationLimit, maxRegistrationQueue)
+			return invalid("registration_limit", fmt.Errorf("%w: min 1, max %d", ErrBlogRegistrationLimit, maxRegistrationQueue))
 		}
 	}
 
 	if registrationMode != storage.RegistrationLimited && registrationLimit != nil {
-		return ErrRegistrationValuesForMode
+		return invalid("registration_limit", ErrRegistrationValuesForMode)
 	}
 	return nil
 }
+
+// invalid tags a validation error with the field it concerns so forms can show it inline
+func invalid(field string, err error) error {
+	return &storage.ValidationError{Field: field, Err: err}
+}
@@ -124,42 +124,44 @@ func TestGetPublicBlogs(t *testing.T) {
 		visibility        storage.Visibility
 		registrationMode  storage.RegistrationMode
 		registrationLimit *int64
-		offset            int64
+		cursor            storage.Cursor
 		limit             int64
 		wantLen           int
 		wantErr           error
 	}{
 		{
-			name:   "nominal",
-			offset: 0, limit: 10,
+			name:              "nominal",
+			limit:             10,
 			registrationMode:  storage.RegistrationOpen,
 			registrationLimit: nil,
 			wantLen:           2,
 			wantErr:           nil,
 		},
 		{
-			name:   "invalid limit",
-			offset: 0, limit: -5,
+			name:              "invalid limit",
+			limit:             -5,
 			registrationMode:  storage.RegistrationOpen,
 			registrationLimit: nil,
 			wantLen:           2,
-			wantErr:           ErrLimitOffset,
+			wantErr:           ErrLimitCursor,
 		},
 		{
-			name:   "invalid offset",
-			offset: -1, limit: 10,
+			name:              "invalid cursor",
+			cursor:            storage.Cursor{ID: -1},
+			limit:             10,
 			registrationMode:  storage.RegistrationOpen,
 			registrationLimit: nil,
 			wantLen:           2,
-			wantErr:           ErrLimitOffset,
+			wantErr:           ErrLimitCursor,
 		},
 		{
-			name:   "invalid limit and offset",
-			offset: -1, limit: -5,
+			name:              "invalid limit and cursor",
+			cursor:            storage.Cursor{ID: -1},
+			limit:             -5,
 			registrationMode:  storage.RegistrationOpen,
 			registrationLimit: nil,
 			wantLen:           2,
-			wantErr:           ErrLimitOffset,
+			wantErr:           ErrLimitCursor,
 		},
 	}
 
@@ -210,7 +212,7 @@ func TestGetPublicBlogs(t *testing.T) {
 			}
 			_, _ = store.CreateBlog(ctx, createBlogParamsPriv3)
 
-			blogs, err := store.GetPublicBlogs(ctx, tt.offset, tt.limit)
+			blogs, err := store.GetPublicBlogs(ctx, tt.cursor, tt.limit)
 			if !errors.Is(err, tt.wantErr) {
 				t.Fatalf("error: want %s, got %s", tt.wantErr, err)
 			}
@@ -1140,3 +1142,25 @@ func TestValidateBlogRegistration(t *testing.T) {
 		})
 	}
 }
+
+func TestBlogValidationErrorField(t *testing.T) {
+	t.Parallel()
+	store, user, _ := setupTestBlog(t)
+
+	params := storage.CreateBlogParams{
+		OwnerID:          user.ID,
+		Slug:             "a-valid-slug",
+		Title:            "tiny",
+		Visibility:       storage.VisibilityPublic,
+		RegistrationMode: storage.RegistrationOpen,
+	}
+	_, err := store.CreateBlog(context.Background(), params)
+
+	var validationErr *storage.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("errors: want a validation error, got %v", err)
+	}
+	if validationErr.Field != "title" || !errors.Is(err, ErrBlogTitle) {
+		t.Fatalf("validation error: want title / %s, got %s / %s", ErrBlogTitle, validationErr.Field, validationErr.Err)
+	}
+}
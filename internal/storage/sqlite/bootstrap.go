@@ -3,8 +3,10 @@ package sqlite
 import (
 	"blogengine/internal/storage"
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 
 	"golang.org/x/crypto/bcrypt"
@@ -12,7 +14,8 @@ import (
 
 const (
 	defaultAdminUsername = "admin"
-	defaultAdminPassword = "adminadmin"
+	// legacyAdminPassword is what the bootstrap used to give every admin, deployments that kept it must change it
+	legacyAdminPassword = "adminadmin"
 )
 
 var (
@@ -20,38 +23,77 @@ var (
 	ErrPasswordHash      = errors.New("could not generate password hash")
 	ErrCreateAdminUser   = errors.New("could not create admin user")
 	ErrCreateDefaultBlog = errors.New("could not create default blog")
+	ErrFlagAdminPassword = errors.New("could not flag admin password")
 )
 
-// Bootstrap ensure the system has at least one admin and one default blog
-func (s *Store) Bootstrap(ctx context.Context, logger *slog.Logger) error {
+// Bootstrap ensure the system has at least one admin and one default blog. The admin gets password, or a random one
+// written this once to console when it is empty, and must change it at the first login. An admin still on the legacy
+// default password is held to change it as well. The password never goes through logger, logs are shipped elsewhere.
+// A deleted admin account is left deleted while other admins remain, and brought back when none do
+func (s *Store) Bootstrap(ctx context.Context, logger *slog.Logger, password string, console io.Writer) error {
 	logger.Info("bootstrapping database...")
 
-	adminUser, err := s.getOrCreateAdminUser(ctx, logger)
+	adminUser, err := s.getOrCreateAdminUser(ctx, logger, password, console)
 	if err != nil {
 		return err
 	}
-	logger.Info("admin user created", "user", adminUser.Username)
+	logger.Info("admin user ready", "user", adminUser.Username)
 	return nil
 }
 
-func (s *Store) getOrCreateAdminUser(ctx context.Context, logger *slog.Logger) (*storage.User, error) {
+func (s *Store) getOrCreateAdminUser(ctx context.Context, logger *slog.Logger, password string, console io.Writer) (*storage.User, error) {
 	u, err := s.GetUserByUsername(ctx, defaultAdminUsername)
 	if err != nil {
 		switch {
 		case errors.Is(err, storage.ErrNotFound):
+			other := &storage.User{}
+			query := `SELECT * FROM users WHERE is_admin = 1 AND deleted_at IS NULL ORDER BY id LIMIT 1`
+			if err := s.db.GetContext(ctx, other, query); err == nil {
+				return other, nil
+			} else if err := mapSqlError(err); !errors.Is(err, storage.ErrNotFound) {
+				return nil, fmt.Errorf("%w: %w", ErrCreateAdminUser, err)
+			}
+
 			logger.Info("no admin user found, creating default 'admin' user")
-			hash, err := bcrypt.GenerateFromPassword([]byte(defaultAdminPassword), bcrypt.DefaultCost)
+			generated := password == ""
+			if generated {
+				password = rand.Text()
+			}
+			hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
 			if err != nil {
 				return nil, fmt.Errorf("%w: %w", ErrPasswordHash, err)
 			}
-			u, err = s.CreateUser(ctx, defaultAdminUsername, string(hash))
-			if err != nil {
-				return nil, fmt.Errorf("%w: %w", ErrCreateAdminUser, err)
+
+			// the username stays taken by a deleted admin, that row is brought back rather than inserted again
+			query = `INSERT INTO users (username, password_hash, must_change_password, is_admin)
+				VALUES (?, ?, 1, 1)
+				ON CONFLICT DO UPDATE SET password_hash = excluded.password_hash, must_change_password = 1, is_admin = 1,
+					deleted_at = NULL
+				RETURNING *`
+			u = &storage.User{}
+			if err := s.db.GetContext(ctx, u, query, defaultAdminUsername, string(hash)); err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrCreateAdminUser, mapSqlError(err))
+			}
+
+			if generated {
+				// the only place the password is ever shown, it has to be changed at the first login anyway
+				fmt.Fprintf(console, "SYSTEM BOOTSTRAP: created user %q with the generated password %s (it is not shown again)\n", defaultAdminUsername, password)
+				logger.Warn("SYSTEM BOOTSTRAP: created admin user with a generated password, written to the console", "username", defaultAdminUsername)
+			} else {
+				logger.Warn("SYSTEM BOOTSTRAP: created admin user with BOOTSTRAP_ADMIN_PASSWORD", "username", defaultAdminUsername)
 			}
-			logger.Warn("SYSTEM BOOTSTRAP: created default admin user. Change the password NOW!!!", "username", defaultAdminUsername, "password", defaultAdminPassword)
 		default:
 			return nil, err
 		}
+		return u, nil
+	}
+
+	if !u.MustChangePassword && bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(legacyAdminPassword)) == nil {
+		if _, err := s.db.ExecContext(ctx, `UPDATE users SET must_change_password = 1 WHERE id = ?`, u.ID); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrFlagAdminPassword, mapSqlError(err))
+		}
+		u.MustChangePassword = true
+		logger.Warn("SYSTEM BOOTSTRAP: admin user still has the default password, it must be changed at the next request", "username", defaultAdminUsername)
 	}
 	return u, nil
 }
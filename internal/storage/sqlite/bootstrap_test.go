@@ -0,0 +1,165 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestBootstrap(t *testing.T) {
+	t.Parallel()
+
+	generatedPassword := regexp.MustCompile(`generated password (\S+)`)
+
+	tests := []struct {
+		name     string
+		password string
+	}{
+		{name: "configured password", password: "correct horse battery"},
+		{name: "generated password"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			store := setupTestStore(t)
+			ctx := context.Background()
+
+			var logs, console bytes.Buffer
+			if err := store.Bootstrap(ctx, slog.New(slog.NewTextHandler(&logs, nil)), tt.password, &console); err != nil {
+				t.Fatalf("could not bootstrap: %v", err)
+			}
+
+			password := tt.password
+			if m := generatedPassword.FindStringSubmatch(console.String()); password == "" {
+				if m == nil {
+					t.Fatalf("generated password: want it on the console, got %s", console.String())
+				}
+				password = m[1]
+			} else if m != nil || strings.Contains(console.String(), password) {
+				t.Fatalf("configured password: want it kept off the console, got %s", console.String())
+			}
+			if strings.Contains(logs.String(), password) {
+				t.Fatalf("password: want it kept out of the logs, got %s", logs.String())
+			}
+
+			admin, err := store.GetUserByUsername(ctx, defaultAdminUsername)
+			if err != nil {
+				t.Fatalf("could not get admin: %v", err)
+			}
+			if err := bcrypt.CompareHashAndPassword([]byte(admin.PasswordHash), []byte(password)); err != nil || password == legacyAdminPassword {
+				t.Fatalf("admin password: want %q, got a mismatch (%v)", password, err)
+			}
+			if !admin.MustChangePassword || !admin.IsAdmin {
+				t.Fatalf("admin: want must_change_password and is_admin set, got %+v", admin)
+			}
+
+			// a second bootstrap leaves the admin alone
+			if err := store.Bootstrap(ctx, slog.New(slog.DiscardHandler), "another password", io.Discard); err != nil {
+				t.Fatalf("could not bootstrap again: %v", err)
+			}
+
+			if err := store.ChangeUserPassword(ctx, admin.ID, gen60CharString()); err != nil {
+				t.Fatalf("could not change password: %v", err)
+			}
+			if admin, err = store.GetUserByID(ctx, admin.ID); err != nil || admin.MustChangePassword {
+				t.Fatalf("after a password change: want must_change_password cleared, got %+v (%v)", admin, err)
+			}
+		})
+	}
+}
+
+func TestBootstrapLegacyPassword(t *testing.T) {
+	t.Parallel()
+	store := setupTestStore(t)
+	ctx := context.Background()
+
+	// an admin made by an older bootstrap, with the default password and no flag
+	hash, err := bcrypt.GenerateFromPassword([]byte(legacyAdminPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("could not hash password: %v", err)
+	}
+	admin, err := store.CreateUser(ctx, defaultAdminUsername, string(hash))
+	if err != nil {
+		t.Fatalf("could not create admin: %v", err)
+	}
+
+	if err := store.Bootstrap(ctx, slog.New(slog.DiscardHandler), "", io.Discard); err != nil {
+		t.Fatalf("could not bootstrap: %v", err)
+	}
+	if admin, err = store.GetUserByID(ctx, admin.ID); err != nil || !admin.MustChangePassword {
+		t.Fatalf("legacy password: want must_change_password set, got %+v (%v)", admin, err)
+	}
+
+	// once changed, restarts leave the admin alone
+	if err := store.ChangeUserPassword(ctx, admin.ID, gen60CharString()); err != nil {
+		t.Fatalf("could not change password: %v", err)
+	}
+	if err := store.Bootstrap(ctx, slog.New(slog.DiscardHandler), "", io.Discard); err != nil {
+		t.Fatalf("could not bootstrap again: %v", err)
+	}
+	if admin, err = store.GetUserByID(ctx, admin.ID); err != nil || admin.MustChangePassword {
+		t.Fatalf("changed password: want must_change_password cleared, got %+v (%v)", admin, err)
+	}
+}
+
+func TestBootstrapDeletedAdmin(t *testing.T) {
+	t.Parallel()
+	store := setupTestStore(t)
+	ctx := context.Background()
+
+	if err := store.Bootstrap(ctx, slog.New(slog.DiscardHandler), "", io.Discard); err != nil {
+		t.Fatalf("could not bootstrap: %v", err)
+	}
+	admin, err := store.GetUserByUsername(ctx, defaultAdminUsername)
+	if err != nil {
+		t.Fatalf("could not get admin: %v", err)
+	}
+	if err := store.DeleteUser(ctx, admin.ID); !errors.Is(err, storage.ErrLastAdmin) {
+		t.Fatalf("last admin: want %v, got %v", storage.ErrLastAdmin, err)
+	}
+
+	// with another admin around the default one can go, and restarts leave it gone
+	other, err := store.CreateUser(ctx, "other_admin", gen60CharString())
+	if err != nil {
+		t.Fatalf("could not create user: %v", err)
+	}
+	if _, err := store.db.ExecContext(ctx, `UPDATE users SET is_admin = 1 WHERE id = ?`, other.ID); err != nil {
+		t.Fatalf("could not grant admin: %v", err)
+	}
+	if err := store.DeleteUser(ctx, admin.ID); err != nil {
+		t.Fatalf("could not delete admin: %v", err)
+	}
+	if err := store.Bootstrap(ctx, slog.New(slog.DiscardHandler), "", io.Discard); err != nil {
+		t.Fatalf("could not bootstrap after deleting the admin: %v", err)
+	}
+	if _, err := store.GetUserByUsername(ctx, defaultAdminUsername); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("deleted admin with another admin left: want it kept deleted, got %v", err)
+	}
+
+	// with no admin left at all the default one comes back, with a new password to change
+	if _, err := store.db.ExecContext(ctx, `UPDATE users SET deleted_at = CURRENT_TIMESTAMP WHERE id = ?`, other.ID); err != nil {
+		t.Fatalf("could not delete other admin: %v", err)
+	}
+	if err := store.Bootstrap(ctx, slog.New(slog.DiscardHandler), "correct horse battery", io.Discard); err != nil {
+		t.Fatalf("could not bootstrap without admins: %v", err)
+	}
+	revived, err := store.GetUserByUsername(ctx, defaultAdminUsername)
+	if err != nil {
+		t.Fatalf("no admin left: want the default admin back, got %v", err)
+	}
+	if revived.ID != admin.ID || !revived.IsAdmin || !revived.MustChangePassword {
+		t.Fatalf("revived admin: want id %d, is_admin and must_change_password, got %+v", admin.ID, revived)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(revived.PasswordHash), []byte("correct horse battery")); err != nil {
+		t.Fatalf("revived admin password: want the configured one, got %v", err)
+	}
+}
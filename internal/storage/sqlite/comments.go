@@ -7,12 +7,13 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/jmoiron/sqlx"
 	"modernc.org/sqlite"
 	sqlite3 "modernc.org/sqlite/lib"
 )
 
 func (s *Store) GetCommentByID(ctx context.Context, commentID int64) (*storage.Comment, error) {
-	query := `SELECT c.id, c.post_id, c.content, c.created_at, c.deleted_at, COALESCE(u.username, 'deleted user') as author_name
+	query := `SELECT c.id, c.post_id, c.user_id, c.parent_id, c.depth, c.content, c.status, c.created_at, c.edited_at, c.deleted_at, COALESCE(u.username, 'deleted user') as author_name
 		FROM comments AS c
 		LEFT JOIN users AS u ON c.user_id = u.id
 		WHERE c.id = ? AND c.deleted_at IS NULL
@@ -26,76 +27,321 @@ func (s *Store) GetCommentByID(ctx context.Context, commentID int64) (*storage.C
 	return &comment, nil
 }
 
-func (s *Store) GetCommentsForPost(ctx context.Context, postID, offset, limit int64) ([]*storage.Comment, error) {
-	query := `SELECT c.id, c.post_id, c.content, c.created_at, c.deleted_at, COALESCE(u.username, 'deleted user') as author_name
-		FROM comments AS c
+// GetCommentsForPost returns a page of threads, newest first, each top level comment followed by its replies in
+// conversation order. limit counts threads, not comments, and the cursor is the position of a top level comment.
+// Readers see the approved comments and the pending ones viewerID wrote, 0 for anonymous readers. Deleted, removed
+// and hidden comments are only kept, without their content or author, when visible replies hang from them
+func (s *Store) GetCommentsForPost(ctx context.Context, postID, viewerID int64, cursor storage.Cursor, limit int64) ([]*storage.Comment, error) {
+	if err := validCursor(cursor, limit); err != nil {
+		return nil, err
+	}
+
+	// pages before the cursor pick their roots oldest first, the threads are still sorted newest first
+	where, order, args := keyset(cursor, "c.created_at", "c.id")
+	query := fmt.Sprintf(`WITH RECURSIVE visible(id) AS (
+			SELECT id FROM comments
+			WHERE post_id = ? AND deleted_at IS NULL
+				AND (status = 'approved' OR (status = 'pending' AND user_id = ?))
+		),
+		live(id, parent_id) AS (
+			-- visible comments and every ancestor they need to stay in their thread
+			SELECT c.id, c.parent_id FROM comments AS c JOIN visible AS v ON v.id = c.id
+			UNION
+			SELECT c.id, c.parent_id FROM comments AS c JOIN live AS l ON c.id = l.parent_id
+		),
+		roots AS (
+			SELECT c.id, c.created_at
+			FROM comments AS c
+			JOIN live AS l ON l.id = c.id
+			WHERE c.parent_id IS NULL AND %s
+			ORDER BY %s
+			LIMIT ?
+		),
+		thread(id, root_id, path) AS (
+			SELECT id, id, printf('%%010d', id) FROM roots
+			UNION ALL
+			SELECT l.id, t.root_id, t.path || '.' || printf('%%010d', l.id)
+			FROM live AS l
+			JOIN thread AS t ON l.parent_id = t.id
+		)
+		SELECT c.id, c.post_id, c.parent_id, c.depth, c.status, c.created_at, c.updated_at, c.edited_at, c.deleted_at,
+			CASE WHEN v.id IS NOT NULL THEN c.user_id END AS user_id,
+			CASE WHEN v.id IS NOT NULL THEN c.content ELSE '' END AS content,
+			CASE WHEN v.id IS NOT NULL THEN COALESCE(u.username, 'deleted user') ELSE '' END AS author_name
+		FROM thread AS t
+		JOIN comments AS c ON c.id = t.id
+		JOIN roots AS r ON r.id = t.root_id
+		LEFT JOIN visible AS v ON v.id = c.id
 		LEFT JOIN users AS u ON c.user_id = u.id
-		WHERE c.post_id = ? AND c.deleted_at IS NULL
-		ORDER BY c.created_at DESC
-		LIMIT ?
-		OFFSET ?`
+		ORDER BY %s DESC, r.id DESC, t.path`, where, order, sortTime("r.created_at"))
 
+	args = append(append([]any{postID, viewerID}, args...), limit)
 	var comments []*storage.Comment
-	if err := s.db.SelectContext(ctx, &comments, query, postID, limit, offset); err != nil {
+	if err := s.db.SelectContext(ctx, &comments, query, args...); err != nil {
 		return nil, fmt.Errorf("failed to get comments: %w", mapSqlError(err))
 	}
 
 	return comments, nil
 }
 
-func (s *Store) GetCommentsForUserID(ctx context.Context, userID, offset, limit int64) ([]*storage.Comment, error) {
-	query := `SELECT c.id, c.post_id, c.content, c.created_at, c.deleted_at, COALESCE(u.username, 'deleted user') as author_name
+// GetCommentsForUserID returns the approved comments of the user that anyone can read, the latest first: comments on
+// posts that are unlisted, locked or unpublished, or on private blogs, stay off their profile
+func (s *Store) GetCommentsForUserID(ctx context.Context, userID, offset, limit int64) ([]*storage.UserComment, error) {
+	if offset < 0 || limit <= 0 {
+		return nil, fmt.Errorf("%w: %w", ErrUserComments, ErrLimitOffset)
+	}
+
+	query := `SELECT c.id, c.post_id, c.user_id, c.parent_id, c.depth, c.content, c.status, c.created_at, c.edited_at,
+			u.username AS author_name,
+			b.slug AS blog_slug, b.title AS blog_title, COALESCE(p.slug, p.public_id) AS post_slug, p.title AS post_title
 		FROM comments AS c
-		LEFT JOIN users AS u ON c.user_id = u.id
-		WHERE c.user_id = ? AND c.deleted_at IS NULL
-		ORDER BY c.created_at DESC
+		JOIN users AS u ON u.id = c.user_id
+		JOIN posts AS p ON p.id = c.post_id
+		JOIN blogs AS b ON b.id = p.blog_id
+		WHERE c.user_id = ? AND c.status = 'approved' AND c.deleted_at IS NULL
+			AND p.deleted_at IS NULL
+			AND p.is_listed = 1
+			AND p.requires_auth = 0
+			AND p.is_encrypted = 0
+			AND p.published_at IS NOT NULL
+			AND p.published_at <= CURRENT_TIMESTAMP
+			AND b.deleted_at IS NULL
+			AND b.visibility = 'public'
+		ORDER BY c.created_at DESC, c.id DESC
 		LIMIT ?
 		OFFSET ?`
 
-	var comments []*storage.Comment
+	comments := make([]*storage.UserComment, 0)
 	if err := s.db.SelectContext(ctx, &comments, query, userID, limit, offset); err != nil {
-		return nil, fmt.Errorf("failed to get comments: %w", mapSqlError(err))
+		return nil, fmt.Errorf("%w: %w", ErrUserComments, mapSqlError(err))
 	}
 
 	return comments, nil
 }
 
-func (s *Store) CreateComment(ctx context.Context, postID, userID int64, content string) (*storage.Comment, error) {
-	if err := validateContent(content); err != nil {
+// CreateComment adds a top level comment, or a reply when ParentID is set. The parent must be an approved comment of
+// the same post that is neither deleted nor at storage.MaxCommentDepth. The comment is pending, approved or spam
+// depending on the comment policy of the blog and the spam flag, see newCommentStatus
+func (s *Store) CreateComment(ctx context.Context, p storage.CreateCommentParams) (*storage.Comment, error) {
+	if err := validateContent(p.Content); err != nil {
 		return nil, err
 	}
+	if p.Flag != "" && p.Flag != storage.CommentPending && p.Flag != storage.CommentSpam {
+		return nil, invalid("status", ErrCommentFlag)
+	}
 
-	query := `INSERT INTO comments (post_id, user_id, content)
-		VALUES (?, ?, ?)
-		RETURNING id, post_id, content, created_at, 
+	status, err := s.newCommentStatus(ctx, p.PostID, p.UserID, p.Flag)
+	if err != nil {
+		return nil, err
+	}
+
+	var fingerprint *string
+	if p.Fingerprint != "" {
+		fingerprint = &p.Fingerprint
+	}
+
+	if p.ParentID == nil {
+		query := `INSERT INTO comments (post_id, user_id, content, status, fingerprint, spam_score, spam_reasons)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			RETURNING id, post_id, user_id, parent_id, depth, content, status, spam_score, spam_reasons, created_at,
+				(SELECT username FROM users WHERE id = ?) as author_name`
+
+		var comment storage.Comment
+		err := s.db.GetContext(ctx, &comment, query, p.PostID, p.UserID, p.Content, status, fingerprint, p.SpamScore, p.SpamReasons, p.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("could not create comment: %w", mapSqlError(err))
+		}
+
+		return &comment, nil
+	}
+
+	query := `INSERT INTO comments (post_id, user_id, content, status, fingerprint, spam_score, spam_reasons, parent_id, depth)
+		SELECT post_id, ?, ?, ?, ?, ?, ?, id, depth + 1
+		FROM comments
+		WHERE id = ? AND post_id = ? AND deleted_at IS NULL AND status = 'approved' AND depth < ?
+		RETURNING id, post_id, user_id, parent_id, depth, content, status, spam_score, spam_reasons, created_at,
 			(SELECT username FROM users WHERE id = ?) as author_name`
 
 	var comment storage.Comment
-	if err := s.db.GetContext(ctx, &comment, query, postID, userID, content, userID); err != nil {
-		return nil, fmt.Errorf("could not create comment: %w", mapSqlError(err))
+	err = s.db.GetContext(ctx, &comment, query, p.UserID, p.Content, status, fingerprint, p.SpamScore, p.SpamReasons,
+		*p.ParentID, p.PostID, storage.MaxCommentDepth, p.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, invalid("parent_id", ErrCommentParent)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not create reply: %w", mapSqlError(err))
 	}
 
 	return &comment, nil
 }
 
-func (s *Store) UpdateComment(ctx context.Context, commentID, userID int64, content string) (*storage.Comment, error) {
-	if err := validateContent(content); err != nil {
+// newCommentStatus decides the status of a new comment of userID on postID. Owners and editors of the blog are never
+// held, a spam flag comes next and the comment policy of the blog last: first_time holds users until one of their
+// comments on the blog was approved
+func (s *Store) newCommentStatus(ctx context.Context, postID, userID int64, flag storage.CommentStatus) (storage.CommentStatus, error) {
+	query := `SELECT CASE
+			WHEN EXISTS (
+				SELECT 1 FROM blog_members AS m
+				WHERE m.blog_id = b.id AND m.user_id = ? AND m.role IN ('owner', 'editor')
+			) THEN 'approved'
+			WHEN ? <> '' THEN ?
+			WHEN b.comment_policy = 'auto' THEN 'approved'
+			WHEN b.comment_policy = 'first_time' AND EXISTS (
+				SELECT 1 FROM comments AS c
+				JOIN posts AS cp ON cp.id = c.post_id
+				WHERE cp.blog_id = b.id AND c.user_id = ? AND c.status = 'approved'
+			) THEN 'approved'
+			ELSE 'pending'
+		END
+		FROM posts AS p
+		JOIN blogs AS b ON b.id = p.blog_id
+		WHERE p.id = ?`
+
+	var status storage.CommentStatus
+	err := s.db.GetContext(ctx, &status, query, userID, flag, flag, userID, postID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// no blog, no policy to apply, the insert decides whether the post is good enough
+		if flag != "" {
+			return flag, nil
+		}
+		return storage.CommentApproved, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not apply comment policy: %w", mapSqlError(err))
+	}
+
+	return status, nil
+}
+
+// UpdateComment saves an edit of the author. Saving the same content changes nothing else, new content is held for
+// review again as a new comment would be: on blogs whose policy is all or first_time an approved comment goes back to
+// pending, and a spam flag applies whatever the policy. Owners and editors of the blog are never held
+func (s *Store) UpdateComment(ctx context.Context, p storage.UpdateCommentParams) (*storage.Comment, error) {
+	if err := validateContent(p.Content); err != nil {
 		return nil, err
 	}
+	if p.Flag != "" && p.Flag != storage.CommentPending && p.Flag != storage.CommentSpam {
+		return nil, invalid("status", ErrCommentFlag)
+	}
 
-	query := `UPDATE comments SET content = ?, updated_at = CURRENT_TIMESTAMP
-		WHERE id = ? AND user_id = ? AND deleted_at IS NULL
-		RETURNING id, post_id, user_id, content, created_at, updated_at,
-		(SELECT username FROM users WHERE id = ?) as author_name`
+	var fingerprint *string
+	if p.Fingerprint != "" {
+		fingerprint = &p.Fingerprint
+	}
+
+	// the previous content goes to comment_revisions through the trg_comments_revisions trigger
+	// removed comments are not coming back through an edit
+	// every expression reads the row as it was before the update, content included
+	query := `UPDATE comments SET content = ?, updated_at = CURRENT_TIMESTAMP,
+			edited_at = CASE WHEN content <> ? THEN CURRENT_TIMESTAMP ELSE edited_at END,
+			status = CASE
+				WHEN content = ? THEN status
+				WHEN EXISTS (
+					SELECT 1 FROM posts AS p
+					JOIN blog_members AS m ON m.blog_id = p.blog_id
+					WHERE p.id = comments.post_id AND m.user_id = comments.user_id AND m.role IN ('owner', 'editor')
+				) THEN status
+				WHEN ? <> '' THEN ?
+				WHEN status = 'approved' AND (
+					SELECT b.comment_policy FROM posts AS p
+					JOIN blogs AS b ON b.id = p.blog_id
+					WHERE p.id = comments.post_id
+				) IN ('all', 'first_time') THEN 'pending'
+				ELSE status
+			END,
+			fingerprint = CASE WHEN content <> ? THEN ? ELSE fingerprint END,
+			spam_score = CASE WHEN content <> ? THEN ? ELSE spam_score END,
+			spam_reasons = CASE WHEN content <> ? THEN ? ELSE spam_reasons END
+		WHERE id = ? AND user_id = ? AND deleted_at IS NULL AND status IN ('pending', 'approved')
+		RETURNING id, post_id, user_id, parent_id, depth, content, status, spam_score, spam_reasons, created_at,
+			updated_at, edited_at, (SELECT username FROM users WHERE id = ?) as author_name`
 
 	var comment storage.Comment
-	if err := s.db.GetContext(ctx, &comment, query, content, commentID, userID, userID); err != nil {
+	err := s.db.GetContext(ctx, &comment, query, p.Content, p.Content, p.Content, p.Flag, p.Flag,
+		p.Content, fingerprint, p.Content, p.SpamScore, p.Content, p.SpamReasons, p.CommentID, p.UserID, p.UserID)
+	if err != nil {
 		return nil, fmt.Errorf("could not update comment: %w", mapSqlError(err))
 	}
 
 	return &comment, nil
 }
 
+// GetCommentRevisionsForPost returns the prior versions of the comments of a post, oldest first, deleted comments
+// keep theirs private
+func (s *Store) GetCommentRevisionsForPost(ctx context.Context, postID int64) ([]*storage.CommentRevision, error) {
+	query := `SELECT r.id, r.comment_id, r.content, r.written_at, r.replaced_at
+		FROM comment_revisions AS r
+		JOIN comments AS c ON c.id = r.comment_id
+		WHERE c.post_id = ? AND c.deleted_at IS NULL
+		ORDER BY r.comment_id, r.id`
+
+	var revisions []*storage.CommentRevision
+	if err := s.db.SelectContext(ctx, &revisions, query, postID); err != nil {
+		return nil, fmt.Errorf("failed to get comment revisions: %w", mapSqlError(err))
+	}
+
+	return revisions, nil
+}
+
+// GetCommentsForModeration returns the comments with status on the blogs moderatorID owns or edits, oldest first
+func (s *Store) GetCommentsForModeration(ctx context.Context, moderatorID int64, status storage.CommentStatus, offset, limit int64) ([]*storage.PendingComment, error) {
+	if offset < 0 || limit <= 0 {
+		return nil, fmt.Errorf("%w: %w", ErrPendingComments, ErrLimitOffset)
+	}
+	if !status.IsValid() {
+		return nil, invalid("status", ErrCommentStatus)
+	}
+
+	query := `SELECT c.id, c.post_id, c.user_id, c.parent_id, c.depth, c.content, c.status, c.spam_score, c.spam_reasons,
+			c.created_at, c.edited_at, COALESCE(u.username, 'deleted user') AS author_name,
+			b.slug AS blog_slug, b.title AS blog_title, COALESCE(p.slug, p.public_id) AS post_slug, p.title AS post_title
+		FROM comments AS c
+		JOIN posts AS p ON p.id = c.post_id
+		JOIN blogs AS b ON b.id = p.blog_id
+		JOIN blog_members AS m ON m.blog_id = b.id AND m.user_id = ? AND m.role IN ('owner', 'editor')
+		LEFT JOIN users AS u ON c.user_id = u.id
+		WHERE c.status = ? AND c.deleted_at IS NULL AND p.deleted_at IS NULL AND b.deleted_at IS NULL
+		ORDER BY c.created_at, c.id
+		LIMIT ?
+		OFFSET ?`
+
+	comments := make([]*storage.PendingComment, 0)
+	if err := s.db.SelectContext(ctx, &comments, query, moderatorID, status, limit, offset); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPendingComments, mapSqlError(err))
+	}
+
+	return comments, nil
+}
+
+// ModerateComments sets the status of the comments moderatorID owns or edits the blog of, others are skipped. It
+// returns the comments that were changed
+func (s *Store) ModerateComments(ctx context.Context, moderatorID int64, commentIDs []int64, status storage.CommentStatus) ([]*storage.Comment, error) {
+	if !status.IsValid() || status == storage.CommentPending {
+		return nil, invalid("status", ErrCommentStatus)
+	}
+	comments := make([]*storage.Comment, 0)
+	if len(commentIDs) == 0 {
+		return comments, nil
+	}
+
+	query, args, err := sqlx.In(`UPDATE comments SET status = ?
+		WHERE id IN (?) AND deleted_at IS NULL AND post_id IN (
+			SELECT p.id FROM posts AS p
+			JOIN blog_members AS m ON m.blog_id = p.blog_id
+			WHERE m.user_id = ? AND m.role IN ('owner', 'editor')
+		)
+		RETURNING id, post_id, user_id, parent_id, depth, content, status, spam_score, spam_reasons, created_at`, status, commentIDs, moderatorID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrModerateComments, err)
+	}
+
+	if err := s.db.SelectContext(ctx, &comments, s.db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrModerateComments, mapSqlError(err))
+	}
+
+	return comments, nil
+}
+
 func (s *Store) DeleteComment(ctx context.Context, commentID, userID int64) error {
 	query := `UPDATE comments SET deleted_at = CURRENT_TIMESTAMP
 		WHERE id = ? AND user_id = ? AND deleted_at IS NULL`
@@ -29,7 +29,7 @@ func TestDeleteCommentCRUD(t *testing.T) {
 
 	var fakePostID int64 = 100
 	fakeComment := "Alice's comment here"
-	aliceComment, err := store.CreateComment(ctx, fakePostID, alice.ID, fakeComment)
+	aliceComment, err := store.CreateComment(ctx, storage.CreateCommentParams{PostID: fakePostID, UserID: alice.ID, Content: fakeComment})
 	if err != nil {
 		t.Fatalf("failed to create comment for Alice: %v", err)
 	}
@@ -45,7 +45,7 @@ func TestDeleteCommentCRUD(t *testing.T) {
 	}
 
 	fakeCommentV2 := "Alice updated her comment"
-	aliceCommentV2, err := store.UpdateComment(ctx, aliceComment.ID, alice.ID, fakeCommentV2)
+	aliceCommentV2, err := store.UpdateComment(ctx, storage.UpdateCommentParams{CommentID: aliceComment.ID, UserID: alice.ID, Content: fakeCommentV2})
 	if err != nil {
 		t.Fatalf("failed to update comment for Alice: %v", err)
 	}
@@ -64,7 +64,7 @@ func TestDeleteCommentCRUD(t *testing.T) {
 
 	// bob tries to update alice's comment - fail
 	bobWantsUpdate := "this is Bob and I updated Alice's comment"
-	bobsPoison, err := store.UpdateComment(ctx, aliceComment.ID, bob.ID, bobWantsUpdate)
+	bobsPoison, err := store.UpdateComment(ctx, storage.UpdateCommentParams{CommentID: aliceComment.ID, UserID: bob.ID, Content: bobWantsUpdate})
 	if err != nil {
 		switch {
 		case errors.Is(err, storage.ErrNotFound):
@@ -0,0 +1,365 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCommentReplies(t *testing.T) {
+	t.Parallel()
+	store, user, blog := setupTestBlog(t)
+	ctx := context.Background()
+
+	post := createTestPost(t, store, user, blog, "a-post", new(time.Now()))
+	otherPost := createTestPost(t, store, user, blog, "another-post", new(time.Now()))
+
+	comment := func(parent *storage.Comment) *storage.Comment {
+		t.Helper()
+		var parentID *int64
+		if parent != nil {
+			parentID = &parent.ID
+		}
+		c, err := store.CreateComment(ctx, storage.CreateCommentParams{PostID: post.ID, UserID: user.ID, ParentID: parentID, Content: "some content"})
+		if err != nil {
+			t.Fatalf("could not create comment: %v", err)
+		}
+		return c
+	}
+
+	c1 := comment(nil)
+	r1 := comment(c1)
+	r2 := comment(r1)
+	r3 := comment(r2)
+	c2 := comment(nil)
+	leaf := comment(c2)
+	c3 := comment(nil)
+
+	if r3.Depth != storage.MaxCommentDepth || *r3.ParentID != r2.ID {
+		t.Fatalf("reply: want depth %d under %d, got %d under %v", storage.MaxCommentDepth, r2.ID, r3.Depth, r3.ParentID)
+	}
+
+	for name, parentID := range map[string]int64{"too deep": r3.ID, "unknown parent": 1000} {
+		_, err := store.CreateComment(ctx, storage.CreateCommentParams{PostID: post.ID, UserID: user.ID, ParentID: &parentID, Content: "some content"})
+		var invalid *storage.ValidationError
+		if !errors.As(err, &invalid) || invalid.Field != "parent_id" {
+			t.Fatalf("%s: want a parent_id validation error, got %v", name, err)
+		}
+	}
+	if _, err := store.CreateComment(ctx, storage.CreateCommentParams{PostID: otherPost.ID, UserID: user.ID, ParentID: &c1.ID, Content: "some content"}); !errors.Is(err, ErrCommentParent) {
+		t.Fatalf("parent of another post: want %v, got %v", ErrCommentParent, err)
+	}
+
+	// r1 keeps a placeholder for its replies, the leaf and c3 have none and go away
+	for _, c := range []*storage.Comment{r1, leaf, c3} {
+		if err := store.DeleteComment(ctx, c.ID, user.ID); err != nil {
+			t.Fatalf("could not delete comment: %v", err)
+		}
+	}
+	if _, err := store.CreateComment(ctx, storage.CreateCommentParams{PostID: post.ID, UserID: user.ID, ParentID: &r1.ID, Content: "some content"}); !errors.Is(err, ErrCommentParent) {
+		t.Fatalf("reply to a deleted comment: want %v, got %v", ErrCommentParent, err)
+	}
+
+	// the comments share their second, the id keeps their order
+	tests := []struct {
+		name   string
+		cursor storage.Cursor
+		limit  int64
+		want   []int64
+	}{
+		{name: "threads newest first, replies in order", limit: 10, want: []int64{c2.ID, c1.ID, r1.ID, r2.ID, r3.ID}},
+		{name: "limit counts threads", limit: 1, want: []int64{c2.ID}},
+		{name: "threads after the cursor", cursor: c2.Cursor(), limit: 1, want: []int64{c1.ID, r1.ID, r2.ID, r3.ID}},
+		{name: "threads before the cursor", cursor: storage.Cursor{Time: c1.CreatedAt, ID: c1.ID, Before: true}, limit: 1, want: []int64{c2.ID}},
+		{name: "nothing after the last thread", cursor: c1.Cursor(), limit: 1, want: []int64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comments, err := store.GetCommentsForPost(ctx, post.ID, 0, tt.cursor, tt.limit)
+			if err != nil {
+				t.Fatalf("could not get comments: %v", err)
+			}
+
+			got := make([]int64, len(comments))
+			for i, c := range comments {
+				got[i] = c.ID
+				if c.ID == r1.ID && (!c.IsDeleted() || c.Content != "" || c.AuthorName != "" || c.UserID != nil) {
+					t.Fatalf("deleted comment: want an empty placeholder, got %+v", c)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("comments: want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestCommentRevisions(t *testing.T) {
+	t.Parallel()
+	store, user, blog := setupTestBlog(t)
+	ctx := context.Background()
+
+	post := createTestPost(t, store, user, blog, "a-post", new(time.Now()))
+
+	comment, err := store.CreateComment(ctx, storage.CreateCommentParams{PostID: post.ID, UserID: user.ID, Content: "first version"})
+	if err != nil {
+		t.Fatalf("could not create comment: %v", err)
+	}
+
+	for _, content := range []string{"second version", "second version", "third version"} {
+		if comment, err = store.UpdateComment(ctx, storage.UpdateCommentParams{CommentID: comment.ID, UserID: user.ID, Content: content}); err != nil {
+			t.Fatalf("could not update comment: %v", err)
+		}
+	}
+	if comment.EditedAt == nil {
+		t.Fatal("edited comment has no edited_at")
+	}
+
+	revisions, err := store.GetCommentRevisionsForPost(ctx, post.ID)
+	if err != nil {
+		t.Fatalf("could not get revisions: %v", err)
+	}
+
+	// saving the same content twice is not a new version
+	got := make([]string, len(revisions))
+	for i, rev := range revisions {
+		got[i] = rev.Content
+	}
+	if want := []string{"first version", "second version"}; !slices.Equal(got, want) {
+		t.Fatalf("revisions: want %v, got %v", want, got)
+	}
+
+	// deleted comments keep their history private
+	if err := store.DeleteComment(ctx, comment.ID, user.ID); err != nil {
+		t.Fatalf("could not delete comment: %v", err)
+	}
+	if revisions, err = store.GetCommentRevisionsForPost(ctx, post.ID); err != nil || len(revisions) != 0 {
+		t.Fatalf("revisions of a deleted comment: want none, got %d (%v)", len(revisions), err)
+	}
+}
+
+func TestCommentModeration(t *testing.T) {
+	t.Parallel()
+	store, owner, blog := setupTestBlog(t)
+	ctx := context.Background()
+
+	post := createTestPost(t, store, owner, blog, "a-post", new(time.Now()))
+	users := createTestUsers(t, store, 2)
+	alice, bob := users[0], users[1]
+
+	comment := func(user *storage.User, parent *storage.Comment) *storage.Comment {
+		t.Helper()
+		params := storage.CreateCommentParams{PostID: post.ID, UserID: user.ID, Content: "some content"}
+		if parent != nil {
+			params.ParentID = &parent.ID
+		}
+		c, err := store.CreateComment(ctx, params)
+		if err != nil {
+			t.Fatalf("could not create comment: %v", err)
+		}
+		return c
+	}
+	edit := func(user *storage.User, c *storage.Comment, content string, flag storage.CommentStatus) *storage.Comment {
+		t.Helper()
+		c, err := store.UpdateComment(ctx, storage.UpdateCommentParams{CommentID: c.ID, UserID: user.ID, Content: content, Flag: flag})
+		if err != nil {
+			t.Fatalf("could not edit comment: %v", err)
+		}
+		return c
+	}
+	visibleTo := func(viewerID int64) []int64 {
+		t.Helper()
+		comments, err := store.GetCommentsForPost(ctx, post.ID, viewerID, storage.Cursor{}, 10)
+		if err != nil {
+			t.Fatalf("could not get comments: %v", err)
+		}
+		ids := make([]int64, 0, len(comments))
+		for _, c := range comments {
+			if c.Content != "" {
+				ids = append(ids, c.ID)
+			}
+		}
+		return ids
+	}
+
+	if err := store.UpdateBlogCommentPolicy(ctx, blog.ID, owner.ID, "sometimes"); err == nil {
+		t.Fatal("unknown policy: want an error")
+	}
+	if err := store.UpdateBlogCommentPolicy(ctx, blog.ID, alice.ID, storage.PolicyAll); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("policy of another user's blog: want %v, got %v", storage.ErrNotFound, err)
+	}
+	if err := store.UpdateBlogCommentPolicy(ctx, blog.ID, owner.ID, storage.PolicyFirstTime); err != nil {
+		t.Fatalf("could not update policy: %v", err)
+	}
+
+	byOwner := comment(owner, nil)
+	first := comment(alice, nil)
+	if byOwner.Status != storage.CommentApproved || first.Status != storage.CommentPending {
+		t.Fatalf("first_time: want the owner approved and alice pending, got %q and %q", byOwner.Status, first.Status)
+	}
+
+	// pending comments are shown to their author only and take no replies
+	if got := visibleTo(0); !slices.Equal(got, []int64{byOwner.ID}) {
+		t.Fatalf("anonymous readers: want %v, got %v", []int64{byOwner.ID}, got)
+	}
+	if got := visibleTo(alice.ID); !slices.Equal(got, []int64{first.ID, byOwner.ID}) {
+		t.Fatalf("author: want %v, got %v", []int64{first.ID, byOwner.ID}, got)
+	}
+	if _, err := store.CreateComment(ctx, storage.CreateCommentParams{PostID: post.ID, UserID: bob.ID, ParentID: &first.ID, Content: "some content"}); !errors.Is(err, ErrCommentParent) {
+		t.Fatalf("reply to a pending comment: want %v, got %v", ErrCommentParent, err)
+	}
+
+	queue, err := store.GetCommentsForModeration(ctx, owner.ID, storage.CommentPending, 0, 10)
+	if err != nil || len(queue) != 1 || queue[0].ID != first.ID || queue[0].PostSlug != "a-post" {
+		t.Fatalf("owner queue: want comment %d on a-post, got %v (%v)", first.ID, queue, err)
+	}
+	if queue, err = store.GetCommentsForModeration(ctx, alice.ID, storage.CommentPending, 0, 10); err != nil || len(queue) != 0 {
+		t.Fatalf("alice queue: want none, got %d (%v)", len(queue), err)
+	}
+
+	if _, err := store.ModerateComments(ctx, owner.ID, []int64{first.ID}, storage.CommentPending); !errors.Is(err, ErrCommentStatus) {
+		t.Fatalf("moderating to pending: want %v, got %v", ErrCommentStatus, err)
+	}
+	if changed, err := store.ModerateComments(ctx, alice.ID, []int64{first.ID}, storage.CommentApproved); err != nil || len(changed) != 0 {
+		t.Fatalf("moderation by a non moderator: want 0 changes, got %d (%v)", len(changed), err)
+	}
+	if changed, err := store.ModerateComments(ctx, owner.ID, []int64{first.ID}, storage.CommentApproved); err != nil || len(changed) != 1 || changed[0].Status != storage.CommentApproved {
+		t.Fatalf("approval: want comment %d approved, got %v (%v)", first.ID, changed, err)
+	}
+
+	// once approved, alice is no longer a first time commenter
+	second := comment(alice, byOwner)
+	if second.Status != storage.CommentApproved {
+		t.Fatalf("known commenter: want approved, got %q", second.Status)
+	}
+
+	// an approved comment edited into something else is reviewed again, saving it unchanged is not an edit
+	if c := edit(alice, second, second.Content, ""); c.Status != storage.CommentApproved {
+		t.Fatalf("unchanged edit: want approved, got %q", c.Status)
+	}
+	if c := edit(alice, second, "something else entirely", ""); c.Status != storage.CommentPending {
+		t.Fatalf("changed edit: want pending, got %q", c.Status)
+	}
+	if slices.Contains(visibleTo(0), second.ID) {
+		t.Fatal("changed edit: want it hidden from anonymous readers until approved")
+	}
+	if c := edit(owner, byOwner, "the owner changed their mind", ""); c.Status != storage.CommentApproved {
+		t.Fatalf("edit by the owner: want approved, got %q", c.Status)
+	}
+	if c := comment(bob, nil); c.Status != storage.CommentPending {
+		t.Fatalf("first time commenter: want pending, got %q", c.Status)
+	}
+
+	if err := store.UpdateBlogCommentPolicy(ctx, blog.ID, owner.ID, storage.PolicyAll); err != nil {
+		t.Fatalf("could not update policy: %v", err)
+	}
+	if c := comment(alice, nil); c.Status != storage.CommentPending {
+		t.Fatalf("all: want pending, got %q", c.Status)
+	}
+	if c := comment(owner, nil); c.Status != storage.CommentApproved {
+		t.Fatalf("all: want the owner approved, got %q", c.Status)
+	}
+
+	// blogs that approve everything keep edits approved unless the scorers flag them
+	if err := store.UpdateBlogCommentPolicy(ctx, blog.ID, owner.ID, storage.PolicyAuto); err != nil {
+		t.Fatalf("could not update policy: %v", err)
+	}
+	auto := comment(bob, nil)
+	if c := edit(bob, auto, "an edit", ""); c.Status != storage.CommentApproved {
+		t.Fatalf("auto edit: want approved, got %q", c.Status)
+	}
+	if c := edit(bob, auto, "buy cheap pills", storage.CommentSpam); c.Status != storage.CommentSpam {
+		t.Fatalf("edit flagged as spam: want spam, got %q", c.Status)
+	}
+
+	// a removed comment stays as a placeholder for the replies readers can still see
+	reply := comment(owner, first)
+	if _, err := store.ModerateComments(ctx, owner.ID, []int64{first.ID}, storage.CommentSpam); err != nil {
+		t.Fatalf("could not mark as spam: %v", err)
+	}
+	comments, err := store.GetCommentsForPost(ctx, post.ID, alice.ID, storage.Cursor{}, 10)
+	if err != nil {
+		t.Fatalf("could not get comments: %v", err)
+	}
+	i := slices.IndexFunc(comments, func(c *storage.Comment) bool { return c.ID == first.ID })
+	if i < 0 || i+1 == len(comments) {
+		t.Fatal("removed comment: placeholder missing")
+	}
+	if c := comments[i]; !c.IsRemoved() || c.Content != "" || c.UserID != nil || comments[i+1].ID != reply.ID {
+		t.Fatalf("removed comment: want an empty placeholder followed by its reply, got %+v", c)
+	}
+}
+
+func TestCommentsForUserID(t *testing.T) {
+	t.Parallel()
+	store, user, blog := setupTestBlog(t)
+	ctx := context.Background()
+
+	private, err := store.CreateBlog(ctx, storage.CreateBlogParams{
+		OwnerID: user.ID, Slug: "a-private-blog", Title: "a private blog", Visibility: storage.VisibilityPrivate, RegistrationMode: storage.RegistrationOpen,
+	})
+	if err != nil {
+		t.Fatalf("could not create blog: %v", err)
+	}
+
+	comment := func(post *storage.Post) *storage.Comment {
+		t.Helper()
+		c, err := store.CreateComment(ctx, storage.CreateCommentParams{PostID: post.ID, UserID: user.ID, Content: "some content"})
+		if err != nil {
+			t.Fatalf("could not create comment: %v", err)
+		}
+		return c
+	}
+
+	post := createTestPost(t, store, user, blog, "a-post", new(time.Now().Add(-time.Minute)))
+	first, deleted, last := comment(post), comment(post), comment(post)
+	if err := store.DeleteComment(ctx, deleted.ID, user.ID); err != nil {
+		t.Fatalf("could not delete comment: %v", err)
+	}
+
+	unlisted := createTestPost(t, store, user, blog, "an-unlisted-post", new(time.Now().Add(-time.Minute)))
+	comment(unlisted)
+	if _, err := store.UpdatePost(ctx, storage.UpdatePostParams{PostID: unlisted.ID, Slug: unlisted.Slug, Title: unlisted.Title, PublishedAt: unlisted.PublishedAt}); err != nil {
+		t.Fatalf("could not unlist post: %v", err)
+	}
+	comment(createTestPost(t, store, user, private, "a-private-post", new(time.Now().Add(-time.Minute))))
+
+	tests := []struct {
+		name          string
+		offset, limit int64
+		want          []int64
+		wantErr       error
+	}{
+		{name: "latest first", offset: 0, limit: 10, want: []int64{last.ID, first.ID}},
+		{name: "paged", offset: 1, limit: 1, want: []int64{first.ID}},
+		{name: "past the end", offset: 2, limit: 1, want: []int64{}},
+		{name: "invalid limit", offset: 0, limit: 0, wantErr: ErrLimitOffset},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comments, err := store.GetCommentsForUserID(ctx, user.ID, tt.offset, tt.limit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("errors: want %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			got := make([]int64, len(comments))
+			for i, c := range comments {
+				got[i] = c.ID
+				if c.BlogSlug != blog.Slug || c.PostSlug != "a-post" || c.AuthorName != user.Username {
+					t.Fatalf("comment: want it on %s/a-post by %s, got %+v", blog.Slug, user.Username, c)
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Fatalf("comments: want %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+)
+
+// sortTimeLayout is the part of stored times that sorts the same in the text of CURRENT_TIMESTAMP and in the one the
+// driver writes for a time.Time, which goes on with fractions and a zone
+const sortTimeLayout = "2006-01-02 15:04:05"
+
+// sortTime is a time column cut to the second, rows of the same second are told apart by their id
+func sortTime(col string) string {
+	return "substr(" + col + ", 1, 19)"
+}
+
+// keyset is the condition and the order of the rows of the page at c, in a listing sorted newest first on timeCol then
+// idCol. Pages before c are selected oldest first so the limit keeps the rows next to it, callers reverse them
+func keyset(c storage.Cursor, timeCol, idCol string) (where, order string, args []any) {
+	key := "(" + sortTime(timeCol) + ", " + idCol + ")"
+	switch {
+	case c.IsZero():
+		return "1", sortTime(timeCol) + " DESC, " + idCol + " DESC", nil
+	case c.Before:
+		return key + " > (?, ?)", sortTime(timeCol) + " ASC, " + idCol + " ASC", []any{c.Time.UTC().Format(sortTimeLayout), c.ID}
+	default:
+		return key + " < (?, ?)", sortTime(timeCol) + " DESC, " + idCol + " DESC", []any{c.Time.UTC().Format(sortTimeLayout), c.ID}
+	}
+}
+
+// validCursor checks the arguments of a keyset paged listing
+func validCursor(c storage.Cursor, limit int64) error {
+	if c.ID < 0 || limit <= 0 {
+		return ErrLimitCursor
+	}
+	return nil
+}
@@ -10,8 +10,10 @@ var (
 	ErrBlogVisibility            = errors.New("visibility must be 'private' or 'public'")
 	ErrBlogRegistrationMode      = errors.New("unknown registration mode")
 	ErrBlogRegistrationLimit     = errors.New("registration limit must be valid")
+	ErrBlogCommentPolicy         = errors.New("comment policy must be 'auto', 'first_time' or 'all'")
 	ErrCreateBlog                = errors.New("could not create blog")
 	ErrLimitOffset               = errors.New("offset must be >= 0 and limit > 0")
+	ErrLimitCursor               = errors.New("cursor id must be >= 0 and limit > 0")
 	ErrAllPublicBlogs            = errors.New("could not get public blog list")
 	ErrInvalidBlogID             = errors.New("blog id must be > 0")
 	ErrInvalidOwnerID            = errors.New("owner id must be > 0")
@@ -23,6 +25,60 @@ var (
 	ErrNegativeIDs               = errors.New("given id(s) must be > 0")
 	ErrUpdateBlogVisibility      = errors.New("could not update blog visibility")
 	ErrUpdateBlogRegistration    = errors.New("could not update blog registration")
+	ErrUpdateBlogCommentPolicy   = errors.New("could not update blog comment policy")
 	ErrRegistrationValuesForMode = errors.New("registration mode incompatible with provided limit")
 	ErrDeleteBlog                = errors.New("could not delete blog")
+	ErrBlogIDFilter              = errors.New("blog id must be >= 0, 0 meaning all blogs")
+
+	// comments
+	ErrCommentParent    = errors.New("replies must answer an approved comment of the same post that is not nested too deep")
+	ErrCommentStatus    = errors.New("comments can only be approved, rejected or marked as spam")
+	ErrCommentFlag      = errors.New("new comments can only be flagged as pending or spam")
+	ErrModerateComments = errors.New("could not moderate comments")
+	ErrPendingComments  = errors.New("could not get comments for moderation")
+
+	// spam filter
+	ErrSpamFilter = errors.New("could not use the spam filter")
+
+	// users
+	ErrUserEmail       = errors.New("email must be a plain address like name@example.com, at most 254 chars")
+	ErrUserDisplayName = errors.New("display name can only be empty OR at most 50 chars")
+	ErrUserBio         = errors.New("bio can only be empty OR at most 500 chars")
+	ErrUserAvatar      = errors.New("avatar key can only be empty OR at most 255 chars")
+	ErrUserComments    = errors.New("could not get comments of user")
+
+	// two-factor
+	ErrTOTPSecret    = errors.New("totp secret must not be empty")
+	ErrRecoveryCodes = errors.New("two-factor needs recovery codes")
+	ErrTwoFactor     = errors.New("could not update two-factor")
+
+	// passkeys
+	ErrPasskeyName       = errors.New("passkey name must be 1 to 64 characters")
+	ErrPasskeyCredential = errors.New("passkey needs a credential id and public key")
+	ErrCreatePasskey     = errors.New("could not create passkey")
+
+	// identities
+	ErrIdentity     = errors.New("identities need an issuer and a subject of at most 255 chars")
+	ErrLinkIdentity = errors.New("could not link identity")
+
+	// login throttling
+	ErrLoginThrottle = errors.New("could not update login failures")
+
+	// password resets
+	ErrResetTokenHash   = errors.New("reset token hash must not be empty")
+	ErrResetExpiry      = errors.New("reset links must expire in the future")
+	ErrPasswordReset    = errors.New("could not create password reset")
+	ErrUsePasswordReset = errors.New("could not use password reset")
+
+	// audit log
+	ErrAuditKind    = errors.New("unknown audit event kind")
+	ErrAuditPayload = errors.New("audit payload must be a JSON object")
+	ErrAuditLog     = errors.New("could not use the audit log")
+
+	// outbox
+	ErrEmailRecipient = errors.New("recipient must be a plain address like name@example.com, at most 254 chars")
+	ErrEmailSubject   = errors.New("subject must not be empty")
+	ErrEmailBody      = errors.New("emails need a text body")
+	ErrEnqueueEmail   = errors.New("could not queue email")
+	ErrOutbox         = errors.New("could not update the outbox")
 )
@@ -0,0 +1,83 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const maxSubjectLen = 255
+
+func validIdentity(issuer, subject string) bool {
+	return issuer != "" && subject != "" && len(subject) <= maxSubjectLen
+}
+
+// CreateUserWithIdentity creates a user who logs in with the identity, neither exists unless both do. A taken username
+// or an identity linked already is a unique violation. The user is passwordless, passwordHash is of a random password
+// nobody knows
+func (s *Store) CreateUserWithIdentity(ctx context.Context, username, passwordHash, issuer, subject string) (*storage.User, error) {
+	if !validIdentity(issuer, subject) {
+		return nil, fmt.Errorf("%w: %w", ErrLinkIdentity, ErrIdentity)
+	}
+
+	var user storage.User
+	err := s.WithTx(ctx, func(tx *sqlx.Tx) error {
+		query := `INSERT INTO users (username, password_hash, passwordless)
+					VALUES (?, ?, 1)
+					RETURNING *`
+		if err := tx.GetContext(ctx, &user, query, username, passwordHash); err != nil {
+			return mapSqlError(err)
+		}
+
+		query = `INSERT INTO user_identities (user_id, issuer, subject) VALUES (?, ?, ?)`
+		return execOne(ctx, tx, storage.ErrNotFound, query, user.ID, issuer, subject)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot create user %q: %w", username, err)
+	}
+	return &user, nil
+}
+
+// GetUserByIdentity returns the user the identity is linked to, identities of deleted users are not found
+func (s *Store) GetUserByIdentity(ctx context.Context, issuer, subject string) (*storage.User, error) {
+	query := `SELECT users.* FROM users
+				JOIN user_identities ON user_identities.user_id = users.id
+				WHERE user_identities.issuer = ? AND user_identities.subject = ?
+				AND users.deleted_at IS NULL`
+
+	var user storage.User
+	if err := s.db.GetContext(ctx, &user, query, issuer, subject); err != nil {
+		return nil, fmt.Errorf("cannot find user by identity: %w", mapSqlError(err))
+	}
+	return &user, nil
+}
+
+// LinkUserIdentity lets the user log in with the identity too, an identity linked already is a unique violation
+func (s *Store) LinkUserIdentity(ctx context.Context, userID int64, issuer, subject string) error {
+	if userID < 1 {
+		return fmt.Errorf("%w: %w", ErrLinkIdentity, ErrInvalidUserID)
+	}
+	if !validIdentity(issuer, subject) {
+		return fmt.Errorf("%w: %w", ErrLinkIdentity, ErrIdentity)
+	}
+
+	query := `INSERT INTO user_identities (user_id, issuer, subject)
+				SELECT id, ?, ? FROM users
+				WHERE id = ? AND deleted_at IS NULL`
+
+	result, err := s.db.ExecContext(ctx, query, issuer, subject, userID)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrLinkIdentity, mapSqlError(err))
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %w", mapSqlError(err))
+	}
+	if rows == 0 {
+		return fmt.Errorf("%w: %w", ErrLinkIdentity, storage.ErrNotFound)
+	}
+	return nil
+}
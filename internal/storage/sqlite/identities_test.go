@@ -0,0 +1,81 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestIdentities(t *testing.T) {
+	t.Parallel()
+	store := setupTestStore(t)
+	ctx := context.Background()
+	users := createTestUsers(t, store, 2)
+	alice, bob := users[0], users[1]
+	const issuer = "https://accounts.example.com"
+
+	if _, err := store.GetUserByIdentity(ctx, issuer, "sub-1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("unlinked identity: want %v, got %v", storage.ErrNotFound, err)
+	}
+	if err := store.LinkUserIdentity(ctx, alice.ID, issuer, strings.Repeat("x", 256)); !errors.Is(err, ErrIdentity) {
+		t.Fatalf("long subject: want %v, got %v", ErrIdentity, err)
+	}
+	if err := store.LinkUserIdentity(ctx, 1000, issuer, "sub-1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("unknown user: want %v, got %v", storage.ErrNotFound, err)
+	}
+
+	if err := store.LinkUserIdentity(ctx, alice.ID, issuer, "sub-1"); err != nil {
+		t.Fatalf("could not link identity: %v", err)
+	}
+	if err := store.LinkUserIdentity(ctx, bob.ID, issuer, "sub-1"); !errors.Is(err, storage.ErrUniqueViolation) {
+		t.Fatalf("identity linked twice: want %v, got %v", storage.ErrUniqueViolation, err)
+	}
+	// subjects are only unique per issuer
+	if err := store.LinkUserIdentity(ctx, bob.ID, "https://other.example.com", "sub-1"); err != nil {
+		t.Fatalf("same subject of another issuer: %v", err)
+	}
+	if got, err := store.GetUserByIdentity(ctx, issuer, "sub-1"); err != nil || got.ID != alice.ID {
+		t.Fatalf("by identity: want alice, got %+v (%v)", got, err)
+	}
+
+	created, err := store.CreateUserWithIdentity(ctx, "carol", gen60CharString(), issuer, "sub-2")
+	if err != nil {
+		t.Fatalf("could not create user with identity: %v", err)
+	}
+	if got, err := store.GetUserByIdentity(ctx, issuer, "sub-2"); err != nil || got.ID != created.ID {
+		t.Fatalf("by identity: want carol, got %+v (%v)", got, err)
+	}
+	// nobody knows the password of carol until one is set
+	if !created.Passwordless {
+		t.Fatal("user with identity: want passwordless")
+	}
+	if err := store.ChangeUserPassword(ctx, created.ID, gen60CharString()); err != nil {
+		t.Fatalf("could not change password: %v", err)
+	}
+	if got, err := store.GetUserByID(ctx, created.ID); err != nil || got.Passwordless {
+		t.Fatalf("after setting a password: want passwordless cleared, got %+v (%v)", got, err)
+	}
+	// neither the user nor the identity exists unless both do
+	if _, err := store.CreateUserWithIdentity(ctx, "dave", gen60CharString(), issuer, "sub-1"); !errors.Is(err, storage.ErrUniqueViolation) {
+		t.Fatalf("identity linked already: want %v, got %v", storage.ErrUniqueViolation, err)
+	}
+	if _, err := store.GetUserByUsername(ctx, "dave"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("user of a failed link: want %v, got %v", storage.ErrNotFound, err)
+	}
+	if _, err := store.CreateUserWithIdentity(ctx, "carol", gen60CharString(), issuer, "sub-3"); !errors.Is(err, storage.ErrUniqueViolation) {
+		t.Fatalf("taken username: want %v, got %v", storage.ErrUniqueViolation, err)
+	}
+	if _, err := store.GetUserByIdentity(ctx, issuer, "sub-3"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("identity of a failed user: want %v, got %v", storage.ErrNotFound, err)
+	}
+
+	// deleted accounts can't log in with the identities they had
+	if err := store.DeleteUser(ctx, alice.ID); err != nil {
+		t.Fatalf("could not delete user: %v", err)
+	}
+	if _, err := store.GetUserByIdentity(ctx, issuer, "sub-1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("identity of a deleted user: want %v, got %v", storage.ErrNotFound, err)
+	}
+}
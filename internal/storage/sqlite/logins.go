@@ -0,0 +1,94 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// loginFailureMemory is how long failures are remembered, a failure after a quiet day starts the count over
+const loginFailureMemory = "-1 day"
+
+// loginKey is the username failures are counted under, it is stored in lower case and compared without case like the
+// users table does. Names too long for an account are cut short, they all belong to nobody
+func loginKey(username string) string {
+	key := strings.TrimSpace(username)
+	if len(key) > 50 {
+		key = strings.ToValidUTF8(key[:50], "")
+	}
+	return key
+}
+
+// GetLoginThrottle returns the failures of username, storage.ErrNotFound when it has none
+func (s *Store) GetLoginThrottle(ctx context.Context, username string) (*storage.LoginThrottle, error) {
+	query := `SELECT * FROM login_failures
+		WHERE username = ? AND last_failure_at >= datetime('now', ?)`
+
+	var throttle storage.LoginThrottle
+	if err := s.db.GetContext(ctx, &throttle, query, loginKey(username), loginFailureMemory); err != nil {
+		return nil, fmt.Errorf("cannot find login failures of %q: %w", username, mapSqlError(err))
+	}
+	return &throttle, nil
+}
+
+// RecordLoginFailure counts a failed login to username and blocks the next ones for as long as policy says. Failures
+// forgotten by now, of any username, are cleared on the way
+func (s *Store) RecordLoginFailure(ctx context.Context, username string, policy storage.LoginPolicy) (*storage.LoginThrottle, error) {
+	key := loginKey(username)
+
+	var throttle storage.LoginThrottle
+	err := s.WithTx(ctx, func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM login_failures WHERE last_failure_at < datetime('now', ?)`, loginFailureMemory); err != nil {
+			return mapSqlError(err)
+		}
+
+		query := `INSERT INTO login_failures (username, failures)
+			VALUES (lower(?), 1)
+			ON CONFLICT (username) DO UPDATE SET failures = failures + 1, last_failure_at = CURRENT_TIMESTAMP
+			RETURNING failures`
+		var failures int64
+		if err := tx.GetContext(ctx, &failures, query, key); err != nil {
+			return mapSqlError(err)
+		}
+
+		query = `UPDATE login_failures SET blocked_until = datetime('now', ?)
+			WHERE username = ?
+			RETURNING *`
+		return mapSqlError(tx.GetContext(ctx, &throttle, query, sqliteInterval(policy.Backoff(failures)), key))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLoginThrottle, err)
+	}
+	return &throttle, nil
+}
+
+// ClearLoginFailures forgets the failures of username after a successful login, or unlocks it for an admin. A new
+// password clears them too, see trg_users_login_failures
+func (s *Store) ClearLoginFailures(ctx context.Context, username string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM login_failures WHERE username = ?`, loginKey(username)); err != nil {
+		return fmt.Errorf("%w: %w", ErrLoginThrottle, mapSqlError(err))
+	}
+	return nil
+}
+
+// GetBlockedLogins lists the usernames logins are refused to right now, the longest blocked first
+func (s *Store) GetBlockedLogins(ctx context.Context, offset, limit int64) ([]*storage.LoginThrottle, error) {
+	if offset < 0 || limit <= 0 {
+		return nil, fmt.Errorf("%w: %w", ErrLoginThrottle, ErrLimitOffset)
+	}
+
+	query := `SELECT * FROM login_failures
+		WHERE blocked_until > CURRENT_TIMESTAMP
+		ORDER BY blocked_until DESC, username ASC
+		LIMIT ?
+		OFFSET ?`
+
+	throttles := make([]*storage.LoginThrottle, 0)
+	if err := s.db.SelectContext(ctx, &throttles, query, limit, offset); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLoginThrottle, mapSqlError(err))
+	}
+	return throttles, nil
+}
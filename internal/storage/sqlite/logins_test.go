@@ -0,0 +1,100 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoginPolicyBackoff(t *testing.T) {
+	t.Parallel()
+	policy := storage.LoginPolicy{FreeAttempts: 3, LockAfter: 10, Lockout: 15 * time.Minute}
+
+	want := map[int64]time.Duration{
+		1: 0, 3: 0,
+		4: time.Second, 5: 2 * time.Second, 9: 32 * time.Second,
+		10: 15 * time.Minute, 1000: 15 * time.Minute,
+	}
+	for failures, backoff := range want {
+		if got := policy.Backoff(failures); got != backoff {
+			t.Fatalf("backoff after %d failures: want %s, got %s", failures, backoff, got)
+		}
+	}
+
+	// the backoff never outgrows the lockout
+	policy = storage.LoginPolicy{FreeAttempts: 0, LockAfter: 100, Lockout: time.Minute}
+	if got := policy.Backoff(90); got != time.Minute {
+		t.Fatalf("long backoff: want %s, got %s", time.Minute, got)
+	}
+}
+
+func TestLoginFailures(t *testing.T) {
+	t.Parallel()
+	store := setupTestStore(t)
+	ctx := context.Background()
+	users := createTestUsers(t, store, 1)
+	policy := storage.LoginPolicy{FreeAttempts: 1, LockAfter: 3, Lockout: time.Hour}
+
+	if _, err := store.GetLoginThrottle(ctx, users[0].Username); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("no failures: want %v, got %v", storage.ErrNotFound, err)
+	}
+
+	throttle, err := store.RecordLoginFailure(ctx, strings.ToUpper(users[0].Username), policy)
+	if err != nil {
+		t.Fatalf("could not record failure: %v", err)
+	}
+	if throttle.Failures != 1 || throttle.Blocked(time.Now()) {
+		t.Fatalf("first failure: want it free, got %+v", throttle)
+	}
+
+	// usernames count the way logins compare them
+	for _, name := range []string{" " + strings.ToUpper(users[0].Username), users[0].Username} {
+		if throttle, err = store.RecordLoginFailure(ctx, name, policy); err != nil {
+			t.Fatalf("could not record failure: %v", err)
+		}
+	}
+	if throttle.Failures != 3 || !throttle.Blocked(time.Now().Add(59*time.Minute)) {
+		t.Fatalf("third failure: want a lockout of an hour, got %+v", throttle)
+	}
+	if got, err := store.GetLoginThrottle(ctx, strings.ToUpper(users[0].Username)); err != nil || got.Failures != 3 {
+		t.Fatalf("get: want 3 failures, got %+v (%v)", got, err)
+	}
+
+	// unknown and impossible usernames are counted too, so they look the same
+	if _, err := store.RecordLoginFailure(ctx, "nobody", policy); err != nil {
+		t.Fatalf("unknown username: %v", err)
+	}
+	if _, err := store.RecordLoginFailure(ctx, strings.Repeat("é", 40), policy); err != nil {
+		t.Fatalf("long username: %v", err)
+	}
+
+	blocked, err := store.GetBlockedLogins(ctx, 0, 10)
+	if err != nil {
+		t.Fatalf("could not list blocked logins: %v", err)
+	}
+	// stored in lower case, whatever case the first failure had
+	if len(blocked) != 1 || blocked[0].Username != users[0].Username {
+		t.Fatalf("blocked logins: want only %s, got %+v", users[0].Username, blocked)
+	}
+	if _, err := store.GetBlockedLogins(ctx, 0, 0); !errors.Is(err, ErrLimitOffset) {
+		t.Fatalf("no limit: want %v, got %v", ErrLimitOffset, err)
+	}
+
+	// a new password starts over
+	if err := store.ChangeUserPassword(ctx, users[0].ID, gen60CharString()); err != nil {
+		t.Fatalf("could not change password: %v", err)
+	}
+	if _, err := store.GetLoginThrottle(ctx, users[0].Username); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("after a new password: want %v, got %v", storage.ErrNotFound, err)
+	}
+
+	if err := store.ClearLoginFailures(ctx, " NOBODY"); err != nil {
+		t.Fatalf("could not clear failures: %v", err)
+	}
+	if _, err := store.GetLoginThrottle(ctx, "nobody"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("after clearing: want %v, got %v", storage.ErrNotFound, err)
+	}
+}
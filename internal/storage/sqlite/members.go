@@ -0,0 +1,228 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrInvalidUserID     = errors.New("user id must be > 0")
+	ErrInviteTokenHash   = errors.New("invite token hash must not be empty")
+	ErrInviteExpiry      = errors.New("invite must expire in the future")
+	ErrJoinBlog          = errors.New("could not join blog")
+	ErrLeaveBlog         = errors.New("could not leave blog")
+	ErrGetBlogMember     = errors.New("could not get blog member")
+	ErrGetBlogMembers    = errors.New("could not get blog members")
+	ErrSetBlogMemberRole = errors.New("could not set blog member role")
+	ErrMemberRole        = errors.New("role must be 'editor', 'author' or 'commenter'")
+	ErrCreateBlogInvite  = errors.New("could not create blog invite")
+)
+
+// JoinBlog makes userID a commenter of blogID following the blog's registration mode. Every new member
+// takes a seat and limited blogs only hand out seats while seats_taken is below registration_limit.
+// inviteTokenHash is only read for invite_only blogs, where it is consumed
+func (s *Store) JoinBlog(ctx context.Context, blogID, userID int64, inviteTokenHash string) (*storage.BlogMember, error) {
+	if blogID < 1 {
+		return nil, fmt.Errorf("%w: %w", ErrJoinBlog, ErrInvalidBlogID)
+	}
+	if userID < 1 {
+		return nil, fmt.Errorf("%w: %w", ErrJoinBlog, ErrInvalidUserID)
+	}
+
+	var member storage.BlogMember
+	err := s.WithTx(ctx, func(tx *sqlx.Tx) error {
+		var mode storage.RegistrationMode
+		if err := tx.GetContext(ctx, &mode, `SELECT registration_mode FROM blogs WHERE id = ? AND deleted_at IS NULL`, blogID); err != nil {
+			return mapSqlError(err)
+		}
+
+		var exists bool
+		err := tx.GetContext(ctx, &exists, `SELECT 1 FROM blog_members WHERE blog_id = ? AND user_id = ?`, blogID, userID)
+		if err == nil {
+			return storage.ErrAlreadyMember
+		}
+		if err = mapSqlError(err); !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+
+		switch mode {
+		case storage.RegistrationOpen, storage.RegistrationLimited:
+		case storage.RegistrationClosed:
+			return storage.ErrRegistrationClosed
+		case storage.RegistrationInviteOnly:
+			query := `UPDATE blog_invites SET used_by = ?, used_at = CURRENT_TIMESTAMP
+						WHERE blog_id = ? AND token_hash = ? AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP`
+			if err := execOne(ctx, tx, storage.ErrInvalidInvite, query, userID, blogID, inviteTokenHash); err != nil {
+				return err
+			}
+		default:
+			return ErrBlogRegistrationMode
+		}
+
+		// the limit check and the increment are one statement so concurrent joins can't overbook
+		query := `UPDATE blogs SET seats_taken = seats_taken + 1
+					WHERE id = ? AND (registration_limit IS NULL OR seats_taken < registration_limit)`
+		if err := execOne(ctx, tx, storage.ErrBlogFull, query, blogID); err != nil {
+			return err
+		}
+
+		query = `INSERT INTO blog_members (blog_id, user_id, role) VALUES (?, ?, ?)
+					RETURNING blog_id, user_id, role, created_at`
+		return mapSqlError(tx.GetContext(ctx, &member, query, blogID, userID, storage.RoleCommenter))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrJoinBlog, err)
+	}
+	return &member, nil
+}
+
+// LeaveBlog removes userID from blogID and frees their seat, owners have to delete the blog instead
+func (s *Store) LeaveBlog(ctx context.Context, blogID, userID int64) error {
+	if blogID < 1 || userID < 1 {
+		return fmt.Errorf("%w: %w", ErrLeaveBlog, ErrNegativeIDs)
+	}
+
+	err := s.WithTx(ctx, func(tx *sqlx.Tx) error {
+		var role storage.BlogRole
+		if err := tx.GetContext(ctx, &role, `SELECT role FROM blog_members WHERE blog_id = ? AND user_id = ?`, blogID, userID); err != nil {
+			return mapSqlError(err)
+		}
+		if role == storage.RoleOwner {
+			return storage.ErrOwnerCannotLeave
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM blog_members WHERE blog_id = ? AND user_id = ?`, blogID, userID); err != nil {
+			return mapSqlError(err)
+		}
+		_, err := tx.ExecContext(ctx, `UPDATE blogs SET seats_taken = seats_taken - 1 WHERE id = ? AND seats_taken > 0`, blogID)
+		return mapSqlError(err)
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrLeaveBlog, err)
+	}
+	return nil
+}
+
+func (s *Store) GetBlogMember(ctx context.Context, blogID, userID int64) (*storage.BlogMember, error) {
+	if blogID < 1 || userID < 1 {
+		return nil, fmt.Errorf("%w: %w", ErrGetBlogMember, ErrNegativeIDs)
+	}
+
+	query := `SELECT m.blog_id, m.user_id, m.role, m.created_at, u.username
+				FROM blog_members AS m
+				JOIN users AS u ON u.id = m.user_id
+				WHERE m.blog_id = ? AND m.user_id = ? AND u.deleted_at IS NULL`
+
+	var member storage.BlogMember
+	if err := s.db.GetContext(ctx, &member, query, blogID, userID); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrGetBlogMember, mapSqlError(err))
+	}
+	return &member, nil
+}
+
+// GetBlogMembers lists the members of a blog, owner first then by join date
+func (s *Store) GetBlogMembers(ctx context.Context, blogID, offset, limit int64) ([]*storage.BlogMember, error) {
+	if blogID < 1 {
+		return nil, fmt.Errorf("%w: %w", ErrGetBlogMembers, ErrInvalidBlogID)
+	}
+	if offset < 0 || limit <= 0 {
+		return nil, fmt.Errorf("%w: %w", ErrGetBlogMembers, ErrLimitOffset)
+	}
+
+	query := `SELECT m.blog_id, m.user_id, m.role, m.created_at, u.username
+				FROM blog_members AS m
+				JOIN users AS u ON u.id = m.user_id
+				WHERE m.blog_id = ? AND u.deleted_at IS NULL
+				ORDER BY m.role = 'owner' DESC, m.created_at ASC, m.user_id ASC
+				LIMIT ?
+				OFFSET ?`
+
+	members := make([]*storage.BlogMember, 0)
+	if err := s.db.SelectContext(ctx, &members, query, blogID, limit, offset); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrGetBlogMembers, mapSqlError(err))
+	}
+	return members, nil
+}
+
+// SetBlogMemberRole changes the role of a member of blogID, only the blog owner can. The owner role isn't handed out
+// and the owner keeps theirs, both come with the blog
+func (s *Store) SetBlogMemberRole(ctx context.Context, blogID, ownerID, userID int64, role storage.BlogRole) error {
+	if blogID < 1 || ownerID < 1 || userID < 1 {
+		return fmt.Errorf("%w: %w", ErrSetBlogMemberRole, ErrNegativeIDs)
+	}
+	if !role.IsValid() || role == storage.RoleOwner {
+		return invalid("role", ErrMemberRole)
+	}
+
+	query := `UPDATE blog_members SET role = ?
+				WHERE blog_id = ? AND user_id = ? AND role != 'owner' AND blog_id IN (
+					SELECT id FROM blogs WHERE id = ? AND owner_id = ? AND deleted_at IS NULL
+				)`
+
+	result, err := s.db.ExecContext(ctx, query, role, blogID, userID, blogID, ownerID)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSetBlogMemberRole, mapSqlError(err))
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSetBlogMemberRole, mapSqlError(err))
+	}
+	if rows == 0 {
+		return fmt.Errorf("%w: %w", ErrSetBlogMemberRole, storage.ErrNotFound)
+	}
+	return nil
+}
+
+// CreateBlogInvite stores the hash of a single use invite token, only the blog owner can invite
+func (s *Store) CreateBlogInvite(ctx context.Context, blogID, ownerID int64, tokenHash string, expiresAt time.Time) error {
+	if blogID < 1 || ownerID < 1 {
+		return fmt.Errorf("%w: %w", ErrCreateBlogInvite, ErrNegativeIDs)
+	}
+	if tokenHash == "" {
+		return fmt.Errorf("%w: %w", ErrCreateBlogInvite, ErrInviteTokenHash)
+	}
+	if !expiresAt.After(time.Now()) {
+		return fmt.Errorf("%w: %w", ErrCreateBlogInvite, ErrInviteExpiry)
+	}
+
+	query := `INSERT INTO blog_invites (blog_id, token_hash, created_by, expires_at)
+				SELECT id, ?, owner_id, ? FROM blogs
+				WHERE id = ? AND owner_id = ? AND deleted_at IS NULL`
+
+	result, err := s.db.ExecContext(ctx, query, tokenHash, expiresAt.UTC(), blogID, ownerID)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCreateBlogInvite, mapSqlError(err))
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCreateBlogInvite, mapSqlError(err))
+	}
+	if rows == 0 {
+		return fmt.Errorf("%w: %w", ErrCreateBlogInvite, storage.ErrNotFound)
+	}
+	return nil
+}
+
+// execOne runs a conditional update and returns errNoRows when its condition matched nothing
+func execOne(ctx context.Context, tx *sqlx.Tx, errNoRows error, query string, args ...any) error {
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return mapSqlError(err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return mapSqlError(err)
+	}
+	if rows == 0 {
+		return errNoRows
+	}
+	return nil
+}
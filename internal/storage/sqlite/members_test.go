@@ -0,0 +1,267 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func setupMembershipBlog(t *testing.T, mode storage.RegistrationMode, limit *int64) (*Store, *storage.User, *storage.Blog) {
+	t.Helper()
+	store, owner, blog := setupTestBlog(t)
+
+	if mode != storage.RegistrationOpen {
+		params := storage.UpdateBlogRegistrationParams{BlogID: blog.ID, OwnerID: owner.ID, RegistrationMode: mode, RegistrationLimit: limit}
+		if err := store.UpdateBlogRegistration(context.Background(), params); err != nil {
+			t.Fatalf("could not update blog registration: %s", err)
+		}
+	}
+	return store, owner, blog
+}
+
+func createTestUsers(t *testing.T, store *Store, n int) []*storage.User {
+	t.Helper()
+
+	users := make([]*storage.User, n)
+	for i := range n {
+		u, err := store.CreateUser(context.Background(), fmt.Sprintf("member_%d", i), gen60CharString())
+		if err != nil {
+			t.Fatalf("could not create user: %s", err)
+		}
+		users[i] = u
+	}
+	return users
+}
+
+func TestCreateBlogAddsOwner(t *testing.T) {
+	t.Parallel()
+	store, owner, blog := setupTestBlog(t)
+
+	member, err := store.GetBlogMember(context.Background(), blog.ID, owner.ID)
+	if err != nil {
+		t.Fatalf("could not get owner membership: %s", err)
+	}
+	if member.Role != storage.RoleOwner {
+		t.Fatalf("role: want %q, got %q", storage.RoleOwner, member.Role)
+	}
+}
+
+func TestJoinBlog(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		mode    storage.RegistrationMode
+		limit   *int64
+		joiners int
+		wantErr error // returned to the last joiner
+	}{
+		{name: "open", mode: storage.RegistrationOpen, joiners: 3, wantErr: nil},
+		{name: "closed", mode: storage.RegistrationClosed, joiners: 1, wantErr: storage.ErrRegistrationClosed},
+		{name: "limited with seats left", mode: storage.RegistrationLimited, limit: new(int64(2)), joiners: 2, wantErr: nil},
+		{name: "limited when full", mode: storage.RegistrationLimited, limit: new(int64(2)), joiners: 3, wantErr: storage.ErrBlogFull},
+		{name: "invite only without invite", mode: storage.RegistrationInviteOnly, joiners: 1, wantErr: storage.ErrInvalidInvite},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			store, _, blog := setupMembershipBlog(t, tt.mode, tt.limit)
+			ctx := context.Background()
+
+			var err error
+			for _, u := range createTestUsers(t, store, tt.joiners) {
+				_, err = store.JoinBlog(ctx, blog.ID, u.ID, "")
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("errors: want %s, got %s", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestJoinBlogTwice(t *testing.T) {
+	t.Parallel()
+	store, _, blog := setupTestBlog(t)
+	ctx := context.Background()
+	u := createTestUsers(t, store, 1)[0]
+
+	if _, err := store.JoinBlog(ctx, blog.ID, u.ID, ""); err != nil {
+		t.Fatalf("could not join blog: %s", err)
+	}
+	if _, err := store.JoinBlog(ctx, blog.ID, u.ID, ""); !errors.Is(err, storage.ErrAlreadyMember) {
+		t.Fatalf("errors: want %s, got %s", storage.ErrAlreadyMember, err)
+	}
+
+	got, err := store.GetBlogByID(ctx, blog.ID)
+	if err != nil {
+		t.Fatalf("could not get blog: %s", err)
+	}
+	if got.SeatsTaken != 1 {
+		t.Fatalf("seats taken: want 1, got %d", got.SeatsTaken)
+	}
+}
+
+func TestLeaveBlogFreesSeat(t *testing.T) {
+	t.Parallel()
+	store, owner, blog := setupMembershipBlog(t, storage.RegistrationLimited, new(int64(1)))
+	ctx := context.Background()
+	users := createTestUsers(t, store, 2)
+
+	if _, err := store.JoinBlog(ctx, blog.ID, users[0].ID, ""); err != nil {
+		t.Fatalf("could not join blog: %s", err)
+	}
+	if _, err := store.JoinBlog(ctx, blog.ID, users[1].ID, ""); !errors.Is(err, storage.ErrBlogFull) {
+		t.Fatalf("errors: want %s, got %s", storage.ErrBlogFull, err)
+	}
+
+	if err := store.LeaveBlog(ctx, blog.ID, users[0].ID); err != nil {
+		t.Fatalf("could not leave blog: %s", err)
+	}
+	if _, err := store.JoinBlog(ctx, blog.ID, users[1].ID, ""); err != nil {
+		t.Fatalf("could not join blog after a seat was freed: %s", err)
+	}
+
+	if err := store.LeaveBlog(ctx, blog.ID, owner.ID); !errors.Is(err, storage.ErrOwnerCannotLeave) {
+		t.Fatalf("errors: want %s, got %s", storage.ErrOwnerCannotLeave, err)
+	}
+	if err := store.LeaveBlog(ctx, blog.ID, users[0].ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("errors: want %s, got %s", storage.ErrNotFound, err)
+	}
+
+	members, err := store.GetBlogMembers(ctx, blog.ID, 0, 10)
+	if err != nil {
+		t.Fatalf("could not get members: %s", err)
+	}
+	if len(members) != 2 || members[0].UserID != owner.ID || members[1].UserID != users[1].ID {
+		t.Fatalf("members: want owner then %d, got %+v", users[1].ID, members)
+	}
+}
+
+func TestSetBlogMemberRole(t *testing.T) {
+	t.Parallel()
+	store, owner, blog := setupTestBlog(t)
+	ctx := context.Background()
+	users := createTestUsers(t, store, 3)
+
+	for _, u := range users[:2] {
+		if _, err := store.JoinBlog(ctx, blog.ID, u.ID, ""); err != nil {
+			t.Fatalf("could not join blog: %s", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		ownerID  int64
+		userID   int64
+		role     storage.BlogRole
+		wantErr  error
+		wantRole storage.BlogRole // of userID afterwards
+	}{
+		{name: "owner makes an editor", ownerID: owner.ID, userID: users[0].ID, role: storage.RoleEditor, wantRole: storage.RoleEditor},
+		{name: "owner makes an author", ownerID: owner.ID, userID: users[1].ID, role: storage.RoleAuthor, wantRole: storage.RoleAuthor},
+		{name: "owner makes a commenter again", ownerID: owner.ID, userID: users[1].ID, role: storage.RoleCommenter, wantRole: storage.RoleCommenter},
+		{name: "editors are not owners", ownerID: users[0].ID, userID: users[1].ID, role: storage.RoleEditor, wantErr: storage.ErrNotFound, wantRole: storage.RoleCommenter},
+		{name: "owner role is not handed out", ownerID: owner.ID, userID: users[0].ID, role: storage.RoleOwner, wantErr: ErrMemberRole, wantRole: storage.RoleEditor},
+		{name: "owner keeps their role", ownerID: owner.ID, userID: owner.ID, role: storage.RoleCommenter, wantErr: storage.ErrNotFound, wantRole: storage.RoleOwner},
+		{name: "not a member", ownerID: owner.ID, userID: users[2].ID, role: storage.RoleEditor, wantErr: storage.ErrNotFound},
+	}
+
+	// the cases build on each other
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := store.SetBlogMemberRole(ctx, blog.ID, tt.ownerID, tt.userID, tt.role)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("errors: want %v, got %v", tt.wantErr, err)
+			}
+
+			member, err := store.GetBlogMember(ctx, blog.ID, tt.userID)
+			if tt.wantRole == "" {
+				if !errors.Is(err, storage.ErrNotFound) {
+					t.Fatalf("errors: want %s, got %v", storage.ErrNotFound, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("could not get member: %s", err)
+			}
+			if member.Role != tt.wantRole {
+				t.Fatalf("role: want %q, got %q", tt.wantRole, member.Role)
+			}
+		})
+	}
+}
+
+func TestDeleteUserFreesSeats(t *testing.T) {
+	t.Parallel()
+	store, _, blog := setupMembershipBlog(t, storage.RegistrationLimited, new(int64(1)))
+	ctx := context.Background()
+	users := createTestUsers(t, store, 2)
+
+	if _, err := store.JoinBlog(ctx, blog.ID, users[0].ID, ""); err != nil {
+		t.Fatalf("could not join blog: %s", err)
+	}
+	if err := store.DeleteUser(ctx, users[0].ID); err != nil {
+		t.Fatalf("could not delete user: %s", err)
+	}
+
+	got, err := store.GetBlogByID(ctx, blog.ID)
+	if err != nil {
+		t.Fatalf("could not get blog: %s", err)
+	}
+	if got.SeatsTaken != 0 {
+		t.Fatalf("seats taken: want 0, got %d", got.SeatsTaken)
+	}
+	var memberships int
+	if err := store.db.GetContext(ctx, &memberships, `SELECT COUNT(*) FROM blog_members WHERE user_id = ?`, users[0].ID); err != nil || memberships != 0 {
+		t.Fatalf("memberships of the deleted user: want none, got %d (%v)", memberships, err)
+	}
+	if _, err := store.JoinBlog(ctx, blog.ID, users[1].ID, ""); err != nil {
+		t.Fatalf("could not join blog on the freed seat: %s", err)
+	}
+}
+
+func TestJoinBlogWithInvite(t *testing.T) {
+	t.Parallel()
+	store, owner, blog := setupMembershipBlog(t, storage.RegistrationInviteOnly, nil)
+	ctx := context.Background()
+	users := createTestUsers(t, store, 3)
+
+	if err := store.CreateBlogInvite(ctx, blog.ID, owner.ID, "valid-hash", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("could not create invite: %s", err)
+	}
+	if err := store.CreateBlogInvite(ctx, blog.ID, users[0].ID, "not-the-owner", time.Now().Add(time.Hour)); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("errors: want %s, got %s", storage.ErrNotFound, err)
+	}
+
+	// expire an invite in place, CreateBlogInvite refuses past dates
+	if err := store.CreateBlogInvite(ctx, blog.ID, owner.ID, "expired-hash", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("could not create invite: %s", err)
+	}
+	if _, err := store.db.ExecContext(ctx, `UPDATE blog_invites SET expires_at = datetime('now', '-1 minute') WHERE token_hash = 'expired-hash'`); err != nil {
+		t.Fatalf("could not expire invite: %s", err)
+	}
+
+	tests := []struct {
+		name    string
+		userID  int64
+		hash    string
+		wantErr error
+	}{
+		{name: "unknown invite", userID: users[0].ID, hash: "unknown-hash", wantErr: storage.ErrInvalidInvite},
+		{name: "expired invite", userID: users[0].ID, hash: "expired-hash", wantErr: storage.ErrInvalidInvite},
+		{name: "valid invite", userID: users[0].ID, hash: "valid-hash", wantErr: nil},
+		{name: "used invite", userID: users[1].ID, hash: "valid-hash", wantErr: storage.ErrInvalidInvite},
+	}
+
+	// sequential, each case depends on the previous ones
+	for _, tt := range tests {
+		_, err := store.JoinBlog(ctx, blog.ID, tt.userID, tt.hash)
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("%s: want %s, got %s", tt.name, tt.wantErr, err)
+		}
+	}
+}
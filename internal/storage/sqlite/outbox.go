@@ -0,0 +1,98 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EnqueueEmail adds an email to the outbox, due straight away
+func (s *Store) EnqueueEmail(ctx context.Context, p storage.EnqueueEmailParams) (*storage.OutboxEmail, error) {
+	if !isEmailAddress(p.Recipient) {
+		return nil, invalid("recipient", ErrEmailRecipient)
+	}
+	if strings.TrimSpace(p.Subject) == "" {
+		return nil, invalid("subject", ErrEmailSubject)
+	}
+	if strings.TrimSpace(p.TextBody) == "" {
+		return nil, invalid("text_body", ErrEmailBody)
+	}
+
+	query := `INSERT INTO outbox (recipient, subject, text_body, html_body)
+		VALUES (?, ?, ?, ?)
+		RETURNING *`
+
+	var email storage.OutboxEmail
+	if err := s.db.GetContext(ctx, &email, query, p.Recipient, p.Subject, p.TextBody, p.HTMLBody); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrEnqueueEmail, mapSqlError(err))
+	}
+	return &email, nil
+}
+
+// ClaimDueEmails locks up to limit emails that are due for lease and counts the attempt, oldest first. Emails locked
+// by a worker that died before marking them become due again once the lease is over
+func (s *Store) ClaimDueEmails(ctx context.Context, limit int64, lease time.Duration) ([]*storage.OutboxEmail, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: %w", ErrOutbox, ErrLimitOffset)
+	}
+
+	query := `UPDATE outbox SET locked_until = datetime('now', ?), attempts = attempts + 1
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE sent_at IS NULL AND failed_at IS NULL AND next_attempt_at <= CURRENT_TIMESTAMP
+				AND (locked_until IS NULL OR locked_until <= CURRENT_TIMESTAMP)
+			ORDER BY next_attempt_at, id
+			LIMIT ?
+		)
+		RETURNING *`
+
+	emails := make([]*storage.OutboxEmail, 0)
+	if err := s.db.SelectContext(ctx, &emails, query, sqliteInterval(lease), limit); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrOutbox, mapSqlError(err))
+	}
+	return emails, nil
+}
+
+// MarkEmailSent records the delivery of a claimed email. Its bodies are dropped, they can carry links that log in
+// like reset tokens and the row is only kept as a record of what went out
+func (s *Store) MarkEmailSent(ctx context.Context, emailID int64) error {
+	query := `UPDATE outbox SET sent_at = CURRENT_TIMESTAMP, locked_until = NULL, last_error = '', text_body = '', html_body = ''
+		WHERE id = ? AND sent_at IS NULL AND failed_at IS NULL`
+
+	return s.updateOutbox(ctx, query, emailID)
+}
+
+// RetryEmail releases a claimed email that could not be sent, it is due again after the given delay
+func (s *Store) RetryEmail(ctx context.Context, emailID int64, lastErr string, after time.Duration) error {
+	query := `UPDATE outbox SET next_attempt_at = datetime('now', ?), locked_until = NULL, last_error = ?
+		WHERE id = ? AND sent_at IS NULL AND failed_at IS NULL`
+
+	return s.updateOutbox(ctx, query, sqliteInterval(after), lastErr, emailID)
+}
+
+// MarkEmailFailed gives up on a claimed email, it stays in the outbox with its last error and without its bodies
+func (s *Store) MarkEmailFailed(ctx context.Context, emailID int64, lastErr string) error {
+	query := `UPDATE outbox SET failed_at = CURRENT_TIMESTAMP, locked_until = NULL, last_error = ?, text_body = '', html_body = ''
+		WHERE id = ? AND sent_at IS NULL AND failed_at IS NULL`
+
+	return s.updateOutbox(ctx, query, lastErr, emailID)
+}
+
+func (s *Store) updateOutbox(ctx context.Context, query string, args ...any) error {
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrOutbox, mapSqlError(err))
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// sqliteInterval is d as a datetime() modifier, to the second
+func sqliteInterval(d time.Duration) string {
+	return fmt.Sprintf("%+d seconds", int64(d.Seconds()))
+}
@@ -0,0 +1,120 @@
+package sqlite
+
+import (
+	"blogengine/internal/mail"
+	"blogengine/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestOutbox(t *testing.T) {
+	t.Parallel()
+	store := setupTestStore(t)
+	ctx := context.Background()
+
+	queue := func(recipient string) *storage.OutboxEmail {
+		t.Helper()
+		e, err := store.EnqueueEmail(ctx, storage.EnqueueEmailParams{Recipient: recipient, Subject: "hi", TextBody: "hello"})
+		if err != nil {
+			t.Fatalf("could not queue email: %v", err)
+		}
+		return e
+	}
+	claim := func() []int64 {
+		t.Helper()
+		emails, err := store.ClaimDueEmails(ctx, 10, time.Minute)
+		if err != nil {
+			t.Fatalf("could not claim: %v", err)
+		}
+		ids := make([]int64, len(emails))
+		for i, e := range emails {
+			ids[i] = e.ID
+		}
+		return ids
+	}
+
+	if _, err := store.EnqueueEmail(ctx, storage.EnqueueEmailParams{Recipient: "nobody", Subject: "hi", TextBody: "hello"}); !errors.Is(err, ErrEmailRecipient) {
+		t.Fatalf("bad recipient: want %v, got %v", ErrEmailRecipient, err)
+	}
+	if _, err := store.EnqueueEmail(ctx, storage.EnqueueEmailParams{Recipient: "a@example.com", Subject: "hi"}); !errors.Is(err, ErrEmailBody) {
+		t.Fatalf("no body: want %v, got %v", ErrEmailBody, err)
+	}
+
+	sent, retried, failed := queue("a@example.com"), queue("b@example.com"), queue("c@example.com")
+
+	// claimed emails are leased to their worker
+	if got := claim(); len(got) != 3 {
+		t.Fatalf("first claim: want 3 emails, got %v", got)
+	}
+	if got := claim(); len(got) != 0 {
+		t.Fatalf("second claim: want none while leased, got %v", got)
+	}
+
+	if err := store.MarkEmailSent(ctx, sent.ID); err != nil {
+		t.Fatalf("could not mark sent: %v", err)
+	}
+	if err := store.RetryEmail(ctx, retried.ID, "connection refused", time.Hour); err != nil {
+		t.Fatalf("could not retry: %v", err)
+	}
+	if err := store.MarkEmailFailed(ctx, failed.ID, "no such user"); err != nil {
+		t.Fatalf("could not mark failed: %v", err)
+	}
+	if err := store.MarkEmailSent(ctx, failed.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("sending a failed email: want %v, got %v", storage.ErrNotFound, err)
+	}
+
+	// the retry is due in an hour, pretend it has passed
+	if got := claim(); len(got) != 0 {
+		t.Fatalf("claim before the retry: want none, got %v", got)
+	}
+	if _, err := store.db.ExecContext(ctx, `UPDATE outbox SET next_attempt_at = datetime('now', '-1 seconds') WHERE id = ?`, retried.ID); err != nil {
+		t.Fatalf("could not move the retry: %v", err)
+	}
+	emails, err := store.ClaimDueEmails(ctx, 10, time.Minute)
+	if err != nil || len(emails) != 1 || emails[0].ID != retried.ID || emails[0].Attempts != 2 || emails[0].LastError != "connection refused" {
+		t.Fatalf("claim after the retry: want email %d on its second attempt, got %+v (%v)", retried.ID, emails, err)
+	}
+}
+
+// permanentSender delivers every email but the ones to nobody@example.com
+type permanentSender struct{}
+
+func (permanentSender) Send(_ context.Context, m mail.Message) error {
+	if m.To == "nobody@example.com" {
+		return fmt.Errorf("%w: no such user", mail.ErrPermanent)
+	}
+	return nil
+}
+
+func TestOutboxDropsBodies(t *testing.T) {
+	t.Parallel()
+	store := setupTestStore(t)
+	ctx := context.Background()
+
+	const token = "a-raw-reset-token"
+	outbox := mail.NewOutbox(store, permanentSender{}, slog.New(slog.DiscardHandler))
+	for _, to := range []string{"alice@example.com", "nobody@example.com"} {
+		reset := mail.PasswordReset{SiteName: "blog", Username: "alice", ResetURL: "https://blog.example.com/reset-password/" + token, ValidFor: time.Hour}
+		if err := outbox.Send(ctx, to, reset); err != nil {
+			t.Fatalf("could not queue reset for %s: %v", to, err)
+		}
+	}
+	var queued int
+	if err := store.db.GetContext(ctx, &queued, `SELECT COUNT(*) FROM outbox WHERE text_body LIKE '%' || ? || '%'`, token); err != nil || queued != 2 {
+		t.Fatalf("queued emails: want the token in both, got %d (%v)", queued, err)
+	}
+
+	outbox.Flush(ctx)
+
+	var left, done int
+	if err := store.db.GetContext(ctx, &left, `SELECT COUNT(*) FROM outbox WHERE text_body LIKE '%' || ? || '%' OR html_body LIKE '%' || ? || '%'`, token, token); err != nil || left != 0 {
+		t.Fatalf("delivered emails: want no token left, got %d rows (%v)", left, err)
+	}
+	if err := store.db.GetContext(ctx, &done, `SELECT COUNT(*) FROM outbox WHERE sent_at IS NOT NULL OR failed_at IS NOT NULL`); err != nil || done != 2 {
+		t.Fatalf("delivered emails: want both sent or given up on, got %d (%v)", done, err)
+	}
+}
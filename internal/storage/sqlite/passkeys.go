@@ -0,0 +1,100 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"fmt"
+	"unicode/utf8"
+)
+
+const maxPasskeyNameLen = 64
+
+// CreatePasskey stores a verified WebAuthn credential of the user, a credential id registered already is a unique
+// violation
+func (s *Store) CreatePasskey(ctx context.Context, p storage.CreatePasskeyParams) (*storage.Passkey, error) {
+	if p.UserID < 1 {
+		return nil, fmt.Errorf("%w: %w", ErrCreatePasskey, ErrInvalidUserID)
+	}
+	if n := utf8.RuneCountInString(p.Name); n < 1 || n > maxPasskeyNameLen {
+		return nil, fmt.Errorf("%w: %w", ErrCreatePasskey, invalid("name", ErrPasskeyName))
+	}
+	if len(p.CredentialID) == 0 || len(p.PublicKey) == 0 {
+		return nil, fmt.Errorf("%w: %w", ErrCreatePasskey, ErrPasskeyCredential)
+	}
+
+	query := `INSERT INTO webauthn_credentials (user_id, credential_id, public_key, sign_count, name)
+				SELECT id, ?, ?, ?, ? FROM users
+				WHERE id = ? AND deleted_at IS NULL
+				RETURNING *`
+
+	var passkey storage.Passkey
+	if err := s.db.GetContext(ctx, &passkey, query, p.CredentialID, p.PublicKey, p.SignCount, p.Name, p.UserID); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCreatePasskey, mapSqlError(err))
+	}
+	return &passkey, nil
+}
+
+// GetPasskeyByCredentialID returns the passkey a login assertion names, passkeys of deleted users are not found
+func (s *Store) GetPasskeyByCredentialID(ctx context.Context, credentialID []byte) (*storage.Passkey, error) {
+	query := `SELECT * FROM webauthn_credentials
+				WHERE credential_id = ?
+				AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)`
+
+	var passkey storage.Passkey
+	if err := s.db.GetContext(ctx, &passkey, query, credentialID); err != nil {
+		return nil, fmt.Errorf("cannot find passkey: %w", mapSqlError(err))
+	}
+	return &passkey, nil
+}
+
+// GetPasskeysForUser lists the passkeys of a user, newest first
+func (s *Store) GetPasskeysForUser(ctx context.Context, userID int64) ([]*storage.Passkey, error) {
+	query := `SELECT * FROM webauthn_credentials
+				WHERE user_id = ?
+				ORDER BY created_at DESC, id DESC`
+
+	passkeys := make([]*storage.Passkey, 0)
+	if err := s.db.SelectContext(ctx, &passkeys, query, userID); err != nil {
+		return nil, fmt.Errorf("could not get passkeys: %w", mapSqlError(err))
+	}
+	return passkeys, nil
+}
+
+// UsePasskey records a login with the passkey and the signature counter it came with. A counter that doesn't go up
+// is not found, so two logins racing with the same assertion can't both pass. Authenticators that don't count
+// always send 0
+func (s *Store) UsePasskey(ctx context.Context, id, signCount int64) error {
+	query := `UPDATE webauthn_credentials SET sign_count = ?, last_used_at = CURRENT_TIMESTAMP
+				WHERE id = ? AND (sign_count < ? OR ? = 0)`
+
+	result, err := s.db.ExecContext(ctx, query, signCount, id, signCount, signCount)
+	if err != nil {
+		return fmt.Errorf("could not use passkey: %w", mapSqlError(err))
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %w", mapSqlError(err))
+	}
+	if rows == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// DeletePasskey removes one of the user's passkeys, passkeys of other users are not found
+func (s *Store) DeletePasskey(ctx context.Context, userID, id int64) error {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM webauthn_credentials WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return fmt.Errorf("could not delete passkey: %w", mapSqlError(err))
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %w", mapSqlError(err))
+	}
+	if rows == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPasskeys(t *testing.T) {
+	t.Parallel()
+	store := setupTestStore(t)
+	ctx := context.Background()
+	users := createTestUsers(t, store, 2)
+	alice, bob := users[0], users[1]
+
+	create := func(userID int64, credentialID string) (*storage.Passkey, error) {
+		return store.CreatePasskey(ctx, storage.CreatePasskeyParams{
+			UserID: userID, Name: "phone", CredentialID: []byte(credentialID), PublicKey: []byte("cose key"), SignCount: 1,
+		})
+	}
+
+	if _, err := store.CreatePasskey(ctx, storage.CreatePasskeyParams{UserID: alice.ID, CredentialID: []byte("id"), PublicKey: []byte("key")}); !errors.Is(err, ErrPasskeyName) {
+		t.Fatalf("no name: want %v, got %v", ErrPasskeyName, err)
+	}
+	if _, err := create(1000, "cred-0"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("unknown user: want %v, got %v", storage.ErrNotFound, err)
+	}
+
+	first, err := create(alice.ID, "cred-1")
+	if err != nil {
+		t.Fatalf("could not create passkey: %v", err)
+	}
+	if _, err := create(bob.ID, "cred-1"); !errors.Is(err, storage.ErrUniqueViolation) {
+		t.Fatalf("same credential twice: want %v, got %v", storage.ErrUniqueViolation, err)
+	}
+	if _, err := create(alice.ID, "cred-2"); err != nil {
+		t.Fatalf("could not create passkey: %v", err)
+	}
+
+	if passkeys, err := store.GetPasskeysForUser(ctx, alice.ID); err != nil || len(passkeys) != 2 || passkeys[0].Name != "phone" {
+		t.Fatalf("passkeys of alice: want 2, got %d (%v)", len(passkeys), err)
+	}
+	got, err := store.GetPasskeyByCredentialID(ctx, []byte("cred-1"))
+	if err != nil || got.ID != first.ID || got.UserID != alice.ID || string(got.PublicKey) != "cose key" {
+		t.Fatalf("by credential id: want passkey %d of alice, got %+v (%v)", first.ID, got, err)
+	}
+
+	// the counter only goes up, 0 is for authenticators that don't count
+	if err := store.UsePasskey(ctx, first.ID, 1); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("same counter: want %v, got %v", storage.ErrNotFound, err)
+	}
+	for _, count := range []int64{5, 0, 0} {
+		if err := store.UsePasskey(ctx, first.ID, count); err != nil {
+			t.Fatalf("counter %d: %v", count, err)
+		}
+	}
+	if got, err = store.GetPasskeyByCredentialID(ctx, []byte("cred-1")); err != nil || got.LastUsedAt == nil {
+		t.Fatalf("used passkey: want last_used_at set, got %+v (%v)", got, err)
+	}
+
+	if err := store.DeletePasskey(ctx, bob.ID, first.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("passkey of another user: want %v, got %v", storage.ErrNotFound, err)
+	}
+	if err := store.DeletePasskey(ctx, alice.ID, first.ID); err != nil {
+		t.Fatalf("could not delete passkey: %v", err)
+	}
+
+	// deleted accounts can't log in with the passkeys they had
+	if err := store.DeleteUser(ctx, alice.ID); err != nil {
+		t.Fatalf("could not delete user: %v", err)
+	}
+	if _, err := store.GetPasskeyByCredentialID(ctx, []byte("cred-2")); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("passkey of a deleted user: want %v, got %v", storage.ErrNotFound, err)
+	}
+}
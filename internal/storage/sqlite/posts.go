@@ -6,6 +6,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"strings"
 )
 
@@ -25,8 +26,21 @@ var (
 	ErrGetPostsByBlogID        = errors.New("could not get posts by blog ID")
 	ErrGetPostBySlugOrPublicID = errors.New("could not get post by slug or public ID")
 	ErrPostIdentifier          = errors.New("post identifier must not be empty")
+	ErrPostSlugReserved        = errors.New("slug is reserved for blog pages")
+	ErrPostCategory            = errors.New("category can only be nil OR between 1 and 50 chars")
+	ErrGetPostByPublicID       = errors.New("could not get post by public ID")
+	ErrGetPostByID             = errors.New("could not get post by id")
+	ErrGetDraftsByAuthor       = errors.New("could not get drafts by author")
+	ErrUpdatePost              = errors.New("could not update post")
+	ErrDeletePost              = errors.New("could not delete post")
 )
 
+// reservedPostSlugs would be shadowed by blog level routes sharing the /blogs/{blog_slug}/{post_slug} shape
+var reservedPostSlugs = map[string]struct{}{
+	"search": {},
+	"join":   {},
+}
+
 func (s *Store) CreatePost(ctx context.Context, p storage.CreatePostParams) (*storage.Post, error) {
 	if p.AuthorID < 1 || p.BlogID < 1 {
 		return nil, fmt.Errorf("%w: %w", ErrCreatingPost, ErrInvalidAuthorOrBlog)
@@ -34,6 +48,9 @@ func (s *Store) CreatePost(ctx context.Context, p storage.CreatePostParams) (*st
 	if err := validatePostDetails(p.Slug, p.Title, p.Description); err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrCreatingPost, err)
 	}
+	if err := validatePostCategory(p.Category); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCreatingPost, err)
+	}
 	publicID := p.PublicID
 	if publicID == "" {
 		var err error
@@ -50,24 +67,26 @@ func (s *Store) CreatePost(ctx context.Context, p storage.CreatePostParams) (*st
 		return nil, fmt.Errorf("%w: %w", ErrCreatingPost, err)
 	}
 
-	query := `INSERT INTO posts (blog_id, author_id, public_id, slug, title, description, s3_key, is_encrypted, encryption_iv, requires_auth, is_listed, allow_comments, published_at)
-				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
-				RETURNING id, blog_id, author_id, public_id, slug, title, description, s3_key, is_encrypted, encryption_iv, requires_auth, is_listed, allow_comments, published_at, created_at`
+	query := `INSERT INTO posts (blog_id, author_id, public_id, slug, title, description, category, s3_key, is_encrypted, encryption_iv, requires_auth, is_listed, allow_comments, published_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+				RETURNING id, blog_id, author_id, public_id, slug, title, description, category, s3_key, is_encrypted, encryption_iv, requires_auth, is_listed, allow_comments, published_at, created_at`
 
 	var post storage.Post
-	if err := s.db.GetContext(ctx, &post, query, p.BlogID, p.AuthorID, publicID, p.Slug, p.Title, p.Description, s3Key, p.IsEncrypted, p.EncryptionIV, p.RequiresAuth, p.IsListed, p.AllowComments, p.PublishedAt); err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrCreatingPost, err)
+	if err := s.db.GetContext(ctx, &post, query, p.BlogID, p.AuthorID, publicID, p.Slug, p.Title, p.Description, p.Category, s3Key, p.IsEncrypted, p.EncryptionIV, p.RequiresAuth, p.IsListed, p.AllowComments, p.PublishedAt); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCreatingPost, mapSqlError(err))
 	}
 	return &post, nil
 }
 
-// GetLatestPublicPosts returns the latest public posts from all public and visible blogs
-func (s *Store) GetLatestPublicPosts(ctx context.Context, offset, limit int64) ([]*storage.Post, error) {
-	if offset < 0 || limit <= 0 {
-		return nil, fmt.Errorf("%w: %w", ErrLatestPublicPosts, ErrLimitOffset)
+// GetLatestPublicPosts returns a page of the latest public posts from all public and visible blogs
+func (s *Store) GetLatestPublicPosts(ctx context.Context, cursor storage.Cursor, limit int64) ([]*storage.Post, error) {
+	if err := validCursor(cursor, limit); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLatestPublicPosts, err)
 	}
 
-	query := `SELECT p.id, p.blog_id, p.author_id, p.public_id, p.slug, p.title, p.description, p.is_listed, p.published_at, u.username AS author_name, b.slug AS blog_slug
+	where, order, args := keyset(cursor, "p.published_at", "p.id")
+	query := fmt.Sprintf(`SELECT p.id, p.blog_id, p.author_id, p.public_id, p.slug, p.title, p.description, p.s3_key, p.is_encrypted, p.requires_auth, p.is_listed, p.published_at, p.updated_at,
+		u.username AS author_name, b.slug AS blog_slug
 				FROM posts AS p
 				JOIN blogs AS b ON b.id = p.blog_id
 				JOIN users AS u ON u.id = p.author_id
@@ -77,14 +96,17 @@ func (s *Store) GetLatestPublicPosts(ctx context.Context, offset, limit int64) (
 				AND p.published_at <= CURRENT_TIMESTAMP
 				AND b.deleted_at IS NULL
 				AND b.visibility = 'public'
-				ORDER BY p.published_at DESC
-				LIMIT ?
-				OFFSET ?`
+				AND %s
+				ORDER BY %s
+				LIMIT ?`, where, order)
 
 	posts := make([]*storage.Post, 0)
-	if err := s.db.SelectContext(ctx, &posts, query, limit, offset); err != nil {
+	if err := s.db.SelectContext(ctx, &posts, query, append(args, limit)...); err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrLatestPublicPosts, err)
 	}
+	if cursor.Before {
+		slices.Reverse(posts)
+	}
 	return posts, nil
 }
 
@@ -98,15 +120,17 @@ func (s *Store) GetAllPostPublicIDs(ctx context.Context) ([]string, error) {
 	return ids, nil
 }
 
-func (s *Store) GetPostsByBlogID(ctx context.Context, blogID, offset, limit int64) ([]*storage.Post, error) {
+// GetPostsByBlogID returns a page of the published posts of a blog, the latest first
+func (s *Store) GetPostsByBlogID(ctx context.Context, blogID int64, cursor storage.Cursor, limit int64) ([]*storage.Post, error) {
 	if blogID < 1 {
 		return nil, ErrNegativeIDs
 	}
-	if offset < 0 || limit <= 0 {
-		return nil, ErrLimitOffset
+	if err := validCursor(cursor, limit); err != nil {
+		return nil, err
 	}
 
-	query := `SELECT p.id, p.blog_id, p.author_id, p.public_id, p.slug, p.title, p.description, p.is_listed, p.published_at,
+	where, order, args := keyset(cursor, "p.published_at", "p.id")
+	query := fmt.Sprintf(`SELECT p.id, p.blog_id, p.author_id, p.public_id, p.slug, p.title, p.description, p.s3_key, p.is_encrypted, p.requires_auth, p.is_listed, p.published_at, p.updated_at,
 	 u.username AS author_name,
 	 b.slug AS blog_slug
 		FROM posts AS p
@@ -118,14 +142,17 @@ func (s *Store) GetPostsByBlogID(ctx context.Context, blogID, offset, limit int6
 		AND p.published_at <= CURRENT_TIMESTAMP
 		AND p.is_listed = 1
 		AND b.deleted_at IS NULL
-		ORDER BY p.published_at DESC
-		LIMIT ?
-		OFFSET ?`
+		AND %s
+		ORDER BY %s
+		LIMIT ?`, where, order)
 
 	posts := make([]*storage.Post, 0)
-	if err := s.db.SelectContext(ctx, &posts, query, blogID, limit, offset); err != nil {
+	if err := s.db.SelectContext(ctx, &posts, query, append(append([]any{blogID}, args...), limit)...); err != nil {
 		return nil, fmt.Errorf("%w: %w", ErrGetPostsByBlogID, err)
 	}
+	if cursor.Before {
+		slices.Reverse(posts)
+	}
 
 	return posts, nil
 }
@@ -138,9 +165,10 @@ func (s *Store) GetPostBySlugOrPublicID(ctx context.Context, blogSlug, postIdent
 		return nil, ErrPostIdentifier
 	}
 
-	query := `SELECT p.id, p.blog_id, p.author_id, p.public_id, p.slug, p.title, p.description, p.s3_key, p.is_listed, p.published_at,
+	// unlisted posts are left out of listings but stay reachable through their link
+	query := `SELECT p.id, p.blog_id, p.author_id, p.public_id, p.slug, p.title, p.description, p.category, p.s3_key, p.is_encrypted, p.encryption_iv, p.requires_auth, p.is_listed, p.allow_comments, p.published_at, p.updated_at,
 	 u.username AS author_name,
-	 b.slug AS blog_slug
+	 b.slug AS blog_slug, b.visibility AS blog_visibility
 		FROM posts AS p
 		JOIN blogs AS b ON b.id = p.blog_id
 		JOIN users AS u ON u.id = p.author_id
@@ -149,26 +177,143 @@ func (s *Store) GetPostBySlugOrPublicID(ctx context.Context, blogSlug, postIdent
 		AND p.deleted_at IS NULL
 		AND p.published_at IS NOT NULL
 		AND p.published_at <= CURRENT_TIMESTAMP
-		AND p.is_listed = 1
 		AND b.deleted_at IS NULL`
 
 	var post storage.Post
 	if err := s.db.GetContext(ctx, &post, query, blogSlug, postIdentifier, postIdentifier); err != nil {
-		return nil, fmt.Errorf("%w: %w", ErrGetPostBySlugOrPublicID, err)
+		return nil, fmt.Errorf("%w: %w", ErrGetPostBySlugOrPublicID, mapSqlError(err))
+	}
+
+	return &post, nil
+}
+
+// GetPostByPublicID returns a post regardless of its publishing state, used by the seeder to update existing posts
+func (s *Store) GetPostByPublicID(ctx context.Context, publicID string) (*storage.Post, error) {
+	if publicID == "" {
+		return nil, fmt.Errorf("%w: %w", ErrGetPostByPublicID, ErrInvalidPublicID)
+	}
+
+	query := `SELECT p.id, p.blog_id, p.author_id, p.public_id, p.slug, p.title, p.description, p.category, p.s3_key, p.is_encrypted, p.encryption_iv, p.requires_auth, p.is_listed, p.allow_comments, p.published_at, p.created_at, p.updated_at,
+	 u.username AS author_name,
+	 b.slug AS blog_slug
+		FROM posts AS p
+		JOIN blogs AS b ON b.id = p.blog_id
+		JOIN users AS u ON u.id = p.author_id
+		WHERE p.public_id = ?
+		AND p.deleted_at IS NULL`
+
+	var post storage.Post
+	if err := s.db.GetContext(ctx, &post, query, publicID); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrGetPostByPublicID, mapSqlError(err))
+	}
+	return &post, nil
+}
+
+// GetPostByID returns a post whatever its publishing state, for its author and the blog editors
+func (s *Store) GetPostByID(ctx context.Context, postID int64) (*storage.Post, error) {
+	if postID < 1 {
+		return nil, fmt.Errorf("%w: %w", ErrGetPostByID, ErrInvalidPostID)
 	}
 
+	query := `SELECT p.id, p.blog_id, p.author_id, p.public_id, p.slug, p.title, p.description, p.category, p.s3_key, p.is_encrypted, p.encryption_iv, p.requires_auth, p.is_listed, p.allow_comments, p.published_at, p.created_at, p.updated_at,
+	 u.username AS author_name,
+	 b.slug AS blog_slug, b.visibility AS blog_visibility
+		FROM posts AS p
+		JOIN blogs AS b ON b.id = p.blog_id
+		JOIN users AS u ON u.id = p.author_id
+		WHERE p.id = ?
+		AND p.deleted_at IS NULL
+		AND b.deleted_at IS NULL`
+
+	var post storage.Post
+	if err := s.db.GetContext(ctx, &post, query, postID); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrGetPostByID, mapSqlError(err))
+	}
 	return &post, nil
 }
 
+// GetDraftsByAuthor lists posts of an author that readers can't see yet, unpublished or scheduled, last edited first
+func (s *Store) GetDraftsByAuthor(ctx context.Context, authorID, offset, limit int64) ([]*storage.Post, error) {
+	if authorID < 1 {
+		return nil, fmt.Errorf("%w: %w", ErrGetDraftsByAuthor, ErrInvalidAuthorOrBlog)
+	}
+	if offset < 0 || limit <= 0 {
+		return nil, fmt.Errorf("%w: %w", ErrGetDraftsByAuthor, ErrLimitOffset)
+	}
+
+	query := `SELECT p.id, p.blog_id, p.author_id, p.public_id, p.slug, p.title, p.description, p.category, p.s3_key, p.is_encrypted, p.requires_auth, p.is_listed, p.allow_comments, p.published_at, p.created_at, p.updated_at,
+	 u.username AS author_name,
+	 b.slug AS blog_slug
+		FROM posts AS p
+		JOIN blogs AS b ON b.id = p.blog_id
+		JOIN users AS u ON u.id = p.author_id
+		WHERE p.author_id = ?
+		AND p.deleted_at IS NULL
+		AND (p.published_at IS NULL OR p.published_at > CURRENT_TIMESTAMP)
+		AND b.deleted_at IS NULL
+		ORDER BY COALESCE(p.updated_at, p.created_at) DESC, p.id DESC
+		LIMIT ?
+		OFFSET ?`
+
+	posts := make([]*storage.Post, 0)
+	if err := s.db.SelectContext(ctx, &posts, query, authorID, limit, offset); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrGetDraftsByAuthor, mapSqlError(err))
+	}
+	return posts, nil
+}
+
+func (s *Store) UpdatePost(ctx context.Context, p storage.UpdatePostParams) (*storage.Post, error) {
+	if p.PostID < 1 {
+		return nil, fmt.Errorf("%w: %w", ErrUpdatePost, ErrInvalidPostID)
+	}
+	if err := validatePostDetails(p.Slug, p.Title, p.Description); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUpdatePost, err)
+	}
+	if err := validatePostCategory(p.Category); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUpdatePost, err)
+	}
+
+	query := `UPDATE posts SET slug = ?, title = ?, description = ?, category = ?, requires_auth = ?, is_listed = ?, allow_comments = ?, published_at = ?
+				WHERE id = ? AND deleted_at IS NULL
+				RETURNING id, blog_id, author_id, public_id, slug, title, description, category, s3_key, is_encrypted, encryption_iv, requires_auth, is_listed, allow_comments, published_at, created_at, updated_at`
+
+	var post storage.Post
+	if err := s.db.GetContext(ctx, &post, query, p.Slug, p.Title, p.Description, p.Category, p.RequiresAuth, p.IsListed, p.AllowComments, p.PublishedAt, p.PostID); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUpdatePost, mapSqlError(err))
+	}
+	return &post, nil
+}
+
+// DeletePost soft deletes a post, its slug can be used again and its s3 object is left alone
+func (s *Store) DeletePost(ctx context.Context, postID int64) error {
+	if postID < 1 {
+		return fmt.Errorf("%w: %w", ErrDeletePost, ErrInvalidPostID)
+	}
+
+	result, err := s.db.ExecContext(ctx, `UPDATE posts SET deleted_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL`, postID)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDeletePost, mapSqlError(err))
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDeletePost, mapSqlError(err))
+	}
+	if rows == 0 {
+		return fmt.Errorf("%w: %w", ErrDeletePost, storage.ErrNotFound)
+	}
+	return nil
+}
+
 func validatePostDetails(slug *string, title string, description *string) error {
 	if err := validatePostSlug(slug); err != nil {
 		return err
 	}
 	if len(title) < minTitleLen || len(title) > maxTitleLen {
-		return ErrPostTitle
+		return invalid("title", ErrPostTitle)
 	}
 	if description != nil && len(*description) > maxDescriptionLen {
-		return ErrPostDescription
+		return invalid("description", ErrPostDescription)
 	}
 	return nil
 }
@@ -178,7 +323,20 @@ func validatePostSlug(slug *string) error {
 		return nil
 	}
 	if len(*slug) < minSlugLen || len(*slug) > maxSlugLen || !validSlug.MatchString(*slug) {
-		return ErrPostSlug
+		return invalid("slug", ErrPostSlug)
+	}
+	if _, reserved := reservedPostSlugs[*slug]; reserved {
+		return invalid("slug", ErrPostSlugReserved)
+	}
+	return nil
+}
+
+func validatePostCategory(category *string) error {
+	if category == nil {
+		return nil
+	}
+	if len(*category) < 1 || len(*category) > maxCategoryLen {
+		return invalid("category", ErrPostCategory)
 	}
 	return nil
 }
@@ -4,8 +4,10 @@ import (
 	"blogengine/internal/storage"
 	"context"
 	"errors"
+	"io"
 	"log/slog"
 	"math"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -18,7 +20,7 @@ func setupTestBlog(t *testing.T) (*Store, *storage.User, *storage.Blog) {
 	ctx := context.Background()
 
 	logger := slog.New(slog.DiscardHandler)
-	if err := store.Bootstrap(ctx, logger); err != nil {
+	if err := store.Bootstrap(ctx, logger, "", io.Discard); err != nil {
 		t.Fatalf("could not bootstrap store: %s", err)
 	}
 	user, err := store.CreateUser(ctx, "test_user", gen60CharString())
@@ -174,46 +176,47 @@ func TestGetLatestPublicPosts(t *testing.T) {
 		postsUnlisted bool
 		isDraft       bool
 		limit         int64
-		offset        int64
+		cursor        storage.Cursor
 		wantLen       int
 		wantErr       error
 	}{
 		{
-			name:   "nominal",
-			offset: 0, limit: 5,
+			name:    "nominal",
+			limit:   5,
 			wantLen: 2,
 			wantErr: nil,
 		},
 		{
-			name:   "one listed post, two unlisted",
-			offset: 0, limit: 5,
+			name:          "one listed post, two unlisted",
+			limit:         5,
 			postsUnlisted: true,
 			wantLen:       1,
 			wantErr:       nil,
 		},
 		{
-			name:   "one listed post, two draft",
-			offset: 0, limit: 5,
+			name:    "one listed post, two draft",
+			limit:   5,
 			isDraft: true,
 			wantLen: 1,
 			wantErr: nil,
 		},
 		{
-			name:   "one listed post, other blog private",
-			offset: 0, limit: 5,
+			name:         "one listed post, other blog private",
+			limit:        5,
 			blog2Private: true,
 			wantLen:      1,
 			wantErr:      nil,
 		},
 		{
-			name:   "bad offset",
-			offset: -1, limit: 5,
+			name:    "bad cursor",
+			cursor:  storage.Cursor{ID: -1},
+			limit:   5,
 			wantLen: 1,
 			wantErr: ErrLatestPublicPosts,
 		},
 		{
-			name:   "bad limit",
-			offset: 0, limit: 0,
+			name:    "bad limit",
+			limit:   0,
 			wantLen: 1,
 			wantErr: ErrLatestPublicPosts,
 		},
@@ -306,7 +309,7 @@ func TestGetLatestPublicPosts(t *testing.T) {
 				t.Fatalf("could not create second test post: %s", err)
 			}
 
-			results, err := store.GetLatestPublicPosts(ctx, tt.offset, tt.limit)
+			results, err := store.GetLatestPublicPosts(ctx, tt.cursor, tt.limit)
 			if !errors.Is(err, tt.wantErr) {
 				t.Fatalf("errors: want %s, got %s", tt.wantErr, err)
 			}
@@ -321,6 +324,198 @@ func TestGetLatestPublicPosts(t *testing.T) {
 	}
 }
 
+func TestPostListingsShareTimestamp(t *testing.T) {
+	t.Parallel()
+	store, user, blog := setupTestBlog(t)
+	ctx := context.Background()
+
+	// seeded posts often share their publication time, paging must neither skip nor repeat them
+	publishedAt := time.Now().Add(-time.Hour).Truncate(time.Second)
+	var want []int64
+	for _, slug := range []string{"post-one", "post-two", "post-three", "post-four", "post-five"} {
+		want = append([]int64{createTestPost(t, store, user, blog, slug, &publishedAt).ID}, want...)
+	}
+	createTestPost(t, store, user, blog, "post-older", new(publishedAt.Add(-time.Minute)))
+
+	listings := map[string]func(storage.Cursor) ([]*storage.Post, error){
+		"latest public posts": func(c storage.Cursor) ([]*storage.Post, error) { return store.GetLatestPublicPosts(ctx, c, 2) },
+		"posts of the blog":   func(c storage.Cursor) ([]*storage.Post, error) { return store.GetPostsByBlogID(ctx, blog.ID, c, 2) },
+	}
+	for name, list := range listings {
+		t.Run(name, func(t *testing.T) {
+			var pages [][]*storage.Post
+			var cursor storage.Cursor
+			for {
+				posts, err := list(cursor)
+				if err != nil {
+					t.Fatalf("could not get posts: %v", err)
+				}
+				if len(posts) == 0 {
+					break
+				}
+				pages = append(pages, posts)
+				cursor = posts[len(posts)-1].Cursor()
+			}
+			got := postIDs(slices.Concat(pages...))
+			if len(got) != 6 || !slices.Equal(got[:5], want) {
+				t.Fatalf("forward: want %v then the older post, got %v", want, got)
+			}
+
+			// back from the last page, the same pages come newest first
+			for i := len(pages) - 1; i > 0; i-- {
+				cursor = pages[i][0].Cursor()
+				cursor.Before = true
+				posts, err := list(cursor)
+				if err != nil {
+					t.Fatalf("could not get posts: %v", err)
+				}
+				if want := postIDs(pages[i-1]); !slices.Equal(postIDs(posts), want) {
+					t.Fatalf("back to page %d: want %v, got %v", i, want, postIDs(posts))
+				}
+			}
+		})
+	}
+}
+
+func postIDs(posts []*storage.Post) []int64 {
+	ids := make([]int64, len(posts))
+	for i, p := range posts {
+		ids[i] = p.ID
+	}
+	return ids
+}
+
+func TestGetPostBySlugOrPublicID(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name              string
+		isListed          bool
+		requiresAuth      bool
+		allowComments     bool
+		publishedAt       *time.Time
+		byPublicID        bool
+		identifier        *string
+		deleteBlog        bool
+		wantRequiresAuth  bool
+		wantAllowComments bool
+		wantErr           error
+	}{
+		{
+			name:     "nominal by slug",
+			isListed: true, allowComments: true,
+			publishedAt:       new(time.Now().Add(-time.Hour)),
+			wantAllowComments: true,
+		},
+		{
+			name:     "by public id",
+			isListed: true, byPublicID: true,
+			publishedAt: new(time.Now().Add(-time.Hour)),
+		},
+		{
+			name:        "unlisted is reachable by link",
+			isListed:    false,
+			publishedAt: new(time.Now().Add(-time.Hour)),
+		},
+		{
+			name:     "access flags are returned",
+			isListed: true, requiresAuth: true, allowComments: false,
+			publishedAt:      new(time.Now().Add(-time.Hour)),
+			wantRequiresAuth: true,
+		},
+		{
+			name:        "draft is not found",
+			isListed:    true,
+			publishedAt: nil,
+			wantErr:     storage.ErrNotFound,
+		},
+		{
+			name:        "scheduled is not found",
+			isListed:    true,
+			publishedAt: new(time.Now().Add(time.Hour)),
+			wantErr:     storage.ErrNotFound,
+		},
+		{
+			name:        "deleted blog",
+			isListed:    true,
+			publishedAt: new(time.Now().Add(-time.Hour)),
+			deleteBlog:  true,
+			wantErr:     storage.ErrNotFound,
+		},
+		{
+			name:        "unknown identifier",
+			isListed:    true,
+			publishedAt: new(time.Now().Add(-time.Hour)),
+			identifier:  new("not-a-post"),
+			wantErr:     storage.ErrNotFound,
+		},
+		{
+			name:        "empty identifier",
+			isListed:    true,
+			publishedAt: new(time.Now().Add(-time.Hour)),
+			identifier:  new(""),
+			wantErr:     ErrPostIdentifier,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			store, user, blog := setupTestBlog(t)
+
+			post, err := store.CreatePost(ctx, storage.CreatePostParams{
+				BlogID:        blog.ID,
+				AuthorID:      user.ID,
+				Slug:          new("a-post-slug"),
+				Title:         "Title of the post",
+				RequiresAuth:  tt.requiresAuth,
+				IsListed:      tt.isListed,
+				AllowComments: tt.allowComments,
+				PublishedAt:   tt.publishedAt,
+			})
+			if err != nil {
+				t.Fatalf("could not create post: %s", err)
+			}
+
+			if tt.deleteBlog {
+				if err := store.DeleteBlog(ctx, blog.ID, user.ID); err != nil {
+					t.Fatalf("could not delete blog: %s", err)
+				}
+			}
+
+			identifier := *post.Slug
+			switch {
+			case tt.identifier != nil:
+				identifier = *tt.identifier
+			case tt.byPublicID:
+				identifier = post.PublicID
+			}
+
+			got, err := store.GetPostBySlugOrPublicID(ctx, blog.Slug, identifier)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("errors: want %s, got %s", tt.wantErr, err)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			if got.ID != post.ID {
+				t.Fatalf("post id: want %d, got %d", post.ID, got.ID)
+			}
+			if got.RequiresAuth != tt.wantRequiresAuth {
+				t.Fatalf("requires auth: want %t, got %t", tt.wantRequiresAuth, got.RequiresAuth)
+			}
+			if got.AllowComments != tt.wantAllowComments {
+				t.Fatalf("allow comments: want %t, got %t", tt.wantAllowComments, got.AllowComments)
+			}
+			if got.IsListed != tt.isListed {
+				t.Fatalf("is listed: want %t, got %t", tt.isListed, got.IsListed)
+			}
+		})
+	}
+}
+
 func TestValidatePostDetails(t *testing.T) {
 	t.Parallel()
 	tests := []struct {
@@ -459,6 +654,11 @@ func TestValidatePostSlug(t *testing.T) {
 			slug:    nil,
 			wantErr: nil,
 		},
+		{
+			name:    "reserved slug",
+			slug:    new("search"),
+			wantErr: ErrPostSlugReserved,
+		},
 	}
 
 	for _, tt := range tests {
@@ -531,7 +731,7 @@ func TestGenPostS3Key(t *testing.T) {
 			ctx := context.Background()
 
 			logger := slog.New(slog.DiscardHandler)
-			if err := store.Bootstrap(ctx, logger); err != nil {
+			if err := store.Bootstrap(ctx, logger, "", io.Discard); err != nil {
 				t.Fatalf("could not bootstrap store: %s", err)
 			}
 
@@ -622,3 +822,134 @@ func TestValidatePostEncryptionSettings(t *testing.T) {
 		})
 	}
 }
+
+func createTestPost(t *testing.T, store *Store, user *storage.User, blog *storage.Blog, slug string, publishedAt *time.Time) *storage.Post {
+	t.Helper()
+
+	params := storage.CreatePostParams{
+		BlogID:        blog.ID,
+		AuthorID:      user.ID,
+		Slug:          &slug,
+		Title:         "a post title",
+		IsListed:      true,
+		AllowComments: true,
+		PublishedAt:   publishedAt,
+	}
+	post, err := store.CreatePost(context.Background(), params)
+	if err != nil {
+		t.Fatalf("could not create post: %s", err)
+	}
+	return post
+}
+
+func TestUpdatePost(t *testing.T) {
+	t.Parallel()
+	store, user, blog := setupTestBlog(t)
+	ctx := context.Background()
+
+	post := createTestPost(t, store, user, blog, "first-post", nil)
+	createTestPost(t, store, user, blog, "second-post", nil)
+
+	tests := []struct {
+		name      string
+		postID    int64
+		slug      string
+		title     string
+		wantErr   error
+		wantField string
+	}{
+		{name: "nominal", postID: post.ID, slug: "renamed-post", title: "a new title"},
+		{name: "invalid title", postID: post.ID, slug: "renamed-post", title: "no", wantErr: ErrPostTitle, wantField: "title"},
+		{name: "taken slug", postID: post.ID, slug: "second-post", title: "a new title", wantErr: storage.ErrUniqueViolation},
+		{name: "missing post", postID: math.MaxInt64, slug: "another-post", title: "a new title", wantErr: storage.ErrNotFound},
+		{name: "invalid post id", postID: 0, slug: "another-post", title: "a new title", wantErr: ErrInvalidPostID},
+	}
+
+	for _, tt := range tests {
+		params := storage.UpdatePostParams{PostID: tt.postID, Slug: &tt.slug, Title: tt.title, IsListed: true}
+		got, err := store.UpdatePost(ctx, params)
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("%s: want %v, got %v", tt.name, tt.wantErr, err)
+		}
+		if tt.wantField != "" {
+			var validationErr *storage.ValidationError
+			if !errors.As(err, &validationErr) || validationErr.Field != tt.wantField {
+				t.Fatalf("%s: want a validation error on %q, got %v", tt.name, tt.wantField, err)
+			}
+		}
+		if err == nil && (*got.Slug != tt.slug || got.Title != tt.title || got.S3Key != post.S3Key) {
+			t.Fatalf("%s: post not updated: %+v", tt.name, got)
+		}
+	}
+}
+
+func TestDeletePost(t *testing.T) {
+	t.Parallel()
+	store, user, blog := setupTestBlog(t)
+	ctx := context.Background()
+
+	post := createTestPost(t, store, user, blog, "a-post-slug", nil)
+
+	if err := store.DeletePost(ctx, post.ID); err != nil {
+		t.Fatalf("could not delete post: %s", err)
+	}
+	if _, err := store.GetPostByID(ctx, post.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("get deleted post: want %s, got %v", storage.ErrNotFound, err)
+	}
+	if err := store.DeletePost(ctx, post.ID); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("delete twice: want %s, got %v", storage.ErrNotFound, err)
+	}
+
+	// the slug of a deleted post is free again
+	createTestPost(t, store, user, blog, "a-post-slug", nil)
+}
+
+func TestGetPostByID(t *testing.T) {
+	t.Parallel()
+	store, user, blog := setupTestBlog(t)
+
+	// drafts are not public but their author still loads them
+	post := createTestPost(t, store, user, blog, "a-draft", nil)
+
+	got, err := store.GetPostByID(context.Background(), post.ID)
+	if err != nil {
+		t.Fatalf("could not get post: %s", err)
+	}
+	if got.AuthorName != user.Username || got.BlogSlug != blog.Slug || got.BlogVisibility != blog.Visibility {
+		t.Fatalf("joined fields: got author %q, blog %q, visibility %q", got.AuthorName, got.BlogSlug, got.BlogVisibility)
+	}
+}
+
+func TestGetDraftsByAuthor(t *testing.T) {
+	t.Parallel()
+	store, user, blog := setupTestBlog(t)
+	ctx := context.Background()
+	other := createTestUsers(t, store, 1)[0]
+
+	past := time.Now().Add(-time.Hour).UTC()
+	future := time.Now().Add(time.Hour).UTC()
+
+	draft := createTestPost(t, store, user, blog, "a-draft", nil)
+	scheduled := createTestPost(t, store, user, blog, "a-scheduled-post", &future)
+	createTestPost(t, store, user, blog, "a-published-post", &past)
+	deleted := createTestPost(t, store, user, blog, "a-deleted-draft", nil)
+	if err := store.DeletePost(ctx, deleted.ID); err != nil {
+		t.Fatalf("could not delete post: %s", err)
+	}
+
+	drafts, err := store.GetDraftsByAuthor(ctx, user.ID, 0, 10)
+	if err != nil {
+		t.Fatalf("could not get drafts: %s", err)
+	}
+	if len(drafts) != 2 || drafts[0].ID != scheduled.ID || drafts[1].ID != draft.ID {
+		t.Fatalf("drafts: want %d then %d, got %+v", scheduled.ID, draft.ID, drafts)
+	}
+
+	drafts, err = store.GetDraftsByAuthor(ctx, other.ID, 0, 10)
+	if err != nil {
+		t.Fatalf("could not get drafts: %s", err)
+	}
+	if len(drafts) != 0 {
+		t.Fatalf("drafts of another author: want none, got %d", len(drafts))
+	}
+}
@@ -0,0 +1,79 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// CreatePasswordReset stores the hash of a reset token for the user, expired resets of every user are cleared on the
+// way. Tokens of a user stop working once their password changes, see trg_users_password_resets
+func (s *Store) CreatePasswordReset(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error {
+	if userID < 1 {
+		return fmt.Errorf("%w: %w", ErrPasswordReset, ErrNegativeIDs)
+	}
+	if tokenHash == "" {
+		return fmt.Errorf("%w: %w", ErrPasswordReset, ErrResetTokenHash)
+	}
+	if !expiresAt.After(time.Now()) {
+		return fmt.Errorf("%w: %w", ErrPasswordReset, ErrResetExpiry)
+	}
+
+	err := s.WithTx(ctx, func(tx *sqlx.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM password_resets WHERE expires_at <= CURRENT_TIMESTAMP`); err != nil {
+			return mapSqlError(err)
+		}
+
+		query := `INSERT INTO password_resets (user_id, token_hash, expires_at)
+					SELECT id, ?, ? FROM users
+					WHERE id = ? AND deleted_at IS NULL`
+
+		return execOne(ctx, tx, storage.ErrNotFound, query, tokenHash, expiresAt.UTC(), userID)
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPasswordReset, err)
+	}
+	return nil
+}
+
+// GetPasswordReset returns the live reset matching tokenHash without using it, so the reset page can tell a dead link
+// before a new password is typed
+func (s *Store) GetPasswordReset(ctx context.Context, tokenHash string) (*storage.PasswordReset, error) {
+	if tokenHash == "" {
+		return nil, fmt.Errorf("%w: %w", ErrUsePasswordReset, ErrResetTokenHash)
+	}
+
+	query := `SELECT id, user_id, expires_at, created_at FROM password_resets
+				WHERE token_hash = ?
+				AND expires_at > CURRENT_TIMESTAMP
+				AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)`
+
+	var reset storage.PasswordReset
+	if err := s.db.GetContext(ctx, &reset, query, tokenHash); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUsePasswordReset, mapSqlError(err))
+	}
+	return &reset, nil
+}
+
+// UsePasswordReset removes the live reset matching tokenHash and returns it, a token works once even when two
+// requests race for it. Expired resets and resets of deleted users are not found
+func (s *Store) UsePasswordReset(ctx context.Context, tokenHash string) (*storage.PasswordReset, error) {
+	if tokenHash == "" {
+		return nil, fmt.Errorf("%w: %w", ErrUsePasswordReset, ErrResetTokenHash)
+	}
+
+	query := `DELETE FROM password_resets
+				WHERE token_hash = ?
+				AND expires_at > CURRENT_TIMESTAMP
+				AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+				RETURNING id, user_id, expires_at, created_at`
+
+	var reset storage.PasswordReset
+	if err := s.db.GetContext(ctx, &reset, query, tokenHash); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUsePasswordReset, mapSqlError(err))
+	}
+	return &reset, nil
+}
@@ -0,0 +1,64 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPasswordResets(t *testing.T) {
+	t.Parallel()
+	store, user, _ := setupTestBlog(t)
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+
+	if err := store.CreatePasswordReset(ctx, user.ID, "hash-1", time.Now().Add(-time.Minute)); !errors.Is(err, ErrResetExpiry) {
+		t.Fatalf("already expired: want %v, got %v", ErrResetExpiry, err)
+	}
+	if err := store.CreatePasswordReset(ctx, 1000, "hash-1", expiresAt); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("unknown user: want %v, got %v", storage.ErrNotFound, err)
+	}
+	for _, hash := range []string{"used-hash", "expired-hash", "other-hash"} {
+		if err := store.CreatePasswordReset(ctx, user.ID, hash, expiresAt); err != nil {
+			t.Fatalf("could not create reset: %v", err)
+		}
+	}
+	if _, err := store.db.ExecContext(ctx, `UPDATE password_resets SET expires_at = datetime('now', '-1 minute') WHERE token_hash = 'expired-hash'`); err != nil {
+		t.Fatalf("could not expire reset: %v", err)
+	}
+
+	// looking doesn't use the token, using it does
+	if reset, err := store.GetPasswordReset(ctx, "used-hash"); err != nil || reset.UserID != user.ID {
+		t.Fatalf("get: want a reset of user %d, got %v (%v)", user.ID, reset, err)
+	}
+	if reset, err := store.UsePasswordReset(ctx, "used-hash"); err != nil || reset.UserID != user.ID {
+		t.Fatalf("use: want a reset of user %d, got %v (%v)", user.ID, reset, err)
+	}
+	for _, hash := range []string{"used-hash", "expired-hash", "unknown-hash"} {
+		if _, err := store.GetPasswordReset(ctx, hash); !errors.Is(err, storage.ErrNotFound) {
+			t.Fatalf("get %s: want %v, got %v", hash, storage.ErrNotFound, err)
+		}
+		if _, err := store.UsePasswordReset(ctx, hash); !errors.Is(err, storage.ErrNotFound) {
+			t.Fatalf("use %s: want %v, got %v", hash, storage.ErrNotFound, err)
+		}
+	}
+
+	// the expired reset is cleared by the next one, the password change clears the rest
+	if err := store.CreatePasswordReset(ctx, user.ID, "new-hash", expiresAt); err != nil {
+		t.Fatalf("could not create reset: %v", err)
+	}
+	var count int
+	if err := store.db.GetContext(ctx, &count, `SELECT COUNT(*) FROM password_resets`); err != nil || count != 2 {
+		t.Fatalf("resets: want 2, got %d (%v)", count, err)
+	}
+	if err := store.ChangeUserPassword(ctx, user.ID, gen60CharString()); err != nil {
+		t.Fatalf("could not change password: %v", err)
+	}
+	for _, hash := range []string{"other-hash", "new-hash"} {
+		if _, err := store.UsePasswordReset(ctx, hash); !errors.Is(err, storage.ErrNotFound) {
+			t.Fatalf("use %s after a password change: want %v, got %v", hash, storage.ErrNotFound, err)
+		}
+	}
+}
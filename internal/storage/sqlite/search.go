@@ -0,0 +1,113 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrIndexPost     = errors.New("could not index post")
+	ErrSearchPosts   = errors.New("could not search posts")
+	ErrInvalidPostID = errors.New("post id must be > 0")
+)
+
+const (
+	maxSearchTerms  = 10
+	snippetMaxWords = 16
+)
+
+// IndexPostBody stores the markdown body of a post in the search index, title and description are kept in sync by triggers
+func (s *Store) IndexPostBody(ctx context.Context, postID int64, body string) error {
+	if postID < 1 {
+		return fmt.Errorf("%w: %w", ErrIndexPost, ErrInvalidPostID)
+	}
+
+	query := `INSERT OR REPLACE INTO posts_fts (rowid, title, description, body)
+				SELECT id, title, COALESCE(description, ''), ?
+				FROM posts
+				WHERE id = ?`
+
+	res, err := s.db.ExecContext(ctx, query, body, postID)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrIndexPost, mapSqlError(err))
+	}
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrIndexPost, mapSqlError(err))
+	}
+	if rows == 0 {
+		return fmt.Errorf("%w: %w", ErrIndexPost, storage.ErrNotFound)
+	}
+	return nil
+}
+
+// SearchPosts returns posts matching query ranked by relevance, blogID 0 searches every public blog.
+// Only posts that would show in GetLatestPublicPosts are returned
+func (s *Store) SearchPosts(ctx context.Context, query string, blogID, offset, limit int64) ([]*storage.SearchResult, error) {
+	if blogID < 0 {
+		return nil, fmt.Errorf("%w: %w", ErrSearchPosts, ErrBlogIDFilter)
+	}
+	if offset < 0 || limit <= 0 {
+		return nil, fmt.Errorf("%w: %w", ErrSearchPosts, ErrLimitOffset)
+	}
+
+	results := make([]*storage.SearchResult, 0)
+
+	// nothing searchable, e.g. only punctuation
+	match := buildMatchQuery(query)
+	if match == "" {
+		return results, nil
+	}
+
+	// title matches weigh more than description, description more than body
+	q := `SELECT p.id, p.blog_id, p.author_id, p.public_id, p.slug, p.title, p.description, p.s3_key, p.is_encrypted, p.requires_auth, p.is_listed, p.published_at, p.updated_at,
+		u.username AS author_name, b.slug AS blog_slug,
+		snippet(posts_fts, -1, ?, ?, '…', ?) AS snippet
+				FROM posts_fts
+				JOIN posts AS p ON p.id = posts_fts.rowid
+				JOIN blogs AS b ON b.id = p.blog_id
+				JOIN users AS u ON u.id = p.author_id
+				WHERE posts_fts MATCH ?
+				AND (? = 0 OR p.blog_id = ?)
+				AND p.deleted_at IS NULL
+				AND p.is_listed = 1
+				AND p.published_at IS NOT NULL
+				AND p.published_at <= CURRENT_TIMESTAMP
+				AND b.deleted_at IS NULL
+				AND b.visibility = 'public'
+				ORDER BY bm25(posts_fts, 10.0, 5.0, 1.0), p.published_at DESC
+				LIMIT ?
+				OFFSET ?`
+
+	if err := s.db.SelectContext(ctx, &results, q,
+		storage.SnippetMatchStart, storage.SnippetMatchEnd, snippetMaxWords,
+		match, blogID, blogID, limit, offset); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSearchPosts, mapSqlError(err))
+	}
+	return results, nil
+}
+
+// buildMatchQuery turns user input into an fts5 query of quoted terms so no input is parsed as fts5 syntax.
+// All terms must match and the last one is treated as a prefix
+func buildMatchQuery(input string) string {
+	terms := strings.FieldsFunc(input, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	if len(terms) == 0 {
+		return ""
+	}
+	if len(terms) > maxSearchTerms {
+		terms = terms[:maxSearchTerms]
+	}
+
+	for i, term := range terms {
+		terms[i] = `"` + term + `"`
+	}
+	terms[len(terms)-1] += "*"
+
+	return strings.Join(terms, " ")
+}
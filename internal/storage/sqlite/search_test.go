@@ -0,0 +1,241 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchPosts(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		query      string
+		ownBlog    bool
+		offset     int64
+		limit      int64
+		wantTitles []string
+		wantErr    error
+	}{
+		{
+			name:  "matches body",
+			query: "goroutines",
+			limit: 10, wantTitles: []string{"Concurrency notes"},
+		},
+		{
+			name:  "title ranks above body",
+			query: "sqlite",
+			limit: 10, wantTitles: []string{"Why sqlite is enough", "Concurrency notes"},
+		},
+		{
+			name:  "prefix match on last term",
+			query: "concurr",
+			limit: 10, wantTitles: []string{"Concurrency notes"},
+		},
+		{
+			name:  "stemmed match",
+			query: "channel",
+			limit: 10, wantTitles: []string{"Concurrency notes"},
+		},
+		{
+			name:  "all terms must match",
+			query: "sqlite goroutines",
+			limit: 10, wantTitles: []string{"Concurrency notes"},
+		},
+		{
+			name:  "fts syntax is treated as text",
+			query: `title:sqlite OR "unbalanced`,
+			limit: 10, wantTitles: []string{},
+		},
+		{
+			name:  "only punctuation",
+			query: "*** ()",
+			limit: 10, wantTitles: []string{},
+		},
+		{
+			name:  "unlisted, draft, future and private posts are hidden",
+			query: "hidden",
+			limit: 10, wantTitles: []string{},
+		},
+		{
+			name:    "scoped to a blog",
+			query:   "sqlite",
+			ownBlog: true,
+			limit:   10, wantTitles: []string{"Why sqlite is enough"},
+		},
+		{
+			name:   "paginated",
+			query:  "sqlite",
+			offset: 1, limit: 1,
+			wantTitles: []string{"Concurrency notes"},
+		},
+		{
+			name:  "bad limit",
+			query: "sqlite",
+			limit: 0, wantErr: ErrLimitOffset,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			store, user, blog := setupTestBlog(t)
+
+			blog2, err := store.CreateBlog(ctx, storage.CreateBlogParams{
+				OwnerID:          user.ID,
+				Slug:             "another-blog",
+				Title:            "another blog title",
+				Visibility:       storage.VisibilityPublic,
+				RegistrationMode: storage.RegistrationOpen,
+			})
+			if err != nil {
+				t.Fatalf("could not create second blog: %s", err)
+			}
+			private, err := store.CreateBlog(ctx, storage.CreateBlogParams{
+				OwnerID:          user.ID,
+				Slug:             "private-blog",
+				Title:            "private blog title",
+				Visibility:       storage.VisibilityPrivate,
+				RegistrationMode: storage.RegistrationOpen,
+			})
+			if err != nil {
+				t.Fatalf("could not create private blog: %s", err)
+			}
+
+			past := new(time.Now().Add(-24 * time.Hour))
+			future := new(time.Now().Add(24 * time.Hour))
+
+			posts := []struct {
+				blogID      int64
+				title       string
+				body        string
+				listed      bool
+				publishedAt *time.Time
+			}{
+				{blog.ID, "Why sqlite is enough", "a small database for a small site", true, past},
+				{blog2.ID, "Concurrency notes", "goroutines and channels, then storing results in sqlite", true, past},
+				{blog.ID, "Hidden unlisted post", "hidden", false, past},
+				{blog.ID, "Hidden draft post", "hidden", true, nil},
+				{blog.ID, "Hidden future post", "hidden", true, future},
+				{private.ID, "Hidden private post", "hidden", true, past},
+			}
+			for _, p := range posts {
+				post, err := store.CreatePost(ctx, storage.CreatePostParams{
+					BlogID:      p.blogID,
+					AuthorID:    user.ID,
+					Title:       p.title,
+					IsListed:    p.listed,
+					PublishedAt: p.publishedAt,
+				})
+				if err != nil {
+					t.Fatalf("could not create post %q: %s", p.title, err)
+				}
+				if err := store.IndexPostBody(ctx, post.ID, p.body); err != nil {
+					t.Fatalf("could not index post %q: %s", p.title, err)
+				}
+			}
+
+			var blogID int64
+			if tt.ownBlog {
+				blogID = blog.ID
+			}
+
+			results, err := store.SearchPosts(ctx, tt.query, blogID, tt.offset, tt.limit)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("errors: want %s, got %s", tt.wantErr, err)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			gotTitles := make([]string, 0, len(results))
+			for _, r := range results {
+				gotTitles = append(gotTitles, r.Title)
+			}
+			if strings.Join(gotTitles, "|") != strings.Join(tt.wantTitles, "|") {
+				t.Fatalf("results mismatch: want %q, got %q", tt.wantTitles, gotTitles)
+			}
+		})
+	}
+}
+
+func TestSearchPostsSnippet(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store, user, blog := setupTestBlog(t)
+
+	post, err := store.CreatePost(ctx, storage.CreatePostParams{
+		BlogID:      blog.ID,
+		AuthorID:    user.ID,
+		Title:       "Title of the post",
+		IsListed:    true,
+		PublishedAt: new(time.Now().Add(-time.Hour)),
+	})
+	if err != nil {
+		t.Fatalf("could not create post: %s", err)
+	}
+	if err := store.IndexPostBody(ctx, post.ID, "some <b>markup</b> around a keyword"); err != nil {
+		t.Fatalf("could not index post: %s", err)
+	}
+
+	results, err := store.SearchPosts(ctx, "keyword", 0, 0, 10)
+	if err != nil {
+		t.Fatalf("could not search: %s", err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("results: want 1, got %d", len(results))
+	}
+
+	want := storage.SnippetMatchStart + "keyword" + storage.SnippetMatchEnd
+	if !strings.Contains(results[0].Snippet, want) {
+		t.Fatalf("snippet %q does not mark %q", results[0].Snippet, "keyword")
+	}
+}
+
+func TestIndexPostBody(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store, user, blog := setupTestBlog(t)
+
+	post, err := store.CreatePost(ctx, storage.CreatePostParams{
+		BlogID:      blog.ID,
+		AuthorID:    user.ID,
+		Title:       "Title of the post",
+		IsListed:    true,
+		PublishedAt: new(time.Now().Add(-time.Hour)),
+	})
+	if err != nil {
+		t.Fatalf("could not create post: %s", err)
+	}
+
+	// reindexing replaces the previous body
+	for _, body := range []string{"first version", "second version"} {
+		if err := store.IndexPostBody(ctx, post.ID, body); err != nil {
+			t.Fatalf("could not index post: %s", err)
+		}
+	}
+
+	for query, want := range map[string]int{"first": 0, "second": 1, "title": 1} {
+		results, err := store.SearchPosts(ctx, query, 0, 0, 10)
+		if err != nil {
+			t.Fatalf("could not search %q: %s", query, err)
+		}
+		if len(results) != want {
+			t.Errorf("search %q: want %d results, got %d", query, want, len(results))
+		}
+	}
+
+	if err := store.IndexPostBody(ctx, 9999, "body"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("missing post: want %s, got %s", storage.ErrNotFound, err)
+	}
+	if err := store.IndexPostBody(ctx, 0, "body"); !errors.Is(err, ErrInvalidPostID) {
+		t.Fatalf("invalid id: want %s, got %s", ErrInvalidPostID, err)
+	}
+}
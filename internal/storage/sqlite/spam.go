@@ -0,0 +1,101 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// CountPostsWithFingerprint counts the posts other than excludePostID that got a comment with fingerprint in the
+// last within
+func (s *Store) CountPostsWithFingerprint(ctx context.Context, fingerprint string, excludePostID int64, within time.Duration) (int64, error) {
+	query := `SELECT COUNT(DISTINCT post_id)
+		FROM comments
+		WHERE fingerprint = ? AND post_id <> ? AND created_at >= datetime('now', ?)`
+
+	var count int64
+	if err := s.db.GetContext(ctx, &count, query, fingerprint, excludePostID, sqliteInterval(-within)); err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrSpamFilter, mapSqlError(err))
+	}
+
+	return count, nil
+}
+
+// TrainSpamFilter teaches the bayes filter that the tokens of a comment are spam or ham. A comment is learnt once,
+// a moderator changing their mind moves its tokens to the other class
+func (s *Store) TrainSpamFilter(ctx context.Context, commentID int64, tokens []string, spam bool) error {
+	class, other := "ham", "spam"
+	if spam {
+		class, other = "spam", "ham"
+	}
+
+	err := s.WithTx(ctx, func(tx *sqlx.Tx) error {
+		var trained *string
+		if err := tx.GetContext(ctx, &trained, `SELECT trained_as FROM comments WHERE id = ?`, commentID); err != nil {
+			return mapSqlError(err)
+		}
+		if trained != nil && *trained == class {
+			return nil
+		}
+
+		if trained != nil && len(tokens) > 0 {
+			// the column names come from the two constants above, never from the caller
+			query, args, err := sqlx.In(fmt.Sprintf(`UPDATE spam_tokens SET %[1]s = MAX(%[1]s - 1, 0) WHERE token IN (?)`, other), tokens)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.ExecContext(ctx, tx.Rebind(query), args...); err != nil {
+				return mapSqlError(err)
+			}
+		}
+
+		upsert := fmt.Sprintf(`INSERT INTO spam_tokens (token, %[1]s) VALUES (?, 1)
+			ON CONFLICT (token) DO UPDATE SET %[1]s = %[1]s + 1`, class)
+		for _, token := range tokens {
+			if _, err := tx.ExecContext(ctx, upsert, token); err != nil {
+				return mapSqlError(err)
+			}
+		}
+
+		_, err := tx.ExecContext(ctx, `UPDATE comments SET trained_as = ? WHERE id = ?`, class, commentID)
+		return mapSqlError(err)
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSpamFilter, err)
+	}
+
+	return nil
+}
+
+// GetSpamFilterStats returns how many comments the bayes filter learnt of each class and its counts for tokens
+func (s *Store) GetSpamFilterStats(ctx context.Context, tokens []string) (*storage.SpamFilterStats, error) {
+	stats := storage.SpamFilterStats{Tokens: make(map[string]storage.SpamTokenCount)}
+
+	query := `SELECT COALESCE(SUM(trained_as = 'spam'), 0), COALESCE(SUM(trained_as = 'ham'), 0)
+		FROM comments
+		WHERE trained_as IS NOT NULL`
+	if err := s.db.QueryRowxContext(ctx, query).Scan(&stats.SpamDocs, &stats.HamDocs); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSpamFilter, mapSqlError(err))
+	}
+	if len(tokens) == 0 {
+		return &stats, nil
+	}
+
+	query, args, err := sqlx.In(`SELECT token, spam, ham FROM spam_tokens WHERE token IN (?)`, tokens)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSpamFilter, err)
+	}
+
+	var counts []storage.SpamTokenCount
+	if err := s.db.SelectContext(ctx, &counts, s.db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrSpamFilter, mapSqlError(err))
+	}
+	for _, c := range counts {
+		stats.Tokens[c.Token] = c
+	}
+
+	return &stats, nil
+}
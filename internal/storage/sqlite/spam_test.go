@@ -0,0 +1,66 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSpamFilter(t *testing.T) {
+	t.Parallel()
+	store, user, blog := setupTestBlog(t)
+	ctx := context.Background()
+
+	post := createTestPost(t, store, user, blog, "a-post", new(time.Now()))
+	otherPost := createTestPost(t, store, user, blog, "another-post", new(time.Now()))
+
+	comment := func(post *storage.Post, fingerprint string) *storage.Comment {
+		t.Helper()
+		c, err := store.CreateComment(ctx, storage.CreateCommentParams{PostID: post.ID, UserID: user.ID, Content: "some content", Fingerprint: fingerprint})
+		if err != nil {
+			t.Fatalf("could not create comment: %v", err)
+		}
+		return c
+	}
+	stats := func() *storage.SpamFilterStats {
+		t.Helper()
+		s, err := store.GetSpamFilterStats(ctx, []string{"cheap", "watches", "hello"})
+		if err != nil {
+			t.Fatalf("could not get stats: %v", err)
+		}
+		return s
+	}
+
+	first := comment(post, "abc")
+	comment(otherPost, "abc")
+	comment(otherPost, "abc")
+	if n, err := store.CountPostsWithFingerprint(ctx, "abc", post.ID, time.Hour); err != nil || n != 1 {
+		t.Fatalf("fingerprint: want 1 other post, got %d (%v)", n, err)
+	}
+	if n, err := store.CountPostsWithFingerprint(ctx, "abc", 0, time.Hour); err != nil || n != 2 {
+		t.Fatalf("fingerprint: want 2 posts, got %d (%v)", n, err)
+	}
+
+	// training twice on the same decision counts once
+	for range 2 {
+		if err := store.TrainSpamFilter(ctx, first.ID, []string{"cheap", "watches"}, true); err != nil {
+			t.Fatalf("could not train: %v", err)
+		}
+	}
+	if s := stats(); s.SpamDocs != 1 || s.HamDocs != 0 || s.Tokens["cheap"].Spam != 1 || len(s.Tokens) != 2 {
+		t.Fatalf("trained as spam: want one spam document with 2 tokens, got %+v", s)
+	}
+
+	// a changed decision moves the comment to the other class
+	if err := store.TrainSpamFilter(ctx, first.ID, []string{"cheap", "watches"}, false); err != nil {
+		t.Fatalf("could not train: %v", err)
+	}
+	if s := stats(); s.SpamDocs != 0 || s.HamDocs != 1 || s.Tokens["cheap"].Spam != 0 || s.Tokens["watches"].Ham != 1 {
+		t.Fatalf("retrained as ham: want one ham document, got %+v", s)
+	}
+
+	if err := store.TrainSpamFilter(ctx, 1000, []string{"cheap"}, true); err == nil {
+		t.Fatal("unknown comment: want an error")
+	}
+}
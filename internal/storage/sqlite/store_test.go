@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/sqlite"
 )
 
 func TestStoreImplementsInterface(t *testing.T) {
@@ -53,3 +54,51 @@ func setupTestStore(t *testing.T) *Store {
 
 	return store
 }
+
+func TestMigrateAdminGrant(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		usernames []string // in order of registration
+		wantAdmin bool
+	}{
+		{name: "the bootstrapped admin is the first account", usernames: []string{"admin", "bob"}, wantAdmin: true},
+		{name: "an admin registered later is not it", usernames: []string{"alice", "admin"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			store, err := NewStore(t.TempDir() + "/test_blog.db")
+			if err != nil {
+				t.Fatalf("failed to create store: %v", err)
+			}
+			t.Cleanup(func() { store.Close() })
+
+			driver, err := sqlite.WithInstance(store.db.DB, &sqlite.Config{})
+			if err != nil {
+				t.Fatalf("could not create migrations driver: %v", err)
+			}
+			m, err := migrate.NewWithDatabaseInstance("file://../../../migrations", "sqlite", driver)
+			if err != nil {
+				t.Fatalf("migration setup failed: %v", err)
+			}
+			if err := m.Migrate(20); err != nil {
+				t.Fatalf("could not migrate to 20: %v", err)
+			}
+			for _, username := range tt.usernames {
+				if _, err := store.db.Exec(`INSERT INTO users (username, password_hash) VALUES (?, ?)`, username, gen60CharString()); err != nil {
+					t.Fatalf("could not create %s: %v", username, err)
+				}
+			}
+			if err := m.Migrate(21); err != nil {
+				t.Fatalf("could not migrate to 21: %v", err)
+			}
+
+			var isAdmin bool
+			if err := store.db.Get(&isAdmin, `SELECT is_admin FROM users WHERE username = 'admin'`); err != nil || isAdmin != tt.wantAdmin {
+				t.Fatalf("admin: want is_admin %v, got %v (%v)", tt.wantAdmin, isAdmin, err)
+			}
+		})
+	}
+}
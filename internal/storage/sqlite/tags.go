@@ -0,0 +1,176 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	maxTagLen      = 32
+	maxTagsPerPost = 10
+	maxCategoryLen = 50
+)
+
+var (
+	ErrTag                = errors.New("tag must be between 1 and 32 chars (lower case letters, digits and '-')")
+	ErrTooManyTags        = errors.New("a post can have at most 10 tags")
+	ErrSyncPostTaxonomy   = errors.New("could not sync post tags")
+	ErrGetPostsByTag      = errors.New("could not get posts by tag")
+	ErrGetTagCountsByBlog = errors.New("could not get tag counts for blog")
+	ErrGetTagsForPost     = errors.New("could not get tags for post")
+)
+
+// SyncPostTaxonomy sets the category of a post and replaces its tags with the given set.
+// Tags must already be normalised, unused tags are removed
+func (s *Store) SyncPostTaxonomy(ctx context.Context, postID int64, category *string, tags []string) error {
+	if postID < 1 {
+		return fmt.Errorf("%w: %w", ErrSyncPostTaxonomy, ErrInvalidPostID)
+	}
+	if err := validatePostCategory(category); err != nil {
+		return fmt.Errorf("%w: %w", ErrSyncPostTaxonomy, err)
+	}
+	if err := validateTags(tags); err != nil {
+		return fmt.Errorf("%w: %w", ErrSyncPostTaxonomy, err)
+	}
+
+	err := s.WithTx(ctx, func(tx *sqlx.Tx) error {
+		var exists bool
+		if err := tx.GetContext(ctx, &exists, `SELECT 1 FROM posts WHERE id = ? AND deleted_at IS NULL`, postID); err != nil {
+			return mapSqlError(err)
+		}
+
+		// only touch the row when needed, the update trigger would bump updated_at
+		if _, err := tx.ExecContext(ctx, `UPDATE posts SET category = ? WHERE id = ? AND category IS NOT ?`, category, postID, category); err != nil {
+			return mapSqlError(err)
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM post_tags WHERE post_id = ?`, postID); err != nil {
+			return mapSqlError(err)
+		}
+
+		for _, tag := range tags {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO tags (name) VALUES (?) ON CONFLICT (name) DO NOTHING`, tag); err != nil {
+				return mapSqlError(err)
+			}
+			query := `INSERT OR IGNORE INTO post_tags (post_id, tag_id)
+						SELECT ?, id FROM tags WHERE name = ?`
+			if _, err := tx.ExecContext(ctx, query, postID, tag); err != nil {
+				return mapSqlError(err)
+			}
+		}
+
+		_, err := tx.ExecContext(ctx, `DELETE FROM tags WHERE id NOT IN (SELECT tag_id FROM post_tags)`)
+		return mapSqlError(err)
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrSyncPostTaxonomy, err)
+	}
+	return nil
+}
+
+// GetPostsByTag returns the latest visible posts carrying tag, blogID 0 lists posts from every public blog
+func (s *Store) GetPostsByTag(ctx context.Context, tag string, blogID, offset, limit int64) ([]*storage.Post, error) {
+	if blogID < 0 {
+		return nil, fmt.Errorf("%w: %w", ErrGetPostsByTag, ErrBlogIDFilter)
+	}
+	if offset < 0 || limit <= 0 {
+		return nil, fmt.Errorf("%w: %w", ErrGetPostsByTag, ErrLimitOffset)
+	}
+
+	posts := make([]*storage.Post, 0)
+
+	// a malformed tag can't be attached to any post
+	if validateTag(tag) != nil {
+		return posts, nil
+	}
+
+	// mirrors GetLatestPublicPosts site wide and GetPostsByBlogID for a single blog
+	query := `SELECT p.id, p.blog_id, p.author_id, p.public_id, p.slug, p.title, p.description, p.category, p.s3_key, p.is_encrypted, p.requires_auth, p.is_listed, p.published_at, p.updated_at,
+		u.username AS author_name, b.slug AS blog_slug
+				FROM post_tags AS pt
+				JOIN tags AS t ON t.id = pt.tag_id
+				JOIN posts AS p ON p.id = pt.post_id
+				JOIN blogs AS b ON b.id = p.blog_id
+				JOIN users AS u ON u.id = p.author_id
+				WHERE t.name = ?
+				AND ((? = 0 AND b.visibility = 'public') OR p.blog_id = ?)
+				AND p.deleted_at IS NULL
+				AND p.is_listed = 1
+				AND p.published_at IS NOT NULL
+				AND p.published_at <= CURRENT_TIMESTAMP
+				AND b.deleted_at IS NULL
+				ORDER BY p.published_at DESC
+				LIMIT ?
+				OFFSET ?`
+
+	if err := s.db.SelectContext(ctx, &posts, query, tag, blogID, blogID, limit, offset); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrGetPostsByTag, mapSqlError(err))
+	}
+	return posts, nil
+}
+
+// GetTagCountsForBlog counts visible posts per tag, most used first
+func (s *Store) GetTagCountsForBlog(ctx context.Context, blogID int64) ([]*storage.TagCount, error) {
+	if blogID < 1 {
+		return nil, fmt.Errorf("%w: %w", ErrGetTagCountsByBlog, ErrInvalidBlogID)
+	}
+
+	query := `SELECT t.name, COUNT(*) AS post_count
+				FROM post_tags AS pt
+				JOIN tags AS t ON t.id = pt.tag_id
+				JOIN posts AS p ON p.id = pt.post_id
+				WHERE p.blog_id = ?
+				AND p.deleted_at IS NULL
+				AND p.is_listed = 1
+				AND p.published_at IS NOT NULL
+				AND p.published_at <= CURRENT_TIMESTAMP
+				GROUP BY t.id
+				ORDER BY post_count DESC, t.name ASC`
+
+	counts := make([]*storage.TagCount, 0)
+	if err := s.db.SelectContext(ctx, &counts, query, blogID); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrGetTagCountsByBlog, mapSqlError(err))
+	}
+	return counts, nil
+}
+
+func (s *Store) GetTagsForPost(ctx context.Context, postID int64) ([]string, error) {
+	if postID < 1 {
+		return nil, fmt.Errorf("%w: %w", ErrGetTagsForPost, ErrInvalidPostID)
+	}
+
+	query := `SELECT t.name
+				FROM post_tags AS pt
+				JOIN tags AS t ON t.id = pt.tag_id
+				WHERE pt.post_id = ?
+				ORDER BY t.name ASC`
+
+	tags := make([]string, 0)
+	if err := s.db.SelectContext(ctx, &tags, query, postID); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrGetTagsForPost, mapSqlError(err))
+	}
+	return tags, nil
+}
+
+func validateTags(tags []string) error {
+	if len(tags) > maxTagsPerPost {
+		return invalid("tags", ErrTooManyTags)
+	}
+	for _, tag := range tags {
+		if err := validateTag(tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateTag(tag string) error {
+	if len(tag) < 1 || len(tag) > maxTagLen || !validSlug.MatchString(tag) {
+		return invalid("tags", ErrTag)
+	}
+	return nil
+}
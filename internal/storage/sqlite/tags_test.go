@@ -0,0 +1,328 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncPostTaxonomy(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name         string
+		postID       int64
+		initialTags  []string
+		tags         []string
+		category     *string
+		wantTags     []string
+		wantCategory *string
+		wantErr      error
+	}{
+		{
+			name:     "nominal",
+			tags:     []string{"go", "sqlite"},
+			category: new("programming"),
+			wantTags: []string{"go", "sqlite"}, wantCategory: new("programming"),
+		},
+		{
+			name:        "replaces previous tags",
+			initialTags: []string{"go", "old-tag"},
+			tags:        []string{"go", "new-tag"},
+			wantTags:    []string{"go", "new-tag"},
+		},
+		{
+			name:        "clears tags",
+			initialTags: []string{"go"},
+			tags:        nil,
+			wantTags:    []string{},
+		},
+		{
+			name:     "duplicate tags are ignored",
+			tags:     []string{"go", "go"},
+			wantTags: []string{"go"},
+		},
+		{
+			name:    "invalid tag",
+			tags:    []string{"Not A Tag"},
+			wantErr: ErrTag,
+		},
+		{
+			name:    "too many tags",
+			tags:    strings.Fields("a b c d e f g h i j k"),
+			wantErr: ErrTooManyTags,
+		},
+		{
+			name:     "category too long",
+			category: new(strings.Repeat("c", maxCategoryLen+1)),
+			wantErr:  ErrPostCategory,
+		},
+		{
+			name:    "invalid post id",
+			postID:  -1,
+			wantErr: ErrInvalidPostID,
+		},
+		{
+			name:    "missing post",
+			postID:  9999,
+			wantErr: storage.ErrNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			store, user, blog := setupTestBlog(t)
+
+			post, err := store.CreatePost(ctx, storage.CreatePostParams{
+				BlogID:      blog.ID,
+				AuthorID:    user.ID,
+				Title:       "Title of the post",
+				IsListed:    true,
+				PublishedAt: new(time.Now().Add(-time.Hour)),
+			})
+			if err != nil {
+				t.Fatalf("could not create post: %s", err)
+			}
+			if tt.initialTags != nil {
+				if err := store.SyncPostTaxonomy(ctx, post.ID, nil, tt.initialTags); err != nil {
+					t.Fatalf("could not set initial tags: %s", err)
+				}
+			}
+
+			postID := post.ID
+			if tt.postID != 0 {
+				postID = tt.postID
+			}
+
+			err = store.SyncPostTaxonomy(ctx, postID, tt.category, tt.tags)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("errors: want %s, got %s", tt.wantErr, err)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			tags, err := store.GetTagsForPost(ctx, post.ID)
+			if err != nil {
+				t.Fatalf("could not get tags: %s", err)
+			}
+			if !slices.Equal(tags, tt.wantTags) {
+				t.Fatalf("tags: want %q, got %q", tt.wantTags, tags)
+			}
+
+			got, err := store.GetPostByPublicID(ctx, post.PublicID)
+			if err != nil {
+				t.Fatalf("could not get post: %s", err)
+			}
+			if (got.Category == nil) != (tt.wantCategory == nil) || (got.Category != nil && *got.Category != *tt.wantCategory) {
+				t.Fatalf("category: want %v, got %v", tt.wantCategory, got.Category)
+			}
+		})
+	}
+}
+
+func TestSyncPostTaxonomyKeepsUpdatedAt(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store, user, blog := setupTestBlog(t)
+
+	post, err := store.CreatePost(ctx, storage.CreatePostParams{
+		BlogID:      blog.ID,
+		AuthorID:    user.ID,
+		Title:       "Title of the post",
+		Category:    new("notes"),
+		IsListed:    true,
+		PublishedAt: new(time.Now().Add(-time.Hour)),
+	})
+	if err != nil {
+		t.Fatalf("could not create post: %s", err)
+	}
+
+	// resyncing an unchanged category must not look like an edit to feeds
+	if err := store.SyncPostTaxonomy(ctx, post.ID, new("notes"), []string{"go"}); err != nil {
+		t.Fatalf("could not sync tags: %s", err)
+	}
+
+	got, err := store.GetPostByPublicID(ctx, post.PublicID)
+	if err != nil {
+		t.Fatalf("could not get post: %s", err)
+	}
+	if got.UpdatedAt != nil {
+		t.Fatalf("updated_at was bumped to %s", got.UpdatedAt)
+	}
+}
+
+func TestGetPostsByTag(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name       string
+		tag        string
+		ownBlog    bool
+		wantTitles []string
+		wantErr    error
+	}{
+		{
+			name:       "across public blogs",
+			tag:        "go",
+			wantTitles: []string{"Newest go post", "Older go post"},
+		},
+		{
+			name:       "scoped to blog",
+			tag:        "go",
+			ownBlog:    true,
+			wantTitles: []string{"Older go post"},
+		},
+		{
+			name:       "unknown tag",
+			tag:        "rust",
+			wantTitles: []string{},
+		},
+		{
+			name:       "malformed tag",
+			tag:        "Not A Tag",
+			wantTitles: []string{},
+		},
+		{
+			name:       "hidden posts are skipped",
+			tag:        "hidden",
+			wantTitles: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			store, user, blog := setupTestBlog(t)
+
+			blog2, err := store.CreateBlog(ctx, storage.CreateBlogParams{
+				OwnerID:          user.ID,
+				Slug:             "another-blog",
+				Title:            "another blog title",
+				Visibility:       storage.VisibilityPublic,
+				RegistrationMode: storage.RegistrationOpen,
+			})
+			if err != nil {
+				t.Fatalf("could not create second blog: %s", err)
+			}
+			private, err := store.CreateBlog(ctx, storage.CreateBlogParams{
+				OwnerID:          user.ID,
+				Slug:             "private-blog",
+				Title:            "private blog title",
+				Visibility:       storage.VisibilityPrivate,
+				RegistrationMode: storage.RegistrationOpen,
+			})
+			if err != nil {
+				t.Fatalf("could not create private blog: %s", err)
+			}
+
+			posts := []struct {
+				blogID      int64
+				title       string
+				listed      bool
+				publishedAt *time.Time
+				tags        []string
+			}{
+				{blog.ID, "Older go post", true, new(time.Now().Add(-48 * time.Hour)), []string{"go"}},
+				{blog2.ID, "Newest go post", true, new(time.Now().Add(-time.Hour)), []string{"go", "sqlite"}},
+				{private.ID, "Private go post", true, new(time.Now().Add(-time.Hour)), []string{"go"}},
+				{blog.ID, "Hidden unlisted post", false, new(time.Now().Add(-time.Hour)), []string{"hidden"}},
+				{blog.ID, "Hidden draft post", true, nil, []string{"hidden"}},
+			}
+			for _, p := range posts {
+				post, err := store.CreatePost(ctx, storage.CreatePostParams{
+					BlogID:      p.blogID,
+					AuthorID:    user.ID,
+					Title:       p.title,
+					IsListed:    p.listed,
+					PublishedAt: p.publishedAt,
+				})
+				if err != nil {
+					t.Fatalf("could not create post %q: %s", p.title, err)
+				}
+				if err := store.SyncPostTaxonomy(ctx, post.ID, nil, p.tags); err != nil {
+					t.Fatalf("could not tag post %q: %s", p.title, err)
+				}
+			}
+
+			var blogID int64
+			if tt.ownBlog {
+				blogID = blog.ID
+			}
+
+			results, err := store.GetPostsByTag(ctx, tt.tag, blogID, 0, 10)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("errors: want %s, got %s", tt.wantErr, err)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			gotTitles := make([]string, 0, len(results))
+			for _, r := range results {
+				gotTitles = append(gotTitles, r.Title)
+			}
+			if !slices.Equal(gotTitles, tt.wantTitles) {
+				t.Fatalf("results mismatch: want %q, got %q", tt.wantTitles, gotTitles)
+			}
+		})
+	}
+}
+
+func TestGetTagCountsForBlog(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	store, user, blog := setupTestBlog(t)
+
+	posts := []struct {
+		listed bool
+		tags   []string
+	}{
+		{true, []string{"go", "sqlite"}},
+		{true, []string{"go"}},
+		{false, []string{"go", "unlisted"}},
+	}
+	for _, p := range posts {
+		post, err := store.CreatePost(ctx, storage.CreatePostParams{
+			BlogID:      blog.ID,
+			AuthorID:    user.ID,
+			Title:       "Title of the post",
+			IsListed:    p.listed,
+			PublishedAt: new(time.Now().Add(-time.Hour)),
+		})
+		if err != nil {
+			t.Fatalf("could not create post: %s", err)
+		}
+		if err := store.SyncPostTaxonomy(ctx, post.ID, nil, p.tags); err != nil {
+			t.Fatalf("could not tag post: %s", err)
+		}
+	}
+
+	counts, err := store.GetTagCountsForBlog(ctx, blog.ID)
+	if err != nil {
+		t.Fatalf("could not get tag counts: %s", err)
+	}
+
+	want := []storage.TagCount{{Name: "go", Count: 2}, {Name: "sqlite", Count: 1}}
+	if len(counts) != len(want) {
+		t.Fatalf("counts: want %d tags, got %d", len(want), len(counts))
+	}
+	for i := range want {
+		if *counts[i] != want[i] {
+			t.Fatalf("tag %d: want %+v, got %+v", i, want[i], *counts[i])
+		}
+	}
+
+	if _, err := store.GetTagCountsForBlog(ctx, 0); !errors.Is(err, ErrInvalidBlogID) {
+		t.Fatalf("invalid blog id: want %s, got %s", ErrInvalidBlogID, err)
+	}
+}
@@ -0,0 +1,117 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+)
+
+const maxTokenNameLen = 50
+
+var (
+	ErrTokenName      = errors.New("token name must be between 1 and 50 chars")
+	ErrTokenScope     = errors.New("token scope must be read or write")
+	ErrTokenHash      = errors.New("token hash must not be empty")
+	ErrTokenExpiry    = errors.New("token must expire in the future")
+	ErrCreateAPIToken = errors.New("could not create api token")
+	ErrGetAPITokens   = errors.New("could not get api tokens")
+	ErrRevokeAPIToken = errors.New("could not revoke api token")
+	ErrUseAPIToken    = errors.New("could not use api token")
+)
+
+func (s *Store) CreateAPIToken(ctx context.Context, p storage.CreateAPITokenParams) (*storage.APIToken, error) {
+	if p.UserID < 1 {
+		return nil, fmt.Errorf("%w: %w", ErrCreateAPIToken, ErrInvalidUserID)
+	}
+	if n := utf8.RuneCountInString(p.Name); n < 1 || n > maxTokenNameLen {
+		return nil, fmt.Errorf("%w: %w", ErrCreateAPIToken, invalid("name", ErrTokenName))
+	}
+	if !p.Scope.IsValid() {
+		return nil, fmt.Errorf("%w: %w", ErrCreateAPIToken, invalid("scope", ErrTokenScope))
+	}
+	if p.TokenHash == "" {
+		return nil, fmt.Errorf("%w: %w", ErrCreateAPIToken, ErrTokenHash)
+	}
+
+	var expiresAt *time.Time
+	if p.ExpiresAt != nil {
+		if !p.ExpiresAt.After(time.Now()) {
+			return nil, fmt.Errorf("%w: %w", ErrCreateAPIToken, invalid("expires_at", ErrTokenExpiry))
+		}
+		utc := p.ExpiresAt.UTC()
+		expiresAt = &utc
+	}
+
+	query := `INSERT INTO api_tokens (user_id, name, token_hash, scope, expires_at) VALUES (?, ?, ?, ?, ?)
+				RETURNING id, user_id, name, scope, created_at, last_used_at, expires_at, revoked_at`
+
+	var token storage.APIToken
+	if err := s.db.GetContext(ctx, &token, query, p.UserID, p.Name, p.TokenHash, p.Scope, expiresAt); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCreateAPIToken, mapSqlError(err))
+	}
+	return &token, nil
+}
+
+// GetAPITokensByUserID lists the tokens of a user that were not revoked, expired ones included, newest first
+func (s *Store) GetAPITokensByUserID(ctx context.Context, userID int64) ([]*storage.APIToken, error) {
+	if userID < 1 {
+		return nil, fmt.Errorf("%w: %w", ErrGetAPITokens, ErrInvalidUserID)
+	}
+
+	query := `SELECT id, user_id, name, scope, created_at, last_used_at, expires_at, revoked_at
+				FROM api_tokens
+				WHERE user_id = ? AND revoked_at IS NULL
+				ORDER BY created_at DESC, id DESC`
+
+	tokens := make([]*storage.APIToken, 0)
+	if err := s.db.SelectContext(ctx, &tokens, query, userID); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrGetAPITokens, mapSqlError(err))
+	}
+	return tokens, nil
+}
+
+func (s *Store) RevokeAPIToken(ctx context.Context, tokenID, userID int64) error {
+	if tokenID < 1 || userID < 1 {
+		return fmt.Errorf("%w: %w", ErrRevokeAPIToken, ErrNegativeIDs)
+	}
+
+	query := `UPDATE api_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ? AND revoked_at IS NULL`
+
+	result, err := s.db.ExecContext(ctx, query, tokenID, userID)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRevokeAPIToken, mapSqlError(err))
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRevokeAPIToken, mapSqlError(err))
+	}
+	if rows == 0 {
+		return fmt.Errorf("%w: %w", ErrRevokeAPIToken, storage.ErrNotFound)
+	}
+	return nil
+}
+
+// UseAPIToken authenticates an api request, it returns the live token matching tokenHash and records its use.
+// Revoked and expired tokens and tokens of deleted users are not found
+func (s *Store) UseAPIToken(ctx context.Context, tokenHash string) (*storage.APIToken, error) {
+	if tokenHash == "" {
+		return nil, fmt.Errorf("%w: %w", ErrUseAPIToken, ErrTokenHash)
+	}
+
+	query := `UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP
+				WHERE token_hash = ?
+				AND revoked_at IS NULL
+				AND (expires_at IS NULL OR expires_at > CURRENT_TIMESTAMP)
+				AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL)
+				RETURNING id, user_id, name, scope, created_at, last_used_at, expires_at, revoked_at`
+
+	var token storage.APIToken
+	if err := s.db.GetContext(ctx, &token, query, tokenHash); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUseAPIToken, mapSqlError(err))
+	}
+	return &token, nil
+}
@@ -0,0 +1,117 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCreateAPIToken(t *testing.T) {
+	t.Parallel()
+	store, user, _ := setupTestBlog(t)
+
+	tests := []struct {
+		name      string
+		params    storage.CreateAPITokenParams
+		wantErr   error
+		wantField string
+	}{
+		{
+			name:   "nominal",
+			params: storage.CreateAPITokenParams{UserID: user.ID, Name: "laptop", TokenHash: "hash-1", Scope: storage.ScopeRead},
+		},
+		{
+			name:   "expiring",
+			params: storage.CreateAPITokenParams{UserID: user.ID, Name: "ci", TokenHash: "hash-2", Scope: storage.ScopeWrite, ExpiresAt: new(time.Now().Add(time.Hour))},
+		},
+		{
+			name:      "empty name",
+			params:    storage.CreateAPITokenParams{UserID: user.ID, TokenHash: "hash-3", Scope: storage.ScopeRead},
+			wantErr:   ErrTokenName,
+			wantField: "name",
+		},
+		{
+			name:      "unknown scope",
+			params:    storage.CreateAPITokenParams{UserID: user.ID, Name: "admin", TokenHash: "hash-4", Scope: "admin"},
+			wantErr:   ErrTokenScope,
+			wantField: "scope",
+		},
+		{
+			name:      "already expired",
+			params:    storage.CreateAPITokenParams{UserID: user.ID, Name: "old", TokenHash: "hash-5", Scope: storage.ScopeRead, ExpiresAt: new(time.Now().Add(-time.Hour))},
+			wantErr:   ErrTokenExpiry,
+			wantField: "expires_at",
+		},
+		{
+			name:    "duplicate hash",
+			params:  storage.CreateAPITokenParams{UserID: user.ID, Name: "copy", TokenHash: "hash-1", Scope: storage.ScopeRead},
+			wantErr: storage.ErrUniqueViolation,
+		},
+	}
+
+	// sequential, the duplicate case needs the first token
+	for _, tt := range tests {
+		_, err := store.CreateAPIToken(context.Background(), tt.params)
+		if !errors.Is(err, tt.wantErr) {
+			t.Fatalf("%s: want %v, got %v", tt.name, tt.wantErr, err)
+		}
+		if tt.wantField != "" {
+			var validationErr *storage.ValidationError
+			if !errors.As(err, &validationErr) || validationErr.Field != tt.wantField {
+				t.Fatalf("%s: want a validation error on %q, got %v", tt.name, tt.wantField, err)
+			}
+		}
+	}
+}
+
+func TestUseAPIToken(t *testing.T) {
+	t.Parallel()
+	store, user, _ := setupTestBlog(t)
+	ctx := context.Background()
+
+	token, err := store.CreateAPIToken(ctx, storage.CreateAPITokenParams{UserID: user.ID, Name: "laptop", TokenHash: "live-hash", Scope: storage.ScopeWrite})
+	if err != nil {
+		t.Fatalf("could not create token: %s", err)
+	}
+	if _, err := store.CreateAPIToken(ctx, storage.CreateAPITokenParams{UserID: user.ID, Name: "old", TokenHash: "expired-hash", Scope: storage.ScopeRead, ExpiresAt: new(time.Now().Add(time.Hour))}); err != nil {
+		t.Fatalf("could not create token: %s", err)
+	}
+	// expire a token in place, CreateAPIToken refuses past dates
+	if _, err := store.db.ExecContext(ctx, `UPDATE api_tokens SET expires_at = datetime('now', '-1 minute') WHERE token_hash = 'expired-hash'`); err != nil {
+		t.Fatalf("could not expire token: %s", err)
+	}
+
+	got, err := store.UseAPIToken(ctx, "live-hash")
+	if err != nil {
+		t.Fatalf("could not use token: %s", err)
+	}
+	if got.ID != token.ID || got.UserID != user.ID || got.Scope != storage.ScopeWrite || got.LastUsedAt == nil {
+		t.Fatalf("token: got %+v", got)
+	}
+
+	for _, hash := range []string{"unknown-hash", "expired-hash"} {
+		if _, err := store.UseAPIToken(ctx, hash); !errors.Is(err, storage.ErrNotFound) {
+			t.Fatalf("%s: want %s, got %v", hash, storage.ErrNotFound, err)
+		}
+	}
+
+	tokens, err := store.GetAPITokensByUserID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("could not get tokens: %s", err)
+	}
+	if len(tokens) != 2 {
+		t.Fatalf("tokens: want 2, got %d", len(tokens))
+	}
+
+	if err := store.RevokeAPIToken(ctx, token.ID, user.ID+1); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("revoke a token of another user: want %s, got %v", storage.ErrNotFound, err)
+	}
+	if err := store.RevokeAPIToken(ctx, token.ID, user.ID); err != nil {
+		t.Fatalf("could not revoke token: %s", err)
+	}
+	if _, err := store.UseAPIToken(ctx, "live-hash"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("revoked token: want %s, got %v", storage.ErrNotFound, err)
+	}
+}
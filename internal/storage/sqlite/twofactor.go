@@ -0,0 +1,124 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// EnableTOTP turns on two-factor for the user with their sealed secret. step is the code that confirmed enrolment so
+// it can't be used to log in, the recovery codes replace any the user had before
+func (s *Store) EnableTOTP(ctx context.Context, userID int64, sealedSecret []byte, step int64, recoveryCodeHashes []string) error {
+	if userID < 1 {
+		return fmt.Errorf("%w: %w", ErrTwoFactor, ErrNegativeIDs)
+	}
+	if len(sealedSecret) == 0 {
+		return fmt.Errorf("%w: %w", ErrTwoFactor, ErrTOTPSecret)
+	}
+	if len(recoveryCodeHashes) == 0 {
+		return fmt.Errorf("%w: %w", ErrTwoFactor, ErrRecoveryCodes)
+	}
+
+	err := s.WithTx(ctx, func(tx *sqlx.Tx) error {
+		query := `UPDATE users SET totp_secret = ?, totp_enabled_at = CURRENT_TIMESTAMP, totp_last_step = ?
+					WHERE id = ? AND deleted_at IS NULL`
+
+		if err := execOne(ctx, tx, storage.ErrNotFound, query, sealedSecret, step, userID); err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID); err != nil {
+			return mapSqlError(err)
+		}
+		for _, hash := range recoveryCodeHashes {
+			if hash == "" {
+				return ErrRecoveryCodes
+			}
+			if _, err := tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)`, userID, hash); err != nil {
+				return mapSqlError(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrTwoFactor, err)
+	}
+	return nil
+}
+
+// DisableTOTP turns off two-factor for the user and drops their secret and recovery codes
+func (s *Store) DisableTOTP(ctx context.Context, userID int64) error {
+	err := s.WithTx(ctx, func(tx *sqlx.Tx) error {
+		query := `UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = 0
+					WHERE id = ? AND deleted_at IS NULL`
+
+		if err := execOne(ctx, tx, storage.ErrNotFound, query, userID); err != nil {
+			return err
+		}
+
+		_, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+		return mapSqlError(err)
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrTwoFactor, err)
+	}
+	return nil
+}
+
+// UseTOTPStep records step as the last code the user logged in with. A step no later than the last one is not found,
+// so a code seen over someone's shoulder can't be used again while it is still valid
+func (s *Store) UseTOTPStep(ctx context.Context, userID, step int64) error {
+	query := `UPDATE users SET totp_last_step = ?
+				WHERE id = ? AND deleted_at IS NULL
+				AND totp_enabled_at IS NOT NULL
+				AND totp_last_step < ?`
+
+	result, err := s.db.ExecContext(ctx, query, step, userID, step)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrTwoFactor, mapSqlError(err))
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %w", mapSqlError(err))
+	}
+	if rows == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// UseRecoveryCode marks the unused recovery code matching codeHash as used, each code works once
+func (s *Store) UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error {
+	query := `UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP
+				WHERE user_id = ? AND code_hash = ? AND used_at IS NULL
+				AND user_id IN (SELECT id FROM users WHERE deleted_at IS NULL AND totp_enabled_at IS NOT NULL)`
+
+	result, err := s.db.ExecContext(ctx, query, userID, codeHash)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrTwoFactor, mapSqlError(err))
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not get rows affected: %w", mapSqlError(err))
+	}
+	if rows == 0 {
+		return storage.ErrNotFound
+	}
+	return nil
+}
+
+// CountRecoveryCodes returns how many recovery codes the user has left
+func (s *Store) CountRecoveryCodes(ctx context.Context, userID int64) (int64, error) {
+	query := `SELECT COUNT(*) FROM recovery_codes
+				WHERE user_id = ? AND used_at IS NULL`
+
+	var count int64
+	if err := s.db.GetContext(ctx, &count, query, userID); err != nil {
+		return 0, fmt.Errorf("could not count recovery codes: %w", mapSqlError(err))
+	}
+	return count, nil
+}
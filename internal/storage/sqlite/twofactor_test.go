@@ -0,0 +1,82 @@
+package sqlite
+
+import (
+	"blogengine/internal/storage"
+	"context"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestTwoFactor(t *testing.T) {
+	t.Parallel()
+	store := setupTestStore(t)
+	ctx := context.Background()
+	user := createTestUsers(t, store, 1)[0]
+
+	if err := store.EnableTOTP(ctx, user.ID, nil, 10, []string{"code-1"}); !errors.Is(err, ErrTOTPSecret) {
+		t.Fatalf("no secret: want %v, got %v", ErrTOTPSecret, err)
+	}
+	if err := store.EnableTOTP(ctx, user.ID, []byte("sealed"), 10, nil); !errors.Is(err, ErrRecoveryCodes) {
+		t.Fatalf("no recovery codes: want %v, got %v", ErrRecoveryCodes, err)
+	}
+	if err := store.EnableTOTP(ctx, 1000, []byte("sealed"), 10, []string{"code-1"}); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("unknown user: want %v, got %v", storage.ErrNotFound, err)
+	}
+	if err := store.UseTOTPStep(ctx, user.ID, 11); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("step without two-factor: want %v, got %v", storage.ErrNotFound, err)
+	}
+
+	// enabling twice replaces the recovery codes
+	if err := store.EnableTOTP(ctx, user.ID, []byte("old"), 5, []string{"old-code"}); err != nil {
+		t.Fatalf("could not enable two-factor: %v", err)
+	}
+	if err := store.EnableTOTP(ctx, user.ID, []byte("sealed"), 10, []string{"code-1", "code-2"}); err != nil {
+		t.Fatalf("could not enable two-factor: %v", err)
+	}
+	got, err := store.GetUserByID(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("could not get user: %v", err)
+	}
+	if !got.HasTOTP() || !slices.Equal(got.TOTPSecret, []byte("sealed")) || got.TOTPLastStep != 10 {
+		t.Fatalf("enabled user: want secret %q at step 10, got %q at step %d", "sealed", got.TOTPSecret, got.TOTPLastStep)
+	}
+
+	// the enrolment step and older ones are spent, each step works once
+	for _, step := range []int64{9, 10} {
+		if err := store.UseTOTPStep(ctx, user.ID, step); !errors.Is(err, storage.ErrNotFound) {
+			t.Fatalf("step %d: want %v, got %v", step, storage.ErrNotFound, err)
+		}
+	}
+	if err := store.UseTOTPStep(ctx, user.ID, 11); err != nil {
+		t.Fatalf("could not use step: %v", err)
+	}
+	if err := store.UseTOTPStep(ctx, user.ID, 11); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("replayed step: want %v, got %v", storage.ErrNotFound, err)
+	}
+
+	for _, hash := range []string{"old-code", "unknown"} {
+		if err := store.UseRecoveryCode(ctx, user.ID, hash); !errors.Is(err, storage.ErrNotFound) {
+			t.Fatalf("recovery code %s: want %v, got %v", hash, storage.ErrNotFound, err)
+		}
+	}
+	if err := store.UseRecoveryCode(ctx, user.ID, "code-1"); err != nil {
+		t.Fatalf("could not use recovery code: %v", err)
+	}
+	if err := store.UseRecoveryCode(ctx, user.ID, "code-1"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("used recovery code: want %v, got %v", storage.ErrNotFound, err)
+	}
+	if count, err := store.CountRecoveryCodes(ctx, user.ID); err != nil || count != 1 {
+		t.Fatalf("recovery codes left: want 1, got %d (%v)", count, err)
+	}
+
+	if err := store.DisableTOTP(ctx, user.ID); err != nil {
+		t.Fatalf("could not disable two-factor: %v", err)
+	}
+	if got, err = store.GetUserByID(ctx, user.ID); err != nil || got.HasTOTP() || got.TOTPSecret != nil {
+		t.Fatalf("disabled user: want no secret, got %+v (%v)", got, err)
+	}
+	if err := store.UseRecoveryCode(ctx, user.ID, "code-2"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("recovery code after disabling: want %v, got %v", storage.ErrNotFound, err)
+	}
+}
@@ -4,6 +4,10 @@ import (
 	"blogengine/internal/storage"
 	"context"
 	"fmt"
+	"net/mail"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
 )
 
 func (s *Store) CreateUser(ctx context.Context, username, passwordHash string) (*storage.User, error) {
@@ -43,7 +47,7 @@ func (s *Store) GetUserByUsername(ctx context.Context, username string) (*storag
 }
 
 func (s *Store) ChangeUserPassword(ctx context.Context, userID int64, newHash string) error {
-	query := `UPDATE users SET password_hash = ?
+	query := `UPDATE users SET password_hash = ?, must_change_password = 0, passwordless = 0
 		WHERE id = ? AND deleted_at IS NULL`
 
 	result, err := s.db.ExecContext(ctx, query, newHash, userID)
@@ -62,13 +66,22 @@ func (s *Store) ChangeUserPassword(ctx context.Context, userID int64, newHash st
 	return nil
 }
 
-func (s *Store) DeleteUser(ctx context.Context, userID int64) error {
-	query := `UPDATE users SET deleted_at = CURRENT_TIMESTAMP
+// SetUserEmail sets the address the engine contacts the user at, an empty email removes it
+func (s *Store) SetUserEmail(ctx context.Context, userID int64, email string) error {
+	var address *string
+	if email != "" {
+		if !isEmailAddress(email) {
+			return invalid("email", ErrUserEmail)
+		}
+		address = &email
+	}
+
+	query := `UPDATE users SET email = ?
 		WHERE id = ? AND deleted_at IS NULL`
 
-	result, err := s.db.ExecContext(ctx, query, userID)
+	result, err := s.db.ExecContext(ctx, query, address, userID)
 	if err != nil {
-		return fmt.Errorf("could not delete user: %w", mapSqlError(err))
+		return fmt.Errorf("could not update email: %w", mapSqlError(err))
 	}
 
 	if rows, _ := result.RowsAffected(); rows == 0 {
@@ -77,3 +90,111 @@ func (s *Store) DeleteUser(ctx context.Context, userID int64) error {
 
 	return nil
 }
+
+// UpdateUserProfile sets what the public profile of the user shows, empty fields are removed
+func (s *Store) UpdateUserProfile(ctx context.Context, userID int64, displayName, bio string) error {
+	if utf8.RuneCountInString(displayName) > 50 {
+		return invalid("display_name", ErrUserDisplayName)
+	}
+	if utf8.RuneCountInString(bio) > 500 {
+		return invalid("bio", ErrUserBio)
+	}
+
+	query := `UPDATE users SET display_name = NULLIF(?, ''), bio = NULLIF(?, '')
+		WHERE id = ? AND deleted_at IS NULL`
+
+	result, err := s.db.ExecContext(ctx, query, displayName, bio, userID)
+	if err != nil {
+		return fmt.Errorf("could not update profile: %w", mapSqlError(err))
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// SetUserAvatar points the profile of the user at an uploaded image, an empty key goes back to the identicon. The
+// image the key replaces stays in the bucket
+func (s *Store) SetUserAvatar(ctx context.Context, userID int64, key string) error {
+	if len(key) > 255 {
+		return invalid("avatar_key", ErrUserAvatar)
+	}
+
+	query := `UPDATE users SET avatar_key = NULLIF(?, '')
+		WHERE id = ? AND deleted_at IS NULL`
+
+	result, err := s.db.ExecContext(ctx, query, key, userID)
+	if err != nil {
+		return fmt.Errorf("could not update avatar: %w", mapSqlError(err))
+	}
+
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// isEmailAddress accepts bare addresses only, display names and groups have no place in a recipient column
+func isEmailAddress(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email && len(email) <= 254
+}
+
+// DeleteUser soft deletes the user and anonymises what they leave behind: their comments lose their author the way
+// ON DELETE SET NULL would, and their email, profile, api tokens, reset links and passkeys go. They leave the blogs they
+// are members of, freeing their seats. Owners must delete their blogs first, and the last admin stays so someone can
+// still run the site
+func (s *Store) DeleteUser(ctx context.Context, userID int64) error {
+	err := s.WithTx(ctx, func(tx *sqlx.Tx) error {
+		var owned int64
+		if err := tx.GetContext(ctx, &owned, `SELECT COUNT(*) FROM blogs WHERE owner_id = ? AND deleted_at IS NULL`, userID); err != nil {
+			return mapSqlError(err)
+		}
+		if owned > 0 {
+			return storage.ErrUserOwnsBlogs
+		}
+
+		var lastAdmin bool
+		query := `SELECT COUNT(*) = 1 FROM users
+			WHERE is_admin = 1 AND deleted_at IS NULL
+			AND EXISTS (SELECT 1 FROM users WHERE id = ? AND is_admin = 1 AND deleted_at IS NULL)`
+		if err := tx.GetContext(ctx, &lastAdmin, query, userID); err != nil {
+			return mapSqlError(err)
+		}
+		if lastAdmin {
+			return storage.ErrLastAdmin
+		}
+
+		query = `UPDATE users SET deleted_at = CURRENT_TIMESTAMP, email = NULL, display_name = NULL, bio = NULL, avatar_key = NULL
+			WHERE id = ? AND deleted_at IS NULL`
+		if err := execOne(ctx, tx, storage.ErrNotFound, query, userID); err != nil {
+			return err
+		}
+
+		cleanup := []string{
+			// seats first, the memberships tell which blogs they were taken on
+			`UPDATE blogs SET seats_taken = seats_taken - 1
+				WHERE seats_taken > 0 AND id IN (SELECT blog_id FROM blog_members WHERE user_id = ? AND role <> 'owner')`,
+			`DELETE FROM blog_members WHERE user_id = ?`,
+			`UPDATE comments SET user_id = NULL WHERE user_id = ?`,
+			`UPDATE api_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = ? AND revoked_at IS NULL`,
+			`DELETE FROM password_resets WHERE user_id = ?`,
+			`DELETE FROM webauthn_credentials WHERE user_id = ?`,
+			`DELETE FROM user_identities WHERE user_id = ?`,
+		}
+		for _, query := range cleanup {
+			if _, err := tx.ExecContext(ctx, query, userID); err != nil {
+				return mapSqlError(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("could not delete user: %w", err)
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"blogengine/internal/storage"
 	"context"
 	"errors"
+	"strings"
 	"testing"
 	"time"
 )
@@ -315,6 +316,34 @@ func TestDeleteUser(t *testing.T) {
 	}
 }
 
+func TestDeleteUserAnonymisesComments(t *testing.T) {
+	t.Parallel()
+	store, owner, blog := setupTestBlog(t)
+	ctx := context.Background()
+
+	post := createTestPost(t, store, owner, blog, "a-post", new(time.Now()))
+	reader := createTestUsers(t, store, 1)[0]
+	comment, err := store.CreateComment(ctx, storage.CreateCommentParams{PostID: post.ID, UserID: reader.ID, Content: "some content"})
+	if err != nil {
+		t.Fatalf("could not create comment: %v", err)
+	}
+
+	if err := store.DeleteUser(ctx, owner.ID); !errors.Is(err, storage.ErrUserOwnsBlogs) {
+		t.Fatalf("blog owner: want %v, got %v", storage.ErrUserOwnsBlogs, err)
+	}
+	if err := store.DeleteUser(ctx, reader.ID); err != nil {
+		t.Fatalf("could not delete user: %v", err)
+	}
+
+	got, err := store.GetCommentByID(ctx, comment.ID)
+	if err != nil {
+		t.Fatalf("could not get comment: %v", err)
+	}
+	if got.UserID != nil || got.AuthorName != "deleted user" || got.Content != "some content" {
+		t.Fatalf("comment of a deleted user: want it kept without its author, got %+v", got)
+	}
+}
+
 func TestDeleteUser_ContextError(t *testing.T) {
 	t.Parallel()
 
@@ -332,3 +361,145 @@ func TestDeleteUser_ContextError(t *testing.T) {
 		t.Errorf("expected context cancellation error, got: %v", err)
 	}
 }
+
+func TestSetUserEmail(t *testing.T) {
+	t.Parallel()
+	tests := []struct {
+		name      string
+		id        int64
+		email     string
+		wantEmail *string
+		wantErr   error
+	}{
+		{name: "nominal", email: "reader@example.com", wantEmail: new("reader@example.com")},
+		{name: "empty removes it", email: "", wantEmail: nil},
+		{name: "display names are refused", email: "Reader <reader@example.com>", wantErr: ErrUserEmail},
+		{name: "not an address", email: "reader", wantErr: ErrUserEmail},
+		{name: "inexistent id", id: 1_001, email: "reader@example.com", wantErr: storage.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := setupTestStore(t)
+			ctx := context.Background()
+
+			t.Parallel()
+
+			u, err := store.CreateUser(ctx, "user_"+gen60CharString()[:5], gen60CharString())
+			if err != nil {
+				t.Fatalf("could not create new user: %v", err)
+			}
+			if err := store.SetUserEmail(ctx, u.ID, "old@example.com"); err != nil {
+				t.Fatalf("could not set first email: %v", err)
+			}
+
+			workingID := u.ID
+			if tt.id > 0 {
+				workingID = tt.id
+			}
+
+			err = store.SetUserEmail(ctx, workingID, tt.email)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("unexpected error: got %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			got, err := store.GetUserByID(ctx, workingID)
+			if err != nil {
+				t.Fatalf("could not fetch user: %v", err)
+			}
+			if (got.Email == nil) != (tt.wantEmail == nil) || (got.Email != nil && *got.Email != *tt.wantEmail) {
+				t.Fatalf("email: want %v, got %v", tt.wantEmail, got.Email)
+			}
+		})
+	}
+}
+
+func TestUpdateUserProfile(t *testing.T) {
+	t.Parallel()
+	store := setupTestStore(t)
+	ctx := context.Background()
+	user := createTestUsers(t, store, 1)[0]
+
+	tests := []struct {
+		name             string
+		id               int64
+		displayName, bio string
+		wantErr          error
+	}{
+		{name: "nominal", displayName: "Member Zero", bio: "Writes about things."},
+		{name: "empty removes them"},
+		{name: "display name too long", displayName: strings.Repeat("é", 51), wantErr: ErrUserDisplayName},
+		{name: "bio too long", bio: strings.Repeat("b", 501), wantErr: ErrUserBio},
+		{name: "inexistent id", id: 1_001, displayName: "Nobody", wantErr: storage.ErrNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			workingID := user.ID
+			if tt.id > 0 {
+				workingID = tt.id
+			}
+
+			err := store.UpdateUserProfile(ctx, workingID, tt.displayName, tt.bio)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("errors: want %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			got, err := store.GetUserByID(ctx, user.ID)
+			if err != nil {
+				t.Fatalf("could not get user: %v", err)
+			}
+			if deref(got.DisplayName) != tt.displayName || deref(got.Bio) != tt.bio {
+				t.Fatalf("profile: want %q %q, got %v %v", tt.displayName, tt.bio, got.DisplayName, got.Bio)
+			}
+			if tt.displayName == "" && got.Name() != user.Username {
+				t.Fatalf("name without a display name: want %s, got %s", user.Username, got.Name())
+			}
+		})
+	}
+}
+
+func TestSetUserAvatar(t *testing.T) {
+	t.Parallel()
+	store := setupTestStore(t)
+	ctx := context.Background()
+	user := createTestUsers(t, store, 1)[0]
+
+	if err := store.SetUserAvatar(ctx, user.ID, "avatars/a.png"); err != nil {
+		t.Fatalf("could not set avatar: %v", err)
+	}
+	if got, _ := store.GetUserByID(ctx, user.ID); got.AvatarKey == nil || *got.AvatarKey != "avatars/a.png" {
+		t.Fatalf("avatar: want avatars/a.png, got %v", got.AvatarKey)
+	}
+	if err := store.SetUserAvatar(ctx, user.ID, strings.Repeat("k", 256)); !errors.Is(err, ErrUserAvatar) {
+		t.Fatalf("long key: want %v, got %v", ErrUserAvatar, err)
+	}
+	if err := store.SetUserAvatar(ctx, 1_001, "avatars/a.png"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("inexistent id: want %v, got %v", storage.ErrNotFound, err)
+	}
+
+	// deleting the account takes the profile with it
+	if err := store.UpdateUserProfile(ctx, user.ID, "Member Zero", "A bio."); err != nil {
+		t.Fatalf("could not update profile: %v", err)
+	}
+	if err := store.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatalf("could not delete user: %v", err)
+	}
+	var left int
+	if err := store.db.GetContext(ctx, &left, `SELECT COUNT(*) FROM users WHERE id = ? AND COALESCE(display_name, bio, avatar_key) IS NOT NULL`, user.ID); err != nil || left != 0 {
+		t.Fatalf("deleted user: want no profile left, got %d (%v)", left, err)
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"errors"
+	"slices"
 	"time"
 )
 
@@ -15,36 +16,124 @@ type Store interface {
 	GetUserByID(ctx context.Context, id int64) (*User, error)
 	GetUserByUsername(ctx context.Context, username string) (*User, error)
 	ChangeUserPassword(ctx context.Context, userID int64, newHash string) error
+	SetUserEmail(ctx context.Context, userID int64, email string) error
+	UpdateUserProfile(ctx context.Context, userID int64, displayName, bio string) error
+	SetUserAvatar(ctx context.Context, userID int64, key string) error
 	DeleteUser(ctx context.Context, userID int64) error
 
+	// two-factor
+	EnableTOTP(ctx context.Context, userID int64, sealedSecret []byte, step int64, recoveryCodeHashes []string) error
+	DisableTOTP(ctx context.Context, userID int64) error
+	UseTOTPStep(ctx context.Context, userID, step int64) error
+	UseRecoveryCode(ctx context.Context, userID int64, codeHash string) error
+	CountRecoveryCodes(ctx context.Context, userID int64) (int64, error)
+
+	// passkeys
+	CreatePasskey(ctx context.Context, params CreatePasskeyParams) (*Passkey, error)
+	GetPasskeyByCredentialID(ctx context.Context, credentialID []byte) (*Passkey, error)
+	GetPasskeysForUser(ctx context.Context, userID int64) ([]*Passkey, error)
+	UsePasskey(ctx context.Context, id, signCount int64) error
+	DeletePasskey(ctx context.Context, userID, id int64) error
+
+	// identities
+	CreateUserWithIdentity(ctx context.Context, username, passwordHash, issuer, subject string) (*User, error)
+	GetUserByIdentity(ctx context.Context, issuer, subject string) (*User, error)
+	LinkUserIdentity(ctx context.Context, userID int64, issuer, subject string) error
+
+	// login throttling
+	GetLoginThrottle(ctx context.Context, username string) (*LoginThrottle, error)
+	RecordLoginFailure(ctx context.Context, username string, policy LoginPolicy) (*LoginThrottle, error)
+	ClearLoginFailures(ctx context.Context, username string) error
+	GetBlockedLogins(ctx context.Context, offset, limit int64) ([]*LoginThrottle, error)
+
+	// password resets
+	CreatePasswordReset(ctx context.Context, userID int64, tokenHash string, expiresAt time.Time) error
+	GetPasswordReset(ctx context.Context, tokenHash string) (*PasswordReset, error)
+	UsePasswordReset(ctx context.Context, tokenHash string) (*PasswordReset, error)
+
 	// comments
-	CreateComment(ctx context.Context, postID, userID int64, content string) (*Comment, error)
+	CreateComment(ctx context.Context, params CreateCommentParams) (*Comment, error)
 	GetCommentByID(ctx context.Context, commentID int64) (*Comment, error)
-	UpdateComment(ctx context.Context, commentID, userID int64, content string) (*Comment, error)
+	UpdateComment(ctx context.Context, params UpdateCommentParams) (*Comment, error)
 	DeleteComment(ctx context.Context, commentID, userID int64) error
-	GetCommentsForPost(ctx context.Context, postID, offset, limit int64) ([]*Comment, error)
-	GetCommentsForUserID(ctx context.Context, userID, offset, limit int64) ([]*Comment, error)
+	GetCommentsForPost(ctx context.Context, postID, viewerID int64, cursor Cursor, limit int64) ([]*Comment, error)
+	GetCommentsForUserID(ctx context.Context, userID, offset, limit int64) ([]*UserComment, error)
+	GetCommentRevisionsForPost(ctx context.Context, postID int64) ([]*CommentRevision, error)
+	GetCommentsForModeration(ctx context.Context, moderatorID int64, status CommentStatus, offset, limit int64) ([]*PendingComment, error)
+	ModerateComments(ctx context.Context, moderatorID int64, commentIDs []int64, status CommentStatus) ([]*Comment, error)
+	CountPostsWithFingerprint(ctx context.Context, fingerprint string, excludePostID int64, within time.Duration) (int64, error)
+
+	// spam filter
+	TrainSpamFilter(ctx context.Context, commentID int64, tokens []string, spam bool) error
+	GetSpamFilterStats(ctx context.Context, tokens []string) (*SpamFilterStats, error)
 
 	// blogs
 	CreateBlog(ctx context.Context, params CreateBlogParams) (*Blog, error)
-	GetPublicBlogs(ctx context.Context, offset, limit int64) ([]*Blog, error)
+	GetPublicBlogs(ctx context.Context, cursor Cursor, limit int64) ([]*Blog, error)
 	GetBlogByID(ctx context.Context, blogID int64) (*Blog, error)
 	GetBlogBySlug(ctx context.Context, slug string) (*Blog, error)
+	GetBlogsByUserID(ctx context.Context, ownerID, offset, limit int64) ([]*Blog, error)
 	UpdateBlog(ctx context.Context, params UpdateBlogParams) (*Blog, error)
 	UpdateBlogVisibility(ctx context.Context, blogID, ownerID int64, visibility Visibility) error
 	UpdateBlogRegistration(ctx context.Context, params UpdateBlogRegistrationParams) error
+	UpdateBlogCommentPolicy(ctx context.Context, blogID, ownerID int64, policy CommentPolicy) error
 	DeleteBlog(ctx context.Context, blogID, ownerID int64) error
 
+	// members
+	JoinBlog(ctx context.Context, blogID, userID int64, inviteTokenHash string) (*BlogMember, error)
+	LeaveBlog(ctx context.Context, blogID, userID int64) error
+	GetBlogMember(ctx context.Context, blogID, userID int64) (*BlogMember, error)
+	GetBlogMembers(ctx context.Context, blogID, offset, limit int64) ([]*BlogMember, error)
+	SetBlogMemberRole(ctx context.Context, blogID, ownerID, userID int64, role BlogRole) error
+	CreateBlogInvite(ctx context.Context, blogID, ownerID int64, tokenHash string, expiresAt time.Time) error
+
 	// posts
 	CreatePost(ctx context.Context, params CreatePostParams) (*Post, error)
-	GetLatestPublicPosts(ctx context.Context, offset, limit int64) ([]*Post, error)
+	GetLatestPublicPosts(ctx context.Context, cursor Cursor, limit int64) ([]*Post, error)
 	GetAllPostPublicIDs(ctx context.Context) ([]string, error)
-	GetPostsByBlogID(ctx context.Context, blogID, offset, limit int64) ([]*Post, error)
+	GetPostsByBlogID(ctx context.Context, blogID int64, cursor Cursor, limit int64) ([]*Post, error)
 	GetPostBySlugOrPublicID(ctx context.Context, blogSlug, postIdentifier string) (*Post, error)
+	GetPostByPublicID(ctx context.Context, publicID string) (*Post, error)
+	GetPostByID(ctx context.Context, postID int64) (*Post, error)
+	GetDraftsByAuthor(ctx context.Context, authorID, offset, limit int64) ([]*Post, error)
+	UpdatePost(ctx context.Context, params UpdatePostParams) (*Post, error)
+	DeletePost(ctx context.Context, postID int64) error
+
+	// tags
+	SyncPostTaxonomy(ctx context.Context, postID int64, category *string, tags []string) error
+	GetPostsByTag(ctx context.Context, tag string, blogID, offset, limit int64) ([]*Post, error)
+	GetTagCountsForBlog(ctx context.Context, blogID int64) ([]*TagCount, error)
+	GetTagsForPost(ctx context.Context, postID int64) ([]string, error)
+
+	// search
+	IndexPostBody(ctx context.Context, postID int64, body string) error
+	SearchPosts(ctx context.Context, query string, blogID, offset, limit int64) ([]*SearchResult, error)
+
+	// api tokens
+	CreateAPIToken(ctx context.Context, params CreateAPITokenParams) (*APIToken, error)
+	GetAPITokensByUserID(ctx context.Context, userID int64) ([]*APIToken, error)
+	RevokeAPIToken(ctx context.Context, tokenID, userID int64) error
+	UseAPIToken(ctx context.Context, tokenHash string) (*APIToken, error)
+
+	// audit log
+	RecordAuditEvent(ctx context.Context, params CreateAuditEventParams) error
+	GetAuditEvents(ctx context.Context, filter AuditFilter, limit int64) ([]*AuditEvent, error)
+
+	// outbox
+	EnqueueEmail(ctx context.Context, params EnqueueEmailParams) (*OutboxEmail, error)
+	ClaimDueEmails(ctx context.Context, limit int64, lease time.Duration) ([]*OutboxEmail, error)
+	MarkEmailSent(ctx context.Context, emailID int64) error
+	RetryEmail(ctx context.Context, emailID int64, lastErr string, after time.Duration) error
+	MarkEmailFailed(ctx context.Context, emailID int64, lastErr string) error
 }
 
 type Visibility string
 type RegistrationMode string
+type BlogRole string
+type TokenScope string
+type CommentStatus string
+type CommentPolicy string
+type AuditKind string
 
 const (
 	VisibilityPublic  Visibility = "public"
@@ -54,31 +143,266 @@ const (
 	RegistrationClosed     RegistrationMode = "closed"
 	RegistrationLimited    RegistrationMode = "limited"
 	RegistrationInviteOnly RegistrationMode = "invite_only"
+
+	RoleOwner     BlogRole = "owner"
+	RoleEditor    BlogRole = "editor"
+	RoleAuthor    BlogRole = "author"
+	RoleCommenter BlogRole = "commenter"
+
+	ScopeRead  TokenScope = "read"
+	ScopeWrite TokenScope = "write"
+
+	CommentPending  CommentStatus = "pending"
+	CommentApproved CommentStatus = "approved"
+	CommentRejected CommentStatus = "rejected"
+	CommentSpam     CommentStatus = "spam"
+
+	PolicyAuto      CommentPolicy = "auto"       // every comment is published straight away
+	PolicyFirstTime CommentPolicy = "first_time" // held until the user has an approved comment on the blog
+	PolicyAll       CommentPolicy = "all"        // every comment waits for a moderator
+
+	AuditLogin          AuditKind = "login"
+	AuditLoginFailed    AuditKind = "login_failed"
+	AuditLogout         AuditKind = "logout"
+	AuditRegister       AuditKind = "register"
+	AuditPasswordChange AuditKind = "password_change"
+	AuditCommentDelete  AuditKind = "comment_delete"
+	AuditCSRFFailure    AuditKind = "csrf_failure"
+	AuditRateLimited    AuditKind = "rate_limited"
+	AuditAccountDelete  AuditKind = "account_delete"
+	AuditLoginUnlock    AuditKind = "login_unlock"
 )
 
+// AuditKinds lists every kind of audit event, in the order the admin page offers them
+var AuditKinds = []AuditKind{
+	AuditLogin, AuditLoginFailed, AuditLogout, AuditRegister, AuditPasswordChange, AuditAccountDelete, AuditCommentDelete, AuditCSRFFailure,
+	AuditRateLimited, AuditLoginUnlock,
+}
+
 var (
 	ErrNotFound        = errors.New("record not found")
 	ErrUniqueViolation = errors.New("unique constraint violation")
 	ErrCheckViolation  = errors.New("check constraint violation")
+
+	// joining and leaving blogs
+	ErrRegistrationClosed = errors.New("blog is not accepting members")
+	ErrBlogFull           = errors.New("blog has no seats left")
+	ErrInvalidInvite      = errors.New("invite is invalid, expired or already used")
+	ErrAlreadyMember      = errors.New("user is already a member of the blog")
+	ErrOwnerCannotLeave   = errors.New("the owner cannot leave their blog")
+
+	// deleting accounts
+	ErrUserOwnsBlogs = errors.New("users who own blogs must delete them first")
+	ErrLastAdmin     = errors.New("the last admin cannot delete their account")
 )
 
+// ValidationError is input rejected before it reaches the database, Field names the offending field
+type ValidationError struct {
+	Field string
+	Err   error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
 type User struct {
 	ID           int64      `db:"id"`
 	Username     string     `db:"username"`
 	PasswordHash string     `db:"password_hash"`
+	Email        *string    `db:"email"`
 	CreatedAt    time.Time  `db:"created_at"`
 	DeletedAt    *time.Time `db:"deleted_at"`
+
+	TOTPSecret    []byte     `db:"totp_secret"` // sealed, see encryption.Seal
+	TOTPEnabledAt *time.Time `db:"totp_enabled_at"`
+	TOTPLastStep  int64      `db:"totp_last_step"`
+
+	MustChangePassword bool `db:"must_change_password"` // cleared by ChangeUserPassword
+	IsAdmin            bool `db:"is_admin"`
+	Passwordless       bool `db:"passwordless"` // created through the OIDC provider, cleared by ChangeUserPassword
+
+	DisplayName *string `db:"display_name"`
+	Bio         *string `db:"bio"`
+	AvatarKey   *string `db:"avatar_key"` // uploaded source in the bucket, nil for the identicon
+}
+
+// Name is what the profile of the user is headed with, the display name when they chose one
+func (u *User) Name() string {
+	if u.DisplayName != nil {
+		return *u.DisplayName
+	}
+	return u.Username
+}
+
+// HasTOTP reports whether logging in as the user takes a code from their authenticator
+func (u *User) HasTOTP() bool {
+	return u.TOTPEnabledAt != nil
+}
+
+// LoginThrottle is the failed logins of a username in a row, whether an account has it or not. Logins to the username
+// are refused until BlockedUntil
+type LoginThrottle struct {
+	Username      string    `db:"username"`
+	Failures      int64     `db:"failures"`
+	BlockedUntil  time.Time `db:"blocked_until"`
+	LastFailureAt time.Time `db:"last_failure_at"`
+}
+
+// Blocked reports whether logins to the username are refused at now
+func (t *LoginThrottle) Blocked(now time.Time) bool {
+	return now.Before(t.BlockedUntil)
 }
 
+// LoginPolicy is how long logins to a username are refused after failures in a row
+type LoginPolicy struct {
+	FreeAttempts int64         // failures that don't hold the next login back
+	LockAfter    int64         // failures from which the username is locked out
+	Lockout      time.Duration // also the longest backoff
+}
+
+// Backoff is how long logins are refused after the given number of failures: not at all for the free attempts, then
+// a second doubling with every failure, and the whole lockout from LockAfter failures on
+func (p LoginPolicy) Backoff(failures int64) time.Duration {
+	switch {
+	case failures >= p.LockAfter:
+		return p.Lockout
+	case failures <= p.FreeAttempts:
+		return 0
+	case failures-p.FreeAttempts > 32:
+		return p.Lockout
+	}
+	return min(time.Second<<(failures-p.FreeAttempts-1), p.Lockout)
+}
+
+// PasswordReset is a pending link to set a new password, the token itself only exists in the email
+type PasswordReset struct {
+	ID        int64     `db:"id"`
+	UserID    int64     `db:"user_id"`
+	ExpiresAt time.Time `db:"expires_at"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+// Passkey is a WebAuthn credential a user logs in with instead of their password, PublicKey is its COSE key
+type Passkey struct {
+	ID           int64      `db:"id"`
+	UserID       int64      `db:"user_id"`
+	CredentialID []byte     `db:"credential_id"`
+	PublicKey    []byte     `db:"public_key"`
+	SignCount    int64      `db:"sign_count"`
+	Name         string     `db:"name"`
+	CreatedAt    time.Time  `db:"created_at"`
+	LastUsedAt   *time.Time `db:"last_used_at"`
+}
+
+type CreatePasskeyParams struct {
+	UserID       int64
+	Name         string
+	CredentialID []byte
+	PublicKey    []byte
+	SignCount    int64
+}
+
+// MaxCommentDepth is the deepest a reply can be nested, top level comments have a depth of 0
+const MaxCommentDepth = 3
+
 type Comment struct {
-	ID         int64      `db:"id"`
-	PostID     int64      `db:"post_id"`
-	UserID     *int64     `db:"user_id"`
-	Content    string     `db:"content"`
-	AuthorName string     `db:"author_name"`
-	CreatedAt  time.Time  `db:"created_at"`
-	UpdatedAt  *time.Time `db:"updated_at"`
-	DeletedAt  *time.Time `db:"deleted_at"`
+	ID          int64         `db:"id"`
+	PostID      int64         `db:"post_id"`
+	UserID      *int64        `db:"user_id"`
+	ParentID    *int64        `db:"parent_id"`
+	Depth       int64         `db:"depth"`
+	Content     string        `db:"content"`
+	AuthorName  string        `db:"author_name"`
+	Status      CommentStatus `db:"status"`
+	SpamScore   float64       `db:"spam_score"`   // combined score of the spam scorers when the comment was posted or last edited
+	SpamReasons string        `db:"spam_reasons"` // what the scorers found, empty when nothing
+	CreatedAt   time.Time     `db:"created_at"`
+	UpdatedAt   *time.Time    `db:"updated_at"`
+	EditedAt    *time.Time    `db:"edited_at"` // last content edit, nil for comments never edited
+	DeletedAt   *time.Time    `db:"deleted_at"`
+}
+
+// CommentRevision is a version a comment had before an edit
+type CommentRevision struct {
+	ID         int64     `db:"id"`
+	CommentID  int64     `db:"comment_id"`
+	Content    string    `db:"content"`
+	WrittenAt  time.Time `db:"written_at"`
+	ReplacedAt time.Time `db:"replaced_at"`
+}
+
+// CreateCommentParams is a new comment, a reply when ParentID is set. Its status follows the comment policy of the
+// blog unless the spam scorers flagged it as pending or spam, comments of moderators are always approved
+type CreateCommentParams struct {
+	PostID      int64
+	UserID      int64
+	ParentID    *int64
+	Content     string
+	Fingerprint string
+	SpamScore   float64
+	SpamReasons string
+	Flag        CommentStatus // "" lets the blog policy decide
+}
+
+// UpdateCommentParams is an edit of their comment by its author, scored again like a new comment. A changed comment
+// that was approved goes back to pending on blogs that hold comments, or to what the spam scorers flagged it as
+type UpdateCommentParams struct {
+	CommentID   int64
+	UserID      int64
+	Content     string
+	Fingerprint string
+	SpamScore   float64
+	SpamReasons string
+	Flag        CommentStatus // "" keeps the status unless the blog holds comments
+}
+
+// PendingComment is a comment of the moderation queue with the post it was left on
+type PendingComment struct {
+	Comment
+	BlogSlug  string `db:"blog_slug"`
+	BlogTitle string `db:"blog_title"`
+	PostSlug  string `db:"post_slug"` // the public id of posts without a slug
+	PostTitle string `db:"post_title"`
+}
+
+// UserComment is a comment of the profile of its author with the post it was left on
+type UserComment struct {
+	Comment
+	BlogSlug  string `db:"blog_slug"`
+	BlogTitle string `db:"blog_title"`
+	PostSlug  string `db:"post_slug"` // the public id of posts without a slug
+	PostTitle string `db:"post_title"`
+}
+
+// Cursor is the position of the comment in the threads of its post, top level comments are sorted by creation
+func (c *Comment) Cursor() Cursor {
+	return Cursor{Time: c.CreatedAt, ID: c.ID}
+}
+
+// IsDeleted is true for the placeholders GetCommentsForPost keeps so the replies of a deleted comment stay in their thread
+func (c *Comment) IsDeleted() bool {
+	return c.DeletedAt != nil
+}
+
+// IsRemoved is true for comments a moderator rejected or marked as spam, GetCommentsForPost only returns them as
+// placeholders
+func (c *Comment) IsRemoved() bool {
+	return c.Status == CommentRejected || c.Status == CommentSpam
+}
+
+// IsPending is true for comments waiting for a moderator, only their author sees them on the post
+func (c *Comment) IsPending() bool {
+	return c.Status == CommentPending
+}
+
+// CanBeRepliedTo is false for deleted, removed or pending comments and for the ones already at MaxCommentDepth
+func (c *Comment) CanBeRepliedTo() bool {
+	return c.DeletedAt == nil && c.Status == CommentApproved && c.Depth < MaxCommentDepth
 }
 
 type Blog struct {
@@ -91,11 +415,18 @@ type Blog struct {
 	Visibility        Visibility       `db:"visibility"`
 	RegistrationMode  RegistrationMode `db:"registration_mode"`
 	RegistrationLimit *int64           `db:"registration_limit"`
+	CommentPolicy     CommentPolicy    `db:"comment_policy"`
+	SeatsTaken        int64            `db:"seats_taken"`
 	CreatedAt         time.Time        `db:"created_at"`
 	UpdatedAt         *time.Time       `db:"updated_at"`
 	DeletedAt         *time.Time       `db:"deleted_at"`
 }
 
+// Cursor is the position of the blog in the public blogs, sorted by creation
+func (b *Blog) Cursor() Cursor {
+	return Cursor{Time: b.CreatedAt, ID: b.ID}
+}
+
 type CreateBlogParams struct {
 	OwnerID           int64
 	Slug              string
@@ -121,26 +452,45 @@ type UpdateBlogRegistrationParams struct {
 	RegistrationLimit *int64
 }
 
+type BlogMember struct {
+	BlogID    int64     `db:"blog_id"`
+	UserID    int64     `db:"user_id"`
+	Username  string    `db:"username"`
+	Role      BlogRole  `db:"role"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
 type Post struct {
-	ID            int64      `db:"id"`
-	BlogID        int64      `db:"blog_id"`
-	BlogSlug      string     `db:"blog_slug"`
-	AuthorID      int64      `db:"author_id"`
-	AuthorName    string     `db:"author_name"`
-	PublicID      string     `db:"public_id"`
-	Slug          *string    `db:"slug"`
-	Title         string     `db:"title"`
-	Description   *string    `db:"description"`
-	S3Key         string     `db:"s3_key"`
-	IsEncrypted   bool       `db:"is_encrypted"`
-	EncryptionIV  *string    `db:"encryption_iv"`
-	RequiresAuth  bool       `db:"requires_auth"`
-	IsListed      bool       `db:"is_listed"`
-	AllowComments bool       `db:"allow_comments"`
-	PublishedAt   *time.Time `db:"published_at"`
-	CreatedAt     time.Time  `db:"created_at"`
-	UpdatedAt     *time.Time `db:"updated_at"`
-	DeletedAt     *time.Time `db:"deleted_at"`
+	ID             int64      `db:"id"`
+	BlogID         int64      `db:"blog_id"`
+	BlogSlug       string     `db:"blog_slug"`
+	BlogVisibility Visibility `db:"blog_visibility"`
+	AuthorID       int64      `db:"author_id"`
+	AuthorName     string     `db:"author_name"`
+	PublicID       string     `db:"public_id"`
+	Slug           *string    `db:"slug"`
+	Title          string     `db:"title"`
+	Description    *string    `db:"description"`
+	Category       *string    `db:"category"`
+	S3Key          string     `db:"s3_key"`
+	IsEncrypted    bool       `db:"is_encrypted"`
+	EncryptionIV   *string    `db:"encryption_iv"`
+	RequiresAuth   bool       `db:"requires_auth"`
+	IsListed       bool       `db:"is_listed"`
+	AllowComments  bool       `db:"allow_comments"`
+	PublishedAt    *time.Time `db:"published_at"`
+	CreatedAt      time.Time  `db:"created_at"`
+	UpdatedAt      *time.Time `db:"updated_at"`
+	DeletedAt      *time.Time `db:"deleted_at"`
+}
+
+// Cursor is the position of the post in the listings of published posts, sorted by publication
+func (p *Post) Cursor() Cursor {
+	c := Cursor{ID: p.ID}
+	if p.PublishedAt != nil {
+		c.Time = *p.PublishedAt
+	}
+	return c
 }
 
 type CreatePostParams struct {
@@ -150,6 +500,7 @@ type CreatePostParams struct {
 	Slug          *string
 	Title         string
 	Description   *string
+	Category      *string
 	IsEncrypted   bool
 	EncryptionIV  *string
 	RequiresAuth  bool
@@ -158,6 +509,138 @@ type CreatePostParams struct {
 	PublishedAt   *time.Time
 }
 
+// UpdatePostParams replaces the editable fields of a post, encryption settings stay with the seeder
+type UpdatePostParams struct {
+	PostID        int64
+	Slug          *string
+	Title         string
+	Description   *string
+	Category      *string
+	RequiresAuth  bool
+	IsListed      bool
+	AllowComments bool
+	PublishedAt   *time.Time
+}
+
+// APIToken is a personal access token of the json api, the token itself is only shown once when created
+type APIToken struct {
+	ID         int64      `db:"id"`
+	UserID     int64      `db:"user_id"`
+	Name       string     `db:"name"`
+	Scope      TokenScope `db:"scope"`
+	CreatedAt  time.Time  `db:"created_at"`
+	LastUsedAt *time.Time `db:"last_used_at"`
+	ExpiresAt  *time.Time `db:"expires_at"`
+	RevokedAt  *time.Time `db:"revoked_at"`
+}
+
+type CreateAPITokenParams struct {
+	UserID    int64
+	Name      string
+	TokenHash string
+	Scope     TokenScope
+	ExpiresAt *time.Time // nil never expires
+}
+
+// SpamFilterStats is what the bayes spam filter learnt from moderator decisions about a set of tokens
+type SpamFilterStats struct {
+	SpamDocs int64
+	HamDocs  int64
+	Tokens   map[string]SpamTokenCount // tokens never seen are left out
+}
+
+// SpamTokenCount is in how many spam and ham comments a token appeared
+type SpamTokenCount struct {
+	Token string `db:"token"`
+	Spam  int64  `db:"spam"`
+	Ham   int64  `db:"ham"`
+}
+
+// OutboxEmail is an email queued for delivery. The outbox worker claims due emails and either marks them sent or
+// moves NextAttemptAt further away until it gives up
+type OutboxEmail struct {
+	ID            int64      `db:"id"`
+	Recipient     string     `db:"recipient"`
+	Subject       string     `db:"subject"`
+	TextBody      string     `db:"text_body"`
+	HTMLBody      string     `db:"html_body"`
+	Attempts      int64      `db:"attempts"`
+	LastError     string     `db:"last_error"`
+	NextAttemptAt time.Time  `db:"next_attempt_at"`
+	LockedUntil   *time.Time `db:"locked_until"`
+	CreatedAt     time.Time  `db:"created_at"`
+	SentAt        *time.Time `db:"sent_at"`
+	FailedAt      *time.Time `db:"failed_at"`
+}
+
+type EnqueueEmailParams struct {
+	Recipient string
+	Subject   string
+	TextBody  string
+	HTMLBody  string
+}
+
+// AuditEvent is a security relevant event, once recorded it never changes. The actor is the account the event is
+// about, Actor is its username today
+type AuditEvent struct {
+	ID        int64     `db:"id"`
+	Kind      AuditKind `db:"kind"`
+	ActorID   *int64    `db:"actor_id"`
+	Actor     *string   `db:"actor"`
+	IP        string    `db:"ip"`
+	UserAgent string    `db:"user_agent"`
+	TraceID   string    `db:"trace_id"`
+	Payload   string    `db:"payload"` // JSON object
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type CreateAuditEventParams struct {
+	Kind      AuditKind
+	ActorID   int64 // 0 for nobody
+	IP        string
+	UserAgent string
+	TraceID   string
+	Payload   []byte // JSON object, nil for an empty one
+}
+
+// AuditFilter narrows down audit events, zero fields match everything
+type AuditFilter struct {
+	Kind     AuditKind
+	Actor    string // username
+	IP       string
+	BeforeID int64 // only events older than this one, for paging
+}
+
+// Cursor is a position in a listing sorted newest first on a time then the id, the zero Cursor is its top. Listings
+// return the rows after it, or the ones right before it when Before is set, newest first either way
+type Cursor struct {
+	Time   time.Time
+	ID     int64
+	Before bool
+}
+
+// IsZero is true for the top of a listing
+func (c Cursor) IsZero() bool {
+	return c.ID == 0
+}
+
+type TagCount struct {
+	Name  string `db:"name"`
+	Count int64  `db:"post_count"`
+}
+
+// SearchResult is a post matching a search query, Snippet marks the matched terms
+// between SnippetMatchStart and SnippetMatchEnd
+type SearchResult struct {
+	Post
+	Snippet string `db:"snippet"`
+}
+
+const (
+	SnippetMatchStart = "\x02"
+	SnippetMatchEnd   = "\x03"
+)
+
 const PublicIDLen = 12
 
 func (v Visibility) IsValid() bool {
@@ -168,6 +651,57 @@ func (v Visibility) IsValid() bool {
 	return false
 }
 
+func (k AuditKind) IsValid() bool {
+	return slices.Contains(AuditKinds, k)
+}
+
+func (r BlogRole) IsValid() bool {
+	switch r {
+	case RoleOwner, RoleEditor, RoleAuthor, RoleCommenter:
+		return true
+	}
+	return false
+}
+
+// CanWritePosts reports whether the role may create posts on the blog
+func (r BlogRole) CanWritePosts() bool {
+	return r == RoleOwner || r == RoleEditor || r == RoleAuthor
+}
+
+// CanEditAllPosts reports whether the role may edit posts written by other members
+func (r BlogRole) CanEditAllPosts() bool {
+	return r == RoleOwner || r == RoleEditor
+}
+
+// CanModerateComments reports whether the role looks after the comments on every post of the blog
+func (r BlogRole) CanModerateComments() bool {
+	return r == RoleOwner || r == RoleEditor
+}
+
+func (s TokenScope) IsValid() bool {
+	switch s {
+	case ScopeRead, ScopeWrite:
+		return true
+	}
+	return false
+}
+
+func (s CommentStatus) IsValid() bool {
+	switch s {
+	case CommentPending, CommentApproved, CommentRejected, CommentSpam:
+		return true
+	}
+	return false
+}
+
+func (p CommentPolicy) IsValid() bool {
+	switch p {
+	case PolicyAuto, PolicyFirstTime, PolicyAll:
+		return true
+	}
+	return false
+}
+
 func (r RegistrationMode) IsValid() bool {
 	switch r {
 	case RegistrationOpen, RegistrationClosed, RegistrationLimited, RegistrationInviteOnly:
@@ -24,6 +24,9 @@ type Metrics struct {
 	RateLimitHitsTotal metric.Int64Counter
 	// assets
 	AssetRequestsTotal metric.Int64Counter
+	// comments
+	CommentSpamScore    metric.Float64Histogram
+	CommentSpamVerdicts metric.Int64Counter
 	// middlewares
 	AuthWorkDuration metric.Float64Histogram
 	Uptime           metric.Float64ObservableGauge
@@ -113,6 +116,25 @@ func NewMetrics(meter metric.Meter) (*Metrics, error) {
 		return nil, fmt.Errorf("failed to create asset_requests_total: %w", err)
 	}
 
+	commentSpamScore, err := meter.Float64Histogram(
+		"comment_spam_score",
+		metric.WithDescription("Spam score of new comments by scorer, from 0 for ham to 1 for spam"),
+		metric.WithUnit("1"),
+		metric.WithExplicitBucketBoundaries(0, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 0.95, 1),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create comment_spam_score: %w", err)
+	}
+
+	commentSpamVerdicts, err := meter.Int64Counter(
+		"comment_spam_verdicts",
+		metric.WithDescription("Number of new comments allowed, held as pending or filed as spam"),
+		metric.WithUnit("{comment}"),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create comment_spam_verdicts: %w", err)
+	}
+
 	authWorkDuration, err := meter.Float64Histogram(
 		"auth_work_duration",
 		metric.WithDescription("real time spent on DB/Bcrypt"),
@@ -165,6 +187,8 @@ func NewMetrics(meter metric.Meter) (*Metrics, error) {
 		CacheMissesTotal:    cacheMissesTotal,
 		RateLimitHitsTotal:  rateLimitHitsTotal,
 		AssetRequestsTotal:  assetRequestsTotal,
+		CommentSpamScore:    commentSpamScore,
+		CommentSpamVerdicts: commentSpamVerdicts,
 		AuthWorkDuration:    authWorkDuration,
 		Uptime:              uptime,
 		HeapAlloc:           heap,
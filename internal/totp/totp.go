@@ -0,0 +1,100 @@
+// Package totp implements the time based one time passwords of RFC 6238 as authenticator apps use them: HMAC-SHA1,
+// 6 digits and a 30 second period
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	SecretSize = 20 // bytes, the HMAC-SHA1 block RFC 4226 recommends
+	Digits     = 6
+	Period     = 30 * time.Second
+
+	// skew is how many periods before and after now a code is accepted, phones and servers drift apart
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns a random secret to share with an authenticator app
+func NewSecret() ([]byte, error) {
+	secret := make([]byte, SecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("could not generate totp secret: %w", err)
+	}
+	return secret, nil
+}
+
+// EncodeSecret is the base32 form of a secret users type into their app when they can't scan the QR code
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// DecodeSecret reads a secret written by EncodeSecret, spaces and case are ignored
+func DecodeSecret(s string) ([]byte, error) {
+	return encoding.DecodeString(strings.ToUpper(strings.ReplaceAll(s, " ", "")))
+}
+
+// URI is the otpauth:// link of the QR code authenticator apps scan, issuer and account label the entry in the app
+func URI(issuer, account string, secret []byte) string {
+	q := url.Values{}
+	q.Set("secret", EncodeSecret(secret))
+	q.Set("issuer", issuer)
+	q.Set("algorithm", "SHA1")
+	q.Set("digits", fmt.Sprint(Digits))
+	q.Set("period", fmt.Sprint(int(Period.Seconds())))
+
+	u := url.URL{Scheme: "otpauth", Host: "totp", Path: "/" + issuer + ":" + account, RawQuery: q.Encode()}
+	return u.String()
+}
+
+// Step is the number of periods since the unix epoch at t, the counter the code of t is derived from
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code is the one time password of secret for the given step
+func Code(secret []byte, step int64) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	// dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for range Digits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", Digits, value%mod)
+}
+
+// Validate checks code against the steps around t and returns the step it matched. Callers keep the last step used
+// and refuse it and earlier ones, a code seen once can't be replayed
+func Validate(secret []byte, code string, t time.Time) (int64, bool) {
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	now := Step(t)
+	for step := now - skew; step <= now+skew; step++ {
+		if hmac.Equal([]byte(Code(secret, step)), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
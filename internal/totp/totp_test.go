@@ -0,0 +1,91 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCode(t *testing.T) {
+	t.Parallel()
+
+	// the SHA1 vectors of RFC 6238 appendix B, truncated to 6 digits
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},
+		{unix: 1111111109, want: "081804"},
+		{unix: 1111111111, want: "050471"},
+		{unix: 1234567890, want: "005924"},
+		{unix: 2000000000, want: "279037"},
+		{unix: 20000000000, want: "353130"},
+	}
+
+	for _, tt := range tests {
+		if got := Code(secret, Step(time.Unix(tt.unix, 0))); got != tt.want {
+			t.Errorf("code at %d: want %s, got %s", tt.unix, tt.want, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	secret := []byte("12345678901234567890")
+	now := time.Unix(1234567890, 0)
+	step := Step(now)
+
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{name: "current", code: Code(secret, step), wantStep: step, wantOK: true},
+		{name: "spaces are ignored", code: Code(secret, step)[:3] + " " + Code(secret, step)[3:], wantStep: step, wantOK: true},
+		{name: "previous period", code: Code(secret, step-1), wantStep: step - 1, wantOK: true},
+		{name: "next period", code: Code(secret, step+1), wantStep: step + 1, wantOK: true},
+		{name: "too old", code: Code(secret, step-2)},
+		{name: "wrong length", code: "12345"},
+		{name: "wrong code", code: "000000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			gotStep, ok := Validate(secret, tt.code, now)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Fatalf("want step %d (%v), got %d (%v)", tt.wantStep, tt.wantOK, gotStep, ok)
+			}
+		})
+	}
+}
+
+func TestSecretRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatalf("could not generate secret: %v", err)
+	}
+
+	// apps show the secret in groups, lower case works too
+	encoded := strings.ToLower(EncodeSecret(secret))
+	decoded, err := DecodeSecret(encoded[:8] + " " + encoded[8:])
+	if err != nil || string(decoded) != string(secret) {
+		t.Fatalf("round trip: want %x, got %x (%v)", secret, decoded, err)
+	}
+
+	uri := URI("A blog", "alice", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/A%20blog:alice?") || !strings.Contains(uri, "secret="+EncodeSecret(secret)) {
+		t.Fatalf("uri: got %s", uri)
+	}
+
+	svg, err := QRCodeSVG(uri)
+	if err != nil || !strings.HasPrefix(svg, "<svg") || !strings.Contains(svg, "M4 4h1v1h-1z") {
+		t.Fatalf("qr code: want an svg starting with the finder pattern, got %.80s (%v)", svg, err)
+	}
+}
@@ -20,3 +20,22 @@ func Slugify(title string) string {
 
 	return result
 }
+
+// NormaliseTags slugifies tags so "Go Lang" and "go-lang" are the same tag, dropping empty and duplicate ones
+func NormaliseTags(tags []string) []string {
+	seen := make(map[string]struct{}, len(tags))
+	normalised := make([]string, 0, len(tags))
+
+	for _, tag := range tags {
+		tag = Slugify(tag)
+		if tag == "" {
+			continue
+		}
+		if _, dup := seen[tag]; dup {
+			continue
+		}
+		seen[tag] = struct{}{}
+		normalised = append(normalised, tag)
+	}
+	return normalised
+}
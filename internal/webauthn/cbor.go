@@ -0,0 +1,130 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// maxCBORDepth bounds nesting so a hostile attestation can't exhaust the stack
+const maxCBORDepth = 8
+
+var errCBOR = errors.New("malformed cbor")
+
+// decodeCBOR reads the first CBOR item of data and returns it with the number of bytes it took. It covers the subset
+// authenticators use: integers, byte and text strings, arrays, maps and simple values, all of definite length.
+// Integers come back as int64, maps as map[any]any keyed by int64 or string
+func decodeCBOR(data []byte) (any, int, error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (any, int, error) {
+	if depth > maxCBORDepth {
+		return nil, 0, fmt.Errorf("%w: nested too deep", errCBOR)
+	}
+	if len(data) == 0 {
+		return nil, 0, fmt.Errorf("%w: unexpected end", errCBOR)
+	}
+
+	major, info := data[0]>>5, data[0]&0x1f
+	arg, n, err := cborArgument(data, info)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, 0, fmt.Errorf("%w: integer overflow", errCBOR)
+		}
+		return int64(arg), n, nil
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, 0, fmt.Errorf("%w: integer overflow", errCBOR)
+		}
+		return -1 - int64(arg), n, nil
+	case 2, 3:
+		if arg > uint64(len(data)-n) {
+			return nil, 0, fmt.Errorf("%w: string past the end", errCBOR)
+		}
+		end := n + int(arg)
+		if major == 3 {
+			return string(data[n:end]), end, nil
+		}
+		return append([]byte(nil), data[n:end]...), end, nil
+	case 4:
+		if arg > uint64(len(data)) {
+			return nil, 0, fmt.Errorf("%w: array past the end", errCBOR)
+		}
+		items := make([]any, 0, arg)
+		for range arg {
+			item, used, err := decodeCBORItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			items = append(items, item)
+			n += used
+		}
+		return items, n, nil
+	case 5:
+		if arg > uint64(len(data)) {
+			return nil, 0, fmt.Errorf("%w: map past the end", errCBOR)
+		}
+		m := make(map[any]any, arg)
+		for range arg {
+			key, used, err := decodeCBORItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += used
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, 0, fmt.Errorf("%w: map key of type %T", errCBOR, key)
+			}
+			value, used, err := decodeCBORItem(data[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += used
+			m[key] = value
+		}
+		return m, n, nil
+	case 7:
+		switch info {
+		case 20:
+			return false, n, nil
+		case 21:
+			return true, n, nil
+		case 22, 23:
+			return nil, n, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("%w: unsupported item 0x%02x", errCBOR, data[0])
+}
+
+// cborArgument reads the argument following the initial byte, indefinite lengths are refused
+func cborArgument(data []byte, info byte) (uint64, int, error) {
+	switch {
+	case info < 24:
+		return uint64(info), 1, nil
+	case info <= 27:
+		size := 1 << (info - 24)
+		if len(data) < 1+size {
+			return 0, 0, fmt.Errorf("%w: unexpected end", errCBOR)
+		}
+		var arg uint64
+		switch size {
+		case 1:
+			arg = uint64(data[1])
+		case 2:
+			arg = uint64(binary.BigEndian.Uint16(data[1:]))
+		case 4:
+			arg = uint64(binary.BigEndian.Uint32(data[1:]))
+		case 8:
+			arg = binary.BigEndian.Uint64(data[1:])
+		}
+		return arg, 1 + size, nil
+	}
+	return 0, 0, fmt.Errorf("%w: indefinite or reserved length", errCBOR)
+}
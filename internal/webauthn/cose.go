@@ -0,0 +1,104 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm identifiers of the keys the engine accepts
+const (
+	AlgES256 = -7   // ECDSA on P-256 with SHA-256
+	AlgRS256 = -257 // RSASSA-PKCS1-v1_5 with SHA-256
+)
+
+// COSE key parameters, see RFC 9053
+const (
+	coseKty    = 1
+	coseAlg    = 3
+	coseCrv    = -1
+	coseX      = -2
+	coseY      = -3
+	coseRSAN   = -1
+	coseRSAE   = -2
+	coseKtyEC2 = 2
+	coseKtyRSA = 3
+	coseP256   = 1
+)
+
+var (
+	ErrPublicKey = errors.New("unsupported or malformed public key")
+	ErrSignature = errors.New("signature does not verify")
+)
+
+// publicKey is a credential public key read from its COSE form
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parsePublicKey reads a COSE_Key as stored with a credential, only ES256 and RS256 keys are accepted
+func parsePublicKey(cose []byte) (*publicKey, error) {
+	item, n, err := decodeCBOR(cose)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPublicKey, err)
+	}
+	m, ok := item.(map[any]any)
+	if !ok || n != len(cose) {
+		return nil, ErrPublicKey
+	}
+
+	alg, _ := m[int64(coseAlg)].(int64)
+	kty, _ := m[int64(coseKty)].(int64)
+
+	switch {
+	case alg == AlgES256 && kty == coseKtyEC2:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if crv != coseP256 || len(x) != 32 || len(y) != 32 {
+			return nil, ErrPublicKey
+		}
+		// the uncompressed point form makes the standard library check the point is on the curve
+		point := append(append([]byte{4}, x...), y...)
+		key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrPublicKey, err)
+		}
+		return &publicKey{alg: alg, key: key}, nil
+
+	case alg == AlgRS256 && kty == coseKtyRSA:
+		n, _ := m[int64(coseRSAN)].([]byte)
+		e, _ := m[int64(coseRSAE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, ErrPublicKey
+		}
+		exponent := int(new(big.Int).SetBytes(e).Int64())
+		if exponent < 3 || exponent%2 == 0 {
+			return nil, ErrPublicKey
+		}
+		return &publicKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}}, nil
+	}
+	return nil, fmt.Errorf("%w: algorithm %d", ErrPublicKey, alg)
+}
+
+// verify checks sig over signed, ES256 signatures are ASN.1 DER encoded as WebAuthn sends them
+func (k *publicKey) verify(signed, sig []byte) error {
+	digest := sha256.Sum256(signed)
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		if ecdsa.VerifyASN1(key, digest[:], sig) {
+			return nil
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil {
+			return nil
+		}
+	}
+	return ErrSignature
+}
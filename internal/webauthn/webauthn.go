@@ -0,0 +1,339 @@
+// Package webauthn implements the relying party side of WebAuthn Level 2 for passkey logins: creation and request
+// options for the browser, and the checks of the registration and authentication ceremonies. Only "none"
+// attestation is asked for, so no attestation statement is trusted and any format is accepted as if it were "none".
+// Credentials are ES256 or RS256 keys and must verify the user, a passkey stands in for the password
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+const (
+	// ChallengeSize is the number of random bytes of a challenge, the spec asks for at least 16
+	ChallengeSize = 32
+	// Timeout is how long the browser waits for the authenticator
+	Timeout = 5 * time.Minute
+)
+
+// authenticator data flags
+const (
+	flagUserPresent  = 1 << 0
+	flagUserVerified = 1 << 2
+	flagAttestedData = 1 << 6
+)
+
+var (
+	ErrClientData     = errors.New("client data does not match the ceremony")
+	ErrAuthData       = errors.New("malformed authenticator data")
+	ErrRelyingParty   = errors.New("credential is for another site")
+	ErrUserPresence   = errors.New("user was not present or not verified")
+	ErrAttestation    = errors.New("malformed attestation")
+	ErrCredentialID   = errors.New("credential id does not match")
+	ErrSignCount      = errors.New("signature counter went backwards, the authenticator may be cloned")
+	ErrUserHandle     = errors.New("user handle does not match the credential")
+	errChallengeEmpty = errors.New("challenge must not be empty")
+)
+
+// Bytes is binary data that travels as unpadded base64url in JSON, as WebAuthn's JSON forms use
+type Bytes []byte
+
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	decoded, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	*b = decoded
+	return nil
+}
+
+// RelyingParty is the site credentials are scoped to. ID is its host name and Origins the origins ceremonies may
+// come from, e.g. "https://blog.example.com"
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+// User is the account a credential is created for, ID is its user handle and must not identify the user outside the
+// site
+type User struct {
+	ID          Bytes  `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type rpEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// CredentialDescriptor names a credential the authenticator should or should not use
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   Bytes  `json:"id"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	RequireResident  bool   `json:"requireResidentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are the PublicKeyCredentialCreationOptions of navigator.credentials.create, binary fields in
+// base64url
+type CreationOptions struct {
+	RP                     rpEntity               `json:"rp"`
+	User                   User                   `json:"user"`
+	Challenge              Bytes                  `json:"challenge"`
+	PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are the PublicKeyCredentialRequestOptions of navigator.credentials.get, binary fields in base64url
+type RequestOptions struct {
+	Challenge        Bytes                  `json:"challenge"`
+	Timeout          int64                  `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RegistrationResponse is what the browser returns from navigator.credentials.create
+type RegistrationResponse struct {
+	ID                Bytes `json:"id"`
+	ClientDataJSON    Bytes `json:"clientDataJSON"`
+	AttestationObject Bytes `json:"attestationObject"`
+}
+
+// AuthenticationResponse is what the browser returns from navigator.credentials.get
+type AuthenticationResponse struct {
+	ID                Bytes `json:"id"`
+	ClientDataJSON    Bytes `json:"clientDataJSON"`
+	AuthenticatorData Bytes `json:"authenticatorData"`
+	Signature         Bytes `json:"signature"`
+	UserHandle        Bytes `json:"userHandle"`
+}
+
+// Credential is a verified new credential, PublicKey is its COSE key to store and hand back to VerifyAuthentication
+type Credential struct {
+	ID        []byte
+	PublicKey []byte
+	SignCount uint32
+}
+
+// NewChallenge returns a random challenge for one ceremony, keep it server side until the response comes back
+func NewChallenge() ([]byte, error) {
+	challenge := make([]byte, ChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
+
+// CreationOptions asks the browser for a discoverable credential of user, exclude lists the credentials the user
+// has already so the same authenticator isn't registered twice
+func (rp RelyingParty) CreationOptions(user User, challenge []byte, exclude [][]byte) CreationOptions {
+	return CreationOptions{
+		RP:        rpEntity{ID: rp.ID, Name: rp.Name},
+		User:      user,
+		Challenge: challenge,
+		PubKeyCredParams: []credentialParameter{
+			{Type: "public-key", Alg: AlgES256},
+			{Type: "public-key", Alg: AlgRS256},
+		},
+		Timeout:            Timeout.Milliseconds(),
+		ExcludeCredentials: descriptors(exclude),
+		AuthenticatorSelection: authenticatorSelection{
+			ResidentKey:      "required",
+			RequireResident:  true,
+			UserVerification: "required",
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions asks the browser for an assertion of any passkey of the site, the user picks the account on their
+// device so no username is typed
+func (rp RelyingParty) RequestOptions(challenge []byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          Timeout.Milliseconds(),
+		RPID:             rp.ID,
+		AllowCredentials: []CredentialDescriptor{},
+		UserVerification: "required",
+	}
+}
+
+func descriptors(ids [][]byte) []CredentialDescriptor {
+	list := make([]CredentialDescriptor, len(ids))
+	for i, id := range ids {
+		list[i] = CredentialDescriptor{Type: "public-key", ID: id}
+	}
+	return list
+}
+
+// VerifyRegistration checks the response to CreationOptions made with challenge and returns the new credential
+func (rp RelyingParty) VerifyRegistration(challenge []byte, resp RegistrationResponse) (*Credential, error) {
+	if err := rp.verifyClientData(resp.ClientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	item, n, err := decodeCBOR(resp.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAttestation, err)
+	}
+	attestation, ok := item.(map[any]any)
+	if !ok || n != len(resp.AttestationObject) {
+		return nil, ErrAttestation
+	}
+	// with "none" asked for the statement is not checked whatever its format, nothing about the authenticator is
+	// trusted beyond its key
+	if _, ok := attestation["fmt"].(string); !ok {
+		return nil, ErrAttestation
+	}
+	authData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, ErrAttestation
+	}
+
+	flags, signCount, err := rp.verifyAuthData(authData)
+	if err != nil {
+		return nil, err
+	}
+	if flags&flagAttestedData == 0 || len(authData) < 37+18 {
+		return nil, fmt.Errorf("%w: no attested credential", ErrAuthData)
+	}
+
+	// aaguid, then the credential id behind its length, then the COSE key
+	attested := authData[37+16:]
+	idLen := int(binary.BigEndian.Uint16(attested))
+	attested = attested[2:]
+	if idLen == 0 || idLen > 1023 || len(attested) < idLen {
+		return nil, fmt.Errorf("%w: bad credential id length", ErrAuthData)
+	}
+	id, attested := attested[:idLen], attested[idLen:]
+	if !bytes.Equal(id, resp.ID) {
+		return nil, ErrCredentialID
+	}
+
+	// extensions may follow the key, so its length is what the decoder took
+	_, keyLen, err := decodeCBOR(attested)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPublicKey, err)
+	}
+	key := attested[:keyLen]
+	if _, err := parsePublicKey(key); err != nil {
+		return nil, err
+	}
+
+	return &Credential{ID: slices.Clone(id), PublicKey: slices.Clone(key), SignCount: signCount}, nil
+}
+
+// VerifyAuthentication checks the response to RequestOptions made with challenge against the stored credential and
+// its user handle, and returns the new signature counter to store
+func (rp RelyingParty) VerifyAuthentication(challenge []byte, cred Credential, userHandle []byte, resp AuthenticationResponse) (uint32, error) {
+	if !bytes.Equal(cred.ID, resp.ID) {
+		return 0, ErrCredentialID
+	}
+	// discoverable credentials always return their user handle, it must be the one the credential was made for
+	if subtle.ConstantTimeCompare(resp.UserHandle, userHandle) != 1 {
+		return 0, ErrUserHandle
+	}
+	if err := rp.verifyClientData(resp.ClientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	_, signCount, err := rp.verifyAuthData(resp.AuthenticatorData)
+	if err != nil {
+		return 0, err
+	}
+
+	key, err := parsePublicKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(resp.ClientDataJSON)
+	signed := append(slices.Clone([]byte(resp.AuthenticatorData)), clientDataHash[:]...)
+	if err := key.verify(signed, resp.Signature); err != nil {
+		return 0, err
+	}
+
+	// authenticators that don't count send 0, the ones that do must go up
+	if (signCount != 0 || cred.SignCount != 0) && signCount <= cred.SignCount {
+		return 0, ErrSignCount
+	}
+	return signCount, nil
+}
+
+// verifyClientData checks the client data the browser signed over is for this ceremony, challenge and origin
+func (rp RelyingParty) verifyClientData(raw []byte, ceremony string, challenge []byte) error {
+	if len(challenge) == 0 {
+		return errChallengeEmpty
+	}
+
+	var clientData struct {
+		Type        string `json:"type"`
+		Challenge   string `json:"challenge"`
+		Origin      string `json:"origin"`
+		CrossOrigin bool   `json:"crossOrigin"`
+	}
+	if err := json.Unmarshal(raw, &clientData); err != nil {
+		return fmt.Errorf("%w: %w", ErrClientData, err)
+	}
+
+	got, err := base64.RawURLEncoding.DecodeString(clientData.Challenge)
+	switch {
+	case err != nil, subtle.ConstantTimeCompare(got, challenge) != 1:
+		return fmt.Errorf("%w: challenge", ErrClientData)
+	case clientData.Type != ceremony:
+		return fmt.Errorf("%w: type %q", ErrClientData, clientData.Type)
+	case !slices.Contains(rp.Origins, clientData.Origin), clientData.CrossOrigin:
+		return fmt.Errorf("%w: origin %q", ErrClientData, clientData.Origin)
+	}
+	return nil
+}
+
+// verifyAuthData checks the fixed part of authenticator data, the relying party hash, user presence and
+// verification, and returns the flags and signature counter
+func (rp RelyingParty) verifyAuthData(authData []byte) (byte, uint32, error) {
+	if len(authData) < 37 {
+		return 0, 0, ErrAuthData
+	}
+
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(authData[:32], rpIDHash[:]) {
+		return 0, 0, ErrRelyingParty
+	}
+
+	flags := authData[32]
+	if flags&flagUserPresent == 0 || flags&flagUserVerified == 0 {
+		return 0, 0, ErrUserPresence
+	}
+	return flags, binary.BigEndian.Uint32(authData[33:37]), nil
+}
@@ -0,0 +1,177 @@
+package webauthn_test
+
+import (
+	"blogengine/internal/webauthn"
+	"blogengine/internal/webauthn/webauthntest"
+	"errors"
+	"testing"
+)
+
+var rp = webauthn.RelyingParty{ID: "blog.example.com", Name: "test blog", Origins: []string{"https://blog.example.com"}}
+
+func challenge(t *testing.T) []byte {
+	t.Helper()
+	c, err := webauthn.NewChallenge()
+	if err != nil {
+		t.Fatalf("could not make challenge: %v", err)
+	}
+	return c
+}
+
+func register(t *testing.T, a *webauthntest.Authenticator, userHandle []byte) *webauthn.Credential {
+	t.Helper()
+	c := challenge(t)
+	resp, err := a.Create(rp.CreationOptions(webauthn.User{ID: userHandle, Name: "admin", DisplayName: "admin"}, c, nil))
+	if err != nil {
+		t.Fatalf("authenticator could not create: %v", err)
+	}
+	cred, err := rp.VerifyRegistration(c, resp)
+	if err != nil {
+		t.Fatalf("could not verify registration: %v", err)
+	}
+	return cred
+}
+
+func TestCeremonies(t *testing.T) {
+	t.Parallel()
+
+	for name, alg := range map[string]int{"ES256": webauthn.AlgES256, "RS256": webauthn.AlgRS256} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			a := webauthntest.New("https://blog.example.com")
+			a.Alg = alg
+			cred := register(t, a, []byte("user-1"))
+
+			for i := range 2 {
+				c := challenge(t)
+				resp, err := a.Get(rp.RequestOptions(c))
+				if err != nil {
+					t.Fatalf("authenticator could not sign: %v", err)
+				}
+				count, err := rp.VerifyAuthentication(c, *cred, []byte("user-1"), resp)
+				if err != nil {
+					t.Fatalf("login %d: %v", i+1, err)
+				}
+				cred.SignCount = count
+			}
+		})
+	}
+}
+
+func TestRegistrationChecks(t *testing.T) {
+	t.Parallel()
+	user := webauthn.User{ID: []byte("user-1"), Name: "admin"}
+
+	tests := []struct {
+		name    string
+		origin  string
+		rp      webauthn.RelyingParty
+		noUV    bool
+		other   bool // answer another challenge
+		wantErr error
+	}{
+		{name: "other origin", origin: "https://evil.example", rp: rp, wantErr: webauthn.ErrClientData},
+		{name: "other relying party", origin: "https://blog.example.com", rp: webauthn.RelyingParty{ID: "evil.example", Origins: rp.Origins}, wantErr: webauthn.ErrRelyingParty},
+		{name: "user not verified", origin: "https://blog.example.com", rp: rp, noUV: true, wantErr: webauthn.ErrUserPresence},
+		{name: "other challenge", origin: "https://blog.example.com", rp: rp, other: true, wantErr: webauthn.ErrClientData},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			a := webauthntest.New(tt.origin)
+			a.SkipUserVerification = tt.noUV
+			c := challenge(t)
+			resp, err := a.Create(tt.rp.CreationOptions(user, c, nil))
+			if err != nil {
+				t.Fatalf("authenticator could not create: %v", err)
+			}
+			if tt.other {
+				c = challenge(t)
+			}
+			if _, err := rp.VerifyRegistration(c, resp); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	// truncated attestations are refused rather than read past their end
+	a := webauthntest.New("https://blog.example.com")
+	c := challenge(t)
+	resp, err := a.Create(rp.CreationOptions(user, c, nil))
+	if err != nil {
+		t.Fatalf("authenticator could not create: %v", err)
+	}
+	for n := range len(resp.AttestationObject) {
+		truncated := resp
+		truncated.AttestationObject = resp.AttestationObject[:n]
+		if _, err := rp.VerifyRegistration(c, truncated); err == nil {
+			t.Fatalf("attestation cut at %d bytes: want an error", n)
+		}
+	}
+}
+
+func TestAuthenticationChecks(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		change  func(c *webauthn.Credential, resp *webauthn.AuthenticationResponse)
+		handle  string
+		wantErr error
+	}{
+		{
+			name:    "other user",
+			handle:  "user-2",
+			wantErr: webauthn.ErrUserHandle,
+		},
+		{
+			name: "tampered signature",
+			change: func(_ *webauthn.Credential, resp *webauthn.AuthenticationResponse) {
+				resp.Signature[len(resp.Signature)-1] ^= 1
+			},
+			wantErr: webauthn.ErrSignature,
+		},
+		{
+			name:    "tampered authenticator data",
+			change:  func(_ *webauthn.Credential, resp *webauthn.AuthenticationResponse) { resp.AuthenticatorData[36] ^= 1 },
+			wantErr: webauthn.ErrSignature,
+		},
+		{
+			name:    "counter went backwards",
+			change:  func(c *webauthn.Credential, _ *webauthn.AuthenticationResponse) { c.SignCount = 100 },
+			wantErr: webauthn.ErrSignCount,
+		},
+		{
+			name:    "other credential",
+			change:  func(c *webauthn.Credential, _ *webauthn.AuthenticationResponse) { c.ID = []byte("another") },
+			wantErr: webauthn.ErrCredentialID,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			a := webauthntest.New("https://blog.example.com")
+			cred := register(t, a, []byte("user-1"))
+			c := challenge(t)
+			resp, err := a.Get(rp.RequestOptions(c))
+			if err != nil {
+				t.Fatalf("authenticator could not sign: %v", err)
+			}
+			if tt.change != nil {
+				tt.change(cred, &resp)
+			}
+			handle := "user-1"
+			if tt.handle != "" {
+				handle = tt.handle
+			}
+			if _, err := rp.VerifyAuthentication(c, *cred, []byte(handle), resp); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("want %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,212 @@
+// Package webauthntest provides a software authenticator that answers WebAuthn ceremonies the way a browser and a
+// platform authenticator would, so passkey flows can be tested without either
+package webauthntest
+
+import (
+	"blogengine/internal/webauthn"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var ErrNoCredential = errors.New("authenticator has no credential for the relying party")
+
+// Authenticator holds discoverable credentials and signs for Origin. Its answers verify the user unless
+// SkipUserVerification is set
+type Authenticator struct {
+	Origin               string
+	Alg                  int // webauthn.AlgES256 or webauthn.AlgRS256
+	SkipUserVerification bool
+
+	creds []*credential
+}
+
+type credential struct {
+	id         []byte
+	rpID       string
+	userHandle []byte
+	key        crypto.Signer
+	count      uint32
+}
+
+// New returns an authenticator making ES256 credentials for origin
+func New(origin string) *Authenticator {
+	return &Authenticator{Origin: origin, Alg: webauthn.AlgES256}
+}
+
+// Create makes a new credential as navigator.credentials.create would with opts
+func (a *Authenticator) Create(opts webauthn.CreationOptions) (webauthn.RegistrationResponse, error) {
+	for _, excluded := range opts.ExcludeCredentials {
+		for _, c := range a.creds {
+			if string(c.id) == string(excluded.ID) {
+				return webauthn.RegistrationResponse{}, errors.New("authenticator already registered")
+			}
+		}
+	}
+
+	var key crypto.Signer
+	var err error
+	switch a.Alg {
+	case webauthn.AlgRS256:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		return webauthn.RegistrationResponse{}, err
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return webauthn.RegistrationResponse{}, err
+	}
+	c := &credential{id: id, rpID: opts.RP.ID, userHandle: opts.User.ID, key: key}
+	a.creds = append(a.creds, c)
+
+	cose, err := coseKey(key.Public())
+	if err != nil {
+		return webauthn.RegistrationResponse{}, err
+	}
+	attested := make([]byte, 16, 16+2+len(id)+len(cose)) // zero aaguid, as with "none" attestation
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(id)))
+	attested = append(append(attested, id...), cose...)
+	authData := a.authData(c, 1<<6, attested)
+
+	attestation := encodeCBOR(cborMap{
+		{"fmt", "none"},
+		{"attStmt", cborMap{}},
+		{"authData", authData},
+	})
+
+	return webauthn.RegistrationResponse{
+		ID:                id,
+		ClientDataJSON:    a.clientData("webauthn.create", opts.Challenge),
+		AttestationObject: attestation,
+	}, nil
+}
+
+// Get signs in with the newest credential for the relying party of opts as navigator.credentials.get would
+func (a *Authenticator) Get(opts webauthn.RequestOptions) (webauthn.AuthenticationResponse, error) {
+	for i := len(a.creds) - 1; i >= 0; i-- {
+		c := a.creds[i]
+		if c.rpID != opts.RPID {
+			continue
+		}
+
+		authData := a.authData(c, 0, nil)
+		clientData := a.clientData("webauthn.get", opts.Challenge)
+		hash := sha256.Sum256(clientData)
+		digest := sha256.Sum256(append(authData, hash[:]...))
+
+		var sig []byte
+		var err error
+		switch c.key.(type) {
+		case *rsa.PrivateKey:
+			sig, err = c.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+		default:
+			sig, err = c.key.Sign(rand.Reader, digest[:], nil)
+		}
+		if err != nil {
+			return webauthn.AuthenticationResponse{}, err
+		}
+
+		return webauthn.AuthenticationResponse{
+			ID:                c.id,
+			ClientDataJSON:    clientData,
+			AuthenticatorData: authData,
+			Signature:         sig,
+			UserHandle:        c.userHandle,
+		}, nil
+	}
+	return webauthn.AuthenticationResponse{}, ErrNoCredential
+}
+
+// authData builds authenticator data for c, counting the signature
+func (a *Authenticator) authData(c *credential, flags byte, attested []byte) []byte {
+	flags |= 1 << 0
+	if !a.SkipUserVerification {
+		flags |= 1 << 2
+	}
+	c.count++
+
+	rpIDHash := sha256.Sum256([]byte(c.rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, c.count)
+	return append(data, attested...)
+}
+
+func (a *Authenticator) clientData(ceremony string, challenge []byte) []byte {
+	data, _ := json.Marshal(map[string]any{
+		"type":        ceremony,
+		"challenge":   base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":      a.Origin,
+		"crossOrigin": false,
+	})
+	return data
+}
+
+func coseKey(pub crypto.PublicKey) ([]byte, error) {
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		point, err := key.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		return encodeCBOR(cborMap{
+			{1, 2}, {3, webauthn.AlgES256}, {-1, 1}, {-2, point[1:33]}, {-3, point[33:]},
+		}), nil
+	case *rsa.PublicKey:
+		return encodeCBOR(cborMap{
+			{1, 3}, {3, webauthn.AlgRS256}, {-1, key.N.Bytes()}, {-2, big.NewInt(int64(key.E)).Bytes()},
+		}), nil
+	}
+	return nil, fmt.Errorf("unsupported key %T", pub)
+}
+
+// cborMap keeps its pairs in the order given, which is how the keys above follow CTAP2's canonical order
+type cborMap [][2]any
+
+func encodeCBOR(v any) []byte {
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return cborHead(1, uint64(-1-v))
+		}
+		return cborHead(0, uint64(v))
+	case []byte:
+		return append(cborHead(2, uint64(len(v))), v...)
+	case string:
+		return append(cborHead(3, uint64(len(v))), v...)
+	case cborMap:
+		out := cborHead(5, uint64(len(v)))
+		for _, p := range v {
+			out = append(out, encodeCBOR(p[0])...)
+			out = append(out, encodeCBOR(p[1])...)
+		}
+		return out
+	}
+	panic(fmt.Sprintf("webauthntest: cannot encode %T", v))
+}
+
+func cborHead(major byte, arg uint64) []byte {
+	switch {
+	case arg < 24:
+		return []byte{major<<5 | byte(arg)}
+	case arg <= 0xff:
+		return []byte{major<<5 | 24, byte(arg)}
+	case arg <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{major<<5 | 25}, uint16(arg))
+	case arg <= 0xffffffff:
+		return binary.BigEndian.AppendUint32([]byte{major<<5 | 26}, uint32(arg))
+	}
+	return binary.BigEndian.AppendUint64([]byte{major<<5 | 27}, arg)
+}
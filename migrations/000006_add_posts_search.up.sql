@@ -0,0 +1,36 @@
+-- full text index over posts, rowid is the post id
+-- body is filled in by the seeder once the markdown is uploaded
+CREATE VIRTUAL TABLE IF NOT EXISTS posts_fts USING fts5(
+    title,
+    description,
+    body,
+    tokenize = 'porter unicode61 remove_diacritics 2'
+);
+
+-- index posts that already exist (metadata only, their body comes with the next upload)
+INSERT INTO posts_fts (rowid, title, description, body)
+SELECT id, title, COALESCE(description, ''), '' FROM posts;
+
+CREATE TRIGGER IF NOT EXISTS trg_posts_fts_insert
+AFTER INSERT ON posts
+FOR EACH ROW
+BEGIN
+    INSERT INTO posts_fts (rowid, title, description, body)
+    VALUES (NEW.id, NEW.title, COALESCE(NEW.description, ''), '');
+END;
+
+CREATE TRIGGER IF NOT EXISTS trg_posts_fts_update
+AFTER UPDATE OF title, description ON posts
+FOR EACH ROW
+BEGIN
+    UPDATE posts_fts
+    SET title = NEW.title, description = COALESCE(NEW.description, '')
+    WHERE rowid = NEW.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS trg_posts_fts_delete
+AFTER DELETE ON posts
+FOR EACH ROW
+BEGIN
+    DELETE FROM posts_fts WHERE rowid = OLD.id;
+END;
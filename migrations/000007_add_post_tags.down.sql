@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_posts_category;
+DROP INDEX IF EXISTS idx_post_tags_tag;
+DROP TABLE IF EXISTS post_tags;
+DROP TABLE IF EXISTS tags;
+ALTER TABLE posts DROP COLUMN category;
@@ -0,0 +1,25 @@
+ALTER TABLE posts ADD COLUMN category TEXT DEFAULT NULL;
+
+CREATE TABLE IF NOT EXISTS tags (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE, -- slugified, also used in urls
+
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+
+    CHECK (length(name) BETWEEN 1 AND 32)
+);
+
+CREATE TABLE IF NOT EXISTS post_tags (
+    post_id INTEGER NOT NULL,
+    tag_id INTEGER NOT NULL,
+
+    PRIMARY KEY (post_id, tag_id),
+
+    FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+    FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE
+);
+
+-- the primary key covers lookups by post, this covers lookups by tag
+CREATE INDEX IF NOT EXISTS idx_post_tags_tag ON post_tags(tag_id, post_id);
+
+CREATE INDEX IF NOT EXISTS idx_posts_category ON posts(blog_id, category) WHERE category IS NOT NULL;
@@ -0,0 +1,14 @@
+DROP TRIGGER IF EXISTS trg_blogs_updated_at;
+CREATE TRIGGER IF NOT EXISTS trg_blogs_updated_at
+AFTER UPDATE ON blogs
+FOR EACH ROW
+BEGIN
+    UPDATE blogs SET updated_at = CURRENT_TIMESTAMP WHERE id = OLD.id;
+END;
+
+ALTER TABLE blogs DROP COLUMN seats_taken;
+
+DROP INDEX IF EXISTS idx_blog_invites_blog;
+DROP TABLE IF EXISTS blog_invites;
+DROP INDEX IF EXISTS idx_blog_members_user;
+DROP TABLE IF EXISTS blog_members;
@@ -0,0 +1,51 @@
+CREATE TABLE IF NOT EXISTS blog_members (
+    blog_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    role TEXT NOT NULL DEFAULT 'commenter',
+
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (blog_id, user_id),
+
+    FOREIGN KEY (blog_id) REFERENCES blogs(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+
+    CHECK (role IN ('owner', 'editor', 'author', 'commenter'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_blog_members_user ON blog_members(user_id);
+
+-- single use invites for invite_only blogs, only the sha256 of the token is stored
+CREATE TABLE IF NOT EXISTS blog_invites (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    blog_id INTEGER NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_by INTEGER NOT NULL,
+
+    expires_at DATETIME NOT NULL,
+    used_by INTEGER DEFAULT NULL,
+    used_at DATETIME DEFAULT NULL,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY (blog_id) REFERENCES blogs(id) ON DELETE CASCADE,
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (used_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_blog_invites_blog ON blog_invites(blog_id);
+
+-- seats held by members who joined, checked against registration_limit in the same update
+ALTER TABLE blogs ADD COLUMN seats_taken INTEGER NOT NULL DEFAULT 0 CHECK (seats_taken >= 0);
+
+-- taking a seat is not an edit of the blog
+DROP TRIGGER IF EXISTS trg_blogs_updated_at;
+CREATE TRIGGER IF NOT EXISTS trg_blogs_updated_at
+AFTER UPDATE OF owner_id, slug, title, description, visibility, registration_mode, registration_limit, deleted_at ON blogs
+FOR EACH ROW
+BEGIN
+    UPDATE blogs SET updated_at = CURRENT_TIMESTAMP WHERE id = OLD.id;
+END;
+
+-- existing owners become members of their blogs
+INSERT OR IGNORE INTO blog_members (blog_id, user_id, role)
+SELECT id, owner_id, 'owner' FROM blogs;
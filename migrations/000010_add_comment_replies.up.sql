@@ -0,0 +1,5 @@
+-- threaded replies, depth is 0 for top level comments and 1 more than the parent for replies
+ALTER TABLE comments ADD COLUMN parent_id INTEGER DEFAULT NULL;
+ALTER TABLE comments ADD COLUMN depth INTEGER NOT NULL DEFAULT 0 CHECK (depth >= 0);
+
+CREATE INDEX IF NOT EXISTS idx_comments_parent_id ON comments(parent_id);
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS trg_comments_revisions;
+DROP INDEX IF EXISTS idx_comment_revisions_comment;
+DROP TABLE IF EXISTS comment_revisions;
+
+ALTER TABLE comments DROP COLUMN edited_at;
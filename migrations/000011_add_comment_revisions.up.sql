@@ -0,0 +1,24 @@
+-- edited_at is only set by content edits, updated_at also moves when a comment is deleted
+ALTER TABLE comments ADD COLUMN edited_at DATETIME DEFAULT NULL;
+
+-- every version a comment had before an edit, written_at is when that version was posted or last edited
+CREATE TABLE IF NOT EXISTS comment_revisions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    comment_id INTEGER NOT NULL,
+    content TEXT NOT NULL,
+    written_at DATETIME NOT NULL,
+    replaced_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_comment_revisions_comment ON comment_revisions(comment_id);
+
+CREATE TRIGGER IF NOT EXISTS trg_comments_revisions
+AFTER UPDATE OF content ON comments
+FOR EACH ROW
+WHEN OLD.content <> NEW.content
+BEGIN
+    INSERT INTO comment_revisions (comment_id, content, written_at)
+    VALUES (OLD.id, OLD.content, COALESCE(OLD.edited_at, OLD.created_at));
+END;
@@ -0,0 +1,13 @@
+DROP TRIGGER IF EXISTS trg_blogs_updated_at;
+CREATE TRIGGER IF NOT EXISTS trg_blogs_updated_at
+AFTER UPDATE OF owner_id, slug, title, description, visibility, registration_mode, registration_limit, deleted_at ON blogs
+FOR EACH ROW
+BEGIN
+    UPDATE blogs SET updated_at = CURRENT_TIMESTAMP WHERE id = OLD.id;
+END;
+
+ALTER TABLE blogs DROP COLUMN comment_policy;
+
+DROP INDEX IF EXISTS idx_comments_status;
+
+ALTER TABLE comments DROP COLUMN status;
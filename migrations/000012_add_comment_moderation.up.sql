@@ -0,0 +1,16 @@
+-- pending comments wait for a moderator, rejected and spam ones stay hidden from everyone
+ALTER TABLE comments ADD COLUMN status TEXT NOT NULL DEFAULT 'approved' CHECK (status IN ('pending', 'approved', 'rejected', 'spam'));
+
+CREATE INDEX IF NOT EXISTS idx_comments_status ON comments(status);
+
+-- which new comments are held: none (auto), those of users without an approved comment on the blog (first_time) or all
+ALTER TABLE blogs ADD COLUMN comment_policy TEXT NOT NULL DEFAULT 'auto' CHECK (comment_policy IN ('auto', 'first_time', 'all'));
+
+-- changing the policy is an edit of the blog
+DROP TRIGGER IF EXISTS trg_blogs_updated_at;
+CREATE TRIGGER IF NOT EXISTS trg_blogs_updated_at
+AFTER UPDATE OF owner_id, slug, title, description, visibility, registration_mode, registration_limit, comment_policy, deleted_at ON blogs
+FOR EACH ROW
+BEGIN
+    UPDATE blogs SET updated_at = CURRENT_TIMESTAMP WHERE id = OLD.id;
+END;
@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS spam_tokens;
+
+DROP INDEX IF EXISTS idx_comments_trained_as;
+DROP INDEX IF EXISTS idx_comments_fingerprint;
+
+ALTER TABLE comments DROP COLUMN trained_as;
+ALTER TABLE comments DROP COLUMN fingerprint;
+ALTER TABLE comments DROP COLUMN spam_reasons;
+ALTER TABLE comments DROP COLUMN spam_score;
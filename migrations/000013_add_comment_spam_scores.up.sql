@@ -0,0 +1,19 @@
+-- what the spam scorers made of a comment when it was posted, spam_reasons lists the scorers that found something
+ALTER TABLE comments ADD COLUMN spam_score REAL NOT NULL DEFAULT 0 CHECK (spam_score >= 0 AND spam_score <= 1);
+ALTER TABLE comments ADD COLUMN spam_reasons TEXT NOT NULL DEFAULT '';
+
+-- hash of the normalised content, the same text posted under several posts is a spam pattern
+ALTER TABLE comments ADD COLUMN fingerprint TEXT DEFAULT NULL;
+
+-- the class a moderator decision taught the bayes filter, so a changed decision can be unlearned
+ALTER TABLE comments ADD COLUMN trained_as TEXT DEFAULT NULL CHECK (trained_as IN ('spam', 'ham'));
+
+CREATE INDEX IF NOT EXISTS idx_comments_fingerprint ON comments(fingerprint);
+CREATE INDEX IF NOT EXISTS idx_comments_trained_as ON comments(trained_as);
+
+-- per token document counts of the bayes filter
+CREATE TABLE IF NOT EXISTS spam_tokens (
+    token TEXT PRIMARY KEY,
+    spam INTEGER NOT NULL DEFAULT 0 CHECK (spam >= 0),
+    ham INTEGER NOT NULL DEFAULT 0 CHECK (ham >= 0)
+) WITHOUT ROWID;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_outbox_due;
+
+DROP TABLE IF EXISTS outbox;
+
+ALTER TABLE users DROP COLUMN email;
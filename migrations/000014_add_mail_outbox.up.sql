@@ -0,0 +1,27 @@
+-- optional address the engine can contact the user at, not unique as nobody logs in with it
+ALTER TABLE users ADD COLUMN email TEXT DEFAULT NULL CHECK (email IS NULL OR (LENGTH(email) >= 3 AND LENGTH(email) <= 254));
+
+-- emails waiting to be sent, rows stay once sent or given up on as a record of what went out
+CREATE TABLE IF NOT EXISTS outbox (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    recipient TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    text_body TEXT NOT NULL,
+    html_body TEXT NOT NULL DEFAULT '',
+
+    attempts INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NOT NULL DEFAULT '',
+    next_attempt_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    -- a worker sending the email, past it the email is claimed again should the worker have died
+    locked_until DATETIME DEFAULT NULL,
+
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    sent_at DATETIME DEFAULT NULL,
+    failed_at DATETIME DEFAULT NULL,
+
+    CHECK(LENGTH(recipient) >= 3 AND LENGTH(recipient) <= 254),
+    CHECK(attempts >= 0),
+    CHECK(id > 0)
+);
+
+CREATE INDEX IF NOT EXISTS idx_outbox_due ON outbox(next_attempt_at) WHERE sent_at IS NULL AND failed_at IS NULL;
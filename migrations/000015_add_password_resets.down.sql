@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS trg_users_password_resets;
+DROP INDEX IF EXISTS idx_password_resets_user;
+DROP TABLE IF EXISTS password_resets;
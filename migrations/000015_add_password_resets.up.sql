@@ -0,0 +1,22 @@
+-- single use links to set a new password, rows go once used, and all of them once the password changes
+CREATE TABLE IF NOT EXISTS password_resets (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_resets_user ON password_resets(user_id);
+
+-- a link sent before the password changed must not undo the change, whoever made it
+CREATE TRIGGER IF NOT EXISTS trg_users_password_resets
+AFTER UPDATE OF password_hash ON users
+FOR EACH ROW
+WHEN OLD.password_hash <> NEW.password_hash
+BEGIN
+    DELETE FROM password_resets WHERE user_id = NEW.id;
+END;
@@ -0,0 +1,17 @@
+-- totp_secret is sealed with the key from config, totp_last_step is the last code used so it can't be replayed
+ALTER TABLE users ADD COLUMN totp_secret BLOB DEFAULT NULL;
+ALTER TABLE users ADD COLUMN totp_enabled_at DATETIME DEFAULT NULL;
+ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0;
+
+-- single use codes to log in without the authenticator, only their hash is stored
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    code_hash TEXT NOT NULL,
+
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    used_at DATETIME DEFAULT NULL,
+
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE (user_id, code_hash)
+);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_webauthn_credentials_user;
+DROP TABLE IF EXISTS webauthn_credentials;
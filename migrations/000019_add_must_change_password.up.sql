@@ -0,0 +1,2 @@
+-- set for accounts whose password someone else chose, logging in sends them to change it first
+ALTER TABLE users ADD COLUMN must_change_password INTEGER NOT NULL DEFAULT 0 CHECK (must_change_password IN (0, 1));
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS trg_users_login_failures;
+DROP INDEX IF EXISTS idx_login_failures_last_failure;
+DROP TABLE IF EXISTS login_failures;
@@ -0,0 +1,22 @@
+-- failed logins per username, whether the account exists or not, so passwords sprayed at one account are slowed down
+-- whatever address they come from. Usernames compare the way the users table compares them
+CREATE TABLE IF NOT EXISTS login_failures (
+    username TEXT PRIMARY KEY COLLATE NOCASE,
+    failures INTEGER NOT NULL DEFAULT 0,
+
+    blocked_until DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_failure_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    CHECK(LENGTH(username) <= 50)
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_failures_last_failure ON login_failures(last_failure_at);
+
+-- a new password, set by a reset link or by the user, starts the count over
+CREATE TRIGGER IF NOT EXISTS trg_users_login_failures
+AFTER UPDATE OF password_hash ON users
+FOR EACH ROW
+WHEN OLD.password_hash <> NEW.password_hash
+BEGIN
+    DELETE FROM login_failures WHERE username = NEW.username;
+END;
@@ -0,0 +1,7 @@
+-- admins manage the site itself under /admin. The account created by the bootstrap is the first one: the bootstrap
+-- runs before the server accepts registrations, so it is the oldest account. Its name alone proves nothing, anyone
+-- could have registered it since
+ALTER TABLE users ADD COLUMN is_admin INTEGER NOT NULL DEFAULT 0 CHECK (is_admin IN (0, 1));
+
+UPDATE users SET is_admin = 1
+WHERE id = (SELECT MIN(id) FROM users) AND username = 'admin' AND deleted_at IS NULL;
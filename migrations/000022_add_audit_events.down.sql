@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS trg_audit_events_no_delete;
+DROP TRIGGER IF EXISTS trg_audit_events_no_update;
+DROP INDEX IF EXISTS idx_audit_events_ip;
+DROP INDEX IF EXISTS idx_audit_events_actor;
+DROP INDEX IF EXISTS idx_audit_events_kind;
+DROP TABLE IF EXISTS audit_events;
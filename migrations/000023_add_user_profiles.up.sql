@@ -0,0 +1,4 @@
+-- shown on /users/{username}, avatar_key is the uploaded source in the bucket and NULL falls back to an identicon
+ALTER TABLE users ADD COLUMN display_name TEXT CHECK (display_name IS NULL OR length(display_name) BETWEEN 1 AND 50);
+ALTER TABLE users ADD COLUMN bio TEXT CHECK (bio IS NULL OR length(bio) BETWEEN 1 AND 500);
+ALTER TABLE users ADD COLUMN avatar_key TEXT CHECK (avatar_key IS NULL OR length(avatar_key) BETWEEN 1 AND 255);
@@ -0,0 +1,2 @@
+-- the cleared bodies are gone for good, nothing to undo
+SELECT 1;
@@ -0,0 +1,2 @@
+-- delivered and abandoned emails only keep their recipient and subject, bodies can hold live password reset links
+UPDATE outbox SET text_body = '', html_body = '' WHERE sent_at IS NOT NULL OR failed_at IS NOT NULL;
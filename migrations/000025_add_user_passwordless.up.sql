@@ -0,0 +1,3 @@
+-- accounts created through the OIDC provider get a random password nobody knows, they confirm account changes with a
+-- fresh login at the provider until they set a password of their own
+ALTER TABLE users ADD COLUMN passwordless INTEGER NOT NULL DEFAULT 0 CHECK (passwordless IN (0, 1));
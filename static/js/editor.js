@@ -0,0 +1,28 @@
+// live markdown preview of the post editor, rendered by the server so it matches the published post
+const body = document.getElementById("body");
+const preview = body && document.getElementById(body.dataset.preview);
+const csrf = document.querySelector('input[name="csrf_token"]');
+
+if (body && preview && csrf) {
+    let timer;
+
+    const render = () => {
+        fetch("/dashboard/preview", {
+            method: "POST",
+            headers: {
+                "Content-Type": "application/x-www-form-urlencoded",
+                "X-CSRF-Token": csrf.value,
+            },
+            body: new URLSearchParams({ body: body.value }),
+        })
+            .then(res => res.ok ? res.text() : Promise.reject(res.status))
+            .then(html => { preview.innerHTML = html; })
+            .catch(() => { preview.textContent = "Preview unavailable."; });
+    };
+
+    body.addEventListener("input", () => {
+        clearTimeout(timer);
+        timer = setTimeout(render, 500);
+    });
+    render();
+}
@@ -0,0 +1,75 @@
+// passkey login and registration, the server sends the WebAuthn options and checks the answers
+const csrf = document.querySelector('input[name="csrf_token"]');
+const errorBox = document.querySelector("[data-passkey-error]");
+const loginButton = document.getElementById("passkey-login");
+const registerForm = document.getElementById("passkey-register");
+
+const toBytes = (s) => Uint8Array.from(atob(s.replace(/-/g, "+").replace(/_/g, "/")), c => c.charCodeAt(0));
+const toBase64URL = (buf) => btoa(String.fromCharCode(...new Uint8Array(buf)))
+    .replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+
+const post = (url, body) => fetch(url, {
+    method: "POST",
+    headers: { "Content-Type": "application/json", "X-CSRF-Token": csrf.value },
+    body: JSON.stringify(body || {}),
+}).then(res => res.json().then(data => res.ok ? data : Promise.reject(data.error ? data.error.message : res.status)));
+
+const showError = (err) => {
+    if (!errorBox) return;
+    errorBox.textContent = typeof err === "string" ? err : "The passkey did not work, try again.";
+    errorBox.classList.remove("hidden");
+};
+
+if (csrf && window.PublicKeyCredential) {
+    if (loginButton) {
+        loginButton.addEventListener("click", () => {
+            post("/login/passkey/options")
+                .then(options => navigator.credentials.get({
+                    publicKey: {
+                        ...options,
+                        challenge: toBytes(options.challenge),
+                        allowCredentials: [],
+                    },
+                }))
+                .then(cred => post("/login/passkey", {
+                    next: loginButton.dataset.next,
+                    credential: {
+                        id: cred.id,
+                        clientDataJSON: toBase64URL(cred.response.clientDataJSON),
+                        authenticatorData: toBase64URL(cred.response.authenticatorData),
+                        signature: toBase64URL(cred.response.signature),
+                        userHandle: cred.response.userHandle ? toBase64URL(cred.response.userHandle) : "",
+                    },
+                }))
+                .then(result => { window.location = result.redirect; })
+                .catch(showError);
+        });
+    }
+
+    if (registerForm) {
+        registerForm.addEventListener("submit", (e) => {
+            e.preventDefault();
+            post("/account/passkeys/options")
+                .then(options => navigator.credentials.create({
+                    publicKey: {
+                        ...options,
+                        challenge: toBytes(options.challenge),
+                        user: { ...options.user, id: toBytes(options.user.id) },
+                        excludeCredentials: options.excludeCredentials.map(c => ({ ...c, id: toBytes(c.id) })),
+                    },
+                }))
+                .then(cred => post("/account/passkeys", {
+                    name: registerForm.elements.passkey_name.value,
+                    credential: {
+                        id: cred.id,
+                        clientDataJSON: toBase64URL(cred.response.clientDataJSON),
+                        attestationObject: toBase64URL(cred.response.attestationObject),
+                    },
+                }))
+                .then(result => { window.location = result.redirect; })
+                .catch(showError);
+        });
+    }
+} else if (loginButton || registerForm) {
+    (loginButton || registerForm).classList.add("hidden");
+}